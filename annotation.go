@@ -0,0 +1,438 @@
+package jvmgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// target_type values of a type annotation, JVMS §4.7.20
+const (
+	TargetClassTypeParameter       = 0x00
+	TargetMethodTypeParameter      = 0x01
+	TargetClassExtends             = 0x10
+	TargetClassTypeParameterBound  = 0x11
+	TargetMethodTypeParameterBound = 0x12
+	TargetField                    = 0x13
+	TargetMethodReturn             = 0x14
+	TargetMethodReceiver           = 0x15
+	TargetMethodFormalParameter    = 0x16
+	TargetThrows                   = 0x17
+	TargetLocalVariable            = 0x40
+	TargetResourceVariable         = 0x41
+	TargetExceptionParameter       = 0x42
+	TargetInstanceof               = 0x43
+	TargetNew                      = 0x44
+	TargetConstructorReference     = 0x45
+	TargetMethodReference          = 0x46
+	TargetCast                     = 0x47
+	TargetConstructorInvocationArg = 0x48
+	TargetMethodInvocationArg      = 0x49
+	TargetConstructorReferenceArg  = 0x4A
+	TargetMethodReferenceTypeArg   = 0x4B
+)
+
+type (
+	// Annotation is an annotation as stored in the class file. Type is the
+	// field descriptor of the annotation interface.
+	Annotation struct {
+		Type     string
+		Elements []ElementValuePair
+	}
+
+	ElementValuePair struct {
+		Name  string
+		Value *ElementValue
+	}
+
+	// ElementValue is the value of an annotation element. Tag tells which
+	// of the other fields is set: Const holds an int32, int64, float32,
+	// float64 or string for the tags BCDFIJSZ and s, EnumType and
+	// EnumConst an enum constant for e, Class the return descriptor of a
+	// class literal for c, Annotation a nested annotation for @ and Values
+	// the elements of an array for [.
+	ElementValue struct {
+		Tag        byte
+		Const      interface{}
+		EnumType   string
+		EnumConst  string
+		Class      string
+		Annotation *Annotation
+		Values     []*ElementValue
+	}
+
+	// TypeAnnotation is an annotation on a use of a type. Which fields of
+	// Target are set depends on TargetType.
+	TypeAnnotation struct {
+		TargetType uint8
+		Target     TypeAnnotationTarget
+		TypePath   []TypePathEntry
+		Annotation *Annotation
+	}
+
+	// TypeAnnotationTarget is the target_info of a type annotation. Index
+	// is the type parameter, supertype, formal parameter, throws, exception
+	// table or type argument index, BoundIndex the bound of a type
+	// parameter, Offset the bytecode offset of an expression and LocalVars
+	// the ranges of a local variable.
+	TypeAnnotationTarget struct {
+		Index      uint16
+		BoundIndex uint8
+		Offset     uint16
+		LocalVars  []LocalVarTarget
+	}
+
+	LocalVarTarget struct {
+		StartPC uint16
+		Length  uint16
+		Index   uint16
+	}
+
+	TypePathEntry struct {
+		Kind          uint8
+		ArgumentIndex uint8
+	}
+)
+
+// Annotations decodes the RuntimeVisibleAnnotations or
+// RuntimeInvisibleAnnotations attribute named name among attrs, which belong
+// to the class, a field or a method. It returns nil if there is none.
+func (c *ClassStructure) Annotations(attrs []*AttributeInfo, name string) ([]*Annotation, error) {
+	a, err := c.attribute(attrs, name)
+	if err != nil || a == nil {
+		return nil, err
+	}
+	r := c.annotationReader(a.Info)
+	annotations, err := r.annotations()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	return annotations, r.end(name)
+}
+
+// ParameterAnnotations decodes the RuntimeVisibleParameterAnnotations or
+// RuntimeInvisibleParameterAnnotations attribute of a method, which holds
+// the annotations of each formal parameter.
+func (c *ClassStructure) ParameterAnnotations(attrs []*AttributeInfo, name string) ([][]*Annotation, error) {
+	a, err := c.attribute(attrs, name)
+	if err != nil || a == nil {
+		return nil, err
+	}
+	r := c.annotationReader(a.Info)
+	n, err := r.u1()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	params := make([][]*Annotation, n)
+	for i := range params {
+		if params[i], err = r.annotations(); err != nil {
+			return nil, fmt.Errorf("read %s of parameter %d: %w", name, i, err)
+		}
+	}
+	return params, r.end(name)
+}
+
+// TypeAnnotations decodes the RuntimeVisibleTypeAnnotations or
+// RuntimeInvisibleTypeAnnotations attribute among attrs, which belong to
+// the class, a field, a method or a Code attribute.
+func (c *ClassStructure) TypeAnnotations(attrs []*AttributeInfo, name string) ([]*TypeAnnotation, error) {
+	a, err := c.attribute(attrs, name)
+	if err != nil || a == nil {
+		return nil, err
+	}
+	r := c.annotationReader(a.Info)
+	n, err := r.u2()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	annotations := make([]*TypeAnnotation, n)
+	for i := range annotations {
+		if annotations[i], err = r.typeAnnotation(); err != nil {
+			return nil, fmt.Errorf("read %s idx=%d: %w", name, i, err)
+		}
+	}
+	return annotations, r.end(name)
+}
+
+// AnnotationDefault decodes the default value of an element of an
+// annotation interface, or returns nil if the method has none.
+func (c *ClassStructure) AnnotationDefault(m *MethodInfo) (*ElementValue, error) {
+	a, err := c.attribute(m.Attributes, "AnnotationDefault")
+	if err != nil || a == nil {
+		return nil, err
+	}
+	r := c.annotationReader(a.Info)
+	v, err := r.elementValue()
+	if err != nil {
+		return nil, fmt.Errorf("read AnnotationDefault: %w", err)
+	}
+	return v, r.end("AnnotationDefault")
+}
+
+// String formats the annotation like the source code, as
+// Annotation.toString does, e.g. @Test(timeout=5).
+func (a *Annotation) String() string {
+	var b bytes.Buffer
+	b.WriteString("@" + javaTypeName(a.Type))
+	switch {
+	case len(a.Elements) == 1 && a.Elements[0].Name == "value":
+		b.WriteString("(" + a.Elements[0].Value.String() + ")")
+	case len(a.Elements) > 0:
+		b.WriteByte('(')
+		for i, e := range a.Elements {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(e.Name + "=" + e.Value.String())
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+func (v *ElementValue) String() string {
+	switch v.Tag {
+	case 's':
+		return fmt.Sprintf("%q", v.Const)
+	case 'B':
+		return fmt.Sprintf("(byte)0x%02x", uint8(v.Const.(int32)))
+	case 'C':
+		return fmt.Sprintf("'%c'", rune(v.Const.(int32)))
+	case 'Z':
+		return fmt.Sprint(v.Const != int32(0))
+	case 'J':
+		return fmt.Sprintf("%dL", v.Const)
+	case 'F':
+		return fmt.Sprintf("%vf", v.Const)
+	case 'e':
+		return v.EnumConst
+	case 'c':
+		return javaTypeName(v.Class) + ".class"
+	case '@':
+		return v.Annotation.String()
+	case '[':
+		var b bytes.Buffer
+		b.WriteByte('{')
+		for i, e := range v.Values {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(e.String())
+		}
+		b.WriteByte('}')
+		return b.String()
+	}
+	return fmt.Sprint(v.Const)
+}
+
+type annotationReader struct {
+	c *ClassStructure
+	r *bytes.Reader
+}
+
+func (c *ClassStructure) annotationReader(info []byte) *annotationReader {
+	return &annotationReader{c: c, r: bytes.NewReader(info)}
+}
+
+func (r *annotationReader) end(name string) error {
+	if r.r.Len() > 0 {
+		return fmt.Errorf("%d bytes after the end of %s", r.r.Len(), name)
+	}
+	return nil
+}
+
+func (r *annotationReader) u1() (uint8, error) {
+	return r.r.ReadByte()
+}
+
+func (r *annotationReader) u2() (uint16, error) {
+	var v uint16
+	err := binary.Read(r.r, binary.BigEndian, &v)
+	return v, err
+}
+
+// constant reads a constant pool index and returns the entry, which must be
+// of kind.
+func (r *annotationReader) constant(kind ConstantKind) (*CpInfo, error) {
+	idx, err := r.u2()
+	if err != nil {
+		return nil, err
+	}
+	if idx == 0 || int(idx) > len(r.c.ConstantPool) || r.c.ConstantPool[idx-1] == nil {
+		return nil, fmt.Errorf("constant pool index %d out of range", idx)
+	}
+	info := r.c.GetCpInfo(idx)
+	if info.Tag != kind {
+		return nil, fmt.Errorf("constant #%d is of kind %d, not %d", idx, info.Tag, kind)
+	}
+	return info, nil
+}
+
+func (r *annotationReader) utf8() (string, error) {
+	info, err := r.constant(ConstantKindUTF8)
+	if err != nil {
+		return "", err
+	}
+	return info.GetAsUTF8String()
+}
+
+func (r *annotationReader) annotations() ([]*Annotation, error) {
+	n, err := r.u2()
+	if err != nil {
+		return nil, err
+	}
+	annotations := make([]*Annotation, n)
+	for i := range annotations {
+		if annotations[i], err = r.annotation(); err != nil {
+			return nil, fmt.Errorf("annotation idx=%d: %w", i, err)
+		}
+	}
+	return annotations, nil
+}
+
+func (r *annotationReader) annotation() (*Annotation, error) {
+	t, err := r.utf8()
+	if err != nil {
+		return nil, fmt.Errorf("type: %w", err)
+	}
+	n, err := r.u2()
+	if err != nil {
+		return nil, err
+	}
+	a := &Annotation{Type: t, Elements: make([]ElementValuePair, n)}
+	for i := range a.Elements {
+		name, err := r.utf8()
+		if err != nil {
+			return nil, fmt.Errorf("element name: %w", err)
+		}
+		v, err := r.elementValue()
+		if err != nil {
+			return nil, fmt.Errorf("element %s: %w", name, err)
+		}
+		a.Elements[i] = ElementValuePair{Name: name, Value: v}
+	}
+	return a, nil
+}
+
+func (r *annotationReader) elementValue() (*ElementValue, error) {
+	tag, err := r.u1()
+	if err != nil {
+		return nil, err
+	}
+	v := &ElementValue{Tag: tag}
+	switch tag {
+	case 'B', 'C', 'I', 'S', 'Z':
+		info, err := r.constant(ConstantKindInteger)
+		if err != nil {
+			return nil, err
+		}
+		v.Const = int32(binary.BigEndian.Uint32(info.Info))
+	case 'J':
+		info, err := r.constant(ConstantKindLong)
+		if err != nil {
+			return nil, err
+		}
+		v.Const = int64(binary.BigEndian.Uint64(info.Info))
+	case 'F':
+		info, err := r.constant(ConstantKindFloat)
+		if err != nil {
+			return nil, err
+		}
+		v.Const = math.Float32frombits(binary.BigEndian.Uint32(info.Info))
+	case 'D':
+		info, err := r.constant(ConstantKindDouble)
+		if err != nil {
+			return nil, err
+		}
+		v.Const = math.Float64frombits(binary.BigEndian.Uint64(info.Info))
+	case 's':
+		if v.Const, err = r.utf8(); err != nil {
+			return nil, err
+		}
+	case 'e':
+		if v.EnumType, err = r.utf8(); err != nil {
+			return nil, err
+		}
+		if v.EnumConst, err = r.utf8(); err != nil {
+			return nil, err
+		}
+	case 'c':
+		if v.Class, err = r.utf8(); err != nil {
+			return nil, err
+		}
+	case '@':
+		if v.Annotation, err = r.annotation(); err != nil {
+			return nil, err
+		}
+	case '[':
+		n, err := r.u2()
+		if err != nil {
+			return nil, err
+		}
+		v.Values = make([]*ElementValue, n)
+		for i := range v.Values {
+			if v.Values[i], err = r.elementValue(); err != nil {
+				return nil, fmt.Errorf("array element idx=%d: %w", i, err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown element value tag 0x%02x", tag)
+	}
+	return v, nil
+}
+
+func (r *annotationReader) typeAnnotation() (*TypeAnnotation, error) {
+	t, err := r.u1()
+	if err != nil {
+		return nil, err
+	}
+	a := &TypeAnnotation{TargetType: t}
+	target := &a.Target
+	switch t {
+	case TargetClassTypeParameter, TargetMethodTypeParameter, TargetMethodFormalParameter:
+		var idx uint8
+		idx, err = r.u1()
+		target.Index = uint16(idx)
+	case TargetClassExtends, TargetThrows, TargetExceptionParameter:
+		target.Index, err = r.u2()
+	case TargetClassTypeParameterBound, TargetMethodTypeParameterBound:
+		var idx uint8
+		if idx, err = r.u1(); err == nil {
+			target.Index = uint16(idx)
+			target.BoundIndex, err = r.u1()
+		}
+	case TargetField, TargetMethodReturn, TargetMethodReceiver:
+	case TargetLocalVariable, TargetResourceVariable:
+		var n uint16
+		if n, err = r.u2(); err == nil {
+			target.LocalVars = make([]LocalVarTarget, n)
+			err = binary.Read(r.r, binary.BigEndian, target.LocalVars)
+		}
+	case TargetInstanceof, TargetNew, TargetConstructorReference, TargetMethodReference:
+		target.Offset, err = r.u2()
+	case TargetCast, TargetConstructorInvocationArg, TargetMethodInvocationArg, TargetConstructorReferenceArg, TargetMethodReferenceTypeArg:
+		var idx uint8
+		if target.Offset, err = r.u2(); err == nil {
+			idx, err = r.u1()
+			target.Index = uint16(idx)
+		}
+	default:
+		return nil, fmt.Errorf("unknown target type 0x%02x", t)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("target of type 0x%02x: %w", t, err)
+	}
+	n, err := r.u1()
+	if err != nil {
+		return nil, fmt.Errorf("type path: %w", err)
+	}
+	a.TypePath = make([]TypePathEntry, n)
+	if err := binary.Read(r.r, binary.BigEndian, a.TypePath); err != nil {
+		return nil, fmt.Errorf("type path: %w", err)
+	}
+	if a.Annotation, err = r.annotation(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
@@ -0,0 +1,248 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type elementPair struct {
+	name  string
+	value []byte
+}
+
+// annotation encodes an annotation of the type desc.
+func (b *classBuilder) annotation(desc string, elements ...elementPair) []byte {
+	info := append(u2(b.utf8(desc)), u2(uint16(len(elements)))...)
+	for _, e := range elements {
+		info = append(append(info, u2(b.utf8(e.name))...), e.value...)
+	}
+	return info
+}
+
+// annotations encodes a count followed by the annotations.
+func annotations(list ...[]byte) []byte {
+	info := u2(uint16(len(list)))
+	for _, a := range list {
+		info = append(info, a...)
+	}
+	return info
+}
+
+func elementConst(tag byte, idx uint16) []byte {
+	return append([]byte{tag}, u2(idx)...)
+}
+
+func (b *classBuilder) elementString(s string) []byte {
+	return elementConst('s', b.utf8(s))
+}
+
+func elementArray(values ...[]byte) []byte {
+	info := append([]byte{'['}, u2(uint16(len(values)))...)
+	for _, v := range values {
+		info = append(info, v...)
+	}
+	return info
+}
+
+func TestClassStructure_Annotations(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	kind := func(name string) []byte {
+		return append(elementConst('e', b.utf8("LKind;")), u2(b.utf8(name))...)
+	}
+	b.attribute("RuntimeVisibleAnnotations", annotations(b.annotation("LTag;",
+		elementPair{"name", b.elementString("x")},
+		elementPair{"n", elementConst('I', b.integer(5))},
+		elementPair{"kinds", elementArray(kind("A"), kind("B"))},
+		elementPair{"type", elementConst('c', b.utf8("Ljava/lang/String;"))},
+		elementPair{"inner", append([]byte{'@'}, b.annotation("LInner;")...)},
+	)))
+	run := b.method(AccPublic, "run", "(II)V", 0, 3, byte(OpCodeReturn))
+	run.Attributes = append(run.Attributes,
+		b.newAttribute("RuntimeInvisibleAnnotations", annotations(b.annotation("LSlow;"))),
+		b.newAttribute("RuntimeVisibleParameterAnnotations", append([]byte{2}, append(annotations(), annotations(b.annotation("LNotNull;"))...)...)),
+		b.newAttribute("RuntimeVisibleTypeAnnotations", append(append(u2(2),
+			append([]byte{TargetMethodFormalParameter, 1, 1, 3, 0}, b.annotation("LNotNull;")...)...),
+			append(append([]byte{TargetLocalVariable}, append(u2(1), append(u2(0), append(u2(1), u2(2)...)...)...)...), append([]byte{0}, b.annotation("LNotNull;")...)...)...)),
+	)
+	limit := b.method(AccPublic|AccAbstract, "limit", "()J", 0, 0)
+	limit.Attributes = append(limit.Attributes, b.newAttribute("AnnotationDefault", elementConst('J', b.long(7))))
+
+	class, err := DecodeClassStructure(bytes.NewReader(encodeClass(b.build())))
+	require.NoError(t, err)
+
+	got, err := class.Annotations(class.Attributes, "RuntimeVisibleAnnotations")
+	require.NoError(t, err)
+	require.Equal(t, []*Annotation{{Type: "LTag;", Elements: []ElementValuePair{
+		{Name: "name", Value: &ElementValue{Tag: 's', Const: "x"}},
+		{Name: "n", Value: &ElementValue{Tag: 'I', Const: int32(5)}},
+		{Name: "kinds", Value: &ElementValue{Tag: '[', Values: []*ElementValue{
+			{Tag: 'e', EnumType: "LKind;", EnumConst: "A"},
+			{Tag: 'e', EnumType: "LKind;", EnumConst: "B"},
+		}}},
+		{Name: "type", Value: &ElementValue{Tag: 'c', Class: "Ljava/lang/String;"}},
+		{Name: "inner", Value: &ElementValue{Tag: '@', Annotation: &Annotation{Type: "LInner;", Elements: []ElementValuePair{}}}},
+	}}}, got)
+	require.Equal(t, `@Tag(name="x", n=5, kinds={A, B}, type=java.lang.String.class, inner=@Inner)`, got[0].String())
+
+	m := class.Methods[0]
+	invisible, err := class.Annotations(m.Attributes, "RuntimeInvisibleAnnotations")
+	require.NoError(t, err)
+	require.Equal(t, "@Slow", invisible[0].String())
+	visible, err := class.Annotations(m.Attributes, "RuntimeVisibleAnnotations")
+	require.NoError(t, err)
+	require.Nil(t, visible)
+
+	params, err := class.ParameterAnnotations(m.Attributes, "RuntimeVisibleParameterAnnotations")
+	require.NoError(t, err)
+	require.Len(t, params, 2)
+	require.Empty(t, params[0])
+	require.Equal(t, "@NotNull", params[1][0].String())
+
+	types, err := class.TypeAnnotations(m.Attributes, "RuntimeVisibleTypeAnnotations")
+	require.NoError(t, err)
+	require.Len(t, types, 2)
+	require.Equal(t, uint8(TargetMethodFormalParameter), types[0].TargetType)
+	require.Equal(t, TypeAnnotationTarget{Index: 1}, types[0].Target)
+	require.Equal(t, []TypePathEntry{{Kind: 3, ArgumentIndex: 0}}, types[0].TypePath)
+	require.Equal(t, TypeAnnotationTarget{LocalVars: []LocalVarTarget{{StartPC: 0, Length: 1, Index: 2}}}, types[1].Target)
+	require.Equal(t, "LNotNull;", types[1].Annotation.Type)
+
+	def, err := class.AnnotationDefault(class.Methods[1])
+	require.NoError(t, err)
+	require.Equal(t, &ElementValue{Tag: 'J', Const: int64(7)}, def)
+}
+
+func TestClassStructure_Annotations_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		info func(b *classBuilder) []byte
+		want string
+	}{
+		{
+			name: "unknown tag",
+			info: func(b *classBuilder) []byte {
+				return annotations(b.annotation("LTag;", elementPair{"x", []byte{'?'}}))
+			},
+			want: "unknown element value tag 0x3f",
+		},
+		{
+			name: "constant out of range",
+			info: func(b *classBuilder) []byte {
+				return annotations(b.annotation("LTag;", elementPair{"x", elementConst('I', 999)}))
+			},
+			want: "constant pool index 999 out of range",
+		},
+		{
+			name: "wrong constant kind",
+			info: func(b *classBuilder) []byte {
+				return annotations(b.annotation("LTag;", elementPair{"x", elementConst('J', b.integer(1))}))
+			},
+			want: "is of kind 3, not 5",
+		},
+		{
+			name: "trailing bytes",
+			info: func(b *classBuilder) []byte {
+				return append(annotations(), 0)
+			},
+			want: "1 bytes after the end of RuntimeVisibleAnnotations",
+		},
+		{
+			name: "truncated",
+			info: func(b *classBuilder) []byte {
+				return b.annotation("LTag;")[:1]
+			},
+			want: "read RuntimeVisibleAnnotations",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newClassBuilder("Main", "java/lang/Object")
+			b.attribute("RuntimeVisibleAnnotations", tt.info(b))
+			class := b.build()
+			_, err := class.Annotations(class.Attributes, "RuntimeVisibleAnnotations")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
+
+func TestVirtualMachine_ExecMain_Annotations(t *testing.T) {
+	annotationInterface := func(name string) *classBuilder {
+		b := newClassBuilder(name, "java/lang/Object")
+		b.class.AccessFlags = AccPublic | AccInterface | AccAbstract | AccAnnotation
+		b.class.Interfaces = append(b.class.Interfaces, b.classRef("java/lang/annotation/Annotation"))
+		return b
+	}
+	// @interface Test { int timeout() default 0; String name(); }
+	test := annotationInterface("Test")
+	timeout := test.method(AccPublic|AccAbstract, "timeout", "()I", 0, 0)
+	timeout.Attributes = append(timeout.Attributes, test.newAttribute("AnnotationDefault", elementConst('I', test.integer(0))))
+	test.method(AccPublic|AccAbstract, "name", "()Ljava/lang/String;", 0, 0)
+	// @Inherited @interface Suite { String value(); }
+	suite := annotationInterface("Suite")
+	suite.attribute("RuntimeVisibleAnnotations", annotations(suite.annotation("Ljava/lang/annotation/Inherited;")))
+	suite.method(AccPublic|AccAbstract, "value", "()Ljava/lang/String;", 0, 0)
+	// @Suite("all") class Base {}
+	base := newClassBuilder("Base", "java/lang/Object")
+	base.attribute("RuntimeVisibleAnnotations", annotations(base.annotation("LSuite;", elementPair{"value", base.elementString("all")})))
+
+	b := newClassBuilder("Main", "Base")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printObject := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/Object;)V")
+	printInt := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	testMethod := func(name string, elements ...elementPair) {
+		m := b.method(AccPublic|AccStatic, name, "()V", 2, 0, newAsm().
+			ref(OpCodeGetStatic, out).op(OpCodeLdc, lo(b.str(name))).
+			ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")).
+			op(OpCodeReturn).bytes()...)
+		if elements != nil {
+			m.Attributes = append(m.Attributes, b.newAttribute("RuntimeVisibleAnnotations", annotations(b.annotation("LTest;", elements...))))
+		}
+	}
+	testMethod("a", elementPair{"name", b.elementString("a")})
+	testMethod("b")
+	testMethod("c", elementPair{"name", b.elementString("c")}, elementPair{"timeout", elementConst('I', b.integer(5))})
+
+	mainClass, testClass := b.classRef("Main"), b.classRef("Test")
+	timeoutRef := b.interfaceMethodRef("Test", "timeout", "()I")
+	// for (Method m : Main.class.getDeclaredMethods()) {
+	//   Test t = m.getAnnotation(Test.class);
+	//   if (t != null) { System.out.println(t); System.out.println(t.timeout()); m.invoke(null); }
+	// }
+	a := newAsm().ref(OpCodeLdcW, mainClass).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Class", "getDeclaredMethods", "()[Ljava/lang/reflect/Method;")).
+		op(OpCodeAstore0+1).op(OpCodeIconst0).op(OpCodeIstore0+2).
+		label("loop").op(OpCodeIload0+2).op(OpCodeAload0+1).op(OpCodeArrayLength).branch(OpCodeIfIcmpge, "end").
+		op(OpCodeAload0+1).op(OpCodeIload0+2).op(OpCodeAaload).ref(OpCodeLdcW, testClass).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/reflect/Method", "getAnnotation", "(Ljava/lang/Class;)Ljava/lang/annotation/Annotation;")).
+		ref(OpCodeCheckCast, testClass).op(OpCodeAstore0+3).
+		op(OpCodeAload0+3).branch(OpCodeIfNull, "next").
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+3).ref(OpCodeInvokeVirtual, printObject).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+3).op(OpCodeInvokeInterface, hi(timeoutRef), lo(timeoutRef), 1, 0).ref(OpCodeInvokeVirtual, printInt).
+		op(OpCodeAload0+1).op(OpCodeIload0+2).op(OpCodeAaload).op(OpCodeAconstNull).op(OpCodeIconst0).ref(OpCodeANewArray, b.classRef("java/lang/Object")).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/reflect/Method", "invoke", "(Ljava/lang/Object;[Ljava/lang/Object;)Ljava/lang/Object;")).op(OpCodePop).
+		label("next").op(OpCodeIinc, 2, 1).branch(OpCodeGoto, "loop").
+		label("end")
+	// System.out.println(Main.class.getAnnotation(Suite.class));
+	// System.out.println(Main.class.getDeclaredAnnotations().length);
+	a.ref(OpCodeGetStatic, out).ref(OpCodeLdcW, mainClass).ref(OpCodeLdcW, b.classRef("Suite")).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Class", "getAnnotation", "(Ljava/lang/Class;)Ljava/lang/annotation/Annotation;")).
+		ref(OpCodeInvokeVirtual, printObject).
+		ref(OpCodeGetStatic, out).ref(OpCodeLdcW, mainClass).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Class", "getDeclaredAnnotations", "()[Ljava/lang/annotation/Annotation;")).
+		op(OpCodeArrayLength).ref(OpCodeInvokeVirtual, printInt).
+		op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 4, 4, a.bytes()...)
+
+	vm := NewVM(b.build())
+	for _, c := range []*classBuilder{test, suite, base} {
+		_, err := vm.DefineClass(c.build())
+		require.NoError(t, err)
+	}
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "@Test(timeout=0, name=\"a\")\n0\na\n@Test(timeout=5, name=\"c\")\n5\nc\n@Suite(\"all\")\n0\n", stdout.String())
+}
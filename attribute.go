@@ -27,8 +27,8 @@ func readAttribute(r io.Reader) (*AttributeInfo, error) {
 	}
 	length := binary.BigEndian.Uint32(lBuf)
 
-	info := make([]byte, length)
-	if _, err := io.ReadFull(r, info); err != nil {
+	info, err := readBytes(r, length)
+	if err != nil {
 		return nil, fmt.Errorf("read attribute info: %w", err)
 	}
 
@@ -39,6 +39,20 @@ func readAttribute(r io.Reader) (*AttributeInfo, error) {
 	}, nil
 }
 
+// readBytes reads n bytes without allocating them up front, so that a
+// corrupt length fails with io.ErrUnexpectedEOF rather than exhausting
+// memory.
+func readBytes(r io.Reader, n uint32) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func (a *AttributeInfo) toCodeAttribute() (*CodeAttribute, error) {
 	r := bytes.NewBuffer(a.Info)
 	ret := &CodeAttribute{
@@ -63,8 +77,8 @@ func (a *AttributeInfo) toCodeAttribute() (*CodeAttribute, error) {
 	}
 	ret.CodeLength = binary.BigEndian.Uint32(lBuf)
 
-	code := make([]byte, ret.CodeLength)
-	if _, err := io.ReadFull(r, code); err != nil {
+	code, err := readBytes(r, ret.CodeLength)
+	if err != nil {
 		return nil, fmt.Errorf("read code: %w", err)
 	}
 	ret.Code = code
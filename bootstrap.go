@@ -0,0 +1,108 @@
+package jvmgo
+
+import (
+	"fmt"
+)
+
+// InitSystem brings up the class library found on the class path the way
+// HotSpot does before running main: it creates the system and main thread
+// groups and the main thread, then runs System.initPhase1.
+func (vm *VirtualMachine) InitSystem() error {
+	if vm.ClassPath == nil {
+		return fmt.Errorf("init system: class path is not set")
+	}
+	for _, name := range []string{
+		"java/lang/Object",
+		"java/lang/String",
+		"java/lang/System",
+		"java/lang/Class",
+		"java/lang/ThreadGroup",
+		"java/lang/Thread",
+	} {
+		c, err := vm.LoadClass(name)
+		if err != nil {
+			return fmt.Errorf("init system: %w", err)
+		}
+		if err := vm.initializeClass(nil, c); err != nil {
+			return fmt.Errorf("init system: initialize %s: %w", name, err)
+		}
+	}
+
+	groupClass, err := vm.LoadClass("java/lang/ThreadGroup")
+	if err != nil {
+		return err
+	}
+	systemGroup, err := vm.NewInstance(nil, groupClass, "()V")
+	if err != nil {
+		return fmt.Errorf("init system: create system thread group: %w", err)
+	}
+	mainGroup, err := vm.NewInstance(nil, groupClass, "(Ljava/lang/ThreadGroup;Ljava/lang/String;)V", systemGroup, vm.NewString("main"))
+	if err != nil {
+		return fmt.Errorf("init system: create main thread group: %w", err)
+	}
+
+	threadClass, err := vm.LoadClass("java/lang/Thread")
+	if err != nil {
+		return err
+	}
+	// the constructor asks for the current thread, so it must exist beforehand
+	vm.mainThread = NewObject(threadClass)
+	vm.mainThread.SetField("priority", "I", int32(5))
+	ctor := threadClass.DeclaredMethod("<init>", "(Ljava/lang/ThreadGroup;Ljava/lang/String;)V")
+	if ctor == nil {
+		return fmt.Errorf("init system: Thread(ThreadGroup, String) does not exist")
+	}
+	if _, err := vm.invokeMethod(nil, ctor, []Value{vm.mainThread, mainGroup, vm.NewString("main")}); err != nil {
+		return fmt.Errorf("init system: create main thread: %w", err)
+	}
+
+	system, err := vm.LoadClass("java/lang/System")
+	if err != nil {
+		return err
+	}
+	initPhase1 := system.DeclaredMethod("initPhase1", "()V")
+	if initPhase1 == nil {
+		return fmt.Errorf("init system: System.initPhase1 does not exist")
+	}
+	if _, err := vm.invokeMethod(nil, initPhase1, nil); err != nil {
+		return fmt.Errorf("init system: initPhase1: %w", err)
+	}
+	vm.systemInitialized = true
+	return nil
+}
+
+// NewInstance allocates an instance of class and runs the constructor with the
+// given descriptor.
+func (vm *VirtualMachine) NewInstance(caller *Frame, class *RuntimeClass, descriptor string, args ...Value) (*Object, error) {
+	if err := vm.initializeClass(caller, class); err != nil {
+		return nil, err
+	}
+	ctor := class.DeclaredMethod("<init>", descriptor)
+	if ctor == nil {
+		return nil, fmt.Errorf("constructor %s%s does not exist", class.Name, descriptor)
+	}
+	obj := NewObject(class)
+	if err := vm.allocate(caller, obj); err != nil {
+		return nil, err
+	}
+	if _, err := vm.invokeMethod(caller, ctor, append([]Value{obj}, args...)); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// InvokeVirtual calls the named method on obj, selecting it from obj's class.
+func (vm *VirtualMachine) InvokeVirtual(caller *Frame, obj *Object, name, descriptor string, args ...Value) (Value, error) {
+	if obj == nil {
+		return nil, vm.throwNullPointer(caller)
+	}
+	m := obj.Class.LookupMethod(name, descriptor)
+	if m == nil {
+		return nil, vm.throwNew(caller, "java/lang/NoSuchMethodError", obj.Class.Name+"."+name+descriptor)
+	}
+	m, err := vm.selectMethod(caller, obj.Class, m)
+	if err != nil {
+		return nil, err
+	}
+	return vm.invokeMethod(caller, m, append([]Value{obj}, args...))
+}
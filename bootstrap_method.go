@@ -0,0 +1,115 @@
+package jvmgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// reference kinds of CONSTANT_MethodHandle
+const (
+	RefGetField         uint8 = 1
+	RefGetStatic        uint8 = 2
+	RefPutField         uint8 = 3
+	RefPutStatic        uint8 = 4
+	RefInvokeVirtual    uint8 = 5
+	RefInvokeStatic     uint8 = 6
+	RefInvokeSpecial    uint8 = 7
+	RefNewInvokeSpecial uint8 = 8
+	RefInvokeInterface  uint8 = 9
+)
+
+type (
+	// BootstrapMethod is an entry of the BootstrapMethods attribute.
+	BootstrapMethod struct {
+		MethodRef uint16
+		Arguments []uint16
+	}
+
+	// MethodHandleRef is a CONSTANT_MethodHandle with its reference resolved.
+	MethodHandleRef struct {
+		Kind uint8
+		Ref  *MemberRef
+	}
+)
+
+// BootstrapMethods decodes the BootstrapMethods attribute of the class.
+func (c *ClassStructure) BootstrapMethods() ([]*BootstrapMethod, error) {
+	for _, a := range c.Attributes {
+		name, err := c.GetCpInfo(a.AttributeNameIndex).GetAsUTF8String()
+		if err != nil {
+			return nil, fmt.Errorf("get attribute name: %w", err)
+		}
+		if name != "BootstrapMethods" {
+			continue
+		}
+
+		r := bytes.NewReader(a.Info)
+		var count uint16
+		if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+			return nil, fmt.Errorf("read bootstrap methods count: %w", err)
+		}
+		methods := make([]*BootstrapMethod, count)
+		for i := range methods {
+			var header [2]uint16
+			if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+				return nil, fmt.Errorf("read bootstrap method idx=%d: %w", i, err)
+			}
+			args := make([]uint16, header[1])
+			if err := binary.Read(r, binary.BigEndian, args); err != nil {
+				return nil, fmt.Errorf("read bootstrap arguments idx=%d: %w", i, err)
+			}
+			methods[i] = &BootstrapMethod{MethodRef: header[0], Arguments: args}
+		}
+		if r.Len() != 0 {
+			return nil, fmt.Errorf("read bootstrap methods: %w", io.ErrUnexpectedEOF)
+		}
+		return methods, nil
+	}
+	return nil, nil
+}
+
+// GetInvokeDynamic returns the bootstrap method index and the name and type
+// of a CONSTANT_InvokeDynamic.
+func (c *ClassStructure) GetInvokeDynamic(idx uint16) (uint16, string, string, error) {
+	info := c.GetCpInfo(idx)
+	if info.Tag != ConstantKindInvokeDynamic {
+		return 0, "", "", fmt.Errorf("constant kind mismatch. kind should be invoke dynamic: %d", info.Tag)
+	}
+	if len(info.Info) < 4 {
+		return 0, "", "", fmt.Errorf("cp info is invalid as kind invoke dynamic")
+	}
+	name, desc, err := c.GetNameAndType(binary.BigEndian.Uint16(info.Info[2:]))
+	if err != nil {
+		return 0, "", "", fmt.Errorf("get invoke dynamic name and type: %w", err)
+	}
+	return binary.BigEndian.Uint16(info.Info[:2]), name, desc, nil
+}
+
+func (c *ClassStructure) GetMethodHandle(idx uint16) (*MethodHandleRef, error) {
+	info := c.GetCpInfo(idx)
+	if info.Tag != ConstantKindMethodHandle {
+		return nil, fmt.Errorf("constant kind mismatch. kind should be method handle: %d", info.Tag)
+	}
+	if len(info.Info) < 3 {
+		return nil, fmt.Errorf("cp info is invalid as kind method handle")
+	}
+	ref, err := c.GetMemberRef(binary.BigEndian.Uint16(info.Info[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("get method handle reference: %w", err)
+	}
+	return &MethodHandleRef{Kind: info.Info[0], Ref: ref}, nil
+}
+
+// GetMethodType returns the descriptor of a CONSTANT_MethodType.
+func (c *ClassStructure) GetMethodType(idx uint16) (string, error) {
+	info := c.GetCpInfo(idx)
+	if info.Tag != ConstantKindMethodType {
+		return "", fmt.Errorf("constant kind mismatch. kind should be method type: %d", info.Tag)
+	}
+	if len(info.Info) < 2 {
+		return "", fmt.Errorf("cp info is invalid as kind method type")
+	}
+	return c.GetCpInfo(binary.BigEndian.Uint16(info.Info)).GetAsUTF8String()
+}
@@ -0,0 +1,153 @@
+package jvmgo
+
+import (
+	"fmt"
+)
+
+type (
+	// builtinClass describes a class of the bundled runtime, which stands in for
+	// the class library when the class path does not provide it. Its methods are
+	// natives registered in every NativeRegistry.
+	builtinClass struct {
+		name       string
+		super      string
+		interfaces []string
+		flags      uint16
+		fields     []builtinField
+		methods    []builtinMethod
+		// spun marks a class made at run time, such as a lambda class,
+		// whose methods keep their natives instead of registering them
+		spun bool
+	}
+	builtinField struct {
+		flags uint16
+		name  string
+		desc  string
+		value Value
+	}
+	builtinMethod struct {
+		flags uint16
+		name  string
+		desc  string
+		fn    NativeMethod
+	}
+	builtinClassSet map[string]*builtinClass
+)
+
+var builtinClasses = builtinClassSet{}
+
+func init() {
+	// the natives refer back to the class loader, so this cannot be a
+	// variable initializer
+	defineBuiltinLang(builtinClasses)
+	defineBuiltinBoxes(builtinClasses)
+	defineBuiltinThrowables(builtinClasses)
+	defineBuiltinUtil(builtinClasses)
+	defineBuiltinAtomic(builtinClasses)
+	defineBuiltinRef(builtinClasses)
+	defineBuiltinReflect(builtinClasses)
+	defineBuiltinAnnotation(builtinClasses)
+}
+
+func (s builtinClassSet) class(name, super string, interfaces ...string) *builtinClass {
+	c := &builtinClass{name: name, super: super, interfaces: interfaces, flags: AccPublic | AccSuper}
+	s[name] = c
+	return c
+}
+
+func (s builtinClassSet) iface(name string, interfaces ...string) *builtinClass {
+	c := s.class(name, "java/lang/Object", interfaces...)
+	c.flags = AccPublic | AccInterface | AccAbstract
+	return c
+}
+
+func (c *builtinClass) field(flags uint16, name, desc string, value Value) *builtinClass {
+	c.fields = append(c.fields, builtinField{flags: flags, name: name, desc: desc, value: value})
+	return c
+}
+
+// method declares a public method implemented by fn. A nil fn declares a
+// native registered elsewhere, or an abstract method when flags say so.
+func (c *builtinClass) method(flags uint16, name, desc string, fn NativeMethod) *builtinClass {
+	flags |= AccPublic
+	if flags&AccAbstract == 0 {
+		flags |= AccNative
+	}
+	c.methods = append(c.methods, builtinMethod{flags: flags, name: name, desc: desc, fn: fn})
+	return c
+}
+
+func (c *builtinClass) virtual(name, desc string, fn NativeMethod) *builtinClass {
+	return c.method(0, name, desc, fn)
+}
+
+func (c *builtinClass) static(name, desc string, fn NativeMethod) *builtinClass {
+	return c.method(AccStatic, name, desc, fn)
+}
+
+func (c *builtinClass) abstract(name, desc string) *builtinClass {
+	return c.method(AccAbstract, name, desc, nil)
+}
+
+func registerBuiltinClasses(r *NativeRegistry) {
+	for _, c := range builtinClasses {
+		for _, m := range c.methods {
+			if m.fn != nil {
+				r.Register(c.name, m.name, m.desc, m.fn)
+			}
+		}
+	}
+}
+
+// defineBuiltin links a class of the bundled runtime.
+func (vm *VirtualMachine) defineBuiltin(b *builtinClass) (*RuntimeClass, error) {
+	c := &RuntimeClass{
+		Name:        b.name,
+		AccessFlags: b.flags,
+		methodIndex: map[string]*RuntimeMethod{},
+	}
+	if b.super != "" && b.name != "java/lang/Object" {
+		super, err := vm.LoadClass(b.super)
+		if err != nil {
+			return nil, fmt.Errorf("load super class of %s: %w", b.name, err)
+		}
+		c.Super = super
+	}
+	for _, name := range b.interfaces {
+		i, err := vm.LoadClass(name)
+		if err != nil {
+			return nil, fmt.Errorf("load interface of %s: %w", b.name, err)
+		}
+		c.Interfaces = append(c.Interfaces, i)
+	}
+	for _, f := range b.fields {
+		c.Fields = append(c.Fields, &RuntimeField{Class: c, Name: f.name, Descriptor: f.desc, AccessFlags: f.flags})
+	}
+	for _, m := range b.methods {
+		desc, err := parseMethodDescriptor(m.desc)
+		if err != nil {
+			return nil, fmt.Errorf("method %s.%s: %w", b.name, m.name, err)
+		}
+		rm := &RuntimeMethod{Class: c, Name: m.name, Descriptor: m.desc, AccessFlags: m.flags, Desc: desc}
+		if b.spun {
+			rm.native = m.fn
+		}
+		c.Methods = append(c.Methods, rm)
+		c.methodIndex[m.name+m.desc] = rm
+	}
+	c.layoutFields()
+	c.linkMethods()
+	for i, f := range b.fields {
+		if f.value != nil {
+			c.StaticValues[c.Fields[i].Slot] = f.value
+		}
+	}
+
+	vm.classesMu.Lock()
+	defer vm.classesMu.Unlock()
+	if existing, ok := vm.classes[c.Name]; ok {
+		return existing, nil
+	}
+	vm.classes[c.Name] = c
+	return c, nil
+}
@@ -0,0 +1,447 @@
+package jvmgo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+const (
+	annotationInterface   = "java/lang/annotation/Annotation"
+	inheritedAnnotation   = "Ljava/lang/annotation/Inherited;"
+	annotationFormatError = "java/lang/annotation/AnnotationFormatError"
+)
+
+var annotationProxyCount uint32
+
+type (
+	// annotationInstance is the state of an annotation proxy: the elements
+	// of the annotation, including defaults, and their values.
+	annotationInstance struct {
+		annotation *Annotation
+		values     []Value
+	}
+
+	// runtimeAnnotation is a runtime visible annotation whose interface
+	// could be loaded.
+	runtimeAnnotation struct {
+		class      *RuntimeClass
+		annotation *Annotation
+	}
+)
+
+func defineBuiltinAnnotation(s builtinClassSet) {
+	s.iface(annotationInterface).
+		abstract("annotationType", "()Ljava/lang/Class;")
+	s.iface("java/lang/annotation/Inherited", annotationInterface).flags |= AccAnnotation
+	defineThrowableConstructors(s.class("java/lang/annotation/IncompleteAnnotationException", "java/lang/RuntimeException"))
+	defineThrowableConstructors(s.class(annotationFormatError, "java/lang/Error"))
+
+	for _, name := range []string{"java/lang/Class", reflectMethodClass, reflectConstructorClass, reflectFieldClass} {
+		defineAnnotatedElement(s[name])
+	}
+	for _, name := range []string{reflectMethodClass, reflectConstructorClass} {
+		s[name].virtual("getParameterAnnotations", "()[[Ljava/lang/annotation/Annotation;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.parameterAnnotations(frame, reflectedMethod(args[0]))
+		})
+	}
+	s[reflectMethodClass].virtual("getDefaultValue", "()Ljava/lang/Object;", func(frame *Frame, args []Value) (Value, error) {
+		m := reflectedMethod(args[0])
+		if m.Info == nil || !m.Class.IsInterface() || m.Class.AccessFlags&AccAnnotation == 0 {
+			return nil, nil
+		}
+		ev, err := m.Class.File.AnnotationDefault(m.Info)
+		if err != nil {
+			return nil, throwOrError(frame, annotationFormatError, err.Error())
+		}
+		if ev == nil {
+			return nil, nil
+		}
+		v, err := frame.VM.annotationValue(frame, m.Desc.Return, ev)
+		if err != nil {
+			return nil, err
+		}
+		return frame.VM.box(frame, m.Desc.Return, v)
+	})
+}
+
+// defineAnnotatedElement declares the methods of
+// java.lang.reflect.AnnotatedElement, which Class and the java.lang.reflect
+// members implement.
+func defineAnnotatedElement(c *builtinClass) {
+	find := func(frame *Frame, args []Value, inherited bool) (*runtimeAnnotation, error) {
+		t, ok := classFromMirror(args[1])
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+		}
+		annotations, err := frame.VM.elementAnnotations(frame, args[0].(*Object), inherited)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range annotations {
+			if a.class == t {
+				return &a, nil
+			}
+		}
+		return nil, nil
+	}
+	get := func(inherited bool) NativeMethod {
+		return func(frame *Frame, args []Value) (Value, error) {
+			a, err := find(frame, args, inherited)
+			if err != nil || a == nil {
+				return nil, err
+			}
+			return frame.VM.annotationObject(frame, a.class, a.annotation)
+		}
+	}
+	list := func(inherited bool) NativeMethod {
+		return func(frame *Frame, args []Value) (Value, error) {
+			annotations, err := frame.VM.elementAnnotations(frame, args[0].(*Object), inherited)
+			if err != nil {
+				return nil, err
+			}
+			return frame.VM.annotationArray(frame, annotations)
+		}
+	}
+	c.virtual("getAnnotation", "(Ljava/lang/Class;)Ljava/lang/annotation/Annotation;", get(true)).
+		virtual("getDeclaredAnnotation", "(Ljava/lang/Class;)Ljava/lang/annotation/Annotation;", get(false)).
+		virtual("isAnnotationPresent", "(Ljava/lang/Class;)Z", func(frame *Frame, args []Value) (Value, error) {
+			a, err := find(frame, args, true)
+			return javaBool(a != nil), err
+		}).
+		virtual("getAnnotations", "()[Ljava/lang/annotation/Annotation;", list(true)).
+		virtual("getDeclaredAnnotations", "()[Ljava/lang/annotation/Annotation;", list(false))
+}
+
+// runtimeAnnotations decodes the RuntimeVisibleAnnotations among attrs.
+// Like the JDK, it skips annotations whose interface is not found.
+func (vm *VirtualMachine) runtimeAnnotations(frame *Frame, file *ClassStructure, attrs []*AttributeInfo) ([]runtimeAnnotation, error) {
+	if file == nil {
+		return nil, nil
+	}
+	annotations, err := file.Annotations(attrs, "RuntimeVisibleAnnotations")
+	if err != nil {
+		return nil, throwOrError(frame, annotationFormatError, err.Error())
+	}
+	return vm.loadAnnotations(annotations)
+}
+
+func (vm *VirtualMachine) loadAnnotations(annotations []*Annotation) ([]runtimeAnnotation, error) {
+	var loaded []runtimeAnnotation
+	for _, a := range annotations {
+		if len(a.Type) < 3 || a.Type[0] != 'L' {
+			continue
+		}
+		c, err := vm.LoadClass(a.Type[1 : len(a.Type)-1])
+		if errors.Is(err, ErrClassNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c.IsInterface() && c.AccessFlags&AccAnnotation != 0 {
+			loaded = append(loaded, runtimeAnnotation{class: c, annotation: a})
+		}
+	}
+	return loaded, nil
+}
+
+// elementAnnotations returns the annotations of a Class mirror or a
+// java.lang.reflect member. With inherited, a class also has the @Inherited
+// annotations of its superclasses that it does not override.
+func (vm *VirtualMachine) elementAnnotations(frame *Frame, obj *Object, inherited bool) ([]runtimeAnnotation, error) {
+	if f := reflectedField(obj); f != nil {
+		if f.Info == nil {
+			return nil, nil
+		}
+		return vm.runtimeAnnotations(frame, f.Class.File, f.Info.Attributes)
+	}
+	if m := reflectedMethod(obj); m != nil {
+		if m.Info == nil {
+			return nil, nil
+		}
+		return vm.runtimeAnnotations(frame, m.Class.File, m.Info.Attributes)
+	}
+	c, _ := classFromMirror(obj)
+	annotations, err := vm.runtimeAnnotations(frame, c.File, classAttributes(c))
+	if err != nil || !inherited {
+		return annotations, err
+	}
+	for super := c.Super; super != nil; super = super.Super {
+		declared, err := vm.runtimeAnnotations(frame, super.File, classAttributes(super))
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range declared {
+			if isInherited(a.class) && !hasAnnotation(annotations, a.class) {
+				annotations = append(annotations, a)
+			}
+		}
+	}
+	return annotations, nil
+}
+
+func hasAnnotation(annotations []runtimeAnnotation, c *RuntimeClass) bool {
+	for _, a := range annotations {
+		if a.class == c {
+			return true
+		}
+	}
+	return false
+}
+
+// isInherited reports whether the annotation interface c is annotated with
+// @Inherited.
+func isInherited(c *RuntimeClass) bool {
+	if c.File == nil {
+		return false
+	}
+	annotations, err := c.File.Annotations(c.File.Attributes, "RuntimeVisibleAnnotations")
+	if err != nil {
+		return false
+	}
+	for _, a := range annotations {
+		if a.Type == inheritedAnnotation {
+			return true
+		}
+	}
+	return false
+}
+
+// classAttributes returns the attributes of the class file of c, or none for
+// a class of the bundled runtime.
+func classAttributes(c *RuntimeClass) []*AttributeInfo {
+	if c.File == nil {
+		return nil
+	}
+	return c.File.Attributes
+}
+
+func (vm *VirtualMachine) annotationArray(frame *Frame, annotations []runtimeAnnotation) (*Object, error) {
+	arr, err := vm.newArray(frame, "[L"+annotationInterface+";", int32(len(annotations)))
+	if err != nil {
+		return nil, err
+	}
+	elems := arr.Array.([]Value)
+	for i, a := range annotations {
+		if elems[i], err = vm.annotationObject(frame, a.class, a.annotation); err != nil {
+			return nil, err
+		}
+	}
+	return arr, nil
+}
+
+// parameterAnnotations is Method.getParameterAnnotations and
+// Constructor.getParameterAnnotations.
+func (vm *VirtualMachine) parameterAnnotations(frame *Frame, m *RuntimeMethod) (*Object, error) {
+	var params [][]*Annotation
+	if m.Info != nil {
+		var err error
+		if params, err = m.Class.File.ParameterAnnotations(m.Info.Attributes, "RuntimeVisibleParameterAnnotations"); err != nil {
+			return nil, throwOrError(frame, annotationFormatError, err.Error())
+		}
+	}
+	arr, err := vm.newArray(frame, "[[L"+annotationInterface+";", int32(len(m.Desc.Parameters)))
+	if err != nil {
+		return nil, err
+	}
+	elems := arr.Array.([]Value)
+	// javac leaves out the synthetic parameters of some constructors, which
+	// come first
+	skip := len(elems) - len(params)
+	for i := range elems {
+		var annotations []runtimeAnnotation
+		if i >= skip && skip >= 0 {
+			if annotations, err = vm.loadAnnotations(params[i-skip]); err != nil {
+				return nil, err
+			}
+		}
+		if elems[i], err = vm.annotationArray(frame, annotations); err != nil {
+			return nil, err
+		}
+	}
+	return arr, nil
+}
+
+// annotationObject returns a new instance of the annotation interface c
+// holding the values of a and the defaults of the elements it omits.
+func (vm *VirtualMachine) annotationObject(frame *Frame, c *RuntimeClass, a *Annotation) (*Object, error) {
+	proxy, err := vm.annotationProxy(c)
+	if err != nil {
+		return nil, err
+	}
+	explicit := map[string]*ElementValue{}
+	for _, e := range a.Elements {
+		explicit[e.Name] = e.Value
+	}
+	inst := &annotationInstance{annotation: &Annotation{Type: a.Type}}
+	for _, m := range annotationElements(c) {
+		ev := explicit[m.Name]
+		if ev == nil && m.Info != nil {
+			if ev, err = c.File.AnnotationDefault(m.Info); err != nil {
+				return nil, throwOrError(frame, annotationFormatError, err.Error())
+			}
+		}
+		if ev == nil {
+			continue
+		}
+		v, err := vm.annotationValue(frame, m.Desc.Return, ev)
+		if err != nil {
+			return nil, err
+		}
+		inst.annotation.Elements = append(inst.annotation.Elements, ElementValuePair{Name: m.Name, Value: ev})
+		inst.values = append(inst.values, v)
+	}
+	obj := NewObject(proxy)
+	obj.Extra = inst
+	if err := vm.allocate(frame, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// annotationElements returns the methods of an annotation interface that
+// declare its elements.
+func annotationElements(c *RuntimeClass) []*RuntimeMethod {
+	var elements []*RuntimeMethod
+	for _, m := range c.Methods {
+		if !m.IsStatic() && len(m.Desc.Parameters) == 0 && m.AccessFlags&AccAbstract != 0 {
+			elements = append(elements, m)
+		}
+	}
+	return elements
+}
+
+// annotationProxy spins the class of the instances of the annotation
+// interface c, whose element methods return the values the instance holds
+// in Extra.
+func (vm *VirtualMachine) annotationProxy(c *RuntimeClass) (*RuntimeClass, error) {
+	c.annotationOnce.Do(func() {
+		name := fmt.Sprintf("com/sun/proxy/$Proxy%d", atomic.AddUint32(&annotationProxyCount, 1))
+		b := &builtinClass{name: name, super: "java/lang/Object", interfaces: []string{c.Name}, flags: AccPublic | AccFinal | AccSuper | AccSynthetic}
+		for _, m := range annotationElements(c) {
+			element := m.Name
+			b.virtual(m.Name, m.Descriptor, func(frame *Frame, args []Value) (Value, error) {
+				return frame.VM.annotationElement(frame, args[0].(*Object), element)
+			})
+		}
+		b.virtual("annotationType", "()Ljava/lang/Class;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.ClassMirror(c)
+		}).
+			virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+				return frame.VM.NewString(annotationOf(args[0]).annotation.String()), nil
+			}).
+			virtual("equals", "(Ljava/lang/Object;)Z", func(frame *Frame, args []Value) (Value, error) {
+				other, ok := args[1].(*Object)
+				return javaBool(ok && other != nil && other.Class == args[0].(*Object).Class &&
+					reflect.DeepEqual(annotationOf(other).annotation, annotationOf(args[0]).annotation)), nil
+			}).
+			virtual("hashCode", "()I", func(frame *Frame, args []Value) (Value, error) {
+				return javaStringHash(javaChars(annotationOf(args[0]).annotation.String())), nil
+			})
+		for _, m := range b.methods {
+			vm.Natives.Register(name, m.name, m.desc, m.fn)
+		}
+		proxy, err := vm.defineBuiltin(b)
+		if err != nil {
+			c.annotationErr = err
+			return
+		}
+		proxy.initState = classInitialized
+		c.annotationProxy = proxy
+	})
+	return c.annotationProxy, c.annotationErr
+}
+
+func annotationOf(v Value) *annotationInstance {
+	inst, _ := v.(*Object).Extra.(*annotationInstance)
+	return inst
+}
+
+// annotationElement returns the value of an element, copying an array so
+// the caller cannot change the annotation.
+func (vm *VirtualMachine) annotationElement(frame *Frame, obj *Object, name string) (Value, error) {
+	inst := annotationOf(obj)
+	for i, e := range inst.annotation.Elements {
+		if e.Name != name {
+			continue
+		}
+		arr, ok := inst.values[i].(*Object)
+		if !ok || arr == nil || arr.Array == nil {
+			return inst.values[i], nil
+		}
+		c := arr.shallowCopy()
+		if err := vm.allocate(frame, c); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return nil, throwOrError(frame, "java/lang/annotation/IncompleteAnnotationException",
+		fmt.Sprintf("%s missing element %s", javaTypeName(inst.annotation.Type), name))
+}
+
+// annotationValue converts an element value to a value of the return type
+// desc of the element.
+func (vm *VirtualMachine) annotationValue(frame *Frame, desc string, v *ElementValue) (Value, error) {
+	var ok bool
+	switch v.Tag {
+	case 's':
+		ok = desc == "Ljava/lang/String;"
+	case 'e':
+		ok = desc == v.EnumType
+	case 'c':
+		ok = desc == "Ljava/lang/Class;"
+	case '@':
+		ok = desc == v.Annotation.Type
+	case '[':
+		ok = desc[0] == '['
+	default:
+		ok = desc == string(v.Tag)
+	}
+	if !ok {
+		return nil, throwOrError(frame, annotationFormatError, fmt.Sprintf("%s cannot hold the element value %s", javaTypeName(desc), v))
+	}
+
+	switch v.Tag {
+	case 's':
+		return vm.intern(v.Const.(string), nil), nil
+	case 'e':
+		c, err := vm.LoadClass(v.EnumType[1 : len(v.EnumType)-1])
+		if err != nil {
+			return nil, err
+		}
+		if err := vm.initializeClass(frame, c); err != nil {
+			return nil, err
+		}
+		f := c.DeclaredField(v.EnumConst, v.EnumType)
+		if f == nil || !f.IsStatic() {
+			return nil, throwOrError(frame, annotationFormatError, fmt.Sprintf("%s has no enum constant %s", javaClassName(c.Name), v.EnumConst))
+		}
+		return loadSlot(c.StaticValues, f.Slot), nil
+	case 'c':
+		return vm.descriptorMirror(v.Class)
+	case '@':
+		c, err := vm.LoadClass(v.Annotation.Type[1 : len(v.Annotation.Type)-1])
+		if err != nil {
+			return nil, err
+		}
+		return vm.annotationObject(frame, c, v.Annotation)
+	case '[':
+		arr, err := vm.newArray(frame, desc, int32(len(v.Values)))
+		if err != nil {
+			return nil, err
+		}
+		for i, e := range v.Values {
+			elem, err := vm.annotationValue(frame, desc[1:], e)
+			if err != nil {
+				return nil, err
+			}
+			if err := vm.arrayStore(frame, arr, int32(i), elem); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return v.Const, nil
+}
@@ -0,0 +1,126 @@
+package jvmgo
+
+// defineBuiltinAtomic defines the classes of java.util.concurrent.atomic. Each
+// keeps its value in a volatile field, updated under the lock of its slot
+// like the Unsafe operations the class library builds them on.
+func defineBuiltinAtomic(s builtinClassSet) {
+	for _, t := range []struct {
+		name string
+		desc string
+	}{
+		{"java/util/concurrent/atomic/AtomicInteger", "I"},
+		{"java/util/concurrent/atomic/AtomicLong", "J"},
+		{"java/util/concurrent/atomic/AtomicBoolean", "Z"},
+		{"java/util/concurrent/atomic/AtomicReference", "Ljava/lang/Object;"},
+	} {
+		desc := t.desc
+		super := "java/lang/Object"
+		if desc == "I" || desc == "J" {
+			super = "java/lang/Number"
+		}
+		c := s.class(t.name, super, "java/io/Serializable").
+			field(AccPrivate|AccVolatile, "value", desc, nil).
+			virtual("<init>", "()V", func(frame *Frame, args []Value) (Value, error) {
+				return nil, nil
+			}).
+			virtual("<init>", "("+desc+")V", func(frame *Frame, args []Value) (Value, error) {
+				atomicUpdate(args[0], func(Value) Value { return args[1] })
+				return nil, nil
+			}).
+			virtual("get", "()"+desc, func(frame *Frame, args []Value) (Value, error) {
+				return atomicGet(args[0]), nil
+			}).
+			virtual("set", "("+desc+")V", func(frame *Frame, args []Value) (Value, error) {
+				atomicUpdate(args[0], func(Value) Value { return args[1] })
+				return nil, nil
+			}).
+			virtual("lazySet", "("+desc+")V", func(frame *Frame, args []Value) (Value, error) {
+				atomicUpdate(args[0], func(Value) Value { return args[1] })
+				return nil, nil
+			}).
+			virtual("getAndSet", "("+desc+")"+desc, func(frame *Frame, args []Value) (Value, error) {
+				return atomicUpdate(args[0], func(Value) Value { return args[1] }), nil
+			}).
+			virtual("compareAndSet", "("+desc+desc+")Z", func(frame *Frame, args []Value) (Value, error) {
+				swapped := false
+				atomicUpdate(args[0], func(old Value) Value {
+					if swapped = sameValue(old, args[1]); swapped {
+						return args[2]
+					}
+					return old
+				})
+				return javaBool(swapped), nil
+			}).
+			virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+				s, err := frame.VM.javaString(frame, desc, atomicGet(args[0]))
+				return frame.VM.NewString(s), err
+			})
+		if desc != "I" && desc != "J" {
+			continue
+		}
+
+		add := func(old Value, delta int64) Value {
+			if desc == "I" {
+				return old.(int32) + int32(delta)
+			}
+			return old.(int64) + delta
+		}
+		long := func(v Value) int64 {
+			if i, ok := v.(int32); ok {
+				return int64(i)
+			}
+			return v.(int64)
+		}
+		for _, m := range []struct {
+			name  string
+			delta int64
+			after bool
+		}{
+			{"getAndIncrement", 1, false}, {"getAndDecrement", -1, false},
+			{"incrementAndGet", 1, true}, {"decrementAndGet", -1, true},
+		} {
+			m := m
+			c.virtual(m.name, "()"+desc, func(frame *Frame, args []Value) (Value, error) {
+				old := atomicUpdate(args[0], func(old Value) Value { return add(old, m.delta) })
+				if m.after {
+					return add(old, m.delta), nil
+				}
+				return old, nil
+			})
+		}
+		c.virtual("getAndAdd", "("+desc+")"+desc, func(frame *Frame, args []Value) (Value, error) {
+			return atomicUpdate(args[0], func(old Value) Value { return add(old, long(args[1])) }), nil
+		}).
+			virtual("addAndGet", "("+desc+")"+desc, func(frame *Frame, args []Value) (Value, error) {
+				old := atomicUpdate(args[0], func(old Value) Value { return add(old, long(args[1])) })
+				return add(old, long(args[1])), nil
+			}).
+			virtual("intValue", "()I", func(frame *Frame, args []Value) (Value, error) {
+				return int32(long(atomicGet(args[0]))), nil
+			}).
+			virtual("longValue", "()J", func(frame *Frame, args []Value) (Value, error) {
+				return long(atomicGet(args[0])), nil
+			}).
+			virtual("floatValue", "()F", func(frame *Frame, args []Value) (Value, error) {
+				return float32(long(atomicGet(args[0]))), nil
+			}).
+			virtual("doubleValue", "()D", func(frame *Frame, args []Value) (Value, error) {
+				return float64(long(atomicGet(args[0]))), nil
+			})
+	}
+}
+
+// atomicGet reads the value field of an atomic.
+func atomicGet(v Value) Value {
+	obj := v.(*Object)
+	slot := obj.Class.LookupField("value", "").Slot
+	return loadSlot(obj.Fields, slot)
+}
+
+// atomicUpdate replaces the value field of an atomic with what fn makes of
+// it and returns the old value.
+func atomicUpdate(v Value, fn func(Value) Value) Value {
+	obj := v.(*Object)
+	slot := obj.Class.LookupField("value", "").Slot
+	return updateSlot(obj.Fields, slot, fn)
+}
@@ -0,0 +1,583 @@
+package jvmgo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	boxClassNames = map[string]string{
+		"Z": "java/lang/Boolean",
+		"B": "java/lang/Byte",
+		"C": "java/lang/Character",
+		"S": "java/lang/Short",
+		"I": "java/lang/Integer",
+		"J": "java/lang/Long",
+		"F": "java/lang/Float",
+		"D": "java/lang/Double",
+	}
+	boxDescriptors = func() map[string]string {
+		descs := map[string]string{}
+		for desc, name := range boxClassNames {
+			descs[name] = desc
+		}
+		return descs
+	}()
+)
+
+type boxKey struct {
+	desc  string
+	value Value
+}
+
+// box wraps v, a primitive of type desc, through the valueOf method of its
+// wrapper class. References are returned unchanged.
+func (vm *VirtualMachine) box(caller *Frame, desc string, v Value) (Value, error) {
+	name, ok := boxClassNames[desc]
+	if !ok {
+		return v, nil
+	}
+	class, err := vm.LoadClass(name)
+	if err != nil {
+		return nil, err
+	}
+	valueOf := class.DeclaredMethod("valueOf", "("+desc+")L"+name+";")
+	if valueOf == nil {
+		return nil, fmt.Errorf("%s.valueOf does not exist", name)
+	}
+	if err := vm.initializeClass(caller, class); err != nil {
+		return nil, err
+	}
+	return vm.invokeMethod(caller, valueOf, []Value{v})
+}
+
+// unbox returns the primitive held by a wrapper object and its descriptor.
+func unbox(v Value) (Value, string, bool) {
+	obj, ok := v.(*Object)
+	if !ok || obj == nil {
+		return nil, "", false
+	}
+	desc, ok := boxDescriptors[obj.ClassName()]
+	if !ok {
+		return nil, "", false
+	}
+	value, ok := obj.GetField("value", desc)
+	return value, desc, ok
+}
+
+// newBox allocates a wrapper object, sharing instances for the values that the
+// valueOf methods cache.
+func (vm *VirtualMachine) newBox(desc string, v Value) (*Object, error) {
+	cached := false
+	switch desc {
+	case "Z", "B":
+		cached = true
+	case "C":
+		cached = v.(int32) <= 127
+	case "S", "I":
+		cached = v.(int32) >= -128 && v.(int32) <= 127
+	case "J":
+		cached = v.(int64) >= -128 && v.(int64) <= 127
+	}
+	key := boxKey{desc: desc, value: v}
+	if cached {
+		vm.boxesMu.Lock()
+		obj, ok := vm.boxes[key]
+		vm.boxesMu.Unlock()
+		if ok {
+			return obj, nil
+		}
+	}
+
+	class, err := vm.LoadClass(boxClassNames[desc])
+	if err != nil {
+		return nil, err
+	}
+	obj := NewObject(class)
+	obj.SetField("value", desc, v)
+	vm.track(obj)
+	if cached {
+		vm.boxesMu.Lock()
+		defer vm.boxesMu.Unlock()
+		if existing, ok := vm.boxes[key]; ok {
+			return existing, nil
+		}
+		vm.boxes[key] = obj
+	}
+	return obj, nil
+}
+
+func defineBuiltinBoxes(s builtinClassSet) {
+	s.class("java/lang/Number", "java/lang/Object").
+		abstract("intValue", "()I").
+		abstract("longValue", "()J").
+		abstract("floatValue", "()F").
+		abstract("doubleValue", "()D").
+		flags |= AccAbstract
+
+	for _, t := range []struct {
+		desc     string
+		min, max Value
+	}{
+		{"B", int32(math.MinInt8), int32(math.MaxInt8)},
+		{"S", int32(math.MinInt16), int32(math.MaxInt16)},
+		{"I", int32(math.MinInt32), int32(math.MaxInt32)},
+		{"J", int64(math.MinInt64), int64(math.MaxInt64)},
+		{"F", float32(math.SmallestNonzeroFloat32), float32(math.MaxFloat32)},
+		{"D", math.SmallestNonzeroFloat64, math.MaxFloat64},
+		{"C", int32(0), int32(math.MaxUint16)},
+		{"Z", nil, nil},
+	} {
+		desc := t.desc
+		name := boxClassNames[desc]
+		super := "java/lang/Number"
+		if desc == "C" || desc == "Z" {
+			super = "java/lang/Object"
+		}
+		c := s.class(name, super, "java/lang/Comparable").
+			field(AccPrivate|AccFinal, "value", desc, nil).
+			virtual("<init>", "("+desc+")V", func(frame *Frame, args []Value) (Value, error) {
+				args[0].(*Object).SetField("value", desc, args[1])
+				return nil, nil
+			}).
+			static("valueOf", "("+desc+")L"+name+";", func(frame *Frame, args []Value) (Value, error) {
+				return frame.VM.newBox(desc, args[0])
+			}).
+			virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+				v, _, _ := unbox(args[0])
+				s, err := frame.VM.javaString(frame, desc, v)
+				return frame.VM.NewString(s), err
+			}).
+			static("toString", "("+desc+")Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+				s, err := frame.VM.javaString(frame, desc, args[0])
+				return frame.VM.NewString(s), err
+			}).
+			virtual("hashCode", "()I", func(frame *Frame, args []Value) (Value, error) {
+				v, _, _ := unbox(args[0])
+				return primitiveHash(desc, v), nil
+			}).
+			static("hashCode", "("+desc+")I", func(frame *Frame, args []Value) (Value, error) {
+				return primitiveHash(desc, args[0]), nil
+			}).
+			virtual("equals", "(Ljava/lang/Object;)Z", func(frame *Frame, args []Value) (Value, error) {
+				a, _, _ := unbox(args[0])
+				b, bdesc, ok := unbox(args[1])
+				return javaBool(ok && bdesc == desc && primitiveCompare(desc, a, b) == 0), nil
+			}).
+			static("compare", "("+desc+desc+")I", func(frame *Frame, args []Value) (Value, error) {
+				return primitiveCompare(desc, args[0], args[1]), nil
+			})
+		compareTo := func(frame *Frame, args []Value) (Value, error) {
+			a, _, _ := unbox(args[0])
+			b, bdesc, ok := unbox(args[1])
+			if !ok || bdesc != desc {
+				if args[1] == nil {
+					return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+				}
+				return nil, throwOrError(frame, "java/lang/ClassCastException", "")
+			}
+			return primitiveCompare(desc, a, b), nil
+		}
+		c.virtual("compareTo", "(L"+name+";)I", compareTo).
+			virtual("compareTo", "(Ljava/lang/Object;)I", compareTo)
+		c.flags |= AccFinal
+
+		switch desc {
+		case "Z":
+			c.field(AccPublic|AccStatic|AccFinal, "TRUE", "Ljava/lang/Boolean;", nil).
+				field(AccPublic|AccStatic|AccFinal, "FALSE", "Ljava/lang/Boolean;", nil).
+				static("<clinit>", "()V", func(frame *Frame, args []Value) (Value, error) {
+					for i, field := range []string{"FALSE", "TRUE"} {
+						b, err := frame.VM.newBox("Z", int32(i))
+						if err != nil {
+							return nil, err
+						}
+						frame.Class.StaticValues[frame.Class.DeclaredField(field, "").Slot] = b
+					}
+					return nil, nil
+				}).
+				virtual("booleanValue", "()Z", func(frame *Frame, args []Value) (Value, error) {
+					v, _, _ := unbox(args[0])
+					return v, nil
+				}).
+				static("parseBoolean", "(Ljava/lang/String;)Z", func(frame *Frame, args []Value) (Value, error) {
+					return javaBool(args[0] != nil && strings.EqualFold(javaStringValue(args[0]), "true")), nil
+				}).
+				static("valueOf", "(Ljava/lang/String;)Ljava/lang/Boolean;", func(frame *Frame, args []Value) (Value, error) {
+					return frame.VM.newBox("Z", javaBool(args[0] != nil && strings.EqualFold(javaStringValue(args[0]), "true")))
+				})
+			continue
+		case "C":
+			defineBuiltinCharacter(c)
+		default:
+			for _, to := range []struct{ name, desc string }{
+				{"byteValue", "B"}, {"shortValue", "S"}, {"intValue", "I"},
+				{"longValue", "J"}, {"floatValue", "F"}, {"doubleValue", "D"},
+			} {
+				to := to
+				c.virtual(to.name, "()"+to.desc, func(frame *Frame, args []Value) (Value, error) {
+					v, _, _ := unbox(args[0])
+					return convertPrimitive(v, to.desc), nil
+				})
+			}
+			parse := func(frame *Frame, s Value, radix int) (Value, error) {
+				return parseNumber(frame, desc, s, radix)
+			}
+			parseName := map[string]string{
+				"B": "parseByte", "S": "parseShort", "I": "parseInt", "J": "parseLong", "F": "parseFloat", "D": "parseDouble",
+			}[desc]
+			c.static(parseName, "(Ljava/lang/String;)"+desc, func(frame *Frame, args []Value) (Value, error) {
+				return parse(frame, args[0], 10)
+			}).
+				static("valueOf", "(Ljava/lang/String;)L"+name+";", func(frame *Frame, args []Value) (Value, error) {
+					v, err := parse(frame, args[0], 10)
+					if err != nil {
+						return nil, err
+					}
+					return frame.VM.newBox(desc, v)
+				})
+			if desc != "F" && desc != "D" {
+				c.static(parseName, "(Ljava/lang/String;I)"+desc, func(frame *Frame, args []Value) (Value, error) {
+					return parse(frame, args[0], int(args[1].(int32)))
+				})
+			}
+		}
+		c.field(AccPublic|AccStatic|AccFinal, "MIN_VALUE", desc, t.min).
+			field(AccPublic|AccStatic|AccFinal, "MAX_VALUE", desc, t.max)
+
+		switch desc {
+		case "I", "J":
+			defineBuiltinIntegerStatics(c, desc)
+		case "F", "D":
+			defineBuiltinFloatStatics(c, desc)
+		}
+	}
+}
+
+func defineBuiltinIntegerStatics(c *builtinClass, desc string) {
+	unsigned := func(v Value) uint64 {
+		if i, ok := v.(int32); ok {
+			return uint64(uint32(i))
+		}
+		return uint64(v.(int64))
+	}
+	for name, base := range map[string]int{"toHexString": 16, "toOctalString": 8, "toBinaryString": 2} {
+		base := base
+		c.static(name, "("+desc+")Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.NewString(strconv.FormatUint(unsigned(args[0]), base)), nil
+		})
+	}
+	c.static("toString", "("+desc+"I)Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+		radix := int(args[1].(int32))
+		if radix < 2 || radix > 36 {
+			radix = 10
+		}
+		return frame.VM.NewString(strconv.FormatInt(convertPrimitive(args[0], "J").(int64), radix)), nil
+	})
+	c.static("sum", "("+desc+desc+")"+desc, func(frame *Frame, args []Value) (Value, error) {
+		if desc == "I" {
+			return args[0].(int32) + args[1].(int32), nil
+		}
+		return args[0].(int64) + args[1].(int64), nil
+	})
+	c.static("max", "("+desc+desc+")"+desc, func(frame *Frame, args []Value) (Value, error) {
+		if primitiveCompare(desc, args[0], args[1]) < 0 {
+			return args[1], nil
+		}
+		return args[0], nil
+	})
+	c.static("min", "("+desc+desc+")"+desc, func(frame *Frame, args []Value) (Value, error) {
+		if primitiveCompare(desc, args[0], args[1]) > 0 {
+			return args[1], nil
+		}
+		return args[0], nil
+	})
+	c.static("bitCount", "("+desc+")I", func(frame *Frame, args []Value) (Value, error) {
+		n := int32(0)
+		for u := unsigned(args[0]); u != 0; u &= u - 1 {
+			n++
+		}
+		return n, nil
+	})
+}
+
+func defineBuiltinFloatStatics(c *builtinClass, desc string) {
+	bits := "I"
+	toBits, fromBits := "floatToIntBits", "intBitsToFloat"
+	if desc == "D" {
+		bits = "J"
+		toBits, fromBits = "doubleToLongBits", "longBitsToDouble"
+	}
+	c.field(AccPublic|AccStatic|AccFinal, "POSITIVE_INFINITY", desc, convertPrimitive(math.Inf(1), desc)).
+		field(AccPublic|AccStatic|AccFinal, "NEGATIVE_INFINITY", desc, convertPrimitive(math.Inf(-1), desc)).
+		field(AccPublic|AccStatic|AccFinal, "NaN", desc, convertPrimitive(math.NaN(), desc)).
+		static(toBits, "("+desc+")"+bits, func(frame *Frame, args []Value) (Value, error) {
+			return canonicalBits(args[0]), nil
+		}).
+		static(fromBits, "("+bits+")"+desc, nil).
+		static("isNaN", "("+desc+")Z", func(frame *Frame, args []Value) (Value, error) {
+			return javaBool(math.IsNaN(convertPrimitive(args[0], "D").(float64))), nil
+		}).
+		static("isInfinite", "("+desc+")Z", func(frame *Frame, args []Value) (Value, error) {
+			return javaBool(math.IsInf(convertPrimitive(args[0], "D").(float64), 0)), nil
+		}).
+		virtual("isNaN", "()Z", func(frame *Frame, args []Value) (Value, error) {
+			v, _, _ := unbox(args[0])
+			return javaBool(math.IsNaN(convertPrimitive(v, "D").(float64))), nil
+		})
+	if desc == "F" {
+		c.static("floatToRawIntBits", "(F)I", nil)
+	} else {
+		c.static("doubleToRawLongBits", "(D)J", nil)
+	}
+}
+
+func defineBuiltinCharacter(c *builtinClass) {
+	predicate := func(pred func(r rune) bool) NativeMethod {
+		return func(frame *Frame, args []Value) (Value, error) {
+			return javaBool(pred(rune(args[0].(int32)))), nil
+		}
+	}
+	mapping := func(fn func(r rune) rune) NativeMethod {
+		return func(frame *Frame, args []Value) (Value, error) {
+			r := fn(rune(args[0].(int32)))
+			if r > 0xffff {
+				return args[0], nil
+			}
+			return int32(r), nil
+		}
+	}
+	c.virtual("charValue", "()C", func(frame *Frame, args []Value) (Value, error) {
+		v, _, _ := unbox(args[0])
+		return v, nil
+	}).
+		static("isDigit", "(C)Z", predicate(unicode.IsDigit)).
+		static("isLetter", "(C)Z", predicate(unicode.IsLetter)).
+		static("isLetterOrDigit", "(C)Z", predicate(func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) })).
+		static("isUpperCase", "(C)Z", predicate(unicode.IsUpper)).
+		static("isLowerCase", "(C)Z", predicate(unicode.IsLower)).
+		static("isWhitespace", "(C)Z", predicate(isJavaWhitespace)).
+		static("toUpperCase", "(C)C", mapping(unicode.ToUpper)).
+		static("toLowerCase", "(C)C", mapping(unicode.ToLower)).
+		static("digit", "(CI)I", func(frame *Frame, args []Value) (Value, error) {
+			d, err := strconv.ParseInt(string(rune(args[0].(int32))), int(args[1].(int32)), 32)
+			if err != nil {
+				return int32(-1), nil
+			}
+			return int32(d), nil
+		})
+}
+
+// isJavaWhitespace is Character.isWhitespace, which excludes no-break spaces.
+func isJavaWhitespace(r rune) bool {
+	switch r {
+	case '\t', '\n', '\v', '\f', '\r', 0x1c, 0x1d, 0x1e, 0x1f:
+		return true
+	case 0xa0, 0x2007, 0x202f:
+		return false
+	}
+	return unicode.In(r, unicode.Zs, unicode.Zl, unicode.Zp)
+}
+
+// convertPrimitive performs the primitive conversion of v to type to, as the
+// i2l, d2i and similar instructions do.
+func convertPrimitive(v Value, to string) Value {
+	var i int64
+	var f float64
+	isFloat := false
+	switch x := v.(type) {
+	case int32:
+		i = int64(x)
+	case int64:
+		i = x
+	case float32:
+		f, isFloat = float64(x), true
+	case float64:
+		f, isFloat = x, true
+	}
+	switch to {
+	case "J":
+		if isFloat {
+			return f2l(f)
+		}
+		return i
+	case "F":
+		if isFloat {
+			return float32(f)
+		}
+		return float32(i)
+	case "D":
+		if isFloat {
+			return f
+		}
+		return float64(i)
+	}
+	n := int32(i)
+	if isFloat {
+		n = f2i(f)
+	}
+	switch to {
+	case "B":
+		return int32(int8(n))
+	case "S":
+		return int32(int16(n))
+	case "C":
+		return int32(uint16(n))
+	}
+	return n
+}
+
+// canonicalBits is Float.floatToIntBits and Double.doubleToLongBits, which
+// collapse all NaNs into one.
+func canonicalBits(v Value) Value {
+	switch f := v.(type) {
+	case float32:
+		if math.IsNaN(float64(f)) {
+			return int32(0x7fc00000)
+		}
+		return int32(math.Float32bits(f))
+	case float64:
+		if math.IsNaN(float64(f)) {
+			return int64(0x7ff8000000000000)
+		}
+		return int64(math.Float64bits(f))
+	}
+	return v
+}
+
+// primitiveHash is the hashCode of the wrapper of v.
+func primitiveHash(desc string, v Value) int32 {
+	switch desc {
+	case "Z":
+		if v.(int32) != 0 {
+			return 1231
+		}
+		return 1237
+	case "J":
+		l := v.(int64)
+		return int32(l ^ int64(uint64(l)>>32))
+	case "F":
+		return canonicalBits(v).(int32)
+	case "D":
+		l := canonicalBits(v).(int64)
+		return int32(l ^ int64(uint64(l)>>32))
+	}
+	return v.(int32)
+}
+
+// primitiveCompare is the static compare method of the wrapper class for desc.
+func primitiveCompare(desc string, a, b Value) int32 {
+	switch desc {
+	case "B", "S", "C":
+		return a.(int32) - b.(int32)
+	case "Z":
+		return javaBool(a.(int32) != 0) - javaBool(b.(int32) != 0)
+	case "F", "D":
+		x, y := convertPrimitive(a, "D").(float64), convertPrimitive(b, "D").(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		xb, yb := convertPrimitive(canonicalBits(a), "J").(int64), convertPrimitive(canonicalBits(b), "J").(int64)
+		switch {
+		case xb < yb:
+			return -1
+		case xb > yb:
+			return 1
+		}
+		return 0
+	}
+	x, y := convertPrimitive(a, "J").(int64), convertPrimitive(b, "J").(int64)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// parseNumber implements Integer.parseInt, Double.parseDouble and their
+// siblings for the primitive type desc.
+func parseNumber(frame *Frame, desc string, v Value, radix int) (Value, error) {
+	if v == nil {
+		if desc == "F" || desc == "D" {
+			return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+		}
+		return nil, throwOrError(frame, "java/lang/NumberFormatException", "Cannot parse null string: null")
+	}
+	s := javaStringValue(v)
+	invalid := func() error {
+		msg := fmt.Sprintf("For input string: %q", s)
+		if radix != 10 {
+			msg += fmt.Sprintf(" under radix %d", radix)
+		}
+		return throwOrError(frame, "java/lang/NumberFormatException", msg)
+	}
+
+	switch desc {
+	case "F", "D":
+		bits := 64
+		if desc == "F" {
+			bits = 32
+		}
+		f, ok := parseJavaFloat(s, bits)
+		if !ok {
+			if strings.TrimSpace(s) == "" {
+				return nil, throwOrError(frame, "java/lang/NumberFormatException", "empty String")
+			}
+			return nil, invalid()
+		}
+		return convertPrimitive(f, desc), nil
+	}
+
+	if radix < 2 || radix > 36 || strings.Contains(s, "_") {
+		return nil, invalid()
+	}
+	size := map[string]int{"B": 8, "S": 16, "I": 32, "J": 64}[desc]
+	n, err := strconv.ParseInt(s, radix, size)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange && size < 32 {
+			return nil, throwOrError(frame, "java/lang/NumberFormatException", fmt.Sprintf("Value out of range. Value:%q Radix:%d", s, radix))
+		}
+		return nil, invalid()
+	}
+	if desc == "J" {
+		return n, nil
+	}
+	return int32(n), nil
+}
+
+// parseJavaFloat parses the syntax Double.parseDouble accepts: surrounding
+// whitespace, an optional type suffix, and the words Infinity and NaN.
+func parseJavaFloat(s string, bits int) (float64, bool) {
+	s = strings.TrimFunc(s, func(r rune) bool { return r <= ' ' })
+	body := strings.TrimLeft(s, "+-")
+	switch body {
+	case "Infinity":
+		if strings.HasPrefix(s, "-") {
+			return math.Inf(-1), len(s) == len(body)+1
+		}
+		return math.Inf(1), len(s) <= len(body)+1
+	case "NaN":
+		return math.NaN(), len(s) <= len(body)+1
+	}
+	if n := len(s); n > 0 && strings.ContainsRune("fFdD", rune(s[n-1])) && !strings.HasPrefix(strings.ToLower(body), "0x") {
+		s = s[:n-1]
+	}
+	if s == "" || strings.ContainsAny(strings.ToLower(s), "_in") {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, bits)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); !ok || ne.Err != strconv.ErrRange {
+			return 0, false
+		}
+	}
+	return f, true
+}
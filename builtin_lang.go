@@ -0,0 +1,872 @@
+package jvmgo
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+func defineBuiltinLang(s builtinClassSet) {
+	s.class("java/lang/Object", "").
+		virtual("<init>", "()V", func(frame *Frame, args []Value) (Value, error) {
+			return nil, nil
+		}).
+		virtual("hashCode", "()I", nil).
+		virtual("getClass", "()Ljava/lang/Class;", nil).
+		virtual("equals", "(Ljava/lang/Object;)Z", func(frame *Frame, args []Value) (Value, error) {
+			return javaBool(sameReference(args[0], args[1])), nil
+		}).
+		virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			obj := args[0].(*Object)
+			h, err := frame.VM.InvokeVirtual(frame, obj, "hashCode", "()I")
+			if err != nil {
+				return nil, err
+			}
+			return frame.VM.NewString(fmt.Sprintf("%s@%x", javaClassName(obj.ClassName()), uint32(h.(int32)))), nil
+		}).
+		method(AccProtected, "clone", "()Ljava/lang/Object;", nil).
+		virtual("notify", "()V", nil).
+		virtual("notifyAll", "()V", nil).
+		virtual("wait", "()V", func(frame *Frame, args []Value) (Value, error) {
+			return nil, frame.VM.wait(frame, args[0].(*Object), 0)
+		}).
+		virtual("wait", "(J)V", nil)
+
+	s.class("java/lang/Class", "java/lang/Object").
+		virtual("getName", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			c, _ := classFromMirror(args[0])
+			return frame.VM.NewString(javaClassName(c.Name)), nil
+		}).
+		virtual("getSimpleName", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			c, _ := classFromMirror(args[0])
+			return frame.VM.NewString(simpleClassName(c.Name)), nil
+		}).
+		virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			c, _ := classFromMirror(args[0])
+			prefix := "class "
+			switch {
+			case c.IsPrimitive():
+				prefix = ""
+			case c.IsInterface():
+				prefix = "interface "
+			}
+			return frame.VM.NewString(prefix + javaClassName(c.Name)), nil
+		}).
+		virtual("isArray", "()Z", nil).
+		virtual("isInterface", "()Z", nil).
+		virtual("isPrimitive", "()Z", nil).
+		virtual("getModifiers", "()I", nil).
+		virtual("getSuperclass", "()Ljava/lang/Class;", nil).
+		virtual("desiredAssertionStatus", "()Z", func(frame *Frame, args []Value) (Value, error) {
+			return int32(0), nil
+		}).
+		virtual("getDeclaredMethods", "()[Ljava/lang/reflect/Method;", func(frame *Frame, args []Value) (Value, error) {
+			c, _ := classFromMirror(args[0])
+			return frame.VM.declaredMembers(frame, c, reflectMethodClass)
+		}).
+		virtual("getDeclaredConstructors", "()[Ljava/lang/reflect/Constructor;", func(frame *Frame, args []Value) (Value, error) {
+			c, _ := classFromMirror(args[0])
+			return frame.VM.declaredMembers(frame, c, reflectConstructorClass)
+		}).
+		virtual("getDeclaredFields", "()[Ljava/lang/reflect/Field;", func(frame *Frame, args []Value) (Value, error) {
+			c, _ := classFromMirror(args[0])
+			return frame.VM.declaredMembers(frame, c, reflectFieldClass)
+		}).
+		virtual("getDeclaredMethod", "(Ljava/lang/String;[Ljava/lang/Class;)Ljava/lang/reflect/Method;", func(frame *Frame, args []Value) (Value, error) {
+			c, _ := classFromMirror(args[0])
+			if isNull(args[1]) {
+				return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+			}
+			return frame.VM.declaredMethod(frame, c, javaStringValue(args[1]), args[2])
+		}).
+		virtual("getDeclaredConstructor", "([Ljava/lang/Class;)Ljava/lang/reflect/Constructor;", func(frame *Frame, args []Value) (Value, error) {
+			c, _ := classFromMirror(args[0])
+			return frame.VM.declaredMethod(frame, c, "<init>", args[1])
+		}).
+		virtual("getDeclaredField", "(Ljava/lang/String;)Ljava/lang/reflect/Field;", func(frame *Frame, args []Value) (Value, error) {
+			c, _ := classFromMirror(args[0])
+			if isNull(args[1]) {
+				return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+			}
+			name := javaStringValue(args[1])
+			f := c.DeclaredField(name, "")
+			if f == nil {
+				return nil, throwOrError(frame, "java/lang/NoSuchFieldException", name)
+			}
+			return frame.VM.reflectObject(frame, reflectFieldClass, f)
+		}).
+		static("getPrimitiveClass", "(Ljava/lang/String;)Ljava/lang/Class;", nil).
+		static("forName", "(Ljava/lang/String;)Ljava/lang/Class;", func(frame *Frame, args []Value) (Value, error) {
+			c, err := frame.VM.LoadClass(binaryClassName(javaStringValue(args[0])))
+			if err != nil {
+				return nil, throwOrError(frame, "java/lang/ClassNotFoundException", javaStringValue(args[0]))
+			}
+			if err := frame.VM.initializeClass(frame, c); err != nil {
+				return nil, err
+			}
+			return frame.VM.ClassMirror(c)
+		})
+
+	s.iface("java/lang/CharSequence").
+		abstract("length", "()I").
+		abstract("toString", "()Ljava/lang/String;")
+	s.iface("java/lang/Comparable").
+		abstract("compareTo", "(Ljava/lang/Object;)I")
+	s.iface("java/lang/Cloneable")
+	s.iface("java/io/Serializable")
+	s.iface("java/lang/Runnable").
+		abstract("run", "()V")
+	s.iface("java/lang/Iterable").
+		abstract("iterator", "()Ljava/util/Iterator;").
+		virtual("forEach", "(Ljava/util/function/Consumer;)V", func(frame *Frame, args []Value) (Value, error) {
+			action, ok := args[1].(*Object)
+			if !ok || action == nil {
+				return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+			}
+			elems, err := frame.VM.collectionElements(frame, args[0])
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range elems {
+				if _, err := frame.VM.InvokeVirtual(frame, action, "accept", "(Ljava/lang/Object;)V", e); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+
+	defineBuiltinString(s)
+	defineBuiltinStringBuilder(s)
+	defineBuiltinMath(s)
+	defineBuiltinSystem(s)
+	defineBuiltinThread(s)
+}
+
+func defineBuiltinString(s builtinClassSet) {
+	str := s.class("java/lang/String", "java/lang/Object", "java/lang/CharSequence", "java/lang/Comparable").
+		field(AccPrivate|AccFinal, "value", "[C", nil).
+		virtual("<init>", "()V", func(frame *Frame, args []Value) (Value, error) {
+			frame.VM.setStringValue(args[0].(*Object), nil)
+			return nil, nil
+		}).
+		virtual("<init>", "(Ljava/lang/String;)V", func(frame *Frame, args []Value) (Value, error) {
+			if isNull(args[1]) {
+				return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+			}
+			frame.VM.setStringValue(args[0].(*Object), stringCharsOf(args[1]))
+			return nil, nil
+		}).
+		virtual("<init>", "([C)V", func(frame *Frame, args []Value) (Value, error) {
+			arr, _ := args[1].(*Object)
+			if arr == nil {
+				return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+			}
+			frame.VM.setStringValue(args[0].(*Object), append([]uint16(nil), arr.Array.([]uint16)...))
+			return nil, nil
+		}).
+		virtual("<init>", "([CII)V", func(frame *Frame, args []Value) (Value, error) {
+			arr, _ := args[1].(*Object)
+			if arr == nil {
+				return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+			}
+			chars := arr.Array.([]uint16)
+			offset, count := int(args[2].(int32)), int(args[3].(int32))
+			if offset < 0 || count < 0 || offset > len(chars)-count {
+				return nil, throwOrError(frame, "java/lang/StringIndexOutOfBoundsException", fmt.Sprintf("offset %d, count %d, length %d", offset, count, len(chars)))
+			}
+			frame.VM.setStringValue(args[0].(*Object), append([]uint16(nil), chars[offset:offset+count]...))
+			return nil, nil
+		}).
+		virtual("length", "()I", func(frame *Frame, args []Value) (Value, error) {
+			return int32(len(stringCharsOf(args[0]))), nil
+		}).
+		virtual("isEmpty", "()Z", func(frame *Frame, args []Value) (Value, error) {
+			return javaBool(len(stringCharsOf(args[0])) == 0), nil
+		}).
+		virtual("charAt", "(I)C", func(frame *Frame, args []Value) (Value, error) {
+			chars, i := stringCharsOf(args[0]), int(args[1].(int32))
+			if i < 0 || i >= len(chars) {
+				return nil, throwOrError(frame, "java/lang/StringIndexOutOfBoundsException", fmt.Sprintf("index %d, length %d", i, len(chars)))
+			}
+			return int32(chars[i]), nil
+		}).
+		virtual("equals", "(Ljava/lang/Object;)Z", func(frame *Frame, args []Value) (Value, error) {
+			if sameReference(args[0], args[1]) {
+				return javaBool(true), nil
+			}
+			other, ok := args[1].(*Object)
+			if !ok || !isString(other) {
+				return javaBool(false), nil
+			}
+			return javaBool(equalChars(stringCharsOf(args[0]), stringCharsOf(other))), nil
+		}).
+		virtual("hashCode", "()I", func(frame *Frame, args []Value) (Value, error) {
+			return javaStringHash(stringCharsOf(args[0])), nil
+		}).
+		virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			return args[0], nil
+		}).
+		virtual("concat", "(Ljava/lang/String;)Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			if isNull(args[1]) {
+				return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+			}
+			a, b := stringCharsOf(args[0]), stringCharsOf(args[1])
+			if len(b) == 0 {
+				return args[0], nil
+			}
+			return frame.VM.newStringOfChars(append(append(make([]uint16, 0, len(a)+len(b)), a...), b...)), nil
+		}).
+		virtual("substring", "(I)Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			return substring(frame, args[0], int(args[1].(int32)), len(stringCharsOf(args[0])))
+		}).
+		virtual("substring", "(II)Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			return substring(frame, args[0], int(args[1].(int32)), int(args[2].(int32)))
+		}).
+		virtual("compareTo", "(Ljava/lang/String;)I", stringCompareTo).
+		virtual("compareTo", "(Ljava/lang/Object;)I", stringCompareTo).
+		virtual("intern", "()Ljava/lang/String;", nil).
+		static("format", "(Ljava/lang/String;[Ljava/lang/Object;)Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			s, err := frame.VM.javaFormat(frame, javaStringValue(args[0]), args[1])
+			if err != nil {
+				return nil, err
+			}
+			return frame.VM.NewString(s), nil
+		})
+	for _, desc := range []string{"Ljava/lang/Object;", "Z", "C", "I", "J", "F", "D"} {
+		desc := desc
+		str.static("valueOf", "("+desc+")Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			s, err := frame.VM.javaString(frame, desc, args[0])
+			if err != nil {
+				return nil, err
+			}
+			return frame.VM.NewString(s), nil
+		})
+	}
+}
+
+// stringCharsOf returns the chars of a String receiver or argument.
+func stringCharsOf(v Value) []uint16 {
+	chars, _ := stringChars(v.(*Object))
+	return chars
+}
+
+func substring(frame *Frame, v Value, begin, end int) (Value, error) {
+	chars := stringCharsOf(v)
+	if begin < 0 || begin > end || end > len(chars) {
+		return nil, throwOrError(frame, "java/lang/StringIndexOutOfBoundsException", fmt.Sprintf("begin %d, end %d, length %d", begin, end, len(chars)))
+	}
+	if begin == 0 && end == len(chars) {
+		return v, nil
+	}
+	return frame.VM.newStringOfChars(append([]uint16(nil), chars[begin:end]...)), nil
+}
+
+func equalChars(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stringCompareTo(frame *Frame, args []Value) (Value, error) {
+	if isNull(args[1]) {
+		return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+	}
+	if !isString(args[1]) {
+		return nil, throwOrError(frame, "java/lang/ClassCastException", "")
+	}
+	a, b := stringCharsOf(args[0]), stringCharsOf(args[1])
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return int32(a[i]) - int32(b[i]), nil
+		}
+	}
+	return int32(len(a) - len(b)), nil
+}
+
+type stringBuilder struct {
+	chars []uint16
+}
+
+func stringBuilderOf(v Value) *stringBuilder {
+	obj := v.(*Object)
+	b, ok := obj.Extra.(*stringBuilder)
+	if !ok {
+		b = &stringBuilder{}
+		obj.Extra = b
+	}
+	return b
+}
+
+func defineBuiltinStringBuilder(s builtinClassSet) {
+	const builder = "java/lang/StringBuilder"
+	sb := s.class(builder, "java/lang/Object", "java/lang/CharSequence").
+		virtual("<init>", "()V", func(frame *Frame, args []Value) (Value, error) {
+			stringBuilderOf(args[0])
+			return nil, nil
+		}).
+		virtual("<init>", "(I)V", func(frame *Frame, args []Value) (Value, error) {
+			if args[1].(int32) < 0 {
+				return nil, throwOrError(frame, "java/lang/NegativeArraySizeException", fmt.Sprint(args[1]))
+			}
+			stringBuilderOf(args[0]).chars = make([]uint16, 0, args[1].(int32))
+			return nil, nil
+		}).
+		virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.NewString(stringFromChars(stringBuilderOf(args[0]).chars)), nil
+		}).
+		virtual("length", "()I", func(frame *Frame, args []Value) (Value, error) {
+			return int32(len(stringBuilderOf(args[0]).chars)), nil
+		}).
+		virtual("charAt", "(I)C", func(frame *Frame, args []Value) (Value, error) {
+			b, i := stringBuilderOf(args[0]), int(args[1].(int32))
+			if i < 0 || i >= len(b.chars) {
+				return nil, throwOrError(frame, "java/lang/StringIndexOutOfBoundsException", fmt.Sprintf("index %d,length %d", i, len(b.chars)))
+			}
+			return int32(b.chars[i]), nil
+		}).
+		virtual("setCharAt", "(IC)V", func(frame *Frame, args []Value) (Value, error) {
+			b, i := stringBuilderOf(args[0]), int(args[1].(int32))
+			if i < 0 || i >= len(b.chars) {
+				return nil, throwOrError(frame, "java/lang/StringIndexOutOfBoundsException", fmt.Sprintf("index %d,length %d", i, len(b.chars)))
+			}
+			b.chars[i] = uint16(args[2].(int32))
+			return nil, nil
+		}).
+		virtual("setLength", "(I)V", func(frame *Frame, args []Value) (Value, error) {
+			b, n := stringBuilderOf(args[0]), int(args[1].(int32))
+			if n < 0 {
+				return nil, throwOrError(frame, "java/lang/StringIndexOutOfBoundsException", fmt.Sprintf("String index out of range: %d", n))
+			}
+			for len(b.chars) < n {
+				b.chars = append(b.chars, 0)
+			}
+			b.chars = b.chars[:n]
+			return nil, nil
+		}).
+		virtual("deleteCharAt", "(I)L"+builder+";", func(frame *Frame, args []Value) (Value, error) {
+			b, i := stringBuilderOf(args[0]), int(args[1].(int32))
+			if i < 0 || i >= len(b.chars) {
+				return nil, throwOrError(frame, "java/lang/StringIndexOutOfBoundsException", fmt.Sprintf("index %d,length %d", i, len(b.chars)))
+			}
+			b.chars = append(b.chars[:i], b.chars[i+1:]...)
+			return args[0], nil
+		}).
+		virtual("insert", "(ILjava/lang/String;)L"+builder+";", func(frame *Frame, args []Value) (Value, error) {
+			b, i := stringBuilderOf(args[0]), int(args[1].(int32))
+			if i < 0 || i > len(b.chars) {
+				return nil, throwOrError(frame, "java/lang/StringIndexOutOfBoundsException", fmt.Sprintf("offset %d, length %d", i, len(b.chars)))
+			}
+			ins := javaChars(javaStringValue(args[2]))
+			b.chars = append(b.chars[:i], append(ins, b.chars[i:]...)...)
+			return args[0], nil
+		}).
+		virtual("indexOf", "(Ljava/lang/String;)I", func(frame *Frame, args []Value) (Value, error) {
+			return int32(indexOfChars(stringBuilderOf(args[0]).chars, javaChars(javaStringValue(args[1])))), nil
+		}).
+		virtual("reverse", "()L"+builder+";", func(frame *Frame, args []Value) (Value, error) {
+			b := stringBuilderOf(args[0])
+			runes := []rune(stringFromChars(b.chars))
+			for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+			}
+			b.chars = javaChars(string(runes))
+			return args[0], nil
+		})
+	for _, desc := range []string{"Ljava/lang/String;", "Ljava/lang/CharSequence;"} {
+		sb.virtual("<init>", "("+desc+")V", func(frame *Frame, args []Value) (Value, error) {
+			s, err := frame.VM.javaString(frame, "Ljava/lang/Object;", args[1])
+			if err != nil {
+				return nil, err
+			}
+			stringBuilderOf(args[0]).chars = javaChars(s)
+			return nil, nil
+		})
+	}
+	for _, desc := range []string{
+		"Ljava/lang/String;", "Ljava/lang/Object;", "Ljava/lang/CharSequence;", "Ljava/lang/StringBuilder;",
+		"Z", "C", "I", "J", "F", "D",
+	} {
+		desc := desc
+		sb.virtual("append", "("+desc+")L"+builder+";", func(frame *Frame, args []Value) (Value, error) {
+			s, err := frame.VM.javaString(frame, desc, args[1])
+			if err != nil {
+				return nil, err
+			}
+			b := stringBuilderOf(args[0])
+			b.chars = append(b.chars, javaChars(s)...)
+			return args[0], nil
+		})
+	}
+	sb.virtual("append", "([C)L"+builder+";", func(frame *Frame, args []Value) (Value, error) {
+		arr, _ := args[1].(*Object)
+		if arr == nil {
+			return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+		}
+		b := stringBuilderOf(args[0])
+		b.chars = append(b.chars, arr.Array.([]uint16)...)
+		return args[0], nil
+	})
+}
+
+func indexOfChars(s, sub []uint16) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		match := true
+		for j := range sub {
+			if s[i+j] != sub[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+func defineBuiltinMath(s builtinClassSet) {
+	m := s.class("java/lang/Math", "java/lang/Object").
+		field(AccPublic|AccStatic|AccFinal, "PI", "D", math.Pi).
+		field(AccPublic|AccStatic|AccFinal, "E", "D", math.E)
+	m.flags |= AccFinal
+	for _, name := range []string{"sqrt", "cbrt", "sin", "cos", "tan", "asin", "acos", "atan", "exp", "log", "log10", "floor", "ceil", "rint"} {
+		m.static(name, "(D)D", nil)
+	}
+	m.static("pow", "(DD)D", nil).static("atan2", "(DD)D", nil)
+
+	for name, fn := range map[string]func(float64) float64{
+		"sinh":      math.Sinh,
+		"cosh":      math.Cosh,
+		"tanh":      math.Tanh,
+		"log1p":     math.Log1p,
+		"expm1":     math.Expm1,
+		"toRadians": func(x float64) float64 { return x / 180 * math.Pi },
+		"toDegrees": func(x float64) float64 { return x * 180 / math.Pi },
+		"abs":       math.Abs,
+		"signum": func(x float64) float64 {
+			if x == 0 || math.IsNaN(x) {
+				return x
+			}
+			return math.Copysign(1, x)
+		},
+	} {
+		fn := fn
+		m.static(name, "(D)D", func(frame *Frame, args []Value) (Value, error) {
+			return fn(args[0].(float64)), nil
+		})
+	}
+	m.static("hypot", "(DD)D", func(frame *Frame, args []Value) (Value, error) {
+		return math.Hypot(args[0].(float64), args[1].(float64)), nil
+	})
+	m.static("random", "()D", func(frame *Frame, args []Value) (Value, error) {
+		return rand.Float64(), nil
+	})
+	m.static("signum", "(F)F", func(frame *Frame, args []Value) (Value, error) {
+		x := args[0].(float32)
+		if x == 0 || math.IsNaN(float64(x)) {
+			return x, nil
+		}
+		return float32(math.Copysign(1, float64(x))), nil
+	})
+	m.static("round", "(F)I", func(frame *Frame, args []Value) (Value, error) {
+		return f2i(javaRound(float64(args[0].(float32)))), nil
+	})
+	m.static("round", "(D)J", func(frame *Frame, args []Value) (Value, error) {
+		return f2l(javaRound(args[0].(float64))), nil
+	})
+
+	m.static("abs", "(I)I", func(frame *Frame, args []Value) (Value, error) {
+		if x := args[0].(int32); x < 0 {
+			return -x, nil
+		}
+		return args[0], nil
+	})
+	m.static("abs", "(J)J", func(frame *Frame, args []Value) (Value, error) {
+		if x := args[0].(int64); x < 0 {
+			return -x, nil
+		}
+		return args[0], nil
+	})
+	m.static("abs", "(F)F", func(frame *Frame, args []Value) (Value, error) {
+		return float32(math.Abs(float64(args[0].(float32)))), nil
+	})
+	m.static("max", "(II)I", func(frame *Frame, args []Value) (Value, error) {
+		if a, b := args[0].(int32), args[1].(int32); a < b {
+			return b, nil
+		}
+		return args[0], nil
+	})
+	m.static("min", "(II)I", func(frame *Frame, args []Value) (Value, error) {
+		if a, b := args[0].(int32), args[1].(int32); a > b {
+			return b, nil
+		}
+		return args[0], nil
+	})
+	m.static("max", "(JJ)J", func(frame *Frame, args []Value) (Value, error) {
+		if a, b := args[0].(int64), args[1].(int64); a < b {
+			return b, nil
+		}
+		return args[0], nil
+	})
+	m.static("min", "(JJ)J", func(frame *Frame, args []Value) (Value, error) {
+		if a, b := args[0].(int64), args[1].(int64); a > b {
+			return b, nil
+		}
+		return args[0], nil
+	})
+	m.static("max", "(FF)F", func(frame *Frame, args []Value) (Value, error) {
+		return float32(math.Max(float64(args[0].(float32)), float64(args[1].(float32)))), nil
+	})
+	m.static("min", "(FF)F", func(frame *Frame, args []Value) (Value, error) {
+		return float32(math.Min(float64(args[0].(float32)), float64(args[1].(float32)))), nil
+	})
+	m.static("max", "(DD)D", func(frame *Frame, args []Value) (Value, error) {
+		return math.Max(args[0].(float64), args[1].(float64)), nil
+	})
+	m.static("min", "(DD)D", func(frame *Frame, args []Value) (Value, error) {
+		return math.Min(args[0].(float64), args[1].(float64)), nil
+	})
+
+	m.static("floorDiv", "(II)I", func(frame *Frame, args []Value) (Value, error) {
+		a, b := args[0].(int32), args[1].(int32)
+		if b == 0 {
+			return nil, throwOrError(frame, "java/lang/ArithmeticException", "/ by zero")
+		}
+		if b == -1 {
+			return -a, nil
+		}
+		q := a / b
+		if (a%b != 0) && ((a < 0) != (b < 0)) {
+			q--
+		}
+		return q, nil
+	})
+	m.static("floorDiv", "(JJ)J", func(frame *Frame, args []Value) (Value, error) {
+		a, b := args[0].(int64), args[1].(int64)
+		if b == 0 {
+			return nil, throwOrError(frame, "java/lang/ArithmeticException", "/ by zero")
+		}
+		if b == -1 {
+			return -a, nil
+		}
+		q := a / b
+		if (a%b != 0) && ((a < 0) != (b < 0)) {
+			q--
+		}
+		return q, nil
+	})
+	m.static("floorMod", "(II)I", func(frame *Frame, args []Value) (Value, error) {
+		a, b := args[0].(int32), args[1].(int32)
+		if b == 0 {
+			return nil, throwOrError(frame, "java/lang/ArithmeticException", "/ by zero")
+		}
+		if b == -1 {
+			return int32(0), nil
+		}
+		r := a % b
+		if r != 0 && ((r < 0) != (b < 0)) {
+			r += b
+		}
+		return r, nil
+	})
+	m.static("floorMod", "(JJ)J", func(frame *Frame, args []Value) (Value, error) {
+		a, b := args[0].(int64), args[1].(int64)
+		if b == 0 {
+			return nil, throwOrError(frame, "java/lang/ArithmeticException", "/ by zero")
+		}
+		if b == -1 {
+			return int64(0), nil
+		}
+		r := a % b
+		if r != 0 && ((r < 0) != (b < 0)) {
+			r += b
+		}
+		return r, nil
+	})
+	m.static("addExact", "(II)I", func(frame *Frame, args []Value) (Value, error) {
+		r := int64(args[0].(int32)) + int64(args[1].(int32))
+		if r != int64(int32(r)) {
+			return nil, throwOrError(frame, "java/lang/ArithmeticException", "integer overflow")
+		}
+		return int32(r), nil
+	})
+	m.static("multiplyExact", "(II)I", func(frame *Frame, args []Value) (Value, error) {
+		r := int64(args[0].(int32)) * int64(args[1].(int32))
+		if r != int64(int32(r)) {
+			return nil, throwOrError(frame, "java/lang/ArithmeticException", "integer overflow")
+		}
+		return int32(r), nil
+	})
+	m.static("addExact", "(JJ)J", func(frame *Frame, args []Value) (Value, error) {
+		a, b := args[0].(int64), args[1].(int64)
+		r := a + b
+		if (a > 0 && b > 0 && r < 0) || (a < 0 && b < 0 && r >= 0) {
+			return nil, throwOrError(frame, "java/lang/ArithmeticException", "long overflow")
+		}
+		return r, nil
+	})
+	m.static("multiplyExact", "(JJ)J", func(frame *Frame, args []Value) (Value, error) {
+		a, b := args[0].(int64), args[1].(int64)
+		r := a * b
+		if a != 0 && (r/a != b || (a == -1 && b == math.MinInt64)) {
+			return nil, throwOrError(frame, "java/lang/ArithmeticException", "long overflow")
+		}
+		return r, nil
+	})
+}
+
+// javaRound rounds half up like Math.round, leaving the conversion to the
+// integer type to the caller.
+func javaRound(x float64) float64 {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return x
+	}
+	f := math.Floor(x)
+	if x-f >= 0.5 {
+		f++
+	}
+	return f
+}
+
+func defineBuiltinSystem(s builtinClassSet) {
+	sys := s.class("java/lang/System", "java/lang/Object").
+		field(AccPublic|AccStatic|AccFinal, "out", "Ljava/io/PrintStream;", nil).
+		field(AccPublic|AccStatic|AccFinal, "err", "Ljava/io/PrintStream;", nil).
+		static("<clinit>", "()V", func(frame *Frame, args []Value) (Value, error) {
+			vm := frame.VM
+			out, err := vm.newPrintStream(writerFunc(func(p []byte) (int, error) { return vm.write(vm.Out, p) }))
+			if err != nil {
+				return nil, err
+			}
+			errStream, err := vm.newPrintStream(writerFunc(func(p []byte) (int, error) { return vm.write(vm.Err, p) }))
+			if err != nil {
+				return nil, err
+			}
+			frame.Class.StaticValues[frame.Class.DeclaredField("out", "").Slot] = out
+			frame.Class.StaticValues[frame.Class.DeclaredField("err", "").Slot] = errStream
+			return nil, nil
+		}).
+		static("currentTimeMillis", "()J", nil).
+		static("nanoTime", "()J", nil).
+		static("identityHashCode", "(Ljava/lang/Object;)I", nil).
+		static("arraycopy", "(Ljava/lang/Object;ILjava/lang/Object;II)V", nil).
+		static("exit", "(I)V", func(frame *Frame, args []Value) (Value, error) {
+			return nil, &ExitError{Code: int(args[0].(int32))}
+		}).
+		static("gc", "()V", func(frame *Frame, args []Value) (Value, error) {
+			frame.VM.collect(frame.VM.currentThread(frame), false)
+			return nil, nil
+		}).
+		static("lineSeparator", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.NewString("\n"), nil
+		}).
+		static("getenv", "(Ljava/lang/String;)Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			v, ok := os.LookupEnv(javaStringValue(args[0]))
+			if !ok {
+				return nil, nil
+			}
+			return frame.VM.NewString(v), nil
+		}).
+		static("getProperty", "(Ljava/lang/String;)Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			return systemProperty(frame.VM, javaStringValue(args[0]), nil), nil
+		}).
+		static("getProperty", "(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			return systemProperty(frame.VM, javaStringValue(args[0]), args[1]), nil
+		})
+	sys.flags |= AccFinal
+
+	rt := s.class("java/lang/Runtime", "java/lang/Object").
+		field(AccPrivate|AccStatic, "currentRuntime", "Ljava/lang/Runtime;", nil).
+		static("<clinit>", "()V", func(frame *Frame, args []Value) (Value, error) {
+			frame.Class.StaticValues[frame.Class.DeclaredField("currentRuntime", "").Slot] = NewObject(frame.Class)
+			return nil, nil
+		}).
+		static("getRuntime", "()Ljava/lang/Runtime;", func(frame *Frame, args []Value) (Value, error) {
+			return loadSlot(frame.Class.StaticValues, frame.Class.DeclaredField("currentRuntime", "").Slot), nil
+		}).
+		virtual("availableProcessors", "()I", nil).
+		virtual("maxMemory", "()J", nil).
+		virtual("totalMemory", "()J", nil).
+		virtual("freeMemory", "()J", nil).
+		virtual("gc", "()V", nil)
+	rt.flags |= AccFinal
+
+	ps := s.class("java/io/PrintStream", "java/lang/Object").
+		virtual("flush", "()V", func(frame *Frame, args []Value) (Value, error) {
+			return nil, nil
+		})
+	for _, desc := range []string{
+		"()V", "(Ljava/lang/String;)V", "(Ljava/lang/Object;)V",
+		"(I)V", "(J)V", "(F)V", "(D)V", "(Z)V", "(C)V", "([C)V",
+	} {
+		ps.virtual("println", desc, nil)
+		if desc != "()V" {
+			ps.virtual("print", desc, nil)
+		}
+	}
+	for _, name := range []string{"printf", "format"} {
+		ps.virtual(name, "(Ljava/lang/String;[Ljava/lang/Object;)Ljava/io/PrintStream;", func(frame *Frame, args []Value) (Value, error) {
+			s, err := frame.VM.javaFormat(frame, javaStringValue(args[1]), args[2])
+			if err != nil {
+				return nil, err
+			}
+			return args[0], printStreamOf(frame, args[0]).print(s)
+		})
+	}
+}
+
+func (vm *VirtualMachine) newPrintStream(w writerFunc) (*Object, error) {
+	class, err := vm.LoadClass("java/io/PrintStream")
+	if err != nil {
+		return nil, err
+	}
+	obj := NewObject(class)
+	obj.Extra = PrintStream{w: w}
+	return obj, nil
+}
+
+func systemProperty(vm *VirtualMachine, key string, def Value) Value {
+	for _, p := range systemProperties(vm) {
+		if p[0] == key {
+			return vm.NewString(p[1])
+		}
+	}
+	return def
+}
+
+// simpleClassName is Class.getSimpleName for a binary class name.
+func simpleClassName(name string) string {
+	if strings.HasPrefix(name, "[") {
+		component := name[1:]
+		if strings.HasPrefix(component, "L") {
+			component = component[1 : len(component)-1]
+		} else if p, ok := primitiveNames[component]; ok {
+			component = p
+		}
+		return simpleClassName(component) + "[]"
+	}
+	if i := strings.LastIndexAny(name, "/$"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+func javaBool(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func defineBuiltinThread(s builtinClassSet) {
+	const (
+		str      = "Ljava/lang/String;"
+		runnable = "Ljava/lang/Runnable;"
+	)
+	init := func(obj *Object, frame *Frame, target, name Value) {
+		if name == nil {
+			n := atomic.AddInt32(&frame.VM.threadNumber, 1) - 1
+			name = frame.VM.NewString(fmt.Sprintf("Thread-%d", n))
+		}
+		obj.SetField("name", str, name)
+		obj.SetField("target", runnable, target)
+		obj.SetField("priority", "I", int32(5))
+		if current, err := frame.VM.currentThreadObject(frame); err == nil && current != nil {
+			// a new thread is a daemon when the thread creating it is
+			daemon, _ := current.GetField("daemon", "Z")
+			obj.SetField("daemon", "Z", daemon)
+		}
+	}
+	s.class("java/lang/Thread", "java/lang/Object", "java/lang/Runnable").
+		field(AccPrivate|AccVolatile, "name", str, nil).
+		field(AccPrivate, "priority", "I", nil).
+		field(AccPrivate, "daemon", "Z", nil).
+		field(AccPrivate, "target", runnable, nil).
+		field(AccPrivate|AccVolatile, "threadStatus", "I", nil).
+		virtual("<init>", "()V", func(frame *Frame, args []Value) (Value, error) {
+			init(args[0].(*Object), frame, nil, nil)
+			return nil, nil
+		}).
+		virtual("<init>", "("+runnable+")V", func(frame *Frame, args []Value) (Value, error) {
+			init(args[0].(*Object), frame, args[1], nil)
+			return nil, nil
+		}).
+		virtual("<init>", "("+runnable+str+")V", func(frame *Frame, args []Value) (Value, error) {
+			init(args[0].(*Object), frame, args[1], args[2])
+			return nil, nil
+		}).
+		virtual("<init>", "("+str+")V", func(frame *Frame, args []Value) (Value, error) {
+			init(args[0].(*Object), frame, nil, args[1])
+			return nil, nil
+		}).
+		virtual("start", "()V", func(frame *Frame, args []Value) (Value, error) {
+			return nil, frame.VM.startThread(frame, args[0].(*Object))
+		}).
+		virtual("run", "()V", func(frame *Frame, args []Value) (Value, error) {
+			target, _ := args[0].(*Object).GetField("target", runnable)
+			if t, ok := target.(*Object); ok && t != nil {
+				return frame.VM.InvokeVirtual(frame, t, "run", "()V")
+			}
+			return nil, nil
+		}).
+		virtual("join", "()V", func(frame *Frame, args []Value) (Value, error) {
+			return nil, frame.VM.join(frame, args[0].(*Object), 0)
+		}).
+		virtual("join", "(J)V", nil).
+		virtual("interrupt", "()V", func(frame *Frame, args []Value) (Value, error) {
+			if t := frame.VM.threadOf(args[0].(*Object)); t != nil {
+				t.interrupt()
+			}
+			return nil, nil
+		}).
+		virtual("isInterrupted", "()Z", func(frame *Frame, args []Value) (Value, error) {
+			t := frame.VM.threadOf(args[0].(*Object))
+			return javaBool(t != nil && t.isInterrupted(false)), nil
+		}).
+		static("interrupted", "()Z", func(frame *Frame, args []Value) (Value, error) {
+			return javaBool(frame.VM.currentThread(frame).isInterrupted(true)), nil
+		}).
+		virtual("isAlive", "()Z", nil).
+		virtual("setDaemon", "(Z)V", func(frame *Frame, args []Value) (Value, error) {
+			obj := args[0].(*Object)
+			if t := frame.VM.threadOf(obj); t != nil && t.alive() {
+				return nil, throwOrError(frame, "java/lang/IllegalThreadStateException", "")
+			}
+			obj.SetField("daemon", "Z", args[1])
+			return nil, nil
+		}).
+		virtual("isDaemon", "()Z", func(frame *Frame, args []Value) (Value, error) {
+			v, _ := args[0].(*Object).GetField("daemon", "Z")
+			return v, nil
+		}).
+		virtual("getName", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			v, _ := args[0].(*Object).GetField("name", str)
+			return v, nil
+		}).
+		virtual("setName", "("+str+")V", func(frame *Frame, args []Value) (Value, error) {
+			if isNull(args[1]) {
+				return nil, throwOrError(frame, "java/lang/NullPointerException", "name cannot be null")
+			}
+			args[0].(*Object).SetField("name", str, args[1])
+			return nil, nil
+		}).
+		virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			obj := args[0].(*Object)
+			name, _ := obj.GetField("name", str)
+			priority, _ := obj.GetField("priority", "I")
+			return frame.VM.NewString(fmt.Sprintf("Thread[%s,%d,main]", javaStringValue(name), priority)), nil
+		}).
+		static("currentThread", "()Ljava/lang/Thread;", nil).
+		static("sleep", "(J)V", nil).
+		static("yield", "()V", nil).
+		static("holdsLock", "(Ljava/lang/Object;)Z", nil)
+}
@@ -0,0 +1,116 @@
+package jvmgo
+
+// defineBuiltinRef defines the classes of java.lang.ref. The collector finds
+// references by their class and clears the referent field itself, so the
+// classes only read and write it.
+func defineBuiltinRef(s builtinClassSet) {
+	const (
+		object   = "Ljava/lang/Object;"
+		queue    = "Ljava/lang/ref/ReferenceQueue;"
+		ref      = "Ljava/lang/ref/Reference;"
+		runnable = "Ljava/lang/Runnable;"
+	)
+	init := func(frame *Frame, args []Value) (Value, error) {
+		obj := args[0].(*Object)
+		storeSlot(obj.Fields, obj.Class.referentSlot, args[1])
+		if len(args) > 2 {
+			storeSlot(obj.Fields, obj.Class.queueSlot, args[2])
+		}
+		return nil, nil
+	}
+	clear := func(v Value) *Object {
+		obj := v.(*Object)
+		storeSlot(obj.Fields, obj.Class.referentSlot, nil)
+		return obj
+	}
+
+	s.class("java/lang/ref/Reference", "java/lang/Object").
+		field(AccPrivate, "referent", object, nil).
+		field(AccVolatile, "queue", queue, nil).
+		virtual("get", "()"+object, func(frame *Frame, args []Value) (Value, error) {
+			obj := args[0].(*Object)
+			return loadSlot(obj.Fields, obj.Class.referentSlot), nil
+		}).
+		virtual("refersTo", "("+object+")Z", func(frame *Frame, args []Value) (Value, error) {
+			obj := args[0].(*Object)
+			return javaBool(sameReference(loadSlot(obj.Fields, obj.Class.referentSlot), args[1])), nil
+		}).
+		virtual("clear", "()V", func(frame *Frame, args []Value) (Value, error) {
+			clear(args[0])
+			return nil, nil
+		}).
+		virtual("enqueue", "()Z", func(frame *Frame, args []Value) (Value, error) {
+			return javaBool(enqueueReference(clear(args[0]))), nil
+		}).
+		flags |= AccAbstract
+	for _, name := range []string{"java/lang/ref/SoftReference", "java/lang/ref/WeakReference"} {
+		s.class(name, "java/lang/ref/Reference").
+			virtual("<init>", "("+object+")V", init).
+			virtual("<init>", "("+object+queue+")V", init)
+	}
+	s.class("java/lang/ref/PhantomReference", "java/lang/ref/Reference").
+		virtual("<init>", "("+object+queue+")V", init).
+		virtual("get", "()"+object, func(frame *Frame, args []Value) (Value, error) {
+			return nil, nil
+		})
+
+	s.class("java/lang/ref/ReferenceQueue", "java/lang/Object").
+		virtual("<init>", "()V", func(frame *Frame, args []Value) (Value, error) {
+			refQueueOf(args[0])
+			return nil, nil
+		}).
+		virtual("poll", "()"+ref, func(frame *Frame, args []Value) (Value, error) {
+			ref, _ := refQueueOf(args[0]).poll()
+			return ref, nil
+		}).
+		virtual("remove", "()"+ref, func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.removeReference(frame, refQueueOf(args[0]), 0)
+		}).
+		virtual("remove", "(J)"+ref, func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.removeReference(frame, refQueueOf(args[0]), args[1].(int64))
+		})
+
+	// a Cleaner runs the action of a cleanable on the finalizer thread once
+	// its object is phantom reachable
+	s.class("java/lang/ref/Cleaner", "java/lang/Object").
+		static("create", "()Ljava/lang/ref/Cleaner;", func(frame *Frame, args []Value) (Value, error) {
+			class, err := frame.VM.LoadClass("java/lang/ref/Cleaner")
+			if err != nil {
+				return nil, err
+			}
+			cleaner := NewObject(class)
+			return cleaner, frame.VM.allocate(frame, cleaner)
+		}).
+		virtual("register", "("+object+runnable+")Ljava/lang/ref/Cleaner$Cleanable;", func(frame *Frame, args []Value) (Value, error) {
+			if args[1] == nil || args[2] == nil {
+				return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+			}
+			class, err := frame.VM.LoadClass("jdk/internal/ref/CleanerImpl$PhantomCleanableRef")
+			if err != nil {
+				return nil, err
+			}
+			cleanable := NewObject(class)
+			if err := frame.VM.allocate(frame, cleanable); err != nil {
+				return nil, err
+			}
+			cleanable.Fields[class.referentSlot] = args[1]
+			cleanable.SetField("action", runnable, args[2])
+			frame.VM.heap.addCleanable(cleanable)
+			return cleanable, nil
+		}).
+		flags |= AccFinal
+	s.iface("java/lang/ref/Cleaner$Cleanable").
+		abstract("clean", "()V")
+	s.class("jdk/internal/ref/CleanerImpl$PhantomCleanableRef", "java/lang/ref/PhantomReference", "java/lang/ref/Cleaner$Cleanable").
+		field(AccPrivate|AccFinal, "action", runnable, nil).
+		virtual("clean", "()V", func(frame *Frame, args []Value) (Value, error) {
+			if !frame.VM.heap.removeCleanable(args[0].(*Object)) {
+				return nil, nil
+			}
+			obj := clear(args[0])
+			action, _ := obj.GetField("action", runnable)
+			obj.SetField("action", runnable, nil)
+			_, err := frame.VM.InvokeVirtual(frame, action.(*Object), "run", "()V")
+			return nil, err
+		})
+}
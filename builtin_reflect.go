@@ -0,0 +1,540 @@
+package jvmgo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The java.lang.reflect objects of the bundled runtime hold the member they
+// reflect in Extra, like Class mirrors hold their RuntimeClass.
+const (
+	reflectMethodClass      = "java/lang/reflect/Method"
+	reflectFieldClass       = "java/lang/reflect/Field"
+	reflectConstructorClass = "java/lang/reflect/Constructor"
+)
+
+// primitiveWidenings lists the types each primitive widens to, JLS §5.1.2.
+var primitiveWidenings = map[string]string{
+	"B": "SIJFD",
+	"S": "IJFD",
+	"C": "IJFD",
+	"I": "JFD",
+	"J": "FD",
+	"F": "D",
+}
+
+// modifierNames is the order in which java.lang.reflect.Modifier.toString
+// lists modifiers.
+var modifierNames = []struct {
+	flag uint16
+	name string
+}{
+	{AccPublic, "public"},
+	{AccProtected, "protected"},
+	{AccPrivate, "private"},
+	{AccAbstract, "abstract"},
+	{AccStatic, "static"},
+	{AccFinal, "final"},
+	{AccTransient, "transient"},
+	{AccVolatile, "volatile"},
+	{AccSynchronized, "synchronized"},
+	{AccNative, "native"},
+	{AccStrict, "strictfp"},
+}
+
+const (
+	fieldModifiers       = AccPublic | AccProtected | AccPrivate | AccStatic | AccFinal | AccTransient | AccVolatile
+	methodModifiers      = AccPublic | AccProtected | AccPrivate | AccAbstract | AccStatic | AccFinal | AccSynchronized | AccNative | AccStrict
+	constructorModifiers = AccPublic | AccProtected | AccPrivate
+)
+
+func defineBuiltinReflect(s builtinClassSet) {
+	s.class("java/lang/reflect/AccessibleObject", "java/lang/Object").
+		field(AccPrivate, "override", "Z", nil).
+		virtual("setAccessible", "(Z)V", func(frame *Frame, args []Value) (Value, error) {
+			args[0].(*Object).SetField("override", "Z", args[1])
+			return nil, nil
+		}).
+		virtual("isAccessible", "()Z", func(frame *Frame, args []Value) (Value, error) {
+			v, _ := args[0].(*Object).GetField("override", "Z")
+			return v, nil
+		})
+	s.iface("java/lang/reflect/Member").
+		abstract("getDeclaringClass", "()Ljava/lang/Class;").
+		abstract("getName", "()Ljava/lang/String;").
+		abstract("getModifiers", "()I")
+
+	defineReflectMember(s.class(reflectMethodClass, "java/lang/reflect/AccessibleObject", "java/lang/reflect/Member"), methodModifiers).
+		virtual("getReturnType", "()Ljava/lang/Class;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.descriptorMirror(reflectedMethod(args[0]).Desc.Return)
+		}).
+		virtual("invoke", "(Ljava/lang/Object;[Ljava/lang/Object;)Ljava/lang/Object;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.reflectInvoke(frame, args[0].(*Object), args[1], args[2])
+		})
+	defineReflectMember(s.class(reflectConstructorClass, "java/lang/reflect/AccessibleObject", "java/lang/reflect/Member"), constructorModifiers).
+		virtual("newInstance", "([Ljava/lang/Object;)Ljava/lang/Object;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.reflectNewInstance(frame, args[0].(*Object), args[1])
+		})
+	defineReflectMember(s.class(reflectFieldClass, "java/lang/reflect/AccessibleObject", "java/lang/reflect/Member"), fieldModifiers).
+		virtual("getType", "()Ljava/lang/Class;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.descriptorMirror(reflectedField(args[0]).Descriptor)
+		}).
+		virtual("get", "(Ljava/lang/Object;)Ljava/lang/Object;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.reflectGet(frame, args[0].(*Object), args[1])
+		}).
+		virtual("set", "(Ljava/lang/Object;Ljava/lang/Object;)V", func(frame *Frame, args []Value) (Value, error) {
+			return nil, frame.VM.reflectSet(frame, args[0].(*Object), args[1], args[2])
+		})
+
+	defineThrowableConstructors(s.class("java/lang/reflect/InvocationTargetException", "java/lang/ReflectiveOperationException").
+		virtual("getTargetException", "()Ljava/lang/Throwable;", func(frame *Frame, args []Value) (Value, error) {
+			v, _ := args[0].(*Object).GetField("cause", "Ljava/lang/Throwable;")
+			return v, nil
+		}))
+}
+
+// defineReflectMember declares the methods Method, Constructor and Field
+// share, which all describe the reflected member.
+func defineReflectMember(c *builtinClass, modifiers uint16) *builtinClass {
+	isField := c.name == reflectFieldClass
+	c.virtual("getDeclaringClass", "()Ljava/lang/Class;", func(frame *Frame, args []Value) (Value, error) {
+		return frame.VM.ClassMirror(reflectedClass(args[0]))
+	}).
+		virtual("getName", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			if isField {
+				return frame.VM.NewString(reflectedField(args[0]).Name), nil
+			}
+			m := reflectedMethod(args[0])
+			if m.Name == "<init>" {
+				return frame.VM.NewString(javaClassName(m.Class.Name)), nil
+			}
+			return frame.VM.NewString(m.Name), nil
+		}).
+		virtual("getModifiers", "()I", func(frame *Frame, args []Value) (Value, error) {
+			return int32(reflectedFlags(args[0]) & modifiers), nil
+		}).
+		virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.NewString(reflectString(args[0].(*Object), modifiers)), nil
+		}).
+		virtual("equals", "(Ljava/lang/Object;)Z", func(frame *Frame, args []Value) (Value, error) {
+			other, ok := args[1].(*Object)
+			return javaBool(ok && other != nil && other.Class == args[0].(*Object).Class && other.Extra == args[0].(*Object).Extra), nil
+		}).
+		virtual("hashCode", "()I", func(frame *Frame, args []Value) (Value, error) {
+			name := reflectedMethod(args[0]).Name
+			if isField {
+				name = reflectedField(args[0]).Name
+			}
+			return javaStringHash(javaChars(javaClassName(reflectedClass(args[0]).Name))) ^ javaStringHash(javaChars(name)), nil
+		})
+	if !isField {
+		c.virtual("getParameterTypes", "()[Ljava/lang/Class;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.parameterMirrors(frame, reflectedMethod(args[0]))
+		}).
+			virtual("getParameterCount", "()I", func(frame *Frame, args []Value) (Value, error) {
+				return int32(len(reflectedMethod(args[0]).Desc.Parameters)), nil
+			})
+	}
+	return c
+}
+
+func reflectedMethod(v Value) *RuntimeMethod {
+	m, _ := v.(*Object).Extra.(*RuntimeMethod)
+	return m
+}
+
+func reflectedField(v Value) *RuntimeField {
+	f, _ := v.(*Object).Extra.(*RuntimeField)
+	return f
+}
+
+func reflectedClass(v Value) *RuntimeClass {
+	if f := reflectedField(v); f != nil {
+		return f.Class
+	}
+	return reflectedMethod(v).Class
+}
+
+func reflectedFlags(v Value) uint16 {
+	if f := reflectedField(v); f != nil {
+		return f.AccessFlags
+	}
+	return reflectedMethod(v).AccessFlags
+}
+
+// reflectString formats a member like the toString methods of
+// java.lang.reflect, e.g. "public static int Main.add(int,int)".
+func reflectString(obj *Object, modifiers uint16) string {
+	var b strings.Builder
+	flags := reflectedFlags(obj) & modifiers
+	for _, m := range modifierNames {
+		if flags&m.flag != 0 {
+			b.WriteString(m.name + " ")
+		}
+	}
+	if f := reflectedField(obj); f != nil {
+		b.WriteString(javaTypeName(f.Descriptor) + " " + javaClassName(f.Class.Name) + "." + f.Name)
+		return b.String()
+	}
+	m := reflectedMethod(obj)
+	if m.Name == "<init>" {
+		b.WriteString(javaClassName(m.Class.Name))
+	} else {
+		b.WriteString(javaTypeName(m.Desc.Return) + " " + javaClassName(m.Class.Name) + "." + m.Name)
+	}
+	params := make([]string, len(m.Desc.Parameters))
+	for i, p := range m.Desc.Parameters {
+		params[i] = javaTypeName(p)
+	}
+	b.WriteString("(" + strings.Join(params, ",") + ")")
+	return b.String()
+}
+
+// descriptorMirror returns the Class mirror of the type a field descriptor
+// names.
+func (vm *VirtualMachine) descriptorMirror(desc string) (*Object, error) {
+	var c *RuntimeClass
+	var err error
+	switch {
+	case desc[0] == 'L':
+		c, err = vm.classOrStub(desc[1 : len(desc)-1])
+	case desc[0] == '[':
+		c, err = vm.LoadClass(desc)
+	default:
+		c, err = vm.primitiveClass(primitiveNames[desc])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vm.ClassMirror(c)
+}
+
+// classDescriptor returns the field descriptor of the type c.
+func classDescriptor(c *RuntimeClass) string {
+	switch {
+	case c.IsPrimitive():
+		return primitiveDescriptors[c.Name]
+	case c.IsArray():
+		return c.Name
+	}
+	return "L" + c.Name + ";"
+}
+
+func (vm *VirtualMachine) parameterMirrors(frame *Frame, m *RuntimeMethod) (*Object, error) {
+	arr, err := vm.newArray(frame, "[Ljava/lang/Class;", int32(len(m.Desc.Parameters)))
+	if err != nil {
+		return nil, err
+	}
+	elems := arr.Array.([]Value)
+	for i, p := range m.Desc.Parameters {
+		if elems[i], err = vm.descriptorMirror(p); err != nil {
+			return nil, err
+		}
+	}
+	return arr, nil
+}
+
+// reflectObject returns a new instance of the java.lang.reflect class
+// className reflecting member.
+func (vm *VirtualMachine) reflectObject(frame *Frame, className string, member interface{}) (*Object, error) {
+	class, err := vm.LoadClass(className)
+	if err != nil {
+		return nil, err
+	}
+	obj := NewObject(class)
+	obj.Extra = member
+	if err := vm.allocate(frame, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// declaredMembers is Class.getDeclaredMethods, getDeclaredConstructors and
+// getDeclaredFields: the members c declares, in class file order.
+func (vm *VirtualMachine) declaredMembers(frame *Frame, c *RuntimeClass, className string) (*Object, error) {
+	var members []interface{}
+	if className == reflectFieldClass {
+		for _, f := range c.Fields {
+			members = append(members, f)
+		}
+	} else {
+		for _, m := range c.Methods {
+			if (m.Name == "<init>") == (className == reflectConstructorClass) && m.Name != "<clinit>" {
+				members = append(members, m)
+			}
+		}
+	}
+	arr, err := vm.newArray(frame, "[L"+className+";", int32(len(members)))
+	if err != nil {
+		return nil, err
+	}
+	elems := arr.Array.([]Value)
+	for i, m := range members {
+		if elems[i], err = vm.reflectObject(frame, className, m); err != nil {
+			return nil, err
+		}
+	}
+	return arr, nil
+}
+
+// declaredMethod is Class.getDeclaredMethod and getDeclaredConstructor,
+// which find a method by its name and parameter types.
+func (vm *VirtualMachine) declaredMethod(frame *Frame, c *RuntimeClass, name string, types Value) (Value, error) {
+	var params []string
+	if arr, ok := types.(*Object); ok && arr != nil {
+		for _, t := range arr.Array.([]Value) {
+			tc, ok := classFromMirror(t)
+			if !ok {
+				return nil, throwOrError(frame, "java/lang/NoSuchMethodException", "null parameter type")
+			}
+			params = append(params, classDescriptor(tc))
+		}
+	}
+	for _, m := range c.Methods {
+		if m.Name == name && strings.HasPrefix(m.Descriptor, "("+strings.Join(params, "")+")") {
+			className := reflectMethodClass
+			if name == "<init>" {
+				className = reflectConstructorClass
+			}
+			return vm.reflectObject(frame, className, m)
+		}
+	}
+	javaParams := make([]string, len(params))
+	for i, p := range params {
+		javaParams[i] = javaTypeName(p)
+	}
+	return nil, throwOrError(frame, "java/lang/NoSuchMethodException", fmt.Sprintf("%s.%s(%s)", javaClassName(c.Name), name, strings.Join(javaParams, ",")))
+}
+
+// checkReflectAccess throws IllegalAccessException unless the code calling
+// the reflective operation may access the member, or setAccessible(true)
+// suppressed the check.
+func (vm *VirtualMachine) checkReflectAccess(frame *Frame, obj *Object) error {
+	if override, _ := obj.GetField("override", "Z"); override == int32(1) {
+		return nil
+	}
+	declaring, flags := reflectedClass(obj), reflectedFlags(obj)
+	var caller *RuntimeClass
+	for f := frame.Caller; f != nil && caller == nil; f = f.Caller {
+		caller = f.Class
+	}
+	switch {
+	case flags&AccPublic != 0 && declaring.AccessFlags&AccPublic != 0,
+		caller == nil, caller == declaring:
+		return nil
+	case flags&AccPrivate != 0:
+		if vm.nestHost(caller) == vm.nestHost(declaring) {
+			return nil
+		}
+	case packageName(caller.Name) == packageName(declaring.Name):
+		return nil
+	case flags&AccProtected != 0 && caller.IsSubclassOf(declaring):
+		return nil
+	}
+	var mods []string
+	for _, m := range modifierNames {
+		if flags&m.flag != 0 && m.flag&(AccPublic|AccProtected|AccPrivate|AccStatic|AccFinal) != 0 {
+			mods = append(mods, m.name)
+		}
+	}
+	return throwOrError(frame, "java/lang/IllegalAccessException", fmt.Sprintf("class %s cannot access a member of class %s with modifiers \"%s\"",
+		javaClassName(caller.Name), javaClassName(declaring.Name), strings.Join(mods, " ")))
+}
+
+// reflectArgs converts the arguments of Method.invoke and
+// Constructor.newInstance to the parameter types of m, unboxing and widening
+// primitives.
+func (vm *VirtualMachine) reflectArgs(frame *Frame, m *RuntimeMethod, argArray Value) ([]Value, error) {
+	var given []Value
+	if arr, ok := argArray.(*Object); ok && arr != nil {
+		given = arr.Array.([]Value)
+	}
+	if len(given) != len(m.Desc.Parameters) {
+		return nil, throwOrError(frame, "java/lang/IllegalArgumentException", fmt.Sprintf("wrong number of arguments: %d expected: %d", len(given), len(m.Desc.Parameters)))
+	}
+	args := make([]Value, len(given))
+	for i, p := range m.Desc.Parameters {
+		v, ok, err := vm.reflectValue(p, given[i])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/IllegalArgumentException", "argument type mismatch")
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+// reflectValue converts v to a value of type desc, unboxing and widening a
+// primitive, and reports whether the conversion is allowed.
+func (vm *VirtualMachine) reflectValue(desc string, v Value) (Value, bool, error) {
+	if desc[0] == 'L' || desc[0] == '[' {
+		obj, _ := v.(*Object)
+		if obj == nil {
+			return nil, true, nil
+		}
+		name := desc
+		if desc[0] == 'L' {
+			name = desc[1 : len(desc)-1]
+		}
+		t, err := vm.classOrStub(name)
+		if err != nil {
+			return nil, false, err
+		}
+		return obj, obj.Class.IsAssignableTo(t), nil
+	}
+	value, from, ok := unbox(v)
+	if !ok || (from != desc && !strings.Contains(primitiveWidenings[from], desc)) {
+		return nil, false, nil
+	}
+	return convertPrimitive(value, desc), true, nil
+}
+
+// reflectTarget checks the receiver of an instance member, or initializes
+// the declaring class of a static one.
+func (vm *VirtualMachine) reflectTarget(frame *Frame, declaring *RuntimeClass, static bool, receiver Value) (*Object, error) {
+	if static {
+		return nil, vm.initializeClass(frame, declaring)
+	}
+	obj, _ := receiver.(*Object)
+	if obj == nil {
+		return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+	}
+	if !obj.Class.IsAssignableTo(declaring) {
+		return nil, throwOrError(frame, "java/lang/IllegalArgumentException", "object is not an instance of declaring class")
+	}
+	return obj, nil
+}
+
+// invocationTarget wraps an exception thrown by a reflectively invoked
+// method into an InvocationTargetException.
+func (vm *VirtualMachine) invocationTarget(frame *Frame, err error) error {
+	ex, ok := err.(*JavaException)
+	if !ok {
+		return err
+	}
+	wrapped := vm.throwNew(frame, "java/lang/reflect/InvocationTargetException", "")
+	if w, ok := wrapped.(*JavaException); ok {
+		w.Object.SetField("detailMessage", "Ljava/lang/String;", nil)
+		w.Object.SetField("cause", "Ljava/lang/Throwable;", ex.Object)
+	}
+	return wrapped
+}
+
+// reflectInvoke is Method.invoke: it dispatches an instance method on the
+// class of the receiver and boxes a primitive result.
+func (vm *VirtualMachine) reflectInvoke(frame *Frame, method *Object, receiver, argArray Value) (Value, error) {
+	m := reflectedMethod(method)
+	if err := vm.checkReflectAccess(frame, method); err != nil {
+		return nil, err
+	}
+	obj, err := vm.reflectTarget(frame, m.Class, m.IsStatic(), receiver)
+	if err != nil {
+		return nil, err
+	}
+	args, err := vm.reflectArgs(frame, m, argArray)
+	if err != nil {
+		return nil, err
+	}
+	target := m
+	if obj != nil {
+		args = append([]Value{obj}, args...)
+		if !m.IsPrivate() {
+			if target, err = vm.selectMethod(frame, obj.Class, m); err != nil {
+				return nil, err
+			}
+		}
+	}
+	ret, err := vm.invokeMethod(frame, target, args)
+	if err != nil {
+		return nil, vm.invocationTarget(frame, err)
+	}
+	if m.Desc.Return == "V" {
+		return nil, nil
+	}
+	return vm.box(frame, m.Desc.Return, ret)
+}
+
+// reflectNewInstance is Constructor.newInstance.
+func (vm *VirtualMachine) reflectNewInstance(frame *Frame, ctor *Object, argArray Value) (Value, error) {
+	m := reflectedMethod(ctor)
+	if m.Class.AccessFlags&(AccInterface|AccAbstract) != 0 {
+		return nil, throwOrError(frame, "java/lang/InstantiationException", javaClassName(m.Class.Name))
+	}
+	if err := vm.checkReflectAccess(frame, ctor); err != nil {
+		return nil, err
+	}
+	args, err := vm.reflectArgs(frame, m, argArray)
+	if err != nil {
+		return nil, err
+	}
+	if err := vm.initializeClass(frame, m.Class); err != nil {
+		return nil, err
+	}
+	obj := NewObject(m.Class)
+	if err := vm.allocate(frame, obj); err != nil {
+		return nil, err
+	}
+	if _, err := vm.invokeMethod(frame, m, append([]Value{obj}, args...)); err != nil {
+		return nil, vm.invocationTarget(frame, err)
+	}
+	return obj, nil
+}
+
+// reflectGet is Field.get, which boxes a primitive value.
+func (vm *VirtualMachine) reflectGet(frame *Frame, field *Object, receiver Value) (Value, error) {
+	f := reflectedField(field)
+	if err := vm.checkReflectAccess(frame, field); err != nil {
+		return nil, err
+	}
+	obj, err := vm.reflectTarget(frame, f.Class, f.IsStatic(), receiver)
+	if err != nil {
+		return nil, err
+	}
+	values := f.Class.StaticValues
+	if obj != nil {
+		values = obj.Fields
+	}
+	return vm.box(frame, f.Descriptor, loadSlot(values, f.Slot))
+}
+
+// reflectSet is Field.set, which unboxes and widens a primitive value. Final
+// fields are only writable after setAccessible(true), and static final ones
+// never.
+func (vm *VirtualMachine) reflectSet(frame *Frame, field *Object, receiver, value Value) error {
+	f := reflectedField(field)
+	if err := vm.checkReflectAccess(frame, field); err != nil {
+		return err
+	}
+	if f.AccessFlags&AccFinal != 0 {
+		if override, _ := field.GetField("override", "Z"); override != int32(1) || f.IsStatic() {
+			return throwOrError(frame, "java/lang/IllegalAccessException", fmt.Sprintf("Can not set final %s field %s.%s", javaTypeName(f.Descriptor), javaClassName(f.Class.Name), f.Name))
+		}
+	}
+	obj, err := vm.reflectTarget(frame, f.Class, f.IsStatic(), receiver)
+	if err != nil {
+		return err
+	}
+	v, ok, err := vm.reflectValue(f.Descriptor, value)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return throwOrError(frame, "java/lang/IllegalArgumentException", fmt.Sprintf("Can not set %s field %s.%s to %s",
+			javaTypeName(f.Descriptor), javaClassName(f.Class.Name), f.Name, valueTypeName(value)))
+	}
+	values := f.Class.StaticValues
+	if obj != nil {
+		values = obj.Fields
+	}
+	storeSlot(values, f.Slot, v)
+	return nil
+}
+
+func valueTypeName(v Value) string {
+	if obj, ok := v.(*Object); ok && obj != nil {
+		return javaClassName(obj.ClassName())
+	}
+	return "null value"
+}
@@ -0,0 +1,165 @@
+package jvmgo
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachine_ExecMain_BuiltinRuntime(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printObj := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/Object;)V")
+	printStr := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+	printBool := b.methodRef("java/io/PrintStream", "println", "(Z)V")
+	intValueOf := b.methodRef("java/lang/Integer", "valueOf", "(I)Ljava/lang/Integer;")
+	doubleValueOf := b.methodRef("java/lang/Double", "valueOf", "(D)Ljava/lang/Double;")
+	parseDouble := b.methodRef("java/lang/Double", "parseDouble", "(Ljava/lang/String;)D")
+	arrayList := b.classRef("java/util/ArrayList")
+	arrayListInit := b.methodRef("java/util/ArrayList", "<init>", "()V")
+	arrayListAdd := b.methodRef("java/util/ArrayList", "add", "(Ljava/lang/Object;)Z")
+	listAdd := b.interfaceMethodRef("java/util/List", "add", "(Ljava/lang/Object;)Z")
+	hashMap := b.classRef("java/util/HashMap")
+	hashMapInit := b.methodRef("java/util/HashMap", "<init>", "()V")
+	hashMapPut := b.methodRef("java/util/HashMap", "put", "(Ljava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object;")
+	mapPut := b.interfaceMethodRef("java/util/Map", "put", "(Ljava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object;")
+	sb := b.classRef("java/lang/StringBuilder")
+	sbInit := b.methodRef("java/lang/StringBuilder", "<init>", "()V")
+	appendStr := b.methodRef("java/lang/StringBuilder", "append", "(Ljava/lang/String;)Ljava/lang/StringBuilder;")
+	appendInt := b.methodRef("java/lang/StringBuilder", "append", "(I)Ljava/lang/StringBuilder;")
+	appendChar := b.methodRef("java/lang/StringBuilder", "append", "(C)Ljava/lang/StringBuilder;")
+	sbToString := b.methodRef("java/lang/StringBuilder", "toString", "()Ljava/lang/String;")
+	object := b.classRef("java/lang/Object")
+	format := b.methodRef("java/lang/String", "format", "(Ljava/lang/String;[Ljava/lang/Object;)Ljava/lang/String;")
+	arithmetic := b.classRef("java/lang/ArithmeticException")
+	getMessage := b.methodRef("java/lang/Throwable", "getMessage", "()Ljava/lang/String;")
+	x, a, bKey, n, pi, pattern := b.str("x"), b.str("a"), b.str("b"), b.str("n="), b.str("3.14159"), b.str("%05d|%-4s|%.2f")
+	ab := b.str("ab")
+
+	code := newAsm().
+		// ArrayList
+		ref(OpCodeNew, arrayList).op(OpCodeDup).ref(OpCodeInvokeSpecial, arrayListInit).op(OpCodeAstore0+1).
+		op(OpCodeAload0+1).op(OpCodeIconst0+3).ref(OpCodeInvokeStatic, intValueOf).ref(OpCodeInvokeVirtual, arrayListAdd).op(OpCodePop).
+		op(OpCodeAload0+1).op(OpCodeLdc, lo(x)).op(OpCodeInvokeInterface, hi(listAdd), lo(listAdd), 2, 0).op(OpCodePop).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).ref(OpCodeInvokeVirtual, printObj).
+		// HashMap
+		ref(OpCodeNew, hashMap).op(OpCodeDup).ref(OpCodeInvokeSpecial, hashMapInit).op(OpCodeAstore0+2).
+		op(OpCodeAload0+2).op(OpCodeLdc, lo(bKey)).op(OpCodeIconst0+2).ref(OpCodeInvokeStatic, intValueOf).
+		op(OpCodeInvokeInterface, hi(mapPut), lo(mapPut), 3, 0).op(OpCodePop).
+		op(OpCodeAload0+2).op(OpCodeLdc, lo(a)).op(OpCodeIconst0+1).ref(OpCodeInvokeStatic, intValueOf).
+		ref(OpCodeInvokeVirtual, hashMapPut).op(OpCodePop).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+2).ref(OpCodeInvokeVirtual, printObj).
+		// StringBuilder
+		ref(OpCodeGetStatic, out).
+		ref(OpCodeNew, sb).op(OpCodeDup).ref(OpCodeInvokeSpecial, sbInit).
+		op(OpCodeLdc, lo(n)).ref(OpCodeInvokeVirtual, appendStr).
+		op(OpCodeBipush, 42).ref(OpCodeInvokeVirtual, appendInt).
+		op(OpCodeBipush, '!').ref(OpCodeInvokeVirtual, appendChar).
+		ref(OpCodeInvokeVirtual, sbToString).ref(OpCodeInvokeVirtual, printStr).
+		// String.format
+		ref(OpCodeGetStatic, out).op(OpCodeLdc, lo(pattern)).
+		op(OpCodeIconst0+3).ref(OpCodeANewArray, object).
+		op(OpCodeDup).op(OpCodeIconst0).op(OpCodeBipush, 42).ref(OpCodeInvokeStatic, intValueOf).op(OpCodeAastore).
+		op(OpCodeDup).op(OpCodeIconst0+1).op(OpCodeLdc, lo(ab)).op(OpCodeAastore).
+		op(OpCodeDup).op(OpCodeIconst0+2).op(OpCodeLdc, lo(pi)).ref(OpCodeInvokeStatic, parseDouble).
+		ref(OpCodeInvokeStatic, doubleValueOf).op(OpCodeAastore).
+		ref(OpCodeInvokeStatic, format).ref(OpCodeInvokeVirtual, printStr).
+		// Integer cache identity
+		ref(OpCodeGetStatic, out).
+		op(OpCodeBipush, 127).ref(OpCodeInvokeStatic, intValueOf).
+		op(OpCodeBipush, 127).ref(OpCodeInvokeStatic, intValueOf).
+		branch(OpCodeIfAcmpne, "differ").op(OpCodeIconst0+1).branch(OpCodeGoto, "print").
+		label("differ").op(OpCodeIconst0).
+		label("print").ref(OpCodeInvokeVirtual, printBool).
+		// ArithmeticException from the interpreter
+		label("try").op(OpCodeIconst0+1).op(OpCodeIconst0).op(OpCodeIdiv).op(OpCodePop).
+		label("end").op(OpCodeReturn).
+		label("catch").op(OpCodeAstore0+3).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+3).ref(OpCodeInvokeVirtual, getMessage).ref(OpCodeInvokeVirtual, printStr).
+		op(OpCodeReturn)
+	body := code.bytes()
+	b.methodWithHandlers(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 8, 4, body, []*Exception{
+		{StartPC: code.pc("try"), EndPC: code.pc("end"), HandlerPC: code.pc("catch"), CatchType: arithmetic},
+	})
+
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "[3, x]\n{a=1, b=2}\nn=42!\n00042|ab  |3.14\ntrue\n/ by zero\n", stdout.String())
+}
+
+func TestVirtualMachine_ExecMain_BuiltinUncaught(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	illegalState := b.classRef("java/lang/IllegalStateException")
+	init := b.methodRef("java/lang/IllegalStateException", "<init>", "(Ljava/lang/String;)V")
+	boom := b.str("boom")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 3, 1, newAsm().
+		ref(OpCodeNew, illegalState).op(OpCodeDup).op(OpCodeLdc, lo(boom)).ref(OpCodeInvokeSpecial, init).
+		op(OpCodeAThrow).bytes()...)
+
+	vm := NewVM(b.build())
+	err := vm.ExecMain()
+	var ex *JavaException
+	require.True(t, errors.As(err, &ex))
+	require.Equal(t, "java/lang/IllegalStateException", ex.Object.ClassName())
+	require.Contains(t, err.Error(), "boom")
+}
+
+func TestVirtualMachine_ExecMain_SystemExit(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	exit := b.methodRef("java/lang/System", "exit", "(I)V")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 1, 1, newAsm().
+		op(OpCodeIconst0+3).ref(OpCodeInvokeStatic, exit).op(OpCodeReturn).bytes()...)
+
+	vm := NewVM(b.build())
+	err := vm.ExecMain()
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, 3, exitErr.Code)
+}
+
+func TestBuiltinNatives(t *testing.T) {
+	vm := NewVM(newClassBuilder("Main", "java/lang/Object").build())
+	call := func(class, name, desc string, args ...Value) (Value, error) {
+		fn, ok := vm.Natives.Lookup(class, name, desc)
+		require.True(t, ok, "%s.%s%s", class, name, desc)
+		return fn(nil, args)
+	}
+
+	v, err := call("java/lang/Integer", "parseInt", "(Ljava/lang/String;)I", vm.NewString("-42"))
+	require.NoError(t, err)
+	require.Equal(t, int32(-42), v)
+
+	_, err = call("java/lang/Integer", "parseInt", "(Ljava/lang/String;)I", vm.NewString("abc"))
+	require.EqualError(t, err, `java/lang/NumberFormatException: For input string: "abc"`)
+
+	v, err = call("java/lang/Double", "parseDouble", "(Ljava/lang/String;)D", vm.NewString(" 1.5d "))
+	require.NoError(t, err)
+	require.Equal(t, 1.5, v)
+
+	v, err = call("java/lang/Double", "compare", "(DD)I", 0.0, negativeZero())
+	require.NoError(t, err)
+	require.Equal(t, int32(1), v)
+
+	args, err := vm.newObjectArray("java/lang/Object", []Value{
+		mustBox(t, vm, "I", int32(1234567)), mustBox(t, vm, "I", int32(-1)), nil,
+	})
+	require.NoError(t, err)
+	s, err := vm.javaFormat(nil, "%,d %x %s%n", args)
+	require.NoError(t, err)
+	require.Equal(t, "1,234,567 ffffffff null\n", s)
+}
+
+func negativeZero() float64 {
+	zero := 0.0
+	return -zero
+}
+
+func mustBox(t *testing.T, vm *VirtualMachine, desc string, v Value) Value {
+	boxed, err := vm.newBox(desc, v)
+	require.NoError(t, err)
+	return boxed
+}
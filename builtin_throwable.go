@@ -0,0 +1,155 @@
+package jvmgo
+
+// builtinThrowables lists the bundled Throwable classes with their superclass.
+var builtinThrowables = [][2]string{
+	{"java/lang/Exception", "java/lang/Throwable"},
+	{"java/lang/Error", "java/lang/Throwable"},
+	{"java/lang/RuntimeException", "java/lang/Exception"},
+	{"java/lang/ArithmeticException", "java/lang/RuntimeException"},
+	{"java/lang/ArrayStoreException", "java/lang/RuntimeException"},
+	{"java/lang/ClassCastException", "java/lang/RuntimeException"},
+	{"java/lang/IllegalArgumentException", "java/lang/RuntimeException"},
+	{"java/lang/NumberFormatException", "java/lang/IllegalArgumentException"},
+	{"java/lang/IllegalStateException", "java/lang/RuntimeException"},
+	{"java/lang/IllegalMonitorStateException", "java/lang/RuntimeException"},
+	{"java/lang/IndexOutOfBoundsException", "java/lang/RuntimeException"},
+	{"java/lang/ArrayIndexOutOfBoundsException", "java/lang/IndexOutOfBoundsException"},
+	{"java/lang/StringIndexOutOfBoundsException", "java/lang/IndexOutOfBoundsException"},
+	{"java/lang/NegativeArraySizeException", "java/lang/RuntimeException"},
+	{"java/lang/NullPointerException", "java/lang/RuntimeException"},
+	{"java/lang/UnsupportedOperationException", "java/lang/RuntimeException"},
+	{"java/lang/ClassNotFoundException", "java/lang/ReflectiveOperationException"},
+	{"java/lang/ReflectiveOperationException", "java/lang/Exception"},
+	{"java/lang/IllegalAccessException", "java/lang/ReflectiveOperationException"},
+	{"java/lang/InstantiationException", "java/lang/ReflectiveOperationException"},
+	{"java/lang/NoSuchFieldException", "java/lang/ReflectiveOperationException"},
+	{"java/lang/NoSuchMethodException", "java/lang/ReflectiveOperationException"},
+	{"java/lang/CloneNotSupportedException", "java/lang/Exception"},
+	{"java/lang/InterruptedException", "java/lang/Exception"},
+	{"java/lang/IllegalThreadStateException", "java/lang/IllegalArgumentException"},
+	{"java/util/NoSuchElementException", "java/lang/RuntimeException"},
+	{"java/util/ConcurrentModificationException", "java/lang/RuntimeException"},
+	{"java/util/IllegalFormatException", "java/lang/IllegalArgumentException"},
+	{"java/util/IllegalFormatConversionException", "java/util/IllegalFormatException"},
+	{"java/util/MissingFormatArgumentException", "java/util/IllegalFormatException"},
+	{"java/util/UnknownFormatConversionException", "java/util/IllegalFormatException"},
+	{"java/io/IOException", "java/lang/Exception"},
+	{"java/lang/AssertionError", "java/lang/Error"},
+	{"java/lang/LinkageError", "java/lang/Error"},
+	{"java/lang/NoClassDefFoundError", "java/lang/LinkageError"},
+	{"java/lang/ExceptionInInitializerError", "java/lang/LinkageError"},
+	{"java/lang/BootstrapMethodError", "java/lang/LinkageError"},
+	{"java/lang/UnsatisfiedLinkError", "java/lang/LinkageError"},
+	{"java/lang/VerifyError", "java/lang/LinkageError"},
+	{"java/lang/IncompatibleClassChangeError", "java/lang/LinkageError"},
+	{"java/lang/AbstractMethodError", "java/lang/IncompatibleClassChangeError"},
+	{"java/lang/IllegalAccessError", "java/lang/IncompatibleClassChangeError"},
+	{"java/lang/NoSuchFieldError", "java/lang/IncompatibleClassChangeError"},
+	{"java/lang/NoSuchMethodError", "java/lang/IncompatibleClassChangeError"},
+	{"java/lang/VirtualMachineError", "java/lang/Error"},
+	{"java/lang/InternalError", "java/lang/VirtualMachineError"},
+	{"java/lang/OutOfMemoryError", "java/lang/VirtualMachineError"},
+	{"java/lang/StackOverflowError", "java/lang/VirtualMachineError"},
+}
+
+func defineBuiltinThrowables(s builtinClassSet) {
+	t := s.class("java/lang/Throwable", "java/lang/Object").
+		field(AccPrivate, "detailMessage", "Ljava/lang/String;", nil).
+		field(AccPrivate, "cause", "Ljava/lang/Throwable;", nil).
+		virtual("getMessage", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			v, _ := args[0].(*Object).GetField("detailMessage", "Ljava/lang/String;")
+			return v, nil
+		}).
+		virtual("getLocalizedMessage", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.InvokeVirtual(frame, args[0].(*Object), "getMessage", "()Ljava/lang/String;")
+		}).
+		virtual("getCause", "()Ljava/lang/Throwable;", func(frame *Frame, args []Value) (Value, error) {
+			v, _ := args[0].(*Object).GetField("cause", "Ljava/lang/Throwable;")
+			return v, nil
+		}).
+		virtual("initCause", "(Ljava/lang/Throwable;)Ljava/lang/Throwable;", func(frame *Frame, args []Value) (Value, error) {
+			obj := args[0].(*Object)
+			if cause, _ := obj.GetField("cause", "Ljava/lang/Throwable;"); cause != nil {
+				return nil, throwOrError(frame, "java/lang/IllegalStateException", "Can't overwrite cause")
+			}
+			if sameReference(args[0], args[1]) {
+				return nil, throwOrError(frame, "java/lang/IllegalArgumentException", "Self-causation not permitted")
+			}
+			obj.SetField("cause", "Ljava/lang/Throwable;", args[1])
+			return obj, nil
+		}).
+		virtual("fillInStackTrace", "()Ljava/lang/Throwable;", func(frame *Frame, args []Value) (Value, error) {
+			return args[0], nil
+		}).
+		virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			s, err := frame.VM.throwableString(frame, args[0].(*Object))
+			return frame.VM.NewString(s), err
+		}).
+		virtual("printStackTrace", "()V", func(frame *Frame, args []Value) (Value, error) {
+			seen := map[*Object]bool{}
+			for ex, prefix := args[0].(*Object), ""; ex != nil && !seen[ex]; prefix = "Caused by: " {
+				seen[ex] = true
+				s, err := frame.VM.throwableString(frame, ex)
+				if err != nil {
+					return nil, err
+				}
+				if _, err := frame.VM.write(frame.VM.Err, []byte(prefix+s+"\n")); err != nil {
+					return nil, err
+				}
+				cause, _ := ex.GetField("cause", "Ljava/lang/Throwable;")
+				ex, _ = cause.(*Object)
+			}
+			return nil, nil
+		})
+	defineThrowableConstructors(t)
+
+	for _, e := range builtinThrowables {
+		defineThrowableConstructors(s.class(e[0], e[1]))
+	}
+}
+
+func defineThrowableConstructors(c *builtinClass) {
+	const (
+		message = "Ljava/lang/String;"
+		cause   = "Ljava/lang/Throwable;"
+	)
+	c.virtual("<init>", "()V", func(frame *Frame, args []Value) (Value, error) {
+		return nil, nil
+	}).
+		virtual("<init>", "("+message+")V", func(frame *Frame, args []Value) (Value, error) {
+			args[0].(*Object).SetField("detailMessage", message, args[1])
+			return nil, nil
+		}).
+		virtual("<init>", "("+message+cause+")V", func(frame *Frame, args []Value) (Value, error) {
+			obj := args[0].(*Object)
+			obj.SetField("detailMessage", message, args[1])
+			obj.SetField("cause", cause, args[2])
+			return nil, nil
+		}).
+		virtual("<init>", "("+cause+")V", func(frame *Frame, args []Value) (Value, error) {
+			obj := args[0].(*Object)
+			obj.SetField("cause", cause, args[1])
+			if c, ok := args[1].(*Object); ok && c != nil {
+				s, err := frame.VM.javaString(frame, cause, c)
+				if err != nil {
+					return nil, err
+				}
+				obj.SetField("detailMessage", message, frame.VM.NewString(s))
+			}
+			return nil, nil
+		})
+}
+
+// throwableString is Throwable.toString: the class name followed by the
+// localized message if there is one.
+func (vm *VirtualMachine) throwableString(caller *Frame, ex *Object) (string, error) {
+	msg, err := vm.InvokeVirtual(caller, ex, "getLocalizedMessage", "()Ljava/lang/String;")
+	if err != nil {
+		return "", err
+	}
+	name := javaClassName(ex.ClassName())
+	if msg == nil {
+		return name, nil
+	}
+	return name + ": " + javaStringValue(msg), nil
+}
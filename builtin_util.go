@@ -0,0 +1,678 @@
+package jvmgo
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	arrayList struct {
+		elems []Value
+	}
+	listIterator struct {
+		list *arrayList
+		pos  int
+	}
+
+	// hashMap keeps entries in buckets the way java.util.HashMap does, so
+	// iteration order matches the JDK for maps that are not treeified.
+	hashMap struct {
+		table [][]*hashEntry
+		size  int
+		// weak is set for a WeakHashMap, whose entries the collector
+		// removes once nothing else refers to their key
+		weak bool
+	}
+	hashEntry struct {
+		hash  int32
+		key   Value
+		value Value
+	}
+)
+
+const hashMapDefaultCapacity = 16
+
+func defineBuiltinUtil(s builtinClassSet) {
+	s.iface("java/util/Iterator").
+		abstract("hasNext", "()Z").
+		abstract("next", "()Ljava/lang/Object;")
+	s.iface("java/util/Collection", "java/lang/Iterable").
+		abstract("size", "()I").
+		abstract("isEmpty", "()Z").
+		abstract("contains", "(Ljava/lang/Object;)Z").
+		abstract("add", "(Ljava/lang/Object;)Z")
+	s.iface("java/util/List", "java/util/Collection").
+		abstract("get", "(I)Ljava/lang/Object;").
+		abstract("set", "(ILjava/lang/Object;)Ljava/lang/Object;").
+		abstract("remove", "(I)Ljava/lang/Object;").
+		abstract("indexOf", "(Ljava/lang/Object;)I")
+	s.iface("java/util/Set", "java/util/Collection")
+	s.iface("java/util/RandomAccess")
+	s.iface("java/util/Comparator").
+		abstract("compare", "(Ljava/lang/Object;Ljava/lang/Object;)I")
+	s.iface("java/util/Map").
+		abstract("size", "()I").
+		abstract("isEmpty", "()Z").
+		abstract("get", "(Ljava/lang/Object;)Ljava/lang/Object;").
+		abstract("put", "(Ljava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object;").
+		abstract("remove", "(Ljava/lang/Object;)Ljava/lang/Object;").
+		abstract("containsKey", "(Ljava/lang/Object;)Z").
+		abstract("keySet", "()Ljava/util/Set;").
+		abstract("values", "()Ljava/util/Collection;").
+		abstract("entrySet", "()Ljava/util/Set;").
+		virtual("forEach", "(Ljava/util/function/BiConsumer;)V", func(frame *Frame, args []Value) (Value, error) {
+			action, ok := args[1].(*Object)
+			if !ok || action == nil {
+				return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+			}
+			entries, err := frame.VM.InvokeVirtual(frame, args[0].(*Object), "entrySet", "()Ljava/util/Set;")
+			if err != nil {
+				return nil, err
+			}
+			elems, err := frame.VM.collectionElements(frame, entries)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range elems {
+				entry := e.(*Object)
+				k, err := frame.VM.InvokeVirtual(frame, entry, "getKey", "()Ljava/lang/Object;")
+				if err != nil {
+					return nil, err
+				}
+				v, err := frame.VM.InvokeVirtual(frame, entry, "getValue", "()Ljava/lang/Object;")
+				if err != nil {
+					return nil, err
+				}
+				if _, err := frame.VM.InvokeVirtual(frame, action, "accept", "(Ljava/lang/Object;Ljava/lang/Object;)V", k, v); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+	s.iface("java/util/Map$Entry").
+		abstract("getKey", "()Ljava/lang/Object;").
+		abstract("getValue", "()Ljava/lang/Object;")
+
+	const object = "Ljava/lang/Object;"
+	s.iface("java/util/function/Consumer").
+		abstract("accept", "("+object+")V")
+	s.iface("java/util/function/BiConsumer").
+		abstract("accept", "("+object+object+")V")
+	s.iface("java/util/function/Function").
+		abstract("apply", "("+object+")"+object)
+	s.iface("java/util/function/BiFunction").
+		abstract("apply", "("+object+object+")"+object)
+	s.iface("java/util/function/Supplier").
+		abstract("get", "()"+object)
+	s.iface("java/util/function/Predicate").
+		abstract("test", "("+object+")Z")
+
+	defineBuiltinArrayList(s)
+	defineBuiltinHashMap(s)
+}
+
+func arrayListOf(v Value) *arrayList {
+	obj := v.(*Object)
+	l, ok := obj.Extra.(*arrayList)
+	if !ok {
+		l = &arrayList{}
+		obj.Extra = l
+	}
+	return l
+}
+
+func defineBuiltinArrayList(s builtinClassSet) {
+	const object = "Ljava/lang/Object;"
+	list := s.class("java/util/ArrayList", "java/lang/Object", "java/util/List", "java/util/RandomAccess").
+		virtual("<init>", "()V", func(frame *Frame, args []Value) (Value, error) {
+			arrayListOf(args[0])
+			return nil, nil
+		}).
+		virtual("<init>", "(I)V", func(frame *Frame, args []Value) (Value, error) {
+			if n := args[1].(int32); n < 0 {
+				return nil, throwOrError(frame, "java/lang/IllegalArgumentException", fmt.Sprintf("Illegal Capacity: %d", n))
+			}
+			arrayListOf(args[0]).elems = make([]Value, 0, args[1].(int32))
+			return nil, nil
+		}).
+		virtual("<init>", "(Ljava/util/Collection;)V", func(frame *Frame, args []Value) (Value, error) {
+			elems, err := frame.VM.collectionElements(frame, args[1])
+			if err != nil {
+				return nil, err
+			}
+			arrayListOf(args[0]).elems = append([]Value{}, elems...)
+			return nil, nil
+		}).
+		virtual("add", "("+object+")Z", func(frame *Frame, args []Value) (Value, error) {
+			l := arrayListOf(args[0])
+			l.elems = append(l.elems, args[1])
+			return int32(1), nil
+		}).
+		virtual("add", "(I"+object+")V", func(frame *Frame, args []Value) (Value, error) {
+			l, i := arrayListOf(args[0]), int(args[1].(int32))
+			if i < 0 || i > len(l.elems) {
+				return nil, throwOrError(frame, "java/lang/IndexOutOfBoundsException", fmt.Sprintf("Index: %d, Size: %d", i, len(l.elems)))
+			}
+			l.elems = append(l.elems, nil)
+			copy(l.elems[i+1:], l.elems[i:])
+			l.elems[i] = args[2]
+			return nil, nil
+		}).
+		virtual("addAll", "(Ljava/util/Collection;)Z", func(frame *Frame, args []Value) (Value, error) {
+			elems, err := frame.VM.collectionElements(frame, args[1])
+			if err != nil {
+				return nil, err
+			}
+			l := arrayListOf(args[0])
+			l.elems = append(l.elems, elems...)
+			return javaBool(len(elems) > 0), nil
+		}).
+		virtual("get", "(I)"+object, func(frame *Frame, args []Value) (Value, error) {
+			l, i := arrayListOf(args[0]), int(args[1].(int32))
+			if err := l.checkIndex(frame, i); err != nil {
+				return nil, err
+			}
+			return l.elems[i], nil
+		}).
+		virtual("set", "(I"+object+")"+object, func(frame *Frame, args []Value) (Value, error) {
+			l, i := arrayListOf(args[0]), int(args[1].(int32))
+			if err := l.checkIndex(frame, i); err != nil {
+				return nil, err
+			}
+			old := l.elems[i]
+			l.elems[i] = args[2]
+			return old, nil
+		}).
+		virtual("remove", "(I)"+object, func(frame *Frame, args []Value) (Value, error) {
+			l, i := arrayListOf(args[0]), int(args[1].(int32))
+			if err := l.checkIndex(frame, i); err != nil {
+				return nil, err
+			}
+			old := l.elems[i]
+			l.elems = append(l.elems[:i], l.elems[i+1:]...)
+			return old, nil
+		}).
+		virtual("remove", "("+object+")Z", func(frame *Frame, args []Value) (Value, error) {
+			l := arrayListOf(args[0])
+			i, err := frame.VM.indexOf(frame, l.elems, args[1])
+			if err != nil || i < 0 {
+				return int32(0), err
+			}
+			l.elems = append(l.elems[:i], l.elems[i+1:]...)
+			return int32(1), nil
+		}).
+		virtual("indexOf", "("+object+")I", func(frame *Frame, args []Value) (Value, error) {
+			i, err := frame.VM.indexOf(frame, arrayListOf(args[0]).elems, args[1])
+			return int32(i), err
+		}).
+		virtual("clear", "()V", func(frame *Frame, args []Value) (Value, error) {
+			arrayListOf(args[0]).elems = nil
+			return nil, nil
+		}).
+		virtual("toArray", "()[Ljava/lang/Object;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.newObjectArray("java/lang/Object", append([]Value{}, arrayListOf(args[0]).elems...))
+		})
+	defineCollectionReaders(list)
+
+	s.class("java/util/ArrayList$Itr", "java/lang/Object", "java/util/Iterator").
+		virtual("hasNext", "()Z", func(frame *Frame, args []Value) (Value, error) {
+			it := args[0].(*Object).Extra.(*listIterator)
+			return javaBool(it.pos < len(it.list.elems)), nil
+		}).
+		virtual("next", "()"+object, func(frame *Frame, args []Value) (Value, error) {
+			it := args[0].(*Object).Extra.(*listIterator)
+			if it.pos >= len(it.list.elems) {
+				return nil, throwOrError(frame, "java/util/NoSuchElementException", "")
+			}
+			it.pos++
+			return it.list.elems[it.pos-1], nil
+		}).
+		virtual("remove", "()V", func(frame *Frame, args []Value) (Value, error) {
+			it := args[0].(*Object).Extra.(*listIterator)
+			if it.pos == 0 {
+				return nil, throwOrError(frame, "java/lang/IllegalStateException", "")
+			}
+			it.pos--
+			it.list.elems = append(it.list.elems[:it.pos], it.list.elems[it.pos+1:]...)
+			return nil, nil
+		})
+}
+
+// defineCollectionReaders declares the read-only Collection methods of a class
+// whose instances hold an *arrayList.
+func defineCollectionReaders(c *builtinClass) {
+	c.virtual("size", "()I", func(frame *Frame, args []Value) (Value, error) {
+		return int32(len(arrayListOf(args[0]).elems)), nil
+	}).
+		virtual("isEmpty", "()Z", func(frame *Frame, args []Value) (Value, error) {
+			return javaBool(len(arrayListOf(args[0]).elems) == 0), nil
+		}).
+		virtual("contains", "(Ljava/lang/Object;)Z", func(frame *Frame, args []Value) (Value, error) {
+			i, err := frame.VM.indexOf(frame, arrayListOf(args[0]).elems, args[1])
+			return javaBool(i >= 0), err
+		}).
+		virtual("iterator", "()Ljava/util/Iterator;", func(frame *Frame, args []Value) (Value, error) {
+			class, err := frame.VM.LoadClass("java/util/ArrayList$Itr")
+			if err != nil {
+				return nil, err
+			}
+			it := NewObject(class)
+			it.Extra = &listIterator{list: arrayListOf(args[0])}
+			return it, nil
+		}).
+		virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			s, err := frame.VM.joinValues(frame, arrayListOf(args[0]).elems, "[", "]", args[0])
+			return frame.VM.NewString(s), err
+		})
+}
+
+func (l *arrayList) checkIndex(frame *Frame, i int) error {
+	if i < 0 || i >= len(l.elems) {
+		return throwOrError(frame, "java/lang/IndexOutOfBoundsException", fmt.Sprintf("Index %d out of bounds for length %d", i, len(l.elems)))
+	}
+	return nil
+}
+
+func hashMapOf(v Value) *hashMap {
+	obj := v.(*Object)
+	m, ok := obj.Extra.(*hashMap)
+	if !ok {
+		m = &hashMap{}
+		for c := obj.Class; c != nil; c = c.Super {
+			m.weak = m.weak || c.Name == "java/util/WeakHashMap"
+		}
+		obj.Extra = m
+	}
+	return m
+}
+
+func defineBuiltinHashMap(s builtinClassSet) {
+	const object = "Ljava/lang/Object;"
+	s.class("java/util/HashMap", "java/lang/Object", "java/util/Map").
+		virtual("<init>", "()V", func(frame *Frame, args []Value) (Value, error) {
+			hashMapOf(args[0])
+			return nil, nil
+		}).
+		virtual("<init>", "(I)V", func(frame *Frame, args []Value) (Value, error) {
+			n := int(args[1].(int32))
+			if n < 0 {
+				return nil, throwOrError(frame, "java/lang/IllegalArgumentException", fmt.Sprintf("Illegal initial capacity: %d", n))
+			}
+			capacity := 1
+			for capacity < n {
+				capacity <<= 1
+			}
+			hashMapOf(args[0]).table = make([][]*hashEntry, capacity)
+			return nil, nil
+		}).
+		virtual("size", "()I", func(frame *Frame, args []Value) (Value, error) {
+			return int32(hashMapOf(args[0]).size), nil
+		}).
+		virtual("isEmpty", "()Z", func(frame *Frame, args []Value) (Value, error) {
+			return javaBool(hashMapOf(args[0]).size == 0), nil
+		}).
+		virtual("get", "("+object+")"+object, func(frame *Frame, args []Value) (Value, error) {
+			e, err := frame.VM.hashMapFind(frame, hashMapOf(args[0]), args[1])
+			if err != nil || e == nil {
+				return nil, err
+			}
+			return e.value, nil
+		}).
+		virtual("getOrDefault", "("+object+object+")"+object, func(frame *Frame, args []Value) (Value, error) {
+			e, err := frame.VM.hashMapFind(frame, hashMapOf(args[0]), args[1])
+			if err != nil || e == nil {
+				return args[2], err
+			}
+			return e.value, nil
+		}).
+		virtual("containsKey", "("+object+")Z", func(frame *Frame, args []Value) (Value, error) {
+			e, err := frame.VM.hashMapFind(frame, hashMapOf(args[0]), args[1])
+			return javaBool(e != nil), err
+		}).
+		virtual("containsValue", "("+object+")Z", func(frame *Frame, args []Value) (Value, error) {
+			i, err := frame.VM.indexOf(frame, hashMapOf(args[0]).values(), args[1])
+			return javaBool(i >= 0), err
+		}).
+		virtual("put", "("+object+object+")"+object, func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.hashMapPut(frame, hashMapOf(args[0]), args[1], args[2], false)
+		}).
+		virtual("putIfAbsent", "("+object+object+")"+object, func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.hashMapPut(frame, hashMapOf(args[0]), args[1], args[2], true)
+		}).
+		virtual("remove", "("+object+")"+object, func(frame *Frame, args []Value) (Value, error) {
+			m := hashMapOf(args[0])
+			e, err := frame.VM.hashMapFind(frame, m, args[1])
+			if err != nil || e == nil {
+				return nil, err
+			}
+			m.remove(e)
+			return e.value, nil
+		}).
+		virtual("clear", "()V", func(frame *Frame, args []Value) (Value, error) {
+			m := hashMapOf(args[0])
+			for i := range m.table {
+				m.table[i] = nil
+			}
+			m.size = 0
+			return nil, nil
+		}).
+		virtual("keySet", "()Ljava/util/Set;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.newCollectionView(frame, "java/util/HashMap$KeySet", hashMapOf(args[0]).keys())
+		}).
+		virtual("values", "()Ljava/util/Collection;", func(frame *Frame, args []Value) (Value, error) {
+			return frame.VM.newCollectionView(frame, "java/util/HashMap$Values", hashMapOf(args[0]).values())
+		}).
+		virtual("entrySet", "()Ljava/util/Set;", func(frame *Frame, args []Value) (Value, error) {
+			class, err := frame.VM.LoadClass("java/util/HashMap$Node")
+			if err != nil {
+				return nil, err
+			}
+			var nodes []Value
+			for _, e := range hashMapOf(args[0]).entries() {
+				node := NewObject(class)
+				node.Extra = e
+				nodes = append(nodes, node)
+			}
+			return frame.VM.newCollectionView(frame, "java/util/HashMap$EntrySet", nodes)
+		}).
+		virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			var b strings.Builder
+			b.WriteString("{")
+			for i, e := range hashMapOf(args[0]).entries() {
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				s, err := frame.VM.entryString(frame, e, args[0])
+				if err != nil {
+					return nil, err
+				}
+				b.WriteString(s)
+			}
+			b.WriteString("}")
+			return frame.VM.NewString(b.String()), nil
+		})
+
+	weak := *s["java/util/HashMap"]
+	weak.name = "java/util/WeakHashMap"
+	s[weak.name] = &weak
+
+	// the views are snapshots taken when keySet, values or entrySet is called
+	defineCollectionReaders(s.class("java/util/HashMap$KeySet", "java/lang/Object", "java/util/Set"))
+	defineCollectionReaders(s.class("java/util/HashMap$Values", "java/lang/Object", "java/util/Collection"))
+	defineCollectionReaders(s.class("java/util/HashMap$EntrySet", "java/lang/Object", "java/util/Set"))
+
+	s.class("java/util/HashMap$Node", "java/lang/Object", "java/util/Map$Entry").
+		virtual("getKey", "()"+object, func(frame *Frame, args []Value) (Value, error) {
+			return args[0].(*Object).Extra.(*hashEntry).key, nil
+		}).
+		virtual("getValue", "()"+object, func(frame *Frame, args []Value) (Value, error) {
+			return args[0].(*Object).Extra.(*hashEntry).value, nil
+		}).
+		virtual("setValue", "("+object+")"+object, func(frame *Frame, args []Value) (Value, error) {
+			e := args[0].(*Object).Extra.(*hashEntry)
+			old := e.value
+			e.value = args[1]
+			return old, nil
+		}).
+		virtual("toString", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+			s, err := frame.VM.entryString(frame, args[0].(*Object).Extra.(*hashEntry), nil)
+			return frame.VM.NewString(s), err
+		})
+}
+
+func (vm *VirtualMachine) newCollectionView(frame *Frame, className string, elems []Value) (*Object, error) {
+	class, err := vm.LoadClass(className)
+	if err != nil {
+		return nil, err
+	}
+	view := NewObject(class)
+	view.Extra = &arrayList{elems: elems}
+	return view, nil
+}
+
+func spreadHash(h int32) int32 {
+	return h ^ int32(uint32(h)>>16)
+}
+
+func (vm *VirtualMachine) hashMapFind(frame *Frame, m *hashMap, key Value) (*hashEntry, error) {
+	if len(m.table) == 0 {
+		return nil, nil
+	}
+	h, err := vm.javaHashCode(frame, key)
+	if err != nil {
+		return nil, err
+	}
+	h = spreadHash(h)
+	for _, e := range m.table[int(h)&(len(m.table)-1)] {
+		if e.hash != h {
+			continue
+		}
+		eq, err := vm.javaEquals(frame, key, e.key)
+		if err != nil {
+			return nil, err
+		}
+		if eq {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+func (vm *VirtualMachine) hashMapPut(frame *Frame, m *hashMap, key, value Value, onlyIfAbsent bool) (Value, error) {
+	e, err := vm.hashMapFind(frame, m, key)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil {
+		old := e.value
+		if !onlyIfAbsent || old == nil {
+			e.value = value
+		}
+		return old, nil
+	}
+
+	h, err := vm.javaHashCode(frame, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.table) == 0 {
+		m.table = make([][]*hashEntry, hashMapDefaultCapacity)
+	}
+	h = spreadHash(h)
+	i := int(h) & (len(m.table) - 1)
+	m.table[i] = append(m.table[i], &hashEntry{hash: h, key: key, value: value})
+	m.size++
+	if m.size > len(m.table)*3/4 {
+		m.resize()
+	}
+	return nil, nil
+}
+
+// resize doubles the table, keeping the relative order of entries that land
+// in the same bucket as HashMap.resize does.
+func (m *hashMap) resize() {
+	table := make([][]*hashEntry, len(m.table)*2)
+	for _, bucket := range m.table {
+		for _, e := range bucket {
+			i := int(e.hash) & (len(table) - 1)
+			table[i] = append(table[i], e)
+		}
+	}
+	m.table = table
+}
+
+func (m *hashMap) remove(target *hashEntry) {
+	i := int(target.hash) & (len(m.table) - 1)
+	bucket := m.table[i]
+	for j, e := range bucket {
+		if e == target {
+			m.table[i] = append(bucket[:j], bucket[j+1:]...)
+			m.size--
+			return
+		}
+	}
+}
+
+// removeKeys removes the entries whose key is an object dead reports. Buckets
+// are copied rather than changed in place, as a thread may be stopped while
+// it goes through one.
+func (m *hashMap) removeKeys(dead func(*Object) bool) {
+	for i, bucket := range m.table {
+		var kept []*hashEntry
+		for _, e := range bucket {
+			if key, ok := e.key.(*Object); ok && key != nil && dead(key) {
+				m.size--
+				continue
+			}
+			kept = append(kept, e)
+		}
+		if len(kept) < len(bucket) {
+			m.table[i] = kept
+		}
+	}
+}
+
+func (m *hashMap) entries() []*hashEntry {
+	var entries []*hashEntry
+	for _, bucket := range m.table {
+		entries = append(entries, bucket...)
+	}
+	return entries
+}
+
+func (m *hashMap) keys() []Value {
+	var keys []Value
+	for _, e := range m.entries() {
+		keys = append(keys, e.key)
+	}
+	return keys
+}
+
+func (m *hashMap) values() []Value {
+	var values []Value
+	for _, e := range m.entries() {
+		values = append(values, e.value)
+	}
+	return values
+}
+
+// javaHashCode calls hashCode on v, treating null as 0.
+func (vm *VirtualMachine) javaHashCode(caller *Frame, v Value) (int32, error) {
+	switch o := v.(type) {
+	case nil:
+		return 0, nil
+	case *Object:
+		if o == nil {
+			return 0, nil
+		}
+		h, err := vm.InvokeVirtual(caller, o, "hashCode", "()I")
+		if err != nil {
+			return 0, err
+		}
+		return h.(int32), nil
+	}
+	return 0, fmt.Errorf("hashCode of non-reference %v", v)
+}
+
+// javaEquals is Objects.equals.
+func (vm *VirtualMachine) javaEquals(caller *Frame, a, b Value) (bool, error) {
+	if sameReference(a, b) {
+		return true, nil
+	}
+	switch o := a.(type) {
+	case *Object:
+		if o == nil {
+			return false, nil
+		}
+		eq, err := vm.InvokeVirtual(caller, o, "equals", "(Ljava/lang/Object;)Z", b)
+		if err != nil {
+			return false, err
+		}
+		return eq.(int32) != 0, nil
+	}
+	return false, nil
+}
+
+func (vm *VirtualMachine) indexOf(caller *Frame, elems []Value, v Value) (int, error) {
+	for i, e := range elems {
+		eq, err := vm.javaEquals(caller, v, e)
+		if err != nil {
+			return -1, err
+		}
+		if eq {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// collectionElements returns the elements of a Collection, iterating it
+// through its methods unless it is one of the bundled collections.
+func (vm *VirtualMachine) collectionElements(caller *Frame, v Value) ([]Value, error) {
+	obj, ok := v.(*Object)
+	if !ok || obj == nil {
+		return nil, vm.throwNullPointer(caller)
+	}
+	if l, ok := obj.Extra.(*arrayList); ok {
+		return l.elems, nil
+	}
+	it, err := vm.InvokeVirtual(caller, obj, "iterator", "()Ljava/util/Iterator;")
+	if err != nil {
+		return nil, err
+	}
+	itObj, _ := it.(*Object)
+	var elems []Value
+	for {
+		more, err := vm.InvokeVirtual(caller, itObj, "hasNext", "()Z")
+		if err != nil {
+			return nil, err
+		}
+		if more.(int32) == 0 {
+			return elems, nil
+		}
+		e, err := vm.InvokeVirtual(caller, itObj, "next", "()Ljava/lang/Object;")
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, e)
+	}
+}
+
+// joinValues formats elements like AbstractCollection.toString, printing
+// self for references to the collection itself.
+func (vm *VirtualMachine) joinValues(caller *Frame, elems []Value, open, close string, self Value) (string, error) {
+	var b strings.Builder
+	b.WriteString(open)
+	for i, e := range elems {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if self != nil && sameReference(e, self) {
+			b.WriteString("(this Collection)")
+			continue
+		}
+		s, err := vm.javaString(caller, "Ljava/lang/Object;", e)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s)
+	}
+	b.WriteString(close)
+	return b.String(), nil
+}
+
+func (vm *VirtualMachine) entryString(caller *Frame, e *hashEntry, self Value) (string, error) {
+	var parts [2]string
+	for i, v := range []Value{e.key, e.value} {
+		if self != nil && sameReference(v, self) {
+			parts[i] = "(this Map)"
+			continue
+		}
+		s, err := vm.javaString(caller, "Ljava/lang/Object;", v)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = s
+	}
+	return parts[0] + "=" + parts[1], nil
+}
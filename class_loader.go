@@ -0,0 +1,282 @@
+package jvmgo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// LoadClass loads, links and returns the named class, searching classes that
+// are already defined and then the class path.
+func (vm *VirtualMachine) LoadClass(name string) (*RuntimeClass, error) {
+	vm.classesMu.Lock()
+	c, ok := vm.classes[name]
+	vm.classesMu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	if len(name) > 0 && name[0] == '[' {
+		return vm.arrayClass(name)
+	}
+	if vm.ClassPath == nil {
+		return vm.builtinClass(name)
+	}
+	buf, boot, err := readClass(vm.ClassPath, name)
+	if errors.Is(err, ErrClassNotFound) {
+		return vm.builtinClass(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	file, err := DecodeClassStructure(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("decode class %s: %w", name, err)
+	}
+	c, err = vm.defineClass(file, boot)
+	if err != nil {
+		return nil, err
+	}
+	if c.Name != name {
+		return nil, fmt.Errorf("class file for %s defines %s", name, c.Name)
+	}
+	return c, nil
+}
+
+// DefineClass links a decoded class file and makes it visible to LoadClass.
+func (vm *VirtualMachine) DefineClass(file *ClassStructure) (*RuntimeClass, error) {
+	return vm.defineClass(file, false)
+}
+
+// defineClass is DefineClass for a class file that the boot loader defines
+// when boot is set.
+func (vm *VirtualMachine) defineClass(file *ClassStructure, boot bool) (*RuntimeClass, error) {
+	c, err := newRuntimeClass(file)
+	if err != nil {
+		return nil, err
+	}
+	c.boot = boot
+
+	vm.classesMu.Lock()
+	if existing, ok := vm.classes[c.Name]; ok {
+		vm.classesMu.Unlock()
+		return existing, nil
+	}
+	vm.classesMu.Unlock()
+
+	if file.SuperClass != 0 {
+		superName, err := file.ClassName(file.SuperClass)
+		if err != nil {
+			return nil, fmt.Errorf("get super class name of %s: %w", c.Name, err)
+		}
+		if c.Super, err = vm.classOrStub(superName); err != nil {
+			return nil, fmt.Errorf("load super class of %s: %w", c.Name, err)
+		}
+		if c.Super.IsInterface() {
+			return nil, fmt.Errorf("incompatible class change: %s has interface %s as super class", c.Name, superName)
+		}
+	}
+	for _, idx := range file.Interfaces {
+		interfaceName, err := file.ClassName(idx)
+		if err != nil {
+			return nil, fmt.Errorf("get interface name of %s: %w", c.Name, err)
+		}
+		i, err := vm.classOrStub(interfaceName)
+		if err != nil {
+			return nil, fmt.Errorf("load interface of %s: %w", c.Name, err)
+		}
+		if i.stub {
+			i.AccessFlags |= AccInterface | AccAbstract
+		}
+		if !i.IsInterface() {
+			return nil, fmt.Errorf("incompatible class change: %s is not an interface", interfaceName)
+		}
+		c.Interfaces = append(c.Interfaces, i)
+	}
+	c.layoutFields()
+	c.linkMethods()
+	if err := c.initConstantValues(vm); err != nil {
+		return nil, fmt.Errorf("prepare %s: %w", c.Name, err)
+	}
+
+	vm.classesMu.Lock()
+	defer vm.classesMu.Unlock()
+	if existing, ok := vm.classes[c.Name]; ok {
+		return existing, nil
+	}
+	vm.classes[c.Name] = c
+	return c, nil
+}
+
+// builtinClass defines the named class from the bundled runtime, which takes
+// the place of the class library classes missing from the class path.
+func (vm *VirtualMachine) builtinClass(name string) (*RuntimeClass, error) {
+	b, ok := builtinClasses[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrClassNotFound, name)
+	}
+	return vm.defineBuiltin(b)
+}
+
+func (vm *VirtualMachine) arrayClass(name string) (*RuntimeClass, error) {
+	if _, err := fieldDescriptorLength(name); err != nil {
+		return nil, fmt.Errorf("invalid array class name %s: %w", name, err)
+	}
+	object, err := vm.LoadClass("java/lang/Object")
+	if errors.Is(err, ErrClassNotFound) {
+		object = nil
+	} else if err != nil {
+		return nil, err
+	}
+	var component *RuntimeClass
+	if elem := name[1:]; elem[0] == 'L' || elem[0] == '[' {
+		if elem[0] == 'L' {
+			elem = elem[1 : len(elem)-1]
+		}
+		if component, err = vm.classOrStub(elem); err != nil {
+			return nil, fmt.Errorf("load component of %s: %w", name, err)
+		}
+	}
+	var interfaces []*RuntimeClass
+	for _, i := range []string{"java/lang/Cloneable", "java/io/Serializable"} {
+		c, err := vm.classOrStub(i)
+		if err != nil && !errors.Is(err, ErrClassNotFound) {
+			return nil, err
+		}
+		if c != nil {
+			interfaces = append(interfaces, c)
+		}
+	}
+
+	vm.classesMu.Lock()
+	defer vm.classesMu.Unlock()
+	if c, ok := vm.classes[name]; ok {
+		return c, nil
+	}
+	c := newSyntheticClass(name, object)
+	c.Interfaces = interfaces
+	c.component = component
+	c.linkMethods()
+	vm.classes[name] = c
+	return c, nil
+}
+
+// classOrStub loads the class and falls back to a synthetic class with no
+// members when a class library class is not on the class path, so programs
+// can run with only the natives standing in for the library.
+func (vm *VirtualMachine) classOrStub(name string) (*RuntimeClass, error) {
+	c, err := vm.LoadClass(name)
+	if !errors.Is(err, ErrClassNotFound) || !strings.HasPrefix(name, "java/") {
+		return c, err
+	}
+
+	vm.classesMu.Lock()
+	defer vm.classesMu.Unlock()
+	if c, ok := vm.classes[name]; ok {
+		return c, nil
+	}
+	var super *RuntimeClass
+	if name != "java/lang/Object" {
+		super = vm.classes["java/lang/Object"]
+	}
+	c = newSyntheticClass(name, super)
+	c.AccessFlags = AccPublic
+	c.stub = true
+	vm.classes[name] = c
+	return c, nil
+}
+
+// initializeClass runs the static initializers of c and its superclasses per
+// JVMS §5.5 unless they already ran.
+func (vm *VirtualMachine) initializeClass(caller *Frame, c *RuntimeClass) error {
+	t := vm.currentThread(caller)
+	c.initMu.Lock()
+	if c.initState == classInitializing && c.initThread != t {
+		// another thread runs the initializers
+		left := vm.leaveWorld(t)
+		if c.initCond == nil {
+			c.initCond = sync.NewCond(&c.initMu)
+		}
+		for c.initState == classInitializing {
+			c.initCond.Wait()
+		}
+		if left {
+			// a collection may be waiting for a thread that needs initMu
+			c.initMu.Unlock()
+			vm.enterWorld(t)
+			c.initMu.Lock()
+		}
+	}
+	switch c.initState {
+	case classInitialized, classInitializing:
+		// initializing means a recursive request from the initializer itself
+		c.initMu.Unlock()
+		return nil
+	case classInitFailed:
+		c.initMu.Unlock()
+		return vm.throwNew(caller, "java/lang/NoClassDefFoundError", "Could not initialize class "+javaClassName(c.Name))
+	}
+	c.initState = classInitializing
+	c.initThread = t
+	c.initMu.Unlock()
+
+	err := vm.runInitializers(caller, c)
+
+	c.initMu.Lock()
+	defer c.initMu.Unlock()
+	c.initThread = nil
+	if c.initCond != nil {
+		c.initCond.Broadcast()
+	}
+	if err != nil {
+		c.initState = classInitFailed
+		return err
+	}
+	c.initState = classInitialized
+	return nil
+}
+
+func (vm *VirtualMachine) runInitializers(caller *Frame, c *RuntimeClass) error {
+	if c.Super != nil {
+		if err := vm.initializeClass(caller, c.Super); err != nil {
+			return err
+		}
+	}
+	if vm.Verify {
+		if err := vm.verifyClass(c); err != nil {
+			return vm.throwNew(caller, "java/lang/VerifyError", err.Error())
+		}
+	}
+	clinit := c.DeclaredMethod("<clinit>", "()V")
+	if clinit == nil {
+		return nil
+	}
+	_, err := vm.invokeMethod(caller, clinit, nil)
+	var ex *JavaException
+	if !errors.As(err, &ex) {
+		return err
+	}
+	// an exception that is not an Error is wrapped in ExceptionInInitializerError
+	errorClass, lerr := vm.LoadClass("java/lang/Error")
+	if lerr != nil {
+		return lerr
+	}
+	if ex.Object.Class.IsSubclassOf(errorClass) {
+		return ex
+	}
+	eiie, lerr := vm.LoadClass("java/lang/ExceptionInInitializerError")
+	if lerr != nil {
+		return lerr
+	}
+	obj, lerr := vm.NewInstance(caller, eiie, "(Ljava/lang/Throwable;)V", ex.Object)
+	if lerr != nil {
+		return lerr
+	}
+	return &JavaException{Object: obj}
+}
+
+func javaClassName(name string) string {
+	return strings.ReplaceAll(name, "/", ".")
+}
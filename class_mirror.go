@@ -0,0 +1,78 @@
+package jvmgo
+
+import "fmt"
+
+var primitiveDescriptors = map[string]string{
+	"boolean": "Z",
+	"byte":    "B",
+	"char":    "C",
+	"short":   "S",
+	"int":     "I",
+	"long":    "J",
+	"float":   "F",
+	"double":  "D",
+	"void":    "V",
+}
+
+var primitiveNames = func() map[string]string {
+	names := map[string]string{}
+	for name, desc := range primitiveDescriptors {
+		names[desc] = name
+	}
+	return names
+}()
+
+// ClassMirror returns the java.lang.Class instance representing c.
+func (vm *VirtualMachine) ClassMirror(c *RuntimeClass) (*Object, error) {
+	c.initMu.Lock()
+	mirror := c.mirror
+	c.initMu.Unlock()
+	if mirror != nil {
+		return mirror, nil
+	}
+
+	classClass, err := vm.classOrStub("java/lang/Class")
+	if err != nil {
+		return nil, fmt.Errorf("load java/lang/Class: %w", err)
+	}
+	mirror = NewObject(classClass)
+	mirror.Extra = c
+
+	c.initMu.Lock()
+	defer c.initMu.Unlock()
+	if c.mirror == nil {
+		c.mirror = mirror
+	}
+	return c.mirror, nil
+}
+
+// classFromMirror returns the class a java.lang.Class instance represents.
+func classFromMirror(v Value) (*RuntimeClass, bool) {
+	o, ok := v.(*Object)
+	if !ok || o == nil {
+		return nil, false
+	}
+	c, ok := o.Extra.(*RuntimeClass)
+	return c, ok
+}
+
+// primitiveClass returns the class for a primitive type name such as "int".
+func (vm *VirtualMachine) primitiveClass(name string) (*RuntimeClass, error) {
+	if _, ok := primitiveDescriptors[name]; !ok {
+		return nil, fmt.Errorf("not a primitive type: %s", name)
+	}
+	vm.classesMu.Lock()
+	defer vm.classesMu.Unlock()
+	if c, ok := vm.classes[name]; ok {
+		return c, nil
+	}
+	c := newSyntheticClass(name, nil)
+	c.AccessFlags = AccPublic | AccFinal | AccAbstract
+	vm.classes[name] = c
+	return c, nil
+}
+
+func (c *RuntimeClass) IsPrimitive() bool {
+	_, ok := primitiveDescriptors[c.Name]
+	return ok && c.File == nil
+}
@@ -0,0 +1,138 @@
+package jvmgo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrClassNotFound = errors.New("class not found")
+)
+
+type (
+	// ClassPath locates class files by binary name such as "java/lang/Object".
+	ClassPath interface {
+		ReadClass(name string) ([]byte, error)
+		Close() error
+	}
+	DirClassPath       string
+	CompositeClassPath []ClassPath
+)
+
+func (d DirClassPath) ReadClass(name string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(string(d), filepath.FromSlash(name)+".class"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrClassNotFound, name)
+	}
+	return buf, err
+}
+
+func (d DirClassPath) Close() error {
+	return nil
+}
+
+func (c CompositeClassPath) ReadClass(name string) ([]byte, error) {
+	for _, p := range c {
+		buf, err := p.ReadClass(name)
+		if err == nil {
+			return buf, nil
+		}
+		if !errors.Is(err, ErrClassNotFound) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrClassNotFound, name)
+}
+
+func (c CompositeClassPath) Close() error {
+	var first error
+	for _, p := range c {
+		if err := p.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// readClass reads the named class from cp and reports whether it comes from
+// the class library of a JDK, a jimage or a jmod, whose classes the boot
+// loader defines.
+func readClass(cp ClassPath, name string) ([]byte, bool, error) {
+	switch cp := cp.(type) {
+	case CompositeClassPath:
+		for _, p := range cp {
+			buf, boot, err := readClass(p, name)
+			if err == nil {
+				return buf, boot, nil
+			}
+			if !errors.Is(err, ErrClassNotFound) {
+				return nil, false, err
+			}
+		}
+		return nil, false, fmt.Errorf("%w: %s", ErrClassNotFound, name)
+	case *JImage, *Jmod:
+		buf, err := cp.ReadClass(name)
+		return buf, err == nil, err
+	}
+	buf, err := cp.ReadClass(name)
+	return buf, false, err
+}
+
+// ParseClassPath builds a class path from a list separated by os.PathListSeparator.
+// Entries may be directories or .jmod files.
+func ParseClassPath(list string) (ClassPath, error) {
+	var ret CompositeClassPath
+	for _, entry := range filepath.SplitList(list) {
+		if entry == "" {
+			continue
+		}
+		if strings.HasSuffix(entry, ".jmod") {
+			jmod, err := OpenJmod(entry)
+			if err != nil {
+				ret.Close()
+				return nil, err
+			}
+			ret = append(ret, jmod)
+			continue
+		}
+		ret = append(ret, DirClassPath(entry))
+	}
+	return ret, nil
+}
+
+// OpenJDK opens the class library of the JDK installed at javaHome, preferring
+// the lib/modules runtime image and falling back to jmods/*.jmod.
+func OpenJDK(javaHome string) (ClassPath, error) {
+	modules := filepath.Join(javaHome, "lib", "modules")
+	if _, err := os.Stat(modules); err == nil {
+		return OpenJImage(modules)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(javaHome, "jmods", "*.jmod"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("neither lib/modules nor jmods found in %s", javaHome)
+	}
+	// java.base holds nearly everything the VM asks for, so search it first
+	for i, p := range paths {
+		if filepath.Base(p) == "java.base.jmod" {
+			paths[0], paths[i] = paths[i], paths[0]
+		}
+	}
+	var ret CompositeClassPath
+	for _, p := range paths {
+		jmod, err := OpenJmod(p)
+		if err != nil {
+			ret.Close()
+			return nil, err
+		}
+		ret = append(ret, jmod)
+	}
+	return ret, nil
+}
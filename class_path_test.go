@@ -0,0 +1,386 @@
+package jvmgo
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenJmod(t *testing.T) {
+	hello, err := ioutil.ReadFile("HelloWorld.class")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	buf.Write(jmodMagic)
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("classes/HelloWorld.class")
+	require.NoError(t, err)
+	_, err = w.Write(hello)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	path := filepath.Join(t.TempDir(), "java.base.jmod")
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+
+	cp, err := ParseClassPath(path)
+	require.NoError(t, err)
+	defer cp.Close()
+
+	got, err := cp.ReadClass("HelloWorld")
+	require.NoError(t, err)
+	require.Equal(t, hello, got)
+
+	_, err = cp.ReadClass("Missing")
+	require.ErrorIs(t, err, ErrClassNotFound)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("PK"), 0644))
+	_, err = OpenJmod(path)
+	require.ErrorIs(t, err, ErrInvalidJmodFile)
+}
+
+func TestReadJImage(t *testing.T) {
+	hello, err := ioutil.ReadFile("HelloWorld.class")
+	require.NoError(t, err)
+
+	img, err := ReadJImage(buildJImage(map[string]string{
+		"/java.base/demo/HelloWorld.class": string(hello),
+		"/java.base/demo/Other.txt":        "other",
+	}))
+	require.NoError(t, err)
+
+	got, err := img.ReadClass("demo/HelloWorld")
+	require.NoError(t, err)
+	require.Equal(t, hello, got)
+
+	res, ok, err := img.Resource("/java.base/demo/Other.txt")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "other", string(res))
+
+	_, err = img.ReadClass("demo/Missing")
+	require.ErrorIs(t, err, ErrClassNotFound)
+	_, err = img.ReadClass("nowhere/HelloWorld")
+	require.ErrorIs(t, err, ErrClassNotFound)
+
+	_, err = ReadJImage([]byte("not an image at all, really not"))
+	require.ErrorIs(t, err, ErrInvalidJImage)
+}
+
+func TestJImage_ReadClass_Concurrent(t *testing.T) {
+	resources := map[string]string{}
+	for i := 0; i < 8; i++ {
+		resources[fmt.Sprintf("/java.base/p%d/C.class", i)] = fmt.Sprint(i)
+	}
+	img, err := ReadJImage(buildJImage(resources))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for i := 0; i < 8; i++ {
+				buf, err := img.ReadClass(fmt.Sprintf("p%d/C", i))
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprint(i), string(buf))
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestOpenJDK(t *testing.T) {
+	_, err := OpenJDK(t.TempDir())
+	require.Error(t, err)
+
+	home := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(home, "lib"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(home, "lib", "modules"), buildJImage(map[string]string{
+		"/java.base/java/lang/Marker.class": "marker",
+	}), 0644))
+	cp, err := OpenJDK(home)
+	require.NoError(t, err)
+	defer cp.Close()
+	buf, err := cp.ReadClass("java/lang/Marker")
+	require.NoError(t, err)
+	require.Equal(t, "marker", string(buf))
+}
+
+func TestVirtualMachine_InitSystem_RequiresClassPath(t *testing.T) {
+	vm := NewVM(nil)
+	require.Error(t, vm.InitSystem())
+}
+
+func TestVirtualMachine_ExecMain_ClassPath(t *testing.T) {
+	cp := mapClassPath{}
+
+	shape := newClassBuilder("Shape", "java/lang/Object")
+	objectInit := shape.methodRef("java/lang/Object", "<init>", "()V")
+	shape.method(0, "<init>", "()V", 1, 1,
+		byte(OpCodeAload0),
+		byte(OpCodeInvokeSpecial), hi(objectInit), lo(objectInit),
+		byte(OpCodeReturn),
+	)
+	shape.method(AccPublic, "area", "()I", 1, 1, byte(OpCodeIconst0), byte(OpCodeIreturn))
+	cp.add(shape.build())
+
+	square := newClassBuilder("Square", "Shape")
+	side := square.fieldRef("Square", "side", "I")
+	shapeInit := square.methodRef("Shape", "<init>", "()V")
+	square.field(AccPrivate, "side", "I")
+	square.method(0, "<init>", "(I)V", 2, 2,
+		byte(OpCodeAload0),
+		byte(OpCodeInvokeSpecial), hi(shapeInit), lo(shapeInit),
+		byte(OpCodeAload0),
+		byte(OpCodeIload0+1),
+		byte(OpCodePutField), hi(side), lo(side),
+		byte(OpCodeReturn),
+	)
+	square.method(AccPublic, "area", "()I", 2, 1,
+		byte(OpCodeAload0),
+		byte(OpCodeGetField), hi(side), lo(side),
+		byte(OpCodeAload0),
+		byte(OpCodeGetField), hi(side), lo(side),
+		byte(OpCodeImul),
+		byte(OpCodeIreturn),
+	)
+	cp.add(square.build())
+
+	oops := newClassBuilder("Oops", "java/lang/Object")
+	cp.add(oops.build())
+
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printInt := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	printStr := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+	count := b.fieldRef("Main", "count", "I")
+	squareClass := b.classRef("Square")
+	squareInit := b.methodRef("Square", "<init>", "(I)V")
+	area := b.methodRef("Shape", "area", "()I")
+	oopsClass := b.classRef("Oops")
+	caught := b.str("caught")
+	b.field(AccStatic, "count", "I")
+	b.method(AccStatic, "<clinit>", "()V", 1, 0,
+		byte(OpCodeBipush), 3,
+		byte(OpCodePutStatic), hi(count), lo(count),
+		byte(OpCodeReturn),
+	)
+	code := []byte{
+		// sum of 1..count
+		byte(OpCodeIconst0), byte(OpCodeIstore0 + 1),
+		byte(OpCodeGetStatic), hi(count), lo(count), byte(OpCodeIstore0 + 2),
+		byte(OpCodeIload0 + 2), byte(OpCodeIfle), 0, 13,
+		byte(OpCodeIload0 + 1), byte(OpCodeIload0 + 2), byte(OpCodeIadd), byte(OpCodeIstore0 + 1),
+		byte(OpCodeIinc), 2, 0xff,
+		byte(OpCodeGoto), 0xff, 0xf5,
+		byte(OpCodeGetStatic), hi(out), lo(out),
+		byte(OpCodeIload0 + 1),
+		byte(OpCodeInvokeVirtual), hi(printInt), lo(printInt),
+		// virtual dispatch to Square.area
+		byte(OpCodeGetStatic), hi(out), lo(out),
+		byte(OpCodeNew), hi(squareClass), lo(squareClass),
+		byte(OpCodeDup),
+		byte(OpCodeBipush), 7,
+		byte(OpCodeInvokeSpecial), hi(squareInit), lo(squareInit),
+		byte(OpCodeInvokeVirtual), hi(area), lo(area),
+		byte(OpCodeInvokeVirtual), hi(printInt), lo(printInt),
+		// throw and catch
+		byte(OpCodeNew), hi(oopsClass), lo(oopsClass),
+		byte(OpCodeAThrow),
+		byte(OpCodeReturn),
+		byte(OpCodePop),
+		byte(OpCodeGetStatic), hi(out), lo(out),
+		byte(OpCodeLdc), lo(caught),
+		byte(OpCodeInvokeVirtual), hi(printStr), lo(printStr),
+		byte(OpCodeReturn),
+	}
+	throwPC := uint16(bytes.IndexByte(code, byte(OpCodeAThrow)) - 3)
+	b.methodWithHandlers(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 4, 3, code, []*Exception{
+		{StartPC: throwPC, EndPC: throwPC + 4, HandlerPC: throwPC + 5, CatchType: oopsClass},
+	})
+
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "6\n49\ncaught\n", stdout.String())
+}
+
+// buildJImage lays out a little-endian jimage holding the given resources,
+// with a /packages entry for every package so ReadClass can find modules.
+func buildJImage(resources map[string]string) []byte {
+	packages := map[string]string{}
+	for path := range resources {
+		parts := strings.SplitN(path[1:], "/", 2)
+		if i := strings.LastIndexByte(parts[1], '/'); i >= 0 {
+			packages[strings.ReplaceAll(parts[1][:i], "/", ".")] = parts[0]
+		}
+	}
+
+	strs := []byte{0}
+	addString := func(s string) uint32 {
+		off := uint32(len(strs))
+		strs = append(append(strs, s...), 0)
+		return off
+	}
+
+	type entry struct {
+		module, base string
+		content      []byte
+	}
+	var entries []entry
+	for path, content := range resources {
+		parts := strings.SplitN(path[1:], "/", 2)
+		entries = append(entries, entry{parts[0], parts[1], []byte(content)})
+	}
+	for pkg, module := range packages {
+		content := make([]byte, 8)
+		binary.LittleEndian.PutUint32(content[4:], addString(module))
+		entries = append(entries, entry{"packages", pkg, content})
+	}
+
+	// pick a table length where every name hashes to its own bucket
+	length := len(entries)
+	for ; ; length++ {
+		seen := map[uint32]bool{}
+		for _, e := range entries {
+			seen[jimageHash("/"+e.module+"/"+e.base, jimageHashMultiplier)%uint32(length)] = true
+		}
+		if len(seen) == len(entries) {
+			break
+		}
+	}
+
+	redirect := make([]byte, length*4)
+	offsets := make([]byte, length*4)
+	var locations, content []byte
+	attr := func(kind byte, v uint64) {
+		locations = append(locations, kind<<3|7)
+		locations = append(locations, u8(v)...)
+	}
+	for i, e := range entries {
+		bucket := jimageHash("/"+e.module+"/"+e.base, jimageHashMultiplier) % uint32(length)
+		binary.LittleEndian.PutUint32(redirect[bucket*4:], uint32(int32(-1-i)))
+		binary.LittleEndian.PutUint32(offsets[i*4:], uint32(len(locations)))
+		attr(jimageAttributeModule, uint64(addString(e.module)))
+		attr(jimageAttributeBase, uint64(addString(e.base)))
+		attr(jimageAttributeOffset, uint64(len(content)))
+		attr(jimageAttributeUncompressed, uint64(len(e.content)))
+		locations = append(locations, jimageAttributeEnd)
+		content = append(content, e.content...)
+	}
+
+	header := make([]byte, jimageHeaderSize)
+	binary.LittleEndian.PutUint32(header, jimageMagic)
+	binary.LittleEndian.PutUint32(header[4:], 1<<16)
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(entries)))
+	binary.LittleEndian.PutUint32(header[16:], uint32(length))
+	binary.LittleEndian.PutUint32(header[20:], uint32(len(locations)))
+	binary.LittleEndian.PutUint32(header[24:], uint32(len(strs)))
+
+	var buf bytes.Buffer
+	for _, part := range [][]byte{header, redirect, offsets, locations, strs, content} {
+		buf.Write(part)
+	}
+	return buf.Bytes()
+}
+
+func TestVirtualMachine_ExecMain_InitializerFails(t *testing.T) {
+	throwing := func(name, exception string) *ClassStructure {
+		c := newClassBuilder(name, "java/lang/Object")
+		c.field(AccStatic, "x", "I")
+		c.method(AccStatic, "<clinit>", "()V", 3, 0, newAsm().
+			ref(OpCodeNew, c.classRef(exception)).op(OpCodeDup).op(OpCodeLdc, lo(c.str("boom"))).
+			ref(OpCodeInvokeSpecial, c.methodRef(exception, "<init>", "(Ljava/lang/String;)V")).
+			op(OpCodeAThrow).bytes()...)
+		return c.build()
+	}
+
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printObj := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/Object;)V")
+	x := b.fieldRef("Bad", "x", "I")
+	// each handler prints the exception it catches
+	code := newAsm().
+		label("first").ref(OpCodeGetStatic, x).op(OpCodePop).label("firstEnd").
+		label("again").ref(OpCodeGetStatic, x).op(OpCodePop).label("againEnd").
+		label("error").ref(OpCodeGetStatic, b.fieldRef("Err", "x", "I")).op(OpCodePop).label("errorEnd").
+		op(OpCodeReturn).
+		label("eiie").ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Throwable", "getCause", "()Ljava/lang/Throwable;")).
+		op(OpCodeAstore0).ref(OpCodeGetStatic, out).op(OpCodeAload0).ref(OpCodeInvokeVirtual, printObj).
+		branch(OpCodeGoto, "again").
+		label("handler").op(OpCodeAstore0).ref(OpCodeGetStatic, out).op(OpCodeAload0).ref(OpCodeInvokeVirtual, printObj).
+		branch(OpCodeGoto, "error").
+		label("assertion").op(OpCodeAstore0).ref(OpCodeGetStatic, out).op(OpCodeAload0).ref(OpCodeInvokeVirtual, printObj).
+		op(OpCodeReturn)
+	b.methodWithHandlers(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 1, code.bytes(), []*Exception{
+		{StartPC: code.pc("first"), EndPC: code.pc("firstEnd"), HandlerPC: code.pc("eiie"), CatchType: b.classRef("java/lang/ExceptionInInitializerError")},
+		{StartPC: code.pc("again"), EndPC: code.pc("againEnd"), HandlerPC: code.pc("handler"), CatchType: b.classRef("java/lang/NoClassDefFoundError")},
+		{StartPC: code.pc("error"), EndPC: code.pc("errorEnd"), HandlerPC: code.pc("assertion"), CatchType: b.classRef("java/lang/AssertionError")},
+	})
+
+	cp := mapClassPath{}
+	cp.add(throwing("Bad", "java/lang/IllegalStateException"))
+	cp.add(throwing("Err", "java/lang/AssertionError"))
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "java.lang.IllegalStateException: boom\njava.lang.NoClassDefFoundError: Could not initialize class Bad\njava.lang.AssertionError: boom\n", stdout.String())
+}
+
+func TestRegisterJDKNatives_RejectsReflection(t *testing.T) {
+	vm := NewVM(nil)
+	native, ok := vm.Natives.Lookup("java/lang/Class", "getDeclaredMethods0", "(Z)[Ljava/lang/reflect/Method;")
+	require.True(t, ok)
+	_, err := native(&Frame{VM: vm}, []Value{nil, int32(0)})
+	var ex *JavaException
+	require.ErrorAs(t, err, &ex)
+	require.Equal(t, "java/lang/UnsupportedOperationException", ex.Object.ClassName())
+}
+
+// TestVirtualMachine_UseJDK runs programs on the class library of the JDK
+// that JAVA_HOME names, which is skipped when it is not set.
+func TestVirtualMachine_UseJDK(t *testing.T) {
+	home := os.Getenv("JAVA_HOME")
+	if home == "" {
+		t.Skip("JAVA_HOME is not set")
+	}
+	hello, err := ioutil.ReadFile("HelloWorld.class")
+	require.NoError(t, err)
+	class, err := DecodeClassStructure(bytes.NewReader(hello))
+	require.NoError(t, err)
+	vm := NewVM(class)
+	require.NoError(t, vm.UseJDK(home))
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "Hello world\n", stdout.String())
+
+	// Main.class.getDeclaredMethods() is rejected
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 1, 1, newAsm().
+		op(OpCodeLdc, lo(b.classRef("Main"))).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Class", "getDeclaredMethods", "()[Ljava/lang/reflect/Method;")).
+		op(OpCodePop).op(OpCodeReturn).bytes()...)
+	vm = NewVM(b.build())
+	require.NoError(t, vm.UseJDK(home))
+	err = vm.ExecMain()
+	var ex *JavaException
+	require.ErrorAs(t, err, &ex)
+	require.Equal(t, "java/lang/UnsupportedOperationException", ex.Object.ClassName())
+}
@@ -15,6 +15,12 @@ var (
 	ErrInvalidVersion     = errors.New("invalid version")
 )
 
+// the class file versions from JDK 1.1 to Java 21
+const (
+	minMajorVersion = 45
+	maxMajorVersion = 65
+)
+
 type (
 	ClassStructure struct {
 		Magic             []byte
@@ -54,20 +60,12 @@ func DecodeClassStructure(r io.Reader) (*ClassStructure, error) {
 	if _, err := io.ReadFull(r, ret.MinorVersion); err != nil {
 		return nil, ErrInvalidVersion
 	}
-	for i := range ret.MinorVersion {
-		if ret.MinorVersion[i] != minorVersion[i] {
-			return nil, ErrInvalidVersion
-		}
-	}
-
 	ret.MajorVersion = make([]byte, 2)
 	if _, err := io.ReadFull(r, ret.MajorVersion); err != nil {
 		return nil, ErrInvalidVersion
 	}
-	for i := range ret.MajorVersion {
-		if ret.MajorVersion[i] != majorVersion[i] {
-			return nil, ErrInvalidVersion
-		}
+	if !ret.supportedVersion() {
+		return nil, ErrInvalidVersion
 	}
 
 	buf := make([]byte, 2)
@@ -132,10 +130,15 @@ func DecodeClassStructure(r io.Reader) (*ClassStructure, error) {
 func (c *ClassStructure) readConstantPool(r io.Reader) error {
 	var err error
 	c.ConstantPool = make([]*CpInfo, c.ConstantPoolCount-1)
-	for i := range c.ConstantPool {
+	for i := 0; i < len(c.ConstantPool); i++ {
 		if c.ConstantPool[i], err = readCpInfo(r); err != nil {
 			return fmt.Errorf("read constant pool idx=%d: %w", i, err)
 		}
+		// a long or double takes two entries, the second of which is unusable
+		if tag := c.ConstantPool[i].Tag; (tag == ConstantKindLong || tag == ConstantKindDouble) && i+1 < len(c.ConstantPool) {
+			i++
+			c.ConstantPool[i] = &CpInfo{}
+		}
 	}
 
 	return nil
@@ -193,3 +196,59 @@ func (c *ClassStructure) readAttributes(r io.Reader) error {
 func (c *ClassStructure) GetCpInfo(idx uint16) *CpInfo {
 	return c.ConstantPool[idx-1]
 }
+
+// Major returns the major version of the class file.
+func (c *ClassStructure) Major() int {
+	if len(c.MajorVersion) != 2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(c.MajorVersion))
+}
+
+// Minor returns the minor version of the class file.
+func (c *ClassStructure) Minor() int {
+	if len(c.MinorVersion) != 2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(c.MinorVersion))
+}
+
+// supportedVersion reports whether the VM runs classes of the version of c.
+// The minor version is unrestricted below version 56; from then on it is 0,
+// or 65535 for the preview features of that release.
+func (c *ClassStructure) supportedVersion() bool {
+	major, minor := c.Major(), c.Minor()
+	if major < minMajorVersion || major > maxMajorVersion {
+		return false
+	}
+	return major < 56 || minor == 0 || minor == 0xFFFF
+}
+
+func (c *ClassStructure) Name() (string, error) {
+	return c.ClassName(c.ThisClass)
+}
+
+func (c *ClassStructure) ClassName(idx uint16) (string, error) {
+	class, err := c.GetCpInfo(idx).ToClass()
+	if err != nil {
+		return "", err
+	}
+	return c.GetCpInfo(class.NameIndex).GetAsUTF8String()
+}
+
+func (c *ClassStructure) FindMethod(name, descriptor string) (*MethodInfo, error) {
+	for _, m := range c.Methods {
+		n, err := m.Name(c)
+		if err != nil {
+			return nil, fmt.Errorf("get method name: %w", err)
+		}
+		d, err := c.GetCpInfo(m.DescriptorIndex).GetAsUTF8String()
+		if err != nil {
+			return nil, fmt.Errorf("get method descriptor: %w", err)
+		}
+		if n == name && d == descriptor {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
+	"math"
+	"os"
 	"testing"
 )
 
@@ -40,3 +42,99 @@ func TestDecodeClassStructure(t *testing.T) {
 	require.Equal(t, uint16(1), class.AttributesCount)
 	require.Len(t, class.Attributes, 1)
 }
+
+func TestDecodeClassStructure_Version(t *testing.T) {
+	tests := []struct {
+		major, minor uint16
+		ok           bool
+	}{
+		{major: 45, minor: 3, ok: true},
+		{major: 55, minor: 0, ok: true},
+		{major: 61, minor: 0, ok: true},
+		{major: 65, minor: 0xFFFF, ok: true},
+		{major: 61, minor: 1},
+		{major: 66, minor: 0},
+		{major: 44, minor: 0},
+	}
+	for _, tt := range tests {
+		c := newClassBuilder("Main", "java/lang/Object").build()
+		c.MajorVersion = []byte{hi(tt.major), lo(tt.major)}
+		c.MinorVersion = []byte{hi(tt.minor), lo(tt.minor)}
+		_, err := DecodeClassStructure(bytes.NewReader(encodeClass(c)))
+		if tt.ok {
+			require.NoError(t, err, "%d.%d", tt.major, tt.minor)
+		} else {
+			require.ErrorIs(t, err, ErrInvalidVersion, "%d.%d", tt.major, tt.minor)
+		}
+	}
+}
+
+// TestDecodeClassStructure_JDK decodes classes of the JDK that JAVA_HOME
+// names, which is skipped when it is not set.
+func TestDecodeClassStructure_JDK(t *testing.T) {
+	home := os.Getenv("JAVA_HOME")
+	if home == "" {
+		t.Skip("JAVA_HOME is not set")
+	}
+	cp, err := OpenJDK(home)
+	require.NoError(t, err)
+	defer cp.Close()
+	for _, name := range []string{"java/lang/Object", "java/lang/String", "java/lang/System", "java/lang/Math", "java/util/HashMap", "java/lang/invoke/MethodHandles"} {
+		buf, err := cp.ReadClass(name)
+		require.NoError(t, err, name)
+		c, err := DecodeClassStructure(bytes.NewReader(buf))
+		require.NoError(t, err, name)
+		require.Empty(t, Validate(c), name)
+	}
+}
+
+func TestDecodeClassStructure_LongAndDouble(t *testing.T) {
+	// a long and a double take two constant pool entries each, so the
+	// entries after them are #5 to #8; javac puts them first for a
+	// serialVersionUID or a constant field
+	var buf bytes.Buffer
+	buf.Write(magic)
+	buf.Write(minorVersion)
+	buf.Write(majorVersion)
+	buf.Write(u2(9))
+	buf.WriteByte(byte(ConstantKindLong))
+	buf.Write(u8(0x0123456789ABCDEF))
+	buf.WriteByte(byte(ConstantKindDouble))
+	buf.Write(u8(math.Float64bits(2.5)))
+	buf.WriteByte(byte(ConstantKindUTF8))
+	buf.Write(append(u2(4), "Main"...))
+	buf.WriteByte(byte(ConstantKindClass))
+	buf.Write(u2(5))
+	buf.WriteByte(byte(ConstantKindUTF8))
+	buf.Write(append(u2(16), "java/lang/Object"...))
+	buf.WriteByte(byte(ConstantKindClass))
+	buf.Write(u2(7))
+	buf.Write(u2(AccPublic | AccSuper))
+	buf.Write(u2(6)) // this_class
+	buf.Write(u2(8)) // super_class
+	buf.Write(u2(0)) // interfaces
+	buf.Write(u2(0)) // fields
+	buf.Write(u2(0)) // methods
+	buf.Write(u2(0)) // attributes
+
+	c, err := DecodeClassStructure(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, c.ConstantPool, 8)
+	require.Empty(t, Validate(c))
+	name, err := c.ClassName(c.ThisClass)
+	require.NoError(t, err)
+	require.Equal(t, "Main", name)
+	super, err := c.ClassName(c.SuperClass)
+	require.NoError(t, err)
+	require.Equal(t, "java/lang/Object", super)
+
+	vm := NewVM(c)
+	class, err := vm.DefineClass(c)
+	require.NoError(t, err)
+	v, err := vm.loadConstant(class, 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(0x0123456789ABCDEF), v)
+	v, err = vm.loadConstant(class, 3)
+	require.NoError(t, err)
+	require.Equal(t, 2.5, v)
+}
@@ -147,14 +147,55 @@ func readCpInfo(r io.Reader) (*CpInfo, error) {
 	}, nil
 }
 
+// GetAsUTF8String decodes the modified UTF-8 of a Utf8 entry. Lone
+// surrogates keep their code units, as javaChars reads them back.
 func (c *CpInfo) GetAsUTF8String() (string, error) {
 	if c.Tag != ConstantKindUTF8 {
 		return "", fmt.Errorf("constant kind mismatch. kind should be UTF8")
 	}
-	if len(c.Info) < 3 {
+	if len(c.Info) < 2 {
 		return "", fmt.Errorf("cp info is invalid as kind UTF8")
 	}
-	return string(c.Info[2:]), nil
+	b := c.Info[2:]
+	ascii := true
+	for _, x := range b {
+		if x == 0 || x >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return string(b), nil
+	}
+	chars, err := decodeModifiedUTF8(b)
+	if err != nil {
+		return "", err
+	}
+	return stringFromChars(chars), nil
+}
+
+// decodeModifiedUTF8 decodes the modified UTF-8 of class files, which writes
+// NUL in two bytes and every UTF-16 code unit of a supplementary character
+// in three, to UTF-16 code units.
+func decodeModifiedUTF8(b []byte) ([]uint16, error) {
+	chars := make([]uint16, 0, len(b))
+	for i := 0; i < len(b); {
+		x := b[i]
+		switch {
+		case x != 0 && x < 0x80:
+			chars = append(chars, uint16(x))
+			i++
+		case x&0xE0 == 0xC0 && i+1 < len(b) && b[i+1]&0xC0 == 0x80:
+			chars = append(chars, uint16(x&0x1F)<<6|uint16(b[i+1]&0x3F))
+			i += 2
+		case x&0xF0 == 0xE0 && i+2 < len(b) && b[i+1]&0xC0 == 0x80 && b[i+2]&0xC0 == 0x80:
+			chars = append(chars, uint16(x&0x0F)<<12|uint16(b[i+1]&0x3F)<<6|uint16(b[i+2]&0x3F))
+			i += 3
+		default:
+			return nil, fmt.Errorf("malformed modified UTF-8 at byte %d", i)
+		}
+	}
+	return chars, nil
 }
 
 func (c *CpInfo) ToFieldRef() (*Fieldref, error) {
@@ -214,3 +255,18 @@ func (c *CpInfo) ToMethodRef() (*Methodref, error) {
 		NameAndTypeIndex: binary.BigEndian.Uint16(c.Info[2:]),
 	}, nil
 }
+
+func (c *CpInfo) ToInterfaceMethodRef() (*Methodref, error) {
+	if c.Tag != ConstantKindInterfaceMethodref {
+		return nil, fmt.Errorf("constant kind mismatch. kind should be interface method ref")
+	}
+	if len(c.Info) < 4 {
+		return nil, fmt.Errorf("cp info is invalid as kind interface method ref")
+	}
+
+	return &Methodref{
+		Tag:              c.Tag,
+		ClassIndex:       binary.BigEndian.Uint16(c.Info[:2]),
+		NameAndTypeIndex: binary.BigEndian.Uint16(c.Info[2:]),
+	}, nil
+}
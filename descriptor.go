@@ -0,0 +1,79 @@
+package jvmgo
+
+import "fmt"
+
+type (
+	MethodDescriptor struct {
+		Parameters []string
+		Return     string
+	}
+)
+
+func parseMethodDescriptor(desc string) (*MethodDescriptor, error) {
+	if len(desc) == 0 || desc[0] != '(' {
+		return nil, fmt.Errorf("invalid method descriptor: %q", desc)
+	}
+	ret := &MethodDescriptor{}
+	i := 1
+	for i < len(desc) && desc[i] != ')' {
+		n, err := fieldDescriptorLength(desc[i:])
+		if err != nil {
+			return nil, fmt.Errorf("parse parameter of %q: %w", desc, err)
+		}
+		ret.Parameters = append(ret.Parameters, desc[i:i+n])
+		i += n
+	}
+	if i >= len(desc) {
+		return nil, fmt.Errorf("invalid method descriptor: %q", desc)
+	}
+	i++
+	if desc[i:] == "V" {
+		ret.Return = "V"
+		return ret, nil
+	}
+	n, err := fieldDescriptorLength(desc[i:])
+	if err != nil || i+n != len(desc) {
+		return nil, fmt.Errorf("invalid return type of %q", desc)
+	}
+	ret.Return = desc[i:]
+
+	return ret, nil
+}
+
+func fieldDescriptorLength(desc string) (int, error) {
+	i := 0
+	for i < len(desc) && desc[i] == '[' {
+		i++
+	}
+	if i >= len(desc) {
+		return 0, fmt.Errorf("invalid field descriptor: %q", desc)
+	}
+	switch desc[i] {
+	case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z':
+		return i + 1, nil
+	case 'L':
+		for j := i + 1; j < len(desc); j++ {
+			if desc[j] == ';' {
+				return j + 1, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("invalid field descriptor: %q", desc)
+}
+
+// ArgSlots returns the number of local variable slots the parameters occupy.
+func (d *MethodDescriptor) ArgSlots() int {
+	n := 0
+	for _, p := range d.Parameters {
+		n += descriptorSlots(p)
+	}
+	return n
+}
+
+func descriptorSlots(desc string) int {
+	if desc == "J" || desc == "D" {
+		return 2
+	}
+	return 1
+}
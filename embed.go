@@ -0,0 +1,290 @@
+package jvmgo
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sync/atomic"
+)
+
+// integerRanges bounds the Go integers ToJava accepts for each integral type.
+var integerRanges = map[string][2]int64{
+	"B": {math.MinInt8, math.MaxInt8},
+	"C": {0, math.MaxUint16},
+	"S": {math.MinInt16, math.MaxInt16},
+	"I": {math.MinInt32, math.MaxInt32},
+	"J": {math.MinInt64, math.MaxInt64},
+}
+
+var objectType = reflect.TypeOf((*Object)(nil))
+
+// Invoke calls the static method name with the descriptor desc of the named
+// class and returns its result. The arguments are converted with ToJava and
+// the result with FromJava. Like ExecMain, the first call brings up the class
+// library and defines the main class. Calls run on the main thread, so calls
+// from several goroutines take turns.
+func (vm *VirtualMachine) Invoke(className, name, desc string, args ...interface{}) (interface{}, error) {
+	vm.entryMu.Lock()
+	defer vm.entryMu.Unlock()
+	atomic.StoreInt64(&vm.instructions, 0)
+	vm.enterWorld(vm.main)
+	defer vm.leaveWorld(vm.main)
+	if _, err := vm.boot(); err != nil {
+		return nil, err
+	}
+
+	class, err := vm.LoadClass(className)
+	if err != nil {
+		return nil, fmt.Errorf("invoke %s.%s%s: %w", className, name, desc, err)
+	}
+	m := class.LookupMethod(name, desc)
+	if m == nil {
+		return nil, fmt.Errorf("invoke %s.%s%s: method does not exist", className, name, desc)
+	}
+	if !m.IsStatic() {
+		return nil, fmt.Errorf("invoke %s.%s%s: method is not static", className, name, desc)
+	}
+	if len(args) != len(m.Desc.Parameters) {
+		return nil, fmt.Errorf("invoke %s.%s%s: %d arguments given, %d expected", className, name, desc, len(args), len(m.Desc.Parameters))
+	}
+	in := make([]Value, len(args))
+	vm.main.hold(in)
+	defer vm.main.release()
+	for i, p := range m.Desc.Parameters {
+		if in[i], err = vm.ToJava(p, args[i]); err != nil {
+			return nil, fmt.Errorf("invoke %s.%s%s: argument %d: %w", className, name, desc, i, err)
+		}
+	}
+	if err := vm.initializeClass(nil, m.Class); err != nil {
+		return nil, fmt.Errorf("invoke %s.%s%s: initialize %s: %w", className, name, desc, m.Class.Name, err)
+	}
+	ret, err := vm.invokeMethod(nil, m, in)
+	if err != nil {
+		return nil, fmt.Errorf("invoke %s.%s%s: %w", className, name, desc, err)
+	}
+	return FromJava(m.Desc.Return, ret), nil
+}
+
+// ToJava converts a Go value to a Java value of the type the field
+// descriptor desc names. A primitive type takes a bool or a Go integer or
+// float it can hold; byte takes a uint8 as its bit pattern. A reference type
+// takes nil, an *Object, a string, which becomes a java.lang.String, a slice,
+// which becomes an array, or a value that is boxed.
+func (vm *VirtualMachine) ToJava(desc string, v interface{}) (Value, error) {
+	if desc[0] != 'L' && desc[0] != '[' {
+		return toPrimitive(desc, v)
+	}
+	obj, err := vm.toObject(desc, v)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	name := desc
+	if desc[0] == 'L' {
+		name = desc[1 : len(desc)-1]
+	}
+	t, err := vm.classOrStub(name)
+	if err != nil {
+		return nil, err
+	}
+	if !obj.Class.IsAssignableTo(t) {
+		return nil, fmt.Errorf("cannot convert %T to %s: %s is not assignable", v, javaTypeName(desc), javaTypeName(classDescriptor(obj.Class)))
+	}
+	return obj, nil
+}
+
+func toPrimitive(desc string, v interface{}) (Value, error) {
+	rv := reflect.ValueOf(v)
+	var n int64
+	switch k := rv.Kind(); {
+	case k == reflect.Bool && desc == "Z":
+		return javaBool(rv.Bool()), nil
+	case k == reflect.Float32 || k == reflect.Float64:
+		switch desc {
+		case "F":
+			return float32(rv.Float()), nil
+		case "D":
+			return rv.Float(), nil
+		}
+		return nil, fmt.Errorf("cannot convert %T to %s", v, javaTypeName(desc))
+	case k >= reflect.Int && k <= reflect.Int64:
+		n = rv.Int()
+	case k == reflect.Uint8 && desc == "B":
+		return int32(int8(rv.Uint())), nil
+	case k >= reflect.Uint && k <= reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%v overflows %s", v, javaTypeName(desc))
+		}
+		n = int64(rv.Uint())
+	default:
+		return nil, fmt.Errorf("cannot convert %T to %s", v, javaTypeName(desc))
+	}
+	switch desc {
+	case "F":
+		return float32(n), nil
+	case "D":
+		return float64(n), nil
+	}
+	r, ok := integerRanges[desc]
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %T to %s", v, javaTypeName(desc))
+	}
+	if n < r[0] || n > r[1] {
+		return nil, fmt.Errorf("%v overflows %s", v, javaTypeName(desc))
+	}
+	if desc == "J" {
+		return n, nil
+	}
+	return int32(n), nil
+}
+
+func (vm *VirtualMachine) toObject(desc string, v interface{}) (*Object, error) {
+	if obj, ok := v.(*Object); ok || v == nil {
+		return obj, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return vm.NewString(rv.String()), nil
+	case reflect.Slice, reflect.Array:
+		arrayDesc := desc
+		if desc[0] != '[' {
+			d, ok := goDescriptor(rv.Type())
+			if !ok {
+				return nil, fmt.Errorf("cannot convert %T to %s", v, javaTypeName(desc))
+			}
+			arrayDesc = d
+		}
+		arr, err := vm.newArray(nil, arrayDesc, int32(rv.Len()))
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < rv.Len(); i++ {
+			e, err := vm.ToJava(arrayDesc[1:], rv.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			if err := vm.arrayStore(nil, arr, int32(i), e); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	// a primitive for a reference type is boxed, as the type or the Go
+	// value asks
+	p, ok := "", false
+	if desc[0] == 'L' {
+		p, ok = boxDescriptors[desc[1:len(desc)-1]]
+	}
+	if !ok {
+		p, ok = goDescriptor(rv.Type())
+	}
+	if !ok || len(p) != 1 {
+		return nil, fmt.Errorf("cannot convert %T to %s", v, javaTypeName(desc))
+	}
+	value, err := toPrimitive(p, v)
+	if err != nil {
+		return nil, err
+	}
+	boxed, err := vm.box(nil, p, value)
+	if err != nil {
+		return nil, err
+	}
+	return boxed.(*Object), nil
+}
+
+// goDescriptor returns the Java type a Go type converts to when the target
+// type does not decide it.
+func goDescriptor(t reflect.Type) (string, bool) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "Z", true
+	case reflect.Int8, reflect.Uint8:
+		return "B", true
+	case reflect.Int16:
+		return "S", true
+	case reflect.Uint16:
+		return "C", true
+	case reflect.Int, reflect.Int32:
+		return "I", true
+	case reflect.Int64:
+		return "J", true
+	case reflect.Float32:
+		return "F", true
+	case reflect.Float64:
+		return "D", true
+	case reflect.String:
+		return "Ljava/lang/String;", true
+	case reflect.Interface:
+		return "Ljava/lang/Object;", true
+	case reflect.Slice, reflect.Array:
+		if elem, ok := goDescriptor(t.Elem()); ok {
+			return "[" + elem, true
+		}
+	}
+	if t == objectType {
+		return "Ljava/lang/Object;", true
+	}
+	return "", false
+}
+
+// FromJava converts a Java value of the type the field descriptor desc names
+// to a Go value. A primitive becomes a bool, int8, uint16, int16, int32,
+// int64, float32 or float64, a String a string, a boxed primitive the Go
+// value of the primitive and an array a slice of Go values. Other objects are
+// returned as *Object, and null as nil.
+func FromJava(desc string, v Value) interface{} {
+	switch desc {
+	case "V":
+		return nil
+	case "Z":
+		return v.(int32) != 0
+	case "B":
+		return int8(v.(int32))
+	case "C":
+		return uint16(v.(int32))
+	case "S":
+		return int16(v.(int32))
+	case "I", "J", "F", "D":
+		return v
+	}
+	obj, _ := v.(*Object)
+	if obj == nil {
+		return nil
+	}
+	if obj.ClassName() == "java/lang/String" {
+		return javaStringValue(obj)
+	}
+	if value, p, ok := unbox(obj); ok {
+		return FromJava(p, value)
+	}
+	switch a := obj.Array.(type) {
+	case []int8:
+		if obj.ClassName() == "[Z" {
+			bools := make([]bool, len(a))
+			for i, b := range a {
+				bools[i] = b != 0
+			}
+			return bools
+		}
+		return append([]int8{}, a...)
+	case []uint16:
+		return append([]uint16{}, a...)
+	case []int16:
+		return append([]int16{}, a...)
+	case []int32:
+		return append([]int32{}, a...)
+	case []int64:
+		return append([]int64{}, a...)
+	case []float32:
+		return append([]float32{}, a...)
+	case []float64:
+		return append([]float64{}, a...)
+	case []Value:
+		values := make([]interface{}, len(a))
+		for i, e := range a {
+			values[i] = FromJava("Ljava/lang/Object;", e)
+		}
+		return values
+	}
+	return obj
+}
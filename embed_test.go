@@ -0,0 +1,142 @@
+package jvmgo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachine_Invoke(t *testing.T) {
+	b := newClassBuilder("com/foo/Calc", "java/lang/Object")
+	b.method(AccPublic|AccStatic, "add", "(II)I", 2, 2, newAsm().
+		op(OpCodeIload0).op(OpCodeIload0+1).op(OpCodeIadd).op(OpCodeIreturn).bytes()...)
+	b.method(AccPublic|AccStatic, "not", "(Z)Z", 2, 1, newAsm().
+		op(OpCodeIload0).op(OpCodeIconst0+1).op(OpCodeIxor).op(OpCodeIreturn).bytes()...)
+	b.method(AccPublic|AccStatic, "first", "([J)J", 2, 1, newAsm().
+		op(OpCodeAload0).op(OpCodeIconst0).op(OpCodeLaload).op(OpCodeLreturn).bytes()...)
+	b.method(AccPublic|AccStatic, "id", "(Ljava/lang/Object;)Ljava/lang/Object;", 1, 1, newAsm().
+		op(OpCodeAload0).op(OpCodeAreturn).bytes()...)
+	b.method(AccPublic, "self", "()Ljava/lang/Object;", 1, 1, newAsm().
+		op(OpCodeAload0).op(OpCodeAreturn).bytes()...)
+	b.method(AccPublic|AccStatic, "fail", "()V", 3, 0, newAsm().
+		ref(OpCodeNew, b.classRef("java/lang/IllegalStateException")).op(OpCodeDup).
+		ref(OpCodeInvokeSpecial, b.methodRef("java/lang/IllegalStateException", "<init>", "()V")).
+		op(OpCodeAThrow).bytes()...)
+	vm := NewVM(nil)
+	_, err := vm.DefineClass(b.build())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		desc string
+		args []interface{}
+		want interface{}
+	}{
+		{name: "add", desc: "(II)I", args: []interface{}{1, 2}, want: int32(3)},
+		{name: "not", desc: "(Z)Z", args: []interface{}{true}, want: false},
+		{name: "first", desc: "([J)J", args: []interface{}{[]int64{7, 8}}, want: int64(7)},
+		{name: "id", desc: "(Ljava/lang/Object;)Ljava/lang/Object;", args: []interface{}{"hi"}, want: "hi"},
+		{name: "id", desc: "(Ljava/lang/Object;)Ljava/lang/Object;", args: []interface{}{5}, want: int32(5)},
+		{name: "id", desc: "(Ljava/lang/Object;)Ljava/lang/Object;", args: []interface{}{2.5}, want: 2.5},
+		{name: "id", desc: "(Ljava/lang/Object;)Ljava/lang/Object;", args: []interface{}{[]interface{}{"a", true, nil}}, want: []interface{}{"a", true, nil}},
+		{name: "id", desc: "(Ljava/lang/Object;)Ljava/lang/Object;", args: []interface{}{[]byte{1, 255}}, want: []int8{1, -1}},
+		{name: "id", desc: "(Ljava/lang/Object;)Ljava/lang/Object;", args: []interface{}{nil}, want: nil},
+	}
+	for _, tt := range tests {
+		got, err := vm.Invoke("com/foo/Calc", tt.name, tt.desc, tt.args...)
+		require.NoError(t, err)
+		require.Equal(t, tt.want, got)
+	}
+
+	_, err = vm.Invoke("com/foo/Calc", "add", "(II)I", int64(1)<<40, 1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "argument 0: 1099511627776 overflows int")
+	_, err = vm.Invoke("com/foo/Calc", "add", "(II)I", 1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "1 arguments given, 2 expected")
+	_, err = vm.Invoke("com/foo/Calc", "add", "(II)I", "1", 2)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot convert string to int")
+	_, err = vm.Invoke("com/foo/Calc", "sub", "(II)I", 1, 2)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "method does not exist")
+	_, err = vm.Invoke("com/foo/Calc", "self", "()Ljava/lang/Object;")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "method is not static")
+	_, err = vm.Invoke("com/foo/Calc", "fail", "()V")
+	var ex *JavaException
+	require.ErrorAs(t, err, &ex)
+	require.Equal(t, "java/lang/IllegalStateException", ex.Object.ClassName())
+}
+
+func TestVirtualMachine_ExecMain_Args(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	// System.out.println(args.length); System.out.println(args[1]);
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 3, 1, newAsm().
+		ref(OpCodeGetStatic, out).op(OpCodeAload0).op(OpCodeArrayLength).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(I)V")).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0).op(OpCodeIconst0+1).op(OpCodeAaload).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")).
+		op(OpCodeReturn).bytes()...)
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain("a", "b"))
+	require.Equal(t, "2\nb\n", stdout.String())
+}
+
+func TestVirtualMachine_ExecMain_MainResolution(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags uint16
+		desc  string
+		want  string
+	}{
+		{name: "instance main", flags: AccPublic, desc: "([Ljava/lang/String;)V", want: "main method of class Main is not public static"},
+		{name: "private main", flags: AccPrivate | AccStatic, desc: "([Ljava/lang/String;)V", want: "main method of class Main is not public static"},
+		{name: "other descriptor", flags: AccPublic | AccStatic, desc: "()V", want: "main method not found in class Main"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newClassBuilder("Main", "java/lang/Object")
+			b.method(tt.flags, "main", tt.desc, 0, 1, byte(OpCodeReturn))
+			err := NewVM(b.build()).ExecMain()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.want)
+		})
+	}
+
+	// main is inherited from a superclass
+	base := newClassBuilder("Base", "java/lang/Object")
+	base.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 1, newAsm().
+		ref(OpCodeGetStatic, base.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")).op(OpCodeLdc, lo(base.str("base"))).
+		ref(OpCodeInvokeVirtual, base.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")).
+		op(OpCodeReturn).bytes()...)
+	vm := NewVM(newClassBuilder("Main", "Base").build())
+	_, err := vm.DefineClass(base.build())
+	require.NoError(t, err)
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "base\n", stdout.String())
+}
+
+func TestVirtualMachine_ExecMain_NoProcessOutput(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 0, 1, byte(OpCodeReturn))
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	err = NewVM(b.build()).ExecMain()
+	os.Stdout = stdout
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	written, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Empty(t, string(written))
+}
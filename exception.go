@@ -0,0 +1,83 @@
+package jvmgo
+
+import (
+	"fmt"
+)
+
+type (
+	// JavaException carries a thrown java.lang.Throwable through Go call chains.
+	JavaException struct {
+		Object *Object
+	}
+)
+
+func (e *JavaException) Error() string {
+	if e.Object == nil {
+		return "null exception"
+	}
+	msg, _ := e.Object.GetField("detailMessage", "Ljava/lang/String;")
+	if msg == nil {
+		return e.Object.ClassName()
+	}
+	return fmt.Sprintf("%s: %s", e.Object.ClassName(), javaStringValue(msg))
+}
+
+// throwNew creates an instance of the named Throwable class and returns it as
+// an error to be raised in the current thread.
+func (vm *VirtualMachine) throwNew(caller *Frame, className, message string) error {
+	class, err := vm.LoadClass(className)
+	if err != nil {
+		return fmt.Errorf("%s: %s (throwable class unavailable: %v)", className, message, err)
+	}
+	if err := vm.initializeClass(caller, class); err != nil {
+		return err
+	}
+
+	obj := NewObject(class)
+	ctor := class.DeclaredMethod("<init>", "(Ljava/lang/String;)V")
+	var args []Value
+	if ctor != nil {
+		args = []Value{obj, vm.NewString(message)}
+	} else if ctor = class.DeclaredMethod("<init>", "()V"); ctor != nil {
+		args = []Value{obj}
+	}
+	if ctor != nil {
+		if _, err := vm.invokeMethod(caller, ctor, args); err != nil {
+			return err
+		}
+	}
+	if ctor == nil || len(args) == 1 {
+		obj.SetField("detailMessage", "Ljava/lang/String;", vm.NewString(message))
+	}
+
+	return &JavaException{Object: obj}
+}
+
+func (vm *VirtualMachine) throwNullPointer(caller *Frame) error {
+	return vm.throwNew(caller, "java/lang/NullPointerException", "")
+}
+
+// findExceptionHandler returns the pc of the handler in f that catches ex
+// thrown at pc, or -1.
+func (vm *VirtualMachine) findExceptionHandler(f *Frame, pc int, ex *Object) (int, error) {
+	for _, e := range f.Code.ExceptionTable {
+		if pc < int(e.StartPC) || pc >= int(e.EndPC) {
+			continue
+		}
+		if e.CatchType == 0 {
+			return int(e.HandlerPC), nil
+		}
+		name, err := f.Class.File.ClassName(e.CatchType)
+		if err != nil {
+			return -1, fmt.Errorf("get catch type: %w", err)
+		}
+		catchType, err := vm.LoadClass(name)
+		if err != nil {
+			return -1, err
+		}
+		if ex.Class.IsSubclassOf(catchType) {
+			return int(e.HandlerPC), nil
+		}
+	}
+	return -1, nil
+}
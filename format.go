@@ -0,0 +1,244 @@
+package jvmgo
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var formatSpecifier = regexp.MustCompile(`%(\d+\$)?([-#+ 0,(<]*)?(\d+)?(\.\d+)?([a-zA-Z%])`)
+
+// javaFormat implements the common subset of java.util.Formatter used by
+// String.format and PrintStream.printf.
+func (vm *VirtualMachine) javaFormat(caller *Frame, format string, argArray Value) (string, error) {
+	var args []Value
+	if arr, ok := argArray.(*Object); ok && arr != nil {
+		args, _ = arr.Array.([]Value)
+	}
+
+	var b strings.Builder
+	next, last := 0, -1
+	rest := format
+	for {
+		loc := formatSpecifier.FindStringSubmatchIndex(rest)
+		if loc == nil {
+			if i := strings.IndexByte(rest, '%'); i >= 0 {
+				return "", throwOrError(caller, "java/util/UnknownFormatConversionException", fmt.Sprintf("Conversion = '%s'", rest[i:]))
+			}
+			b.WriteString(rest)
+			return b.String(), nil
+		}
+		if i := strings.IndexByte(rest[:loc[0]], '%'); i >= 0 {
+			return "", throwOrError(caller, "java/util/UnknownFormatConversionException", fmt.Sprintf("Conversion = '%c'", rest[i+1]))
+		}
+		b.WriteString(rest[:loc[0]])
+		spec := rest[loc[0]:loc[1]]
+		group := func(n int) string {
+			if loc[2*n] < 0 {
+				return ""
+			}
+			return rest[loc[2*n]:loc[2*n+1]]
+		}
+		flags, width, precision, conv := group(2), group(3), group(4), group(5)
+		rest = rest[loc[1]:]
+
+		switch conv {
+		case "n":
+			b.WriteString("\n")
+			continue
+		case "%":
+			b.WriteString(pad("%", flags, width))
+			continue
+		}
+
+		idx := next
+		switch {
+		case group(1) != "":
+			n, _ := strconv.Atoi(strings.TrimSuffix(group(1), "$"))
+			idx = n - 1
+		case strings.Contains(flags, "<"):
+			idx = last
+		default:
+			next++
+		}
+		if idx < 0 || idx >= len(args) {
+			return "", throwOrError(caller, "java/util/MissingFormatArgumentException", fmt.Sprintf("Format specifier '%s'", spec))
+		}
+		last = idx
+
+		s, err := vm.formatValue(caller, args[idx], flags, precision, conv)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(pad(s, flags, width))
+	}
+}
+
+func (vm *VirtualMachine) formatValue(caller *Frame, arg Value, flags, precision, conv string) (string, error) {
+	upper := strings.ToUpper(conv) == conv
+	var s string
+	switch strings.ToLower(conv) {
+	case "s":
+		str, err := vm.javaString(caller, "Ljava/lang/Object;", arg)
+		if err != nil {
+			return "", err
+		}
+		if precision != "" {
+			if n, _ := strconv.Atoi(precision[1:]); n < len([]rune(str)) {
+				str = string([]rune(str)[:n])
+			}
+		}
+		s = str
+	case "b":
+		v, desc, ok := unbox(arg)
+		s = strconv.FormatBool(arg != nil && (!ok || desc != "Z" || v.(int32) != 0))
+	case "c":
+		v, _, ok := unbox(arg)
+		if arg == nil {
+			s = "null"
+		} else if !ok {
+			return "", vm.formatConversionError(caller, conv, arg)
+		} else {
+			s = string(rune(v.(int32)))
+		}
+	case "d", "x", "o":
+		v, desc, ok := unbox(arg)
+		if arg == nil {
+			s = "null"
+			break
+		}
+		if !ok || desc == "F" || desc == "D" || desc == "Z" || desc == "C" {
+			return "", vm.formatConversionError(caller, conv, arg)
+		}
+		n := convertPrimitive(v, "J").(int64)
+		switch strings.ToLower(conv) {
+		case "d":
+			s = formatDecimal(n, flags)
+		default:
+			base := 16
+			if conv == "o" {
+				base = 8
+			}
+			u := uint64(n)
+			if desc != "J" {
+				u = uint64(uint32(n))
+				if desc == "B" {
+					u = uint64(uint8(n))
+				} else if desc == "S" {
+					u = uint64(uint16(n))
+				}
+			}
+			s = strconv.FormatUint(u, base)
+			if strings.Contains(flags, "#") {
+				s = map[int]string{16: "0x", 8: "0"}[base] + s
+			}
+		}
+	case "f", "e", "g":
+		v, desc, ok := unbox(arg)
+		if arg == nil {
+			s = "null"
+			break
+		}
+		if !ok || (desc != "F" && desc != "D") {
+			return "", vm.formatConversionError(caller, conv, arg)
+		}
+		f := convertPrimitive(v, "D").(float64)
+		prec := 6
+		if precision != "" {
+			prec, _ = strconv.Atoi(precision[1:])
+		}
+		switch {
+		case math.IsNaN(f):
+			s = "NaN"
+		case math.IsInf(f, 0):
+			s = "Infinity"
+			if f < 0 {
+				s = "-Infinity"
+			} else if strings.Contains(flags, "+") {
+				s = "+Infinity"
+			}
+		default:
+			s = strconv.FormatFloat(f, strings.ToLower(conv)[0], prec, 64)
+			if strings.Contains(flags, ",") && conv == "f" {
+				intPart, frac := s, ""
+				if i := strings.IndexByte(s, '.'); i >= 0 {
+					intPart, frac = s[:i], s[i:]
+				}
+				n, _ := strconv.ParseInt(intPart, 10, 64)
+				s = formatDecimal(n, ",") + frac
+				if n == 0 && math.Signbit(f) {
+					s = "-" + s
+				}
+			}
+			if f >= 0 && strings.Contains(flags, "+") {
+				s = "+" + s
+			} else if f >= 0 && strings.Contains(flags, " ") {
+				s = " " + s
+			}
+		}
+	default:
+		return "", throwOrError(caller, "java/util/UnknownFormatConversionException", fmt.Sprintf("Conversion = '%s'", conv))
+	}
+	if upper {
+		s = strings.ToUpper(s)
+	}
+	return s, nil
+}
+
+func (vm *VirtualMachine) formatConversionError(caller *Frame, conv string, arg Value) error {
+	class, err := vm.classOf(arg)
+	if err != nil {
+		return err
+	}
+	return throwOrError(caller, "java/util/IllegalFormatConversionException", fmt.Sprintf("%s != %s", conv, javaClassName(class.Name)))
+}
+
+func formatDecimal(n int64, flags string) string {
+	s := strconv.FormatInt(n, 10)
+	neg := n < 0
+	if neg {
+		s = s[1:]
+	}
+	if strings.Contains(flags, ",") {
+		var b strings.Builder
+		for i, c := range s {
+			if i > 0 && (len(s)-i)%3 == 0 {
+				b.WriteByte(',')
+			}
+			b.WriteRune(c)
+		}
+		s = b.String()
+	}
+	switch {
+	case neg && strings.Contains(flags, "("):
+		return "(" + s + ")"
+	case neg:
+		return "-" + s
+	case strings.Contains(flags, "+"):
+		return "+" + s
+	case strings.Contains(flags, " "):
+		return " " + s
+	}
+	return s
+}
+
+func pad(s, flags, width string) string {
+	w, _ := strconv.Atoi(width)
+	n := len([]rune(s))
+	if n >= w {
+		return s
+	}
+	switch {
+	case strings.Contains(flags, "-"):
+		return s + strings.Repeat(" ", w-n)
+	case strings.Contains(flags, "0"):
+		sign := ""
+		if len(s) > 0 && strings.ContainsAny(s[:1], "+- (") {
+			sign, s = s[:1], s[1:]
+		}
+		return sign + strings.Repeat("0", w-n) + s
+	}
+	return strings.Repeat(" ", w-n) + s
+}
@@ -0,0 +1,195 @@
+package jvmgo
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type (
+	Frame struct {
+		VM           *VirtualMachine
+		Caller       *Frame
+		Class        *RuntimeClass
+		Method       *RuntimeMethod
+		Code         *CodeAttribute
+		Locals       []Value
+		OperandStack *OperandStack
+		PC           int
+		Depth        int
+
+		thread *thread
+	}
+	OperandStack []Value
+)
+
+func newFrame(vm *VirtualMachine, caller *Frame, m *RuntimeMethod, args []Value) (*Frame, error) {
+	locals := make([]Value, m.Code.MaxLocals)
+	slot := 0
+	if !m.IsStatic() {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("missing receiver")
+		}
+		locals[0] = args[0]
+		args = args[1:]
+		slot++
+	}
+	if len(args) != len(m.Desc.Parameters) {
+		return nil, fmt.Errorf("argument count mismatch: want %d, got %d", len(m.Desc.Parameters), len(args))
+	}
+	for i, p := range m.Desc.Parameters {
+		if slot >= len(locals) {
+			return nil, fmt.Errorf("arguments exceed max locals %d", m.Code.MaxLocals)
+		}
+		locals[slot] = args[i]
+		slot += descriptorSlots(p)
+	}
+
+	depth := 0
+	var t *thread
+	if caller != nil {
+		depth = caller.Depth + 1
+		t = caller.thread
+	}
+	return &Frame{
+		VM:           vm,
+		Caller:       caller,
+		Class:        m.Class,
+		Method:       m,
+		Code:         m.Code,
+		Locals:       locals,
+		OperandStack: &OperandStack{},
+		Depth:        depth,
+		thread:       t,
+	}, nil
+}
+
+func (f *Frame) readU1() (uint8, error) {
+	if f.PC >= len(f.Code.Code) {
+		return 0, fmt.Errorf("unexpected end of code at pc=%d", f.PC)
+	}
+	b := f.Code.Code[f.PC]
+	f.PC++
+	return b, nil
+}
+
+func (f *Frame) readU2() (uint16, error) {
+	if f.PC+2 > len(f.Code.Code) {
+		return 0, fmt.Errorf("unexpected end of code at pc=%d", f.PC)
+	}
+	v := binary.BigEndian.Uint16(f.Code.Code[f.PC:])
+	f.PC += 2
+	return v, nil
+}
+
+func (f *Frame) readU4() (uint32, error) {
+	if f.PC+4 > len(f.Code.Code) {
+		return 0, fmt.Errorf("unexpected end of code at pc=%d", f.PC)
+	}
+	v := binary.BigEndian.Uint32(f.Code.Code[f.PC:])
+	f.PC += 4
+	return v, nil
+}
+
+func (f *Frame) load(idx int) error {
+	if idx >= len(f.Locals) {
+		return fmt.Errorf("local variable index %d out of range", idx)
+	}
+	f.OperandStack.push(f.Locals[idx])
+	return nil
+}
+
+// store pops a value into local idx. A long or double also takes local
+// idx+1, and overwriting either half of one invalidates it.
+func (f *Frame) store(idx int) error {
+	if idx >= len(f.Locals) {
+		return fmt.Errorf("local variable index %d out of range", idx)
+	}
+	v, ok := f.OperandStack.pop()
+	if !ok {
+		return fmt.Errorf("operand stack is empty")
+	}
+	if isCategory2(v) {
+		if idx+1 >= len(f.Locals) {
+			return fmt.Errorf("local variable index %d out of range", idx+1)
+		}
+		f.Locals[idx+1] = nil
+	}
+	if idx > 0 && isCategory2(f.Locals[idx-1]) {
+		f.Locals[idx-1] = nil
+	}
+	f.Locals[idx] = v
+	return nil
+}
+
+// isCategory2 reports whether v is a long or double, which takes two words
+// of the operand stack and two local variables.
+func isCategory2(v Value) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+// popWords pops values taking n words of the operand stack, top first.
+func (s *OperandStack) popWords(n int) []Value {
+	var values []Value
+	for n > 0 {
+		v := s.mustPop()
+		if isCategory2(v) {
+			n--
+			if n == 0 {
+				panic(stackError{"operand stack splits a long or double"})
+			}
+		}
+		n--
+		values = append(values, v)
+	}
+	return values
+}
+
+// dupX duplicates the top n words of the operand stack and inserts the copy
+// below the x words under them, which covers dup, dup2 and their _x1 and
+// _x2 forms.
+func (s *OperandStack) dupX(n, x int) {
+	top := s.popWords(n)
+	under := s.popWords(x)
+	for _, group := range [][]Value{top, under, top} {
+		for i := len(group) - 1; i >= 0; i-- {
+			s.push(group[i])
+		}
+	}
+}
+
+func (s *OperandStack) push(ope Value) {
+	*s = append(*s, ope)
+}
+
+func (s *OperandStack) pop() (Value, bool) {
+	if len(*s) == 0 {
+		return nil, false
+	}
+	idx := len(*s) - 1
+	res := (*s)[idx]
+	*s = (*s)[:idx]
+	return res, true
+}
+
+// popN pops n values and returns them in the order they were pushed.
+func (s *OperandStack) popN(n int) ([]Value, bool) {
+	if len(*s) < n {
+		return nil, false
+	}
+	idx := len(*s) - n
+	res := make([]Value, n)
+	copy(res, (*s)[idx:])
+	*s = (*s)[:idx]
+	return res, true
+}
+
+func (s *OperandStack) peek(depth int) (Value, bool) {
+	if depth >= len(*s) {
+		return nil, false
+	}
+	return (*s)[len(*s)-1-depth], true
+}
@@ -0,0 +1,444 @@
+package jvmgo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// objectHeaderSize is what every object costs besides its fields or
+	// elements, as on a 64-bit HotSpot with compressed class pointers
+	objectHeaderSize = 16
+	// minCollectionThreshold is the heap usage the first collection waits for
+	minCollectionThreshold = 16 << 20
+)
+
+type (
+	// heap accounts for the objects the program allocates. Memory itself
+	// belongs to the Go runtime: the heap keeps every object it tracks
+	// alive until a collection finds it unreachable from the roots of the
+	// VM and drops it, which leaves it to the Go collector. An object that
+	// only Go code still refers to is forgotten by the accounting, but
+	// stays valid.
+	heap struct {
+		mu          sync.Mutex
+		objects     []*Object
+		used        int64
+		peak        int64
+		next        int64
+		epoch       uint32
+		collections int
+		freed       int64
+
+		// finalizable holds the objects with a finalize method to run once
+		// they become unreachable
+		finalizable []*Object
+		// cleanables holds the Cleaner actions registered and not run yet
+		cleanables map[*Object]bool
+		// pending holds the work of the finalizer thread, kept alive until
+		// it is done
+		pending       []pendingObject
+		finalizerOnce sync.Once
+		wakeFinalizer chan struct{}
+		oomDumpOnce   sync.Once
+		// dumps counts the heap dump files written to HeapDumpPath
+		dumps int32
+
+		// world is read-locked by every thread while it runs Java code and
+		// write-locked by the collector to stop them
+		world    sync.RWMutex
+		stopping int32
+	}
+
+	// HeapStats describes the heap of a VM.
+	HeapStats struct {
+		// Used is the size of the objects allocated and not yet collected.
+		Used int64
+		// Committed is the size the heap has grown to, or the maximum size
+		// when there is one.
+		Committed int64
+		// Max is the maximum size of the heap, or zero for no limit.
+		Max int64
+		// Objects is the number of objects allocated and not yet collected.
+		Objects int
+		// Collections is the number of collections run so far.
+		Collections int
+		// Freed is the size of the objects the collections dropped.
+		Freed int64
+	}
+)
+
+// ParseMemorySize parses a size as -Xmx takes it: a number of bytes with an
+// optional k, m or g suffix in either case.
+func ParseMemorySize(s string) (int64, error) {
+	digits, shift := s, uint(0)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			shift = 10
+		case 'm', 'M':
+			shift = 20
+		case 'g', 'G':
+			shift = 30
+		}
+	}
+	if shift > 0 {
+		digits = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)>>shift {
+		return 0, fmt.Errorf("invalid memory size: %q", s)
+	}
+	return n << shift, nil
+}
+
+// objectSize estimates the bytes obj takes: eight per field or reference
+// element and the natural width of primitive elements.
+func objectSize(obj *Object) int64 {
+	size := int64(objectHeaderSize + 8*len(obj.Fields))
+	switch a := obj.Array.(type) {
+	case []int8:
+		size += int64(len(a))
+	case []uint16:
+		size += 2 * int64(len(a))
+	case []int16:
+		size += 2 * int64(len(a))
+	case []int32:
+		size += 4 * int64(len(a))
+	case []float32:
+		size += 4 * int64(len(a))
+	case []int64:
+		size += 8 * int64(len(a))
+	case []float64:
+		size += 8 * int64(len(a))
+	case []Value:
+		size += 8 * int64(len(a))
+	}
+	return size
+}
+
+// HeapStats returns the current statistics of the heap.
+func (vm *VirtualMachine) HeapStats() HeapStats {
+	h := &vm.heap
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	committed := h.peak
+	if vm.MaxHeapSize > 0 {
+		committed = vm.MaxHeapSize
+	}
+	return HeapStats{
+		Used:        h.used,
+		Committed:   committed,
+		Max:         vm.MaxHeapSize,
+		Objects:     len(h.objects),
+		Collections: h.collections,
+		Freed:       h.freed,
+	}
+}
+
+// allocate adds a new object to the heap, collecting garbage when the heap
+// has grown enough, and throws OutOfMemoryError when even a collection does
+// not leave room for it.
+func (vm *VirtualMachine) allocate(f *Frame, obj *Object) error {
+	size := objectSize(obj)
+	if vm.heap.add(obj, size, vm.MaxHeapSize, false) {
+		return nil
+	}
+	t := vm.currentThread(f)
+	vm.collect(t, false)
+	if max := vm.MaxHeapSize; max > 0 && vm.heap.usage()+size > max {
+		// soft references go before the heap is given up on
+		vm.collect(t, true)
+		if vm.heap.usage()+size > max {
+			vm.dumpHeapOnOutOfMemory(t)
+			return vm.throwNew(f, "java/lang/OutOfMemoryError", "Java heap space")
+		}
+	}
+	vm.heap.add(obj, size, vm.MaxHeapSize, true)
+	return nil
+}
+
+// track adds an object allocated where no exception can be thrown, such as
+// a string the VM creates, to the heap without checking the limit.
+func (vm *VirtualMachine) track(obj *Object) {
+	vm.heap.add(obj, objectSize(obj), vm.MaxHeapSize, true)
+}
+
+// add records obj unless it would take the heap past the threshold of the
+// next collection and force is unset. The first threshold is capped at max.
+func (h *heap) add(obj *Object, size, max int64, force bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.next == 0 {
+		h.next = minCollectionThreshold
+		if max > 0 && h.next > max {
+			h.next = max
+		}
+	}
+	if !force && h.used+size > h.next {
+		return false
+	}
+	h.objects = append(h.objects, obj)
+	if obj.Class != nil && obj.Class.finalizer {
+		h.finalizable = append(h.finalizable, obj)
+	}
+	h.used += size
+	if h.used > h.peak {
+		h.peak = h.used
+	}
+	return true
+}
+
+func (h *heap) usage() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.used
+}
+
+func registerHeapNatives(r *NativeRegistry) {
+	r.Register("java/lang/Runtime", "maxMemory", "()J", func(frame *Frame, args []Value) (Value, error) {
+		if max := frame.VM.MaxHeapSize; max > 0 {
+			return max, nil
+		}
+		return int64(math.MaxInt64), nil
+	})
+	r.Register("java/lang/Runtime", "totalMemory", "()J", func(frame *Frame, args []Value) (Value, error) {
+		return frame.VM.HeapStats().Committed, nil
+	})
+	r.Register("java/lang/Runtime", "freeMemory", "()J", func(frame *Frame, args []Value) (Value, error) {
+		stats := frame.VM.HeapStats()
+		return stats.Committed - stats.Used, nil
+	})
+	r.Register("java/lang/Runtime", "gc", "()V", func(frame *Frame, args []Value) (Value, error) {
+		frame.VM.collect(frame.VM.currentThread(frame), false)
+		return nil, nil
+	})
+}
+
+// enterWorld makes t a thread running Java code, which the collector has to
+// stop, waiting for a collection in progress to finish.
+func (vm *VirtualMachine) enterWorld(t *thread) {
+	vm.heap.world.RLock()
+	t.inWorld = true
+}
+
+// leaveWorld lets the collector run while t blocks or is done with Java code.
+// It reports whether t was in the world and so has to enter it again.
+func (vm *VirtualMachine) leaveWorld(t *thread) bool {
+	if !t.inWorld {
+		return false
+	}
+	t.inWorld = false
+	vm.heap.world.RUnlock()
+	return true
+}
+
+// safepoint parks t while a collection runs.
+func (vm *VirtualMachine) safepoint(t *thread) {
+	if atomic.LoadInt32(&vm.heap.stopping) != 0 && vm.leaveWorld(t) {
+		vm.enterWorld(t)
+	}
+}
+
+// collect stops every other thread at a safepoint, marks the objects
+// reachable from the roots and drops the rest from the heap. Soft references
+// are cleared only when clearSoft is set.
+func (vm *VirtualMachine) collect(t *thread, clearSoft bool) {
+	if vm.stopAndCollect(t, clearSoft) {
+		vm.wakeFinalizer()
+	}
+}
+
+// stopAndCollect runs a collection with the world stopped and reports whether
+// it left work for the finalizer thread.
+func (vm *VirtualMachine) stopAndCollect(t *thread, clearSoft bool) bool {
+	h := &vm.heap
+	atomic.StoreInt32(&h.stopping, 1)
+	if vm.leaveWorld(t) {
+		defer vm.enterWorld(t)
+	}
+	h.world.Lock()
+	defer h.world.Unlock()
+	atomic.StoreInt32(&h.stopping, 0)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.epoch++
+	m := &marker{epoch: h.epoch, clearSoft: clearSoft}
+	vm.markRoots(m)
+	m.drain()
+	queued := h.processReferences(m)
+
+	live := h.objects[:0]
+	used := int64(0)
+	for _, obj := range h.objects {
+		if obj.gcMark == h.epoch {
+			live = append(live, obj)
+			used += objectSize(obj)
+		}
+	}
+	for i := len(live); i < len(h.objects); i++ {
+		h.objects[i] = nil
+	}
+	h.objects = live
+	h.freed += h.used - used
+	h.used = used
+	h.collections++
+	h.next = 2 * used
+	if h.next < minCollectionThreshold {
+		h.next = minCollectionThreshold
+	}
+	if vm.MaxHeapSize > 0 && h.next > vm.MaxHeapSize {
+		h.next = vm.MaxHeapSize
+	}
+	return queued
+}
+
+// markRoots marks the objects the VM refers to: the frames of every thread,
+// the static fields and mirrors of the loaded classes, interned strings,
+// cached boxes, the thread objects and what the finalizer thread has yet to
+// process. The caller holds the lock of the heap.
+func (vm *VirtualMachine) markRoots(m *marker) {
+	for _, p := range vm.heap.pending {
+		m.mark(p.obj)
+	}
+	for c := range vm.heap.cleanables {
+		m.mark(c)
+	}
+	vm.classesMu.Lock()
+	for _, c := range vm.classes {
+		m.markAll(c.StaticValues)
+		m.mark(c.mirror)
+	}
+	vm.classesMu.Unlock()
+	vm.internMu.Lock()
+	for _, s := range vm.interned {
+		m.mark(s)
+	}
+	vm.internMu.Unlock()
+	vm.boxesMu.Lock()
+	for _, b := range vm.boxes {
+		m.mark(b)
+	}
+	vm.boxesMu.Unlock()
+
+	vm.threadsMu.Lock()
+	threads := []*thread{vm.main}
+	for obj, t := range vm.threads {
+		m.mark(obj)
+		threads = append(threads, t)
+	}
+	m.mark(vm.mainThread)
+	vm.threadsMu.Unlock()
+	for _, t := range threads {
+		for _, args := range t.pending {
+			m.markAll(args)
+		}
+		for f := t.top; f != nil; f = f.Caller {
+			m.markAll(f.Locals)
+			if f.OperandStack != nil {
+				m.markAll(*f.OperandStack)
+			}
+		}
+	}
+}
+
+// marker traces the object graph from the roots it is given. It leaves the
+// referents of references and the keys of weak maps to processReferences,
+// unless all is set, which also records every object it marks in found and
+// the values held by Go state in held.
+type marker struct {
+	epoch     uint32
+	stack     []*Object
+	clearSoft bool
+	refs      []*Object
+	weakMaps  []*hashMap
+
+	all   bool
+	found []*Object
+	held  []Value
+}
+
+func (m *marker) mark(v Value) {
+	obj, ok := v.(*Object)
+	if !ok || obj == nil || obj.gcMark == m.epoch {
+		return
+	}
+	obj.gcMark = m.epoch
+	m.stack = append(m.stack, obj)
+	if m.all {
+		m.found = append(m.found, obj)
+	}
+}
+
+func (m *marker) markAll(values []Value) {
+	for _, v := range values {
+		m.mark(v)
+	}
+}
+
+func (m *marker) drain() {
+	for len(m.stack) > 0 {
+		obj := m.stack[len(m.stack)-1]
+		m.stack = m.stack[:len(m.stack)-1]
+		if m.discover(obj) {
+			slot := obj.Class.referentSlot
+			m.markAll(obj.Fields[:slot])
+			m.markAll(obj.Fields[slot+1:])
+		} else {
+			m.markAll(obj.Fields)
+		}
+		if a, ok := obj.Array.([]Value); ok {
+			m.markAll(a)
+		}
+		if obj.Class != nil {
+			m.mark(obj.Class.mirror)
+		}
+		// the bundled runtime keeps the state of some classes in Go
+		switch e := obj.Extra.(type) {
+		case *arrayList:
+			m.holdAll(e.elems)
+		case *listIterator:
+			m.holdAll(e.list.elems)
+		case *hashMap:
+			weak := e.weak && !m.all
+			if weak {
+				m.weakMaps = append(m.weakMaps, e)
+			}
+			for _, bucket := range e.table {
+				for _, entry := range bucket {
+					if !weak {
+						m.hold(entry.key)
+					}
+					m.hold(entry.value)
+				}
+			}
+		case *refQueue:
+			e.mu.Lock()
+			m.holdAll(e.refs)
+			e.mu.Unlock()
+		case *hashEntry:
+			m.hold(e.key)
+			m.hold(e.value)
+		case *annotationInstance:
+			m.holdAll(e.values)
+		}
+	}
+}
+
+// hold marks v, which an object refers to from Go state rather than a field.
+func (m *marker) hold(v Value) {
+	if m.all && v != nil {
+		m.held = append(m.held, v)
+	}
+	m.mark(v)
+}
+
+func (m *marker) holdAll(values []Value) {
+	for _, v := range values {
+		m.hold(v)
+	}
+}
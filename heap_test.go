@@ -0,0 +1,96 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// churn appends a loop that allocates n int[10000] arrays and drops each one
+// right away, keeping the counter in local.
+func churn(a *asm, n int16, local byte) *asm {
+	loop, end := "churn"+string('0'+local), "churnEnd"+string('0'+local)
+	return a.op(OpCodeIconst0).op(OpCodeIstore, local).
+		label(loop).op(OpCodeIload, local).op(OpCodeSipush, byte(n>>8), byte(n)).branch(OpCodeIfIcmpge, end).
+		op(OpCodeSipush, hi(10000), lo(10000)).op(OpCodeNewArray, 10).op(OpCodePop).
+		op(OpCodeIinc, local, 1).branch(OpCodeGoto, loop).
+		label(end)
+}
+
+func TestVirtualMachine_ExecMain_Heap(t *testing.T) {
+	// class Churn extends Thread { public void run() { for (...) new int[10000]; } }
+	c := newClassBuilder("Churn", "java/lang/Thread")
+	c.method(AccPublic, "<init>", "()V", 1, 1, newAsm().
+		op(OpCodeAload0).ref(OpCodeInvokeSpecial, c.methodRef("java/lang/Thread", "<init>", "()V")).op(OpCodeReturn).bytes()...)
+	c.method(AccPublic, "run", "()V", 2, 2, churn(newAsm(), 300, 1).op(OpCodeReturn).bytes()...)
+	cp := mapClassPath{}
+	cp.add(c.build())
+
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printStr := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+	printLong := b.methodRef("java/io/PrintStream", "println", "(J)V")
+	getMessage := b.methodRef("java/lang/Throwable", "getMessage", "()Ljava/lang/String;")
+	getRuntime := b.methodRef("java/lang/Runtime", "getRuntime", "()Ljava/lang/Runtime;")
+	start := b.methodRef("java/lang/Thread", "start", "()V")
+	join := b.methodRef("java/lang/Thread", "join", "()V")
+
+	code := newAsm()
+	// garbage is collected, also while other threads allocate
+	for i := byte(1); i <= 2; i++ {
+		code.ref(OpCodeNew, b.classRef("Churn")).op(OpCodeDup).ref(OpCodeInvokeSpecial, b.methodRef("Churn", "<init>", "()V")).
+			op(OpCodeDup).op(OpCodeAstore, i).ref(OpCodeInvokeVirtual, start)
+	}
+	churn(code, 300, 3)
+	code.op(OpCodeAload, 1).ref(OpCodeInvokeVirtual, join).op(OpCodeAload, 2).ref(OpCodeInvokeVirtual, join).
+		ref(OpCodeGetStatic, out).ref(OpCodeInvokeStatic, getRuntime).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Runtime", "maxMemory", "()J")).ref(OpCodeInvokeVirtual, printLong).
+		// an array larger than the heap
+		label("huge").op(OpCodeLdc, lo(b.integer(1<<20))).op(OpCodeNewArray, 10).op(OpCodePop).label("hugeEnd").
+		// arrays that are all kept fill the heap
+		op(OpCodeSipush, 0, 200).ref(OpCodeANewArray, b.classRef("[I")).op(OpCodeAstore, 4).
+		op(OpCodeIconst0).op(OpCodeIstore, 3).
+		label("keep").op(OpCodeAload, 4).op(OpCodeIload, 3).
+		op(OpCodeSipush, hi(10000), lo(10000)).op(OpCodeNewArray, 10).op(OpCodeAastore).
+		op(OpCodeIinc, 3, 1).branch(OpCodeGoto, "keep").label("keepEnd").
+		label("hugeHandler").ref(OpCodeInvokeVirtual, getMessage).op(OpCodeAstore, 5).
+		ref(OpCodeGetStatic, out).op(OpCodeAload, 5).ref(OpCodeInvokeVirtual, printStr).
+		branch(OpCodeGoto, "hugeEnd").
+		label("keepHandler").op(OpCodePop).op(OpCodeAconstNull).op(OpCodeAstore, 4).
+		ref(OpCodeGetStatic, out).op(OpCodeLdc, lo(b.str("kept"))).ref(OpCodeInvokeVirtual, printStr).
+		ref(OpCodeInvokeStatic, b.methodRef("java/lang/System", "gc", "()V")).
+		op(OpCodeReturn)
+	oom := b.classRef("java/lang/OutOfMemoryError")
+	b.methodWithHandlers(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 4, 6, code.bytes(), []*Exception{
+		{StartPC: code.pc("huge"), EndPC: code.pc("hugeEnd"), HandlerPC: code.pc("hugeHandler"), CatchType: oom},
+		{StartPC: code.pc("keep"), EndPC: code.pc("keepEnd"), HandlerPC: code.pc("keepHandler"), CatchType: oom},
+	})
+
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	vm.MaxHeapSize = 4 << 20
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "4194304\nJava heap space\nkept\n", stdout.String())
+
+	stats := vm.HeapStats()
+	require.Equal(t, int64(4<<20), stats.Max)
+	require.Greater(t, stats.Collections, 1)
+	// 900 arrays of 40016 bytes went through a heap of 4 MiB
+	require.Greater(t, stats.Freed, int64(30<<20))
+	require.Less(t, stats.Used, int64(1<<20))
+}
+
+func TestParseMemorySize(t *testing.T) {
+	for s, want := range map[string]int64{"1024": 1024, "64k": 64 << 10, "512m": 512 << 20, "2G": 2 << 30} {
+		got, err := ParseMemorySize(s)
+		require.NoError(t, err)
+		require.Equal(t, want, got, s)
+	}
+	for _, s := range []string{"", "m", "-1m", "12x", "99999999999g"} {
+		_, err := ParseMemorySize(s)
+		require.Error(t, err, s)
+	}
+}
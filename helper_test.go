@@ -0,0 +1,368 @@
+package jvmgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// classBuilder assembles a ClassStructure in memory so tests can exercise
+// bytecode without a Java compiler.
+type classBuilder struct {
+	class            *ClassStructure
+	utf8s            map[string]uint16
+	bootstrapMethods *AttributeInfo
+}
+
+func newClassBuilder(name, super string) *classBuilder {
+	b := &classBuilder{
+		class: &ClassStructure{
+			Magic:        magic,
+			MinorVersion: minorVersion,
+			MajorVersion: majorVersion,
+			AccessFlags:  AccPublic | AccSuper,
+		},
+		utf8s: map[string]uint16{},
+	}
+	b.class.ThisClass = b.classRef(name)
+	if super != "" {
+		b.class.SuperClass = b.classRef(super)
+	}
+	return b
+}
+
+func (b *classBuilder) add(tag ConstantKind, info []byte) uint16 {
+	b.class.ConstantPool = append(b.class.ConstantPool, &CpInfo{Tag: tag, Info: info})
+	b.class.ConstantPoolCount = uint16(len(b.class.ConstantPool) + 1)
+	return uint16(len(b.class.ConstantPool))
+}
+
+func (b *classBuilder) utf8(s string) uint16 {
+	if idx, ok := b.utf8s[s]; ok {
+		return idx
+	}
+	m := modifiedUTF8(s)
+	info := append(u2(uint16(len(m))), m...)
+	idx := b.add(ConstantKindUTF8, info)
+	b.utf8s[s] = idx
+	return idx
+}
+
+// modifiedUTF8 encodes s as class files do, in the format
+// decodeModifiedUTF8 reads.
+func modifiedUTF8(s string) []byte {
+	var b []byte
+	for _, c := range javaChars(s) {
+		switch {
+		case c != 0 && c < 0x80:
+			b = append(b, byte(c))
+		case c < 0x800:
+			b = append(b, 0xC0|byte(c>>6), 0x80|byte(c)&0x3F)
+		default:
+			b = append(b, 0xE0|byte(c>>12), 0x80|byte(c>>6)&0x3F, 0x80|byte(c)&0x3F)
+		}
+	}
+	return b
+}
+
+func (b *classBuilder) classRef(name string) uint16 {
+	return b.add(ConstantKindClass, u2(b.utf8(name)))
+}
+
+func (b *classBuilder) str(s string) uint16 {
+	return b.add(ConstantKindString, u2(b.utf8(s)))
+}
+
+func (b *classBuilder) integer(v int32) uint16 {
+	return b.add(ConstantKindInteger, u4(uint32(v)))
+}
+
+func (b *classBuilder) long(v int64) uint16 {
+	idx := b.add(ConstantKindLong, u8(uint64(v)))
+	b.add(0, nil)
+	return idx
+}
+
+func (b *classBuilder) double(v float64) uint16 {
+	idx := b.add(ConstantKindDouble, u8(math.Float64bits(v)))
+	b.add(0, nil)
+	return idx
+}
+
+func (b *classBuilder) nameAndType(name, desc string) uint16 {
+	return b.add(ConstantKindNameAndType, append(u2(b.utf8(name)), u2(b.utf8(desc))...))
+}
+
+func (b *classBuilder) memberRef(tag ConstantKind, class, name, desc string) uint16 {
+	return b.add(tag, append(u2(b.classRef(class)), u2(b.nameAndType(name, desc))...))
+}
+
+func (b *classBuilder) fieldRef(class, name, desc string) uint16 {
+	return b.memberRef(ConstantKindFieldref, class, name, desc)
+}
+
+func (b *classBuilder) methodRef(class, name, desc string) uint16 {
+	return b.memberRef(ConstantKindMethodref, class, name, desc)
+}
+
+func (b *classBuilder) interfaceMethodRef(class, name, desc string) uint16 {
+	return b.memberRef(ConstantKindInterfaceMethodref, class, name, desc)
+}
+
+func (b *classBuilder) methodHandle(kind uint8, ref uint16) uint16 {
+	return b.add(ConstantKindMethodHandle, append([]byte{kind}, u2(ref)...))
+}
+
+// invokeDynamic adds a bootstrap method with its static arguments and an
+// invokedynamic constant that refers to it.
+func (b *classBuilder) invokeDynamic(bootstrap uint16, args []uint16, name, desc string) uint16 {
+	if b.bootstrapMethods == nil {
+		b.bootstrapMethods = &AttributeInfo{AttributeNameIndex: b.utf8("BootstrapMethods"), Info: u2(0)}
+		b.class.Attributes = append(b.class.Attributes, b.bootstrapMethods)
+		b.class.AttributesCount = uint16(len(b.class.Attributes))
+	}
+	info := b.bootstrapMethods.Info
+	n := binary.BigEndian.Uint16(info)
+	info = append(info, u2(bootstrap)...)
+	info = append(info, u2(uint16(len(args)))...)
+	for _, a := range args {
+		info = append(info, u2(a)...)
+	}
+	binary.BigEndian.PutUint16(info, n+1)
+	b.bootstrapMethods.Info = info
+	b.bootstrapMethods.AttributeLength = uint32(len(info))
+	return b.add(ConstantKindInvokeDynamic, append(u2(n), u2(b.nameAndType(name, desc))...))
+}
+
+// attribute adds a class attribute.
+func (b *classBuilder) attribute(name string, info []byte) {
+	b.class.Attributes = append(b.class.Attributes, b.newAttribute(name, info))
+	b.class.AttributesCount = uint16(len(b.class.Attributes))
+}
+
+// newAttribute builds an attribute for a field, a method or a Code
+// attribute.
+func (b *classBuilder) newAttribute(name string, info []byte) *AttributeInfo {
+	return &AttributeInfo{AttributeNameIndex: b.utf8(name), AttributeLength: uint32(len(info)), Info: info}
+}
+
+func (b *classBuilder) field(flags uint16, name, desc string) *FieldInfo {
+	f := &FieldInfo{AccessFlags: flags, NameIndex: b.utf8(name), DescriptorIndex: b.utf8(desc)}
+	b.class.Fields = append(b.class.Fields, f)
+	b.class.FieldsCount = uint16(len(b.class.Fields))
+	return f
+}
+
+func (b *classBuilder) method(flags uint16, name, desc string, maxStack, maxLocals uint16, code ...byte) *MethodInfo {
+	m := &MethodInfo{AccessFlags: flags, NameIndex: b.utf8(name), DescriptorIndex: b.utf8(desc)}
+	if code != nil {
+		m.Attributes = []*AttributeInfo{b.codeAttribute(maxStack, maxLocals, code, nil)}
+		m.AttributesCount = 1
+	}
+	b.class.Methods = append(b.class.Methods, m)
+	b.class.MethodsCount = uint16(len(b.class.Methods))
+	return m
+}
+
+func (b *classBuilder) methodWithHandlers(flags uint16, name, desc string, maxStack, maxLocals uint16, code []byte, exceptions []*Exception) *MethodInfo {
+	m := b.method(flags, name, desc, maxStack, maxLocals)
+	m.Attributes = []*AttributeInfo{b.codeAttribute(maxStack, maxLocals, code, exceptions)}
+	m.AttributesCount = 1
+	return m
+}
+
+func (b *classBuilder) codeAttribute(maxStack, maxLocals uint16, code []byte, exceptions []*Exception, attributes ...*AttributeInfo) *AttributeInfo {
+	var buf bytes.Buffer
+	buf.Write(u2(maxStack))
+	buf.Write(u2(maxLocals))
+	buf.Write(u4(uint32(len(code))))
+	buf.Write(code)
+	buf.Write(u2(uint16(len(exceptions))))
+	for _, e := range exceptions {
+		buf.Write(u2(e.StartPC))
+		buf.Write(u2(e.EndPC))
+		buf.Write(u2(e.HandlerPC))
+		buf.Write(u2(e.CatchType))
+	}
+	buf.Write(u2(uint16(len(attributes))))
+	for _, a := range attributes {
+		buf.Write(u2(a.AttributeNameIndex))
+		buf.Write(u4(a.AttributeLength))
+		buf.Write(a.Info)
+	}
+	return &AttributeInfo{
+		AttributeNameIndex: b.utf8("Code"),
+		AttributeLength:    uint32(buf.Len()),
+		Info:               buf.Bytes(),
+	}
+}
+
+// stackMapTable builds a StackMapTable attribute of encoded frames.
+func (b *classBuilder) stackMapTable(frames ...[]byte) *AttributeInfo {
+	info := u2(uint16(len(frames)))
+	for _, f := range frames {
+		info = append(info, f...)
+	}
+	return &AttributeInfo{AttributeNameIndex: b.utf8("StackMapTable"), AttributeLength: uint32(len(info)), Info: info}
+}
+
+func (b *classBuilder) build() *ClassStructure {
+	return b.class
+}
+
+func u2(v uint16) []byte {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, v)
+	return buf
+}
+
+func u4(v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, v)
+	return buf
+}
+
+func u8(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return buf
+}
+
+// hi and lo split a constant pool index into instruction operand bytes.
+func hi(v uint16) byte { return byte(v >> 8) }
+func lo(v uint16) byte { return byte(v) }
+
+// encodeClass serializes a ClassStructure into the class file format.
+func encodeClass(c *ClassStructure) []byte {
+	var buf bytes.Buffer
+	buf.Write(c.Magic)
+	buf.Write(c.MinorVersion)
+	buf.Write(c.MajorVersion)
+	buf.Write(u2(c.ConstantPoolCount))
+	for _, cp := range c.ConstantPool {
+		if cp.Tag == 0 {
+			// second slot of a long or double
+			continue
+		}
+		buf.WriteByte(byte(cp.Tag))
+		buf.Write(cp.Info)
+	}
+	buf.Write(u2(c.AccessFlags))
+	buf.Write(u2(c.ThisClass))
+	buf.Write(u2(c.SuperClass))
+	buf.Write(u2(uint16(len(c.Interfaces))))
+	for _, i := range c.Interfaces {
+		buf.Write(u2(i))
+	}
+	buf.Write(u2(uint16(len(c.Fields))))
+	for _, f := range c.Fields {
+		buf.Write(u2(f.AccessFlags))
+		buf.Write(u2(f.NameIndex))
+		buf.Write(u2(f.DescriptorIndex))
+		writeAttributes(&buf, f.Attributes)
+	}
+	buf.Write(u2(uint16(len(c.Methods))))
+	for _, m := range c.Methods {
+		buf.Write(u2(m.AccessFlags))
+		buf.Write(u2(m.NameIndex))
+		buf.Write(u2(m.DescriptorIndex))
+		writeAttributes(&buf, m.Attributes)
+	}
+	writeAttributes(&buf, c.Attributes)
+	return buf.Bytes()
+}
+
+func writeAttributes(buf *bytes.Buffer, attrs []*AttributeInfo) {
+	buf.Write(u2(uint16(len(attrs))))
+	for _, a := range attrs {
+		buf.Write(u2(a.AttributeNameIndex))
+		buf.Write(u4(uint32(len(a.Info))))
+		buf.Write(a.Info)
+	}
+}
+
+// mapClassPath serves class files from memory.
+type mapClassPath map[string][]byte
+
+func (m mapClassPath) ReadClass(name string) ([]byte, error) {
+	buf, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrClassNotFound, name)
+	}
+	return buf, nil
+}
+
+func (m mapClassPath) Close() error {
+	return nil
+}
+
+func (m mapClassPath) add(c *ClassStructure) {
+	name, err := c.Name()
+	if err != nil {
+		panic(err)
+	}
+	m[name] = encodeClass(c)
+}
+
+// asm assembles bytecode with symbolic branch targets.
+type asm struct {
+	code   []byte
+	labels map[string]int
+	fixups []asmFixup
+}
+
+type asmFixup struct {
+	pc, at int
+	label  string
+	wide   bool
+}
+
+func newAsm() *asm {
+	return &asm{labels: map[string]int{}}
+}
+
+func (a *asm) op(op OpCode, operands ...byte) *asm {
+	a.code = append(a.code, byte(op))
+	a.code = append(a.code, operands...)
+	return a
+}
+
+// ref emits an instruction taking a two byte constant pool index.
+func (a *asm) ref(op OpCode, idx uint16) *asm {
+	return a.op(op, hi(idx), lo(idx))
+}
+
+func (a *asm) branch(op OpCode, label string) *asm {
+	wide := op == OpCodeGotoW || op == OpCodeJsrW
+	a.fixups = append(a.fixups, asmFixup{pc: len(a.code), at: len(a.code) + 1, label: label, wide: wide})
+	if wide {
+		return a.op(op, 0, 0, 0, 0)
+	}
+	return a.op(op, 0, 0)
+}
+
+func (a *asm) label(name string) *asm {
+	a.labels[name] = len(a.code)
+	return a
+}
+
+func (a *asm) pc(label string) uint16 {
+	return uint16(a.labels[label])
+}
+
+func (a *asm) bytes() []byte {
+	for _, f := range a.fixups {
+		target, ok := a.labels[f.label]
+		if !ok {
+			panic("undefined label " + f.label)
+		}
+		off := target - f.pc
+		if f.wide {
+			copy(a.code[f.at:], u4(uint32(int32(off))))
+		} else {
+			copy(a.code[f.at:], u2(uint16(int16(off))))
+		}
+	}
+	return a.code
+}
@@ -0,0 +1,280 @@
+package jvmgo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	frameType = reflect.TypeOf((*Frame)(nil))
+)
+
+// BindHost defines a final class named className whose static methods call
+// into Go, so Java code can use what the embedding application provides.
+// host is either a map from method names to Go functions or a value whose
+// exported methods become static methods named in lower camel case, e.g.
+// Log becomes log and URLFor urlFor.
+//
+// The Java descriptor of each method follows from the Go signature: bool,
+// int8, uint8, int16, uint16, int, int32, int64, float32 and float64 map to
+// the primitives as ToJava does, string to String, slices to arrays, *Object
+// and interface{} to Object. A function taking *Frame first gets the frame of
+// the calling native method and may call back into Java; any other function
+// runs outside the world of the heap, so it may block. An error result, which
+// must come last, throws: a *JavaException as is and any other error as a
+// RuntimeException. The Go function sees a copy of a primitive array
+// argument, which is copied back after the call, so it can fill a byte[]
+// buffer as io.Reader.Read does.
+func (vm *VirtualMachine) BindHost(className string, host interface{}) (*RuntimeClass, error) {
+	funcs := map[string]reflect.Value{}
+	if m, ok := host.(map[string]interface{}); ok {
+		for name, fn := range m {
+			funcs[name] = reflect.ValueOf(fn)
+		}
+	} else {
+		v := reflect.ValueOf(host)
+		for i := 0; i < v.NumMethod(); i++ {
+			funcs[javaMethodName(v.Type().Method(i).Name)] = v.Method(i)
+		}
+	}
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	vm.classesMu.Lock()
+	_, exists := vm.classes[className]
+	vm.classesMu.Unlock()
+	if exists {
+		return nil, fmt.Errorf("bind host %s: class is already defined", className)
+	}
+	b := &builtinClass{name: className, super: "java/lang/Object", flags: AccPublic | AccFinal | AccSuper}
+	for _, name := range names {
+		desc, fn, err := hostMethod(funcs[name])
+		if err != nil {
+			return nil, fmt.Errorf("bind host %s.%s: %w", className, name, err)
+		}
+		b.static(name, desc, fn)
+	}
+	for _, m := range b.methods {
+		vm.Natives.Register(className, m.name, m.desc, m.fn)
+	}
+	class, err := vm.defineBuiltin(b)
+	if err != nil {
+		return nil, err
+	}
+	class.initState = classInitialized
+	return class, nil
+}
+
+// javaMethodName turns an exported Go name into a Java method name by
+// lowering its leading initialism or first letter.
+func javaMethodName(name string) string {
+	runes := []rune(name)
+	n := 0
+	for n < len(runes) && unicode.IsUpper(runes[n]) {
+		n++
+	}
+	if n > 1 && n < len(runes) {
+		// the last capital starts the next word, as in URLFor
+		n--
+	}
+	return strings.ToLower(string(runes[:n])) + string(runes[n:])
+}
+
+// hostMethod derives the descriptor of a Go function and wraps it as a
+// native method.
+func hostMethod(fn reflect.Value) (string, NativeMethod, error) {
+	if fn.Kind() != reflect.Func {
+		return "", nil, fmt.Errorf("%s is not a function", fn.Type())
+	}
+	t := fn.Type()
+	if t.IsVariadic() {
+		return "", nil, fmt.Errorf("variadic functions are not supported")
+	}
+	withFrame := t.NumIn() > 0 && t.In(0) == frameType
+	first := 0
+	if withFrame {
+		first = 1
+	}
+	params := make([]string, t.NumIn()-first)
+	for i := range params {
+		d, ok := hostDescriptor(t.In(first + i))
+		if !ok {
+			return "", nil, fmt.Errorf("parameter %d: unsupported type %s", i, t.In(first+i))
+		}
+		params[i] = d
+	}
+	ret := "V"
+	hasError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	results := t.NumOut()
+	if hasError {
+		results--
+	}
+	switch results {
+	case 0:
+	case 1:
+		d, ok := hostDescriptor(t.Out(0))
+		if !ok {
+			return "", nil, fmt.Errorf("unsupported result type %s", t.Out(0))
+		}
+		ret = d
+	default:
+		return "", nil, fmt.Errorf("functions return at most a value and an error")
+	}
+	desc := "(" + strings.Join(params, "") + ")" + ret
+
+	return desc, func(frame *Frame, args []Value) (Value, error) {
+		vm := frame.VM
+		in := make([]reflect.Value, 0, t.NumIn())
+		if withFrame {
+			in = append(in, reflect.ValueOf(frame))
+		}
+		for i, p := range params {
+			v, err := goValue(t.In(first+i), p, args[i])
+			if err != nil {
+				return nil, throwOrError(frame, "java/lang/IllegalArgumentException", err.Error())
+			}
+			in = append(in, v)
+		}
+
+		var out []reflect.Value
+		if withFrame {
+			out = fn.Call(in)
+		} else {
+			out = func() []reflect.Value {
+				if th := vm.currentThread(frame); vm.leaveWorld(th) {
+					defer vm.enterWorld(th)
+				}
+				return fn.Call(in)
+			}()
+		}
+
+		// copy primitive arrays back so the function can fill them
+		for i, p := range params {
+			arr, ok := args[i].(*Object)
+			if !ok || arr == nil || len(p) != 2 {
+				continue
+			}
+			for j := 0; j < arr.ArrayLength() && j < in[first+i].Len(); j++ {
+				e, err := toPrimitive(p[1:], in[first+i].Index(j).Interface())
+				if err != nil {
+					return nil, throwOrError(frame, "java/lang/IllegalArgumentException", err.Error())
+				}
+				if err := vm.arrayStore(frame, arr, int32(j), e); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if hasError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				var ex *JavaException
+				if errors.As(err, &ex) {
+					return nil, ex
+				}
+				return nil, throwOrError(frame, "java/lang/RuntimeException", err.Error())
+			}
+		}
+		if ret == "V" {
+			return nil, nil
+		}
+		v, err := vm.ToJava(ret, out[0].Interface())
+		if err != nil {
+			return nil, throwOrError(frame, "java/lang/IllegalArgumentException", err.Error())
+		}
+		return v, nil
+	}, nil
+}
+
+// hostDescriptor is goDescriptor for the types a host function may take or
+// return.
+func hostDescriptor(t reflect.Type) (string, bool) {
+	if t == objectType {
+		return "Ljava/lang/Object;", true
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Struct, reflect.Chan, reflect.Func:
+		return "", false
+	case reflect.Interface:
+		if t.NumMethod() > 0 {
+			return "", false
+		}
+	case reflect.Slice:
+		if _, ok := hostDescriptor(t.Elem()); !ok {
+			return "", false
+		}
+	}
+	return goDescriptor(t)
+}
+
+// goValue converts a Java value of type desc to the Go type t.
+func goValue(t reflect.Type, desc string, v Value) (reflect.Value, error) {
+	switch {
+	case t == objectType:
+		obj, _ := v.(*Object)
+		return reflect.ValueOf(obj), nil
+	case t.Kind() == reflect.Interface:
+		if g := FromJava(desc, v); g != nil {
+			return reflect.ValueOf(g), nil
+		}
+		return reflect.Zero(t), nil
+	case t.Kind() == reflect.Slice:
+		arr, _ := v.(*Object)
+		if arr == nil {
+			return reflect.Zero(t), nil
+		}
+		s := reflect.MakeSlice(t, arr.ArrayLength(), arr.ArrayLength())
+		for i := 0; i < arr.ArrayLength(); i++ {
+			e, err := elementValue(arr, i)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			ev, err := goValue(t.Elem(), desc[1:], e)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("element %d: %w", i, err)
+			}
+			s.Index(i).Set(ev)
+		}
+		return s, nil
+	case t.Kind() == reflect.String:
+		if isNull(v) {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(javaStringValue(v)).Convert(t), nil
+	}
+	g := reflect.ValueOf(FromJava(desc, v))
+	if !g.IsValid() || !g.Type().ConvertibleTo(t) {
+		return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", javaTypeName(desc), t)
+	}
+	return g.Convert(t), nil
+}
+
+// elementValue reads an array element the way the interpreter pushes it.
+func elementValue(arr *Object, i int) (Value, error) {
+	switch a := arr.Array.(type) {
+	case []int8:
+		return int32(a[i]), nil
+	case []uint16:
+		return int32(a[i]), nil
+	case []int16:
+		return int32(a[i]), nil
+	case []int32:
+		return a[i], nil
+	case []int64:
+		return a[i], nil
+	case []float32:
+		return a[i], nil
+	case []float64:
+		return a[i], nil
+	case []Value:
+		return a[i], nil
+	}
+	return nil, fmt.Errorf("not an array: %s", arr.ClassName())
+}
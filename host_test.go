@@ -0,0 +1,92 @@
+package jvmgo
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testEnv struct {
+	logs   []string
+	config map[string]string
+}
+
+func (e *testEnv) Log(msg string) {
+	e.logs = append(e.logs, msg)
+}
+
+func (e *testEnv) Config(key string) (string, error) {
+	v, ok := e.config[key]
+	if !ok {
+		return "", fmt.Errorf("no config %q", key)
+	}
+	return v, nil
+}
+
+func (e *testEnv) Read(buf []byte) int {
+	return copy(buf, "jvm")
+}
+
+func TestVirtualMachine_BindHost(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printInt := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 3, 2, newAsm().
+		op(OpCodeLdc, lo(b.str("hello"))).
+		ref(OpCodeInvokeStatic, b.methodRef("host/Env", "log", "(Ljava/lang/String;)V")).
+		ref(OpCodeGetStatic, out).op(OpCodeLdc, lo(b.str("name"))).
+		ref(OpCodeInvokeStatic, b.methodRef("host/Env", "config", "(Ljava/lang/String;)Ljava/lang/String;")).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")).
+		op(OpCodeIconst0+4).op(OpCodeNewArray, ArrayTypeByte).op(OpCodeAstore0+1).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).
+		ref(OpCodeInvokeStatic, b.methodRef("host/Env", "read", "([B)I")).ref(OpCodeInvokeVirtual, printInt).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).op(OpCodeIconst0+1).op(OpCodeBaload).ref(OpCodeInvokeVirtual, printInt).
+		ref(OpCodeGetStatic, out).op(OpCodeBipush, 21).
+		ref(OpCodeInvokeStatic, b.methodRef("host/Util", "twice", "(I)I")).ref(OpCodeInvokeVirtual, printInt).
+		op(OpCodeReturn).bytes()...)
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+
+	env := &testEnv{config: map[string]string{"name": "plugin"}}
+	_, err := vm.BindHost("host/Env", env)
+	require.NoError(t, err)
+	_, err = vm.BindHost("host/Util", map[string]interface{}{
+		"twice": func(n int) int { return n * 2 },
+		"sum": func(f *Frame, values []int64) int64 {
+			var sum int64
+			for _, v := range values {
+				sum += v
+			}
+			return sum
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "plugin\n3\n118\n42\n", stdout.String())
+	require.Equal(t, []string{"hello"}, env.logs)
+
+	got, err := vm.Invoke("host/Util", "sum", "([J)J", []int64{1, 2, 3})
+	require.NoError(t, err)
+	require.Equal(t, int64(6), got)
+	_, err = vm.Invoke("host/Env", "config", "(Ljava/lang/String;)Ljava/lang/String;", "missing")
+	var ex *JavaException
+	require.ErrorAs(t, err, &ex)
+	require.Equal(t, "java/lang/RuntimeException", ex.Object.ClassName())
+
+	_, err = vm.BindHost("host/Env", env)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "class is already defined")
+	_, err = vm.BindHost("host/Bad", map[string]interface{}{"open": func(m map[string]int) {}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "bind host host/Bad.open: parameter 0: unsupported type map[string]int")
+}
+
+func TestJavaMethodName(t *testing.T) {
+	for name, want := range map[string]string{"Log": "log", "URL": "url", "URLFor": "urlFor", "GetID": "getID"} {
+		require.Equal(t, want, javaMethodName(name))
+	}
+}
@@ -0,0 +1,602 @@
+package jvmgo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// record and sub-record tags of the HPROF format, version 1.0.2
+const (
+	hprofString          = 0x01
+	hprofLoadClass       = 0x02
+	hprofStackFrame      = 0x04
+	hprofStackTrace      = 0x05
+	hprofHeapDumpSegment = 0x1c
+	hprofHeapDumpEnd     = 0x2c
+
+	hprofRootUnknown        = 0xff
+	hprofRootJavaFrame      = 0x03
+	hprofRootStickyClass    = 0x05
+	hprofRootThreadObject   = 0x08
+	hprofClassDump          = 0x20
+	hprofInstanceDump       = 0x21
+	hprofObjectArrayDump    = 0x22
+	hprofPrimitiveArrayDump = 0x23
+
+	// hprofSegmentSize is the size a heap dump segment is closed at
+	hprofSegmentSize = 1 << 20
+	// hprofNoTrace is the serial of the empty stack trace objects are
+	// allocated at, as the VM does not record allocation sites
+	hprofNoTrace = 1
+)
+
+// hprofTypes maps the first byte of a field descriptor to its basic type.
+var hprofTypes = map[byte]byte{
+	'L': 2, '[': 2, 'Z': 4, 'C': 5, 'F': 6, 'D': 7, 'B': 8, 'S': 9, 'I': 10, 'J': 11,
+}
+
+type (
+	hprofWriter struct {
+		out *bufio.Writer
+		// buf holds the body of the record being written
+		buf     bytes.Buffer
+		strings map[string]uint64
+		serials map[*RuntimeClass]uint32
+	}
+
+	// heapSnapshot is what a heap dump writes, taken while the world is
+	// stopped.
+	heapSnapshot struct {
+		objects []*Object
+		classes []*RuntimeClass
+		threads []*thread
+		// roots are the objects the VM refers to outside of threads and
+		// classes
+		roots []Value
+	}
+)
+
+// DumpHeap writes the objects reachable in the heap, their classes and the
+// stacks of the threads to w in the HPROF format that heap analyzers such as
+// Eclipse MAT and VisualVM read. The threads of the VM are stopped until the
+// heap has been traversed, so it must not be called from a native method.
+func (vm *VirtualMachine) DumpHeap(w io.Writer) error {
+	return vm.dumpHeap(nil, w)
+}
+
+// dumpHeap dumps the heap from t, which is nil when called from Go.
+func (vm *VirtualMachine) dumpHeap(t *thread, w io.Writer) error {
+	h := &vm.heap
+	atomic.StoreInt32(&h.stopping, 1)
+	if t != nil && vm.leaveWorld(t) {
+		defer vm.enterWorld(t)
+	}
+	h.world.Lock()
+	defer h.world.Unlock()
+	atomic.StoreInt32(&h.stopping, 0)
+	return vm.writeHeapDump(w, vm.snapshotHeap())
+}
+
+// snapshotHeap finds every object reachable from the roots, references and
+// weak maps included.
+func (vm *VirtualMachine) snapshotHeap() *heapSnapshot {
+	h := &vm.heap
+	h.mu.Lock()
+	h.epoch++
+	m := &marker{epoch: h.epoch, all: true}
+	vm.markRoots(m)
+	m.drain()
+	s := &heapSnapshot{objects: m.found, roots: m.held}
+	for _, p := range h.pending {
+		s.roots = append(s.roots, p.obj)
+	}
+	for c := range h.cleanables {
+		s.roots = append(s.roots, c)
+	}
+	h.mu.Unlock()
+
+	vm.internMu.Lock()
+	for _, str := range vm.interned {
+		s.roots = append(s.roots, str)
+	}
+	vm.internMu.Unlock()
+	vm.boxesMu.Lock()
+	for _, b := range vm.boxes {
+		s.roots = append(s.roots, b)
+	}
+	vm.boxesMu.Unlock()
+
+	vm.threadsMu.Lock()
+	s.threads = append(s.threads, vm.main)
+	for _, t := range vm.threads {
+		s.threads = append(s.threads, t)
+	}
+	mainThread := vm.mainThread
+	vm.threadsMu.Unlock()
+	if mainThread != nil {
+		s.roots = append(s.roots, mainThread)
+	}
+
+	seen := map[*RuntimeClass]bool{}
+	var add func(c *RuntimeClass)
+	add = func(c *RuntimeClass) {
+		if c == nil || seen[c] || c.IsPrimitive() {
+			return
+		}
+		seen[c] = true
+		s.classes = append(s.classes, c)
+		add(c.Super)
+	}
+	vm.classesMu.Lock()
+	for _, c := range vm.classes {
+		add(c)
+	}
+	vm.classesMu.Unlock()
+	for _, obj := range s.objects {
+		add(obj.Class)
+	}
+	sort.Slice(s.classes, func(i, j int) bool { return s.classes[i].Name < s.classes[j].Name })
+	return s
+}
+
+func (vm *VirtualMachine) writeHeapDump(w io.Writer, s *heapSnapshot) error {
+	d := &hprofWriter{out: bufio.NewWriter(w), strings: map[string]uint64{}, serials: map[*RuntimeClass]uint32{}}
+	d.out.WriteString("JAVA PROFILE 1.0.2\x00")
+	d.u4(8)
+	d.u8(uint64(time.Now().UnixNano() / int64(time.Millisecond)))
+	d.out.Write(d.buf.Bytes())
+	d.buf.Reset()
+
+	d.u4(hprofNoTrace)
+	d.u4(0)
+	d.u4(0)
+	d.flush(hprofStackTrace)
+	for i, c := range s.classes {
+		d.serials[c] = uint32(i + 1)
+		name := d.str(c.Name)
+		d.u4(uint32(i + 1))
+		d.id(classID(c))
+		d.u4(hprofNoTrace)
+		d.id(name)
+		d.flush(hprofLoadClass)
+	}
+
+	// the stack of a thread is a trace numbered after the thread
+	frameID := uint64(0)
+	for i, t := range s.threads {
+		var frames []uint64
+		for f := t.top; f != nil; f = f.Caller {
+			if f.Method == nil {
+				continue
+			}
+			frameID++
+			frames = append(frames, frameID)
+			line := int32(-1)
+			if f.Method.IsNative() {
+				line = -3
+			}
+			name, desc := d.str(f.Method.Name), d.str(f.Method.Descriptor)
+			d.id(frameID)
+			d.id(name)
+			d.id(desc)
+			d.id(0)
+			d.u4(d.serials[f.Method.Class])
+			d.u4(uint32(line))
+			d.flush(hprofStackFrame)
+		}
+		d.u4(uint32(i + 2))
+		d.u4(uint32(i + 1))
+		d.u4(uint32(len(frames)))
+		for _, id := range frames {
+			d.id(id)
+		}
+		d.flush(hprofStackTrace)
+	}
+	for _, c := range s.classes {
+		for _, f := range c.Fields {
+			d.str(f.Name)
+		}
+	}
+
+	for i, t := range s.threads {
+		if t.object != nil {
+			d.u1(hprofRootThreadObject)
+			d.id(objectID(t.object))
+			d.u4(uint32(i + 1))
+			d.u4(uint32(i + 2))
+			d.endSubRecord()
+		}
+		depth := uint32(0)
+		for f := t.top; f != nil; f = f.Caller {
+			// values of the frames Go code calls from belong to no method
+			frame := uint32(math.MaxUint32)
+			if f.Method != nil {
+				frame = depth
+				depth++
+			}
+			values := f.Locals
+			if f.OperandStack != nil {
+				values = append(values[:len(values):len(values)], *f.OperandStack...)
+			}
+			for _, v := range values {
+				if obj, ok := v.(*Object); ok && obj != nil {
+					d.u1(hprofRootJavaFrame)
+					d.id(objectID(obj))
+					d.u4(uint32(i + 1))
+					d.u4(frame)
+					d.endSubRecord()
+				}
+			}
+		}
+	}
+	for _, v := range s.roots {
+		if obj, ok := v.(*Object); ok && obj != nil {
+			d.u1(hprofRootUnknown)
+			d.id(objectID(obj))
+			d.endSubRecord()
+		}
+	}
+	for _, c := range s.classes {
+		d.u1(hprofRootStickyClass)
+		d.id(classID(c))
+		d.endSubRecord()
+	}
+
+	for _, c := range s.classes {
+		d.classDump(c)
+	}
+	for _, obj := range s.objects {
+		if _, ok := classFromMirror(obj); ok || obj.Class == nil {
+			continue
+		}
+		switch a := obj.Array.(type) {
+		case nil:
+			d.instanceDump(obj)
+		case []Value:
+			d.u1(hprofObjectArrayDump)
+			d.id(objectID(obj))
+			d.u4(hprofNoTrace)
+			d.u4(uint32(len(a)))
+			d.id(classID(obj.Class))
+			for _, v := range a {
+				d.id(objectID(v))
+			}
+			d.endSubRecord()
+		default:
+			d.primitiveArrayDump(obj)
+		}
+	}
+	if d.buf.Len() > 0 {
+		d.flush(hprofHeapDumpSegment)
+	}
+	d.flush(hprofHeapDumpEnd)
+	return d.out.Flush()
+}
+
+func (d *hprofWriter) classDump(c *RuntimeClass) {
+	var statics, fields []*RuntimeField
+	for _, f := range c.Fields {
+		if f.IsStatic() {
+			statics = append(statics, f)
+		} else {
+			fields = append(fields, f)
+		}
+	}
+	size := 0
+	for k := c; k != nil; k = k.Super {
+		for _, f := range k.Fields {
+			if !f.IsStatic() {
+				size += hprofSize(f.Descriptor)
+			}
+		}
+	}
+
+	d.u1(hprofClassDump)
+	d.id(classID(c))
+	d.u4(hprofNoTrace)
+	d.id(classID(c.Super))
+	// class loader, signers, protection domain and two reserved ids
+	for i := 0; i < 5; i++ {
+		d.id(0)
+	}
+	d.u4(uint32(size))
+	d.u2(0)
+	d.u2(uint16(len(statics)))
+	for _, f := range statics {
+		d.id(d.str(f.Name))
+		d.u1(hprofTypes[f.Descriptor[0]])
+		var v Value
+		if f.Slot < len(c.StaticValues) {
+			v = c.StaticValues[f.Slot]
+		}
+		d.value(f.Descriptor, v)
+	}
+	d.u2(uint16(len(fields)))
+	for _, f := range fields {
+		d.id(d.str(f.Name))
+		d.u1(hprofTypes[f.Descriptor[0]])
+	}
+	d.endSubRecord()
+}
+
+// instanceDump writes the fields of obj from its class up to Object, each
+// class in the order the class dump lists them.
+func (d *hprofWriter) instanceDump(obj *Object) {
+	var fields []*RuntimeField
+	size := 0
+	for k := obj.Class; k != nil; k = k.Super {
+		for _, f := range k.Fields {
+			if !f.IsStatic() {
+				fields = append(fields, f)
+				size += hprofSize(f.Descriptor)
+			}
+		}
+	}
+	d.u1(hprofInstanceDump)
+	d.id(objectID(obj))
+	d.u4(hprofNoTrace)
+	d.id(classID(obj.Class))
+	d.u4(uint32(size))
+	for _, f := range fields {
+		var v Value
+		if f.Slot < len(obj.Fields) {
+			v = obj.Fields[f.Slot]
+		}
+		d.value(f.Descriptor, v)
+	}
+	d.endSubRecord()
+}
+
+func (d *hprofWriter) primitiveArrayDump(obj *Object) {
+	var body bytes.Buffer
+	n, elem := 0, byte(0)
+	switch a := obj.Array.(type) {
+	case []int8:
+		n, elem = len(a), hprofTypes['B']
+		if obj.Class.Name == "[Z" {
+			elem = hprofTypes['Z']
+		}
+		binary.Write(&body, binary.BigEndian, a)
+	case []uint16:
+		n, elem = len(a), hprofTypes['C']
+		binary.Write(&body, binary.BigEndian, a)
+	case []int16:
+		n, elem = len(a), hprofTypes['S']
+		binary.Write(&body, binary.BigEndian, a)
+	case []int32:
+		n, elem = len(a), hprofTypes['I']
+		binary.Write(&body, binary.BigEndian, a)
+	case []float32:
+		n, elem = len(a), hprofTypes['F']
+		binary.Write(&body, binary.BigEndian, a)
+	case []int64:
+		n, elem = len(a), hprofTypes['J']
+		binary.Write(&body, binary.BigEndian, a)
+	case []float64:
+		n, elem = len(a), hprofTypes['D']
+		binary.Write(&body, binary.BigEndian, a)
+	default:
+		return
+	}
+	d.u1(hprofPrimitiveArrayDump)
+	d.id(objectID(obj))
+	d.u4(hprofNoTrace)
+	d.u4(uint32(n))
+	d.u1(elem)
+	d.buf.Write(body.Bytes())
+	d.endSubRecord()
+}
+
+// value writes v as a field of type desc, treating a missing value as zero.
+func (d *hprofWriter) value(desc string, v Value) {
+	switch desc[0] {
+	case 'L', '[':
+		d.id(objectID(v))
+	case 'Z', 'B':
+		i, _ := v.(int32)
+		d.u1(byte(i))
+	case 'C', 'S':
+		i, _ := v.(int32)
+		d.u2(uint16(i))
+	case 'I':
+		i, _ := v.(int32)
+		d.u4(uint32(i))
+	case 'F':
+		f, _ := v.(float32)
+		d.u4(math.Float32bits(f))
+	case 'J':
+		i, _ := v.(int64)
+		d.u8(uint64(i))
+	case 'D':
+		f, _ := v.(float64)
+		d.u8(math.Float64bits(f))
+	}
+}
+
+func hprofSize(desc string) int {
+	switch desc[0] {
+	case 'Z', 'B':
+		return 1
+	case 'C', 'S':
+		return 2
+	case 'I', 'F':
+		return 4
+	}
+	return 8
+}
+
+// objectID identifies an object by its address, or a class mirror by the
+// class, as class dumps stand in for the java.lang.Class objects.
+func objectID(v Value) uint64 {
+	obj, ok := v.(*Object)
+	if !ok || obj == nil {
+		return 0
+	}
+	if c, ok := classFromMirror(obj); ok {
+		return classID(c)
+	}
+	return uint64(uintptr(unsafe.Pointer(obj)))
+}
+
+func classID(c *RuntimeClass) uint64 {
+	if c == nil {
+		return 0
+	}
+	return uint64(uintptr(unsafe.Pointer(c)))
+}
+
+// str returns the id of s, writing its record on first use.
+func (d *hprofWriter) str(s string) uint64 {
+	if id, ok := d.strings[s]; ok {
+		return id
+	}
+	id := uint64(len(d.strings) + 1)
+	d.strings[s] = id
+	var head [17]byte
+	head[0] = hprofString
+	binary.BigEndian.PutUint32(head[5:], uint32(8+len(s)))
+	binary.BigEndian.PutUint64(head[9:], id)
+	d.out.Write(head[:])
+	d.out.WriteString(s)
+	return id
+}
+
+// flush writes the body in buf as a record.
+func (d *hprofWriter) flush(tag byte) {
+	var head [9]byte
+	head[0] = tag
+	binary.BigEndian.PutUint32(head[5:], uint32(d.buf.Len()))
+	d.out.Write(head[:])
+	d.out.Write(d.buf.Bytes())
+	d.buf.Reset()
+}
+
+// endSubRecord closes the heap dump segment once it is large enough.
+func (d *hprofWriter) endSubRecord() {
+	if d.buf.Len() >= hprofSegmentSize {
+		d.flush(hprofHeapDumpSegment)
+	}
+}
+
+func (d *hprofWriter) u1(v byte) {
+	d.buf.WriteByte(v)
+}
+
+func (d *hprofWriter) u2(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	d.buf.Write(b[:])
+}
+
+func (d *hprofWriter) u4(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	d.buf.Write(b[:])
+}
+
+func (d *hprofWriter) u8(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	d.buf.Write(b[:])
+}
+
+func (d *hprofWriter) id(v uint64) {
+	d.u8(v)
+}
+
+// dumpHeapOnOutOfMemory writes the heap dump HeapDumpOnOutOfMemoryError asks
+// for, the first time the heap runs out, and reports on it as HotSpot does.
+func (vm *VirtualMachine) dumpHeapOnOutOfMemory(t *thread) {
+	if !vm.HeapDumpOnOutOfMemoryError {
+		return
+	}
+	vm.heap.oomDumpOnce.Do(func() {
+		vm.dumpHeapToPath(t)
+	})
+}
+
+// DumpHeapOnSignal dumps the heap to HeapDumpPath each time the process
+// receives one of sigs, so that a running program can be dumped from the
+// command line like jmap -dump does, e.g. with kill -USR2 <pid>. It reports
+// on the dumps as HeapDumpOnOutOfMemoryError does. Calling stop ends it.
+func (vm *VirtualMachine) DumpHeapOnSignal(sigs ...os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	done := make(chan struct{})
+	go vm.dumpHeapOn(ch, done)
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+func (vm *VirtualMachine) dumpHeapOn(ch <-chan os.Signal, done <-chan struct{}) {
+	for {
+		select {
+		case <-ch:
+			vm.dumpHeapToPath(nil)
+		case <-done:
+			return
+		}
+	}
+}
+
+// dumpHeapToPath dumps the heap to the next file of HeapDumpPath and reports
+// on it as HotSpot does.
+func (vm *VirtualMachine) dumpHeapToPath(t *thread) {
+	path := vm.heapDumpPath(int(atomic.AddInt32(&vm.heap.dumps, 1)) - 1)
+	vm.write(vm.Out, []byte(fmt.Sprintf("Dumping heap to %s ...\n", path)))
+	start := time.Now()
+	n, err := vm.dumpHeapFile(t, path)
+	if err != nil {
+		vm.write(vm.Out, []byte(fmt.Sprintf("Unable to create %s: %v\n", path, err)))
+		return
+	}
+	vm.write(vm.Out, []byte(fmt.Sprintf("Heap dump file created [%d bytes in %.3f secs]\n", n, time.Since(start).Seconds())))
+}
+
+// heapDumpPath resolves HeapDumpPath, which may name a directory, to a file.
+// Like HotSpot, it numbers the files of the dumps after the first.
+func (vm *VirtualMachine) heapDumpPath(seq int) string {
+	path := fmt.Sprintf("java_pid%d.hprof", os.Getpid())
+	if vm.HeapDumpPath != "" {
+		if fi, err := os.Stat(vm.HeapDumpPath); err == nil && fi.IsDir() {
+			path = filepath.Join(vm.HeapDumpPath, path)
+		} else {
+			path = vm.HeapDumpPath
+		}
+	}
+	if seq > 0 {
+		path = fmt.Sprintf("%s.%d", path, seq)
+	}
+	return path
+}
+
+// dumpHeapFile dumps the heap to a new file, never replacing one.
+func (vm *VirtualMachine) dumpHeapFile(t *thread, path string) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	if err := vm.dumpHeap(t, f); err != nil {
+		f.Close()
+		return 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	return fi.Size(), f.Close()
+}
@@ -0,0 +1,255 @@
+package jvmgo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// hprofDump is what readHprof finds in a heap dump.
+type hprofDump struct {
+	strings   map[uint64]string
+	classes   map[uint64]string
+	fields    map[uint64][]byte
+	instances map[string][][]byte
+	ints      [][]int32
+	arrays    map[string][]int
+	roots     map[byte]int
+}
+
+func readHprof(t *testing.T, data []byte) *hprofDump {
+	const header = "JAVA PROFILE 1.0.2\x00"
+	require.True(t, bytes.HasPrefix(data, []byte(header)))
+	r := bytes.NewReader(data[len(header):])
+	var idSize uint32
+	var timestamp uint64
+	require.NoError(t, binary.Read(r, binary.BigEndian, &idSize))
+	require.NoError(t, binary.Read(r, binary.BigEndian, &timestamp))
+	require.Equal(t, uint32(8), idSize)
+
+	d := &hprofDump{
+		strings:   map[uint64]string{},
+		classes:   map[uint64]string{},
+		fields:    map[uint64][]byte{},
+		instances: map[string][][]byte{},
+		arrays:    map[string][]int{},
+		roots:     map[byte]int{},
+	}
+	u1 := func(r *bytes.Reader) byte { b, _ := r.ReadByte(); return b }
+	u2 := func(r *bytes.Reader) (v uint16) { binary.Read(r, binary.BigEndian, &v); return v }
+	u4 := func(r *bytes.Reader) (v uint32) { binary.Read(r, binary.BigEndian, &v); return v }
+	u8 := func(r *bytes.Reader) (v uint64) { binary.Read(r, binary.BigEndian, &v); return v }
+	sizes := map[byte]int{2: 8, 4: 1, 5: 2, 6: 4, 7: 8, 8: 1, 9: 2, 10: 4, 11: 8}
+	skip := func(r *bytes.Reader, n int) { r.Seek(int64(n), 1) }
+
+	ended := false
+	for r.Len() > 0 {
+		tag := u1(r)
+		u4(r)
+		body := make([]byte, u4(r))
+		_, err := io.ReadFull(r, body)
+		require.NoError(t, err)
+		b := bytes.NewReader(body)
+		switch tag {
+		case hprofString:
+			id := u8(b)
+			d.strings[id] = string(body[8:])
+		case hprofLoadClass:
+			u4(b)
+			id := u8(b)
+			u4(b)
+			d.classes[id] = d.strings[u8(b)]
+		case hprofHeapDumpSegment:
+			for b.Len() > 0 {
+				sub := u1(b)
+				switch sub {
+				case hprofRootUnknown, hprofRootStickyClass:
+					skip(b, 8)
+				case hprofRootJavaFrame, hprofRootThreadObject:
+					skip(b, 16)
+				case hprofClassDump:
+					id := u8(b)
+					skip(b, 4+6*8+4)
+					skip(b, 2)
+					for n := u2(b); n > 0; n-- {
+						skip(b, 8)
+						skip(b, sizes[u1(b)])
+					}
+					var types []byte
+					for n := u2(b); n > 0; n-- {
+						skip(b, 8)
+						types = append(types, u1(b))
+					}
+					d.fields[id] = types
+				case hprofInstanceDump:
+					skip(b, 12)
+					class := d.classes[u8(b)]
+					values := make([]byte, u4(b))
+					b.Read(values)
+					d.instances[class] = append(d.instances[class], values)
+				case hprofObjectArrayDump:
+					skip(b, 12)
+					n := int(u4(b))
+					class := d.classes[u8(b)]
+					d.arrays[class] = append(d.arrays[class], n)
+					skip(b, 8*n)
+				case hprofPrimitiveArrayDump:
+					skip(b, 12)
+					n := int(u4(b))
+					elem := u1(b)
+					if elem == 10 {
+						ints := make([]int32, n)
+						binary.Read(b, binary.BigEndian, ints)
+						d.ints = append(d.ints, ints)
+					} else {
+						skip(b, n*sizes[elem])
+					}
+				default:
+					t.Fatalf("unknown sub-record %#x", sub)
+				}
+				d.roots[sub]++
+			}
+		case hprofHeapDumpEnd:
+			ended = true
+		}
+	}
+	require.True(t, ended)
+	return d
+}
+
+func TestVirtualMachine_DumpHeap(t *testing.T) {
+	// class Point { int x, y; Point(int x, int y) { ... } }
+	p := newClassBuilder("Point", "java/lang/Object")
+	p.field(0, "x", "I")
+	p.field(0, "y", "I")
+	p.method(0, "<init>", "(II)V", 2, 3, newAsm().
+		op(OpCodeAload0).ref(OpCodeInvokeSpecial, p.methodRef("java/lang/Object", "<init>", "()V")).
+		op(OpCodeAload0).op(OpCodeIload0+1).ref(OpCodePutField, p.fieldRef("Point", "x", "I")).
+		op(OpCodeAload0).op(OpCodeIload0+2).ref(OpCodePutField, p.fieldRef("Point", "y", "I")).
+		op(OpCodeReturn).bytes()...)
+	cp := mapClassPath{}
+	cp.add(p.build())
+
+	// static Point[] points = { new Point(1, 2), new Point(3, 4) }; static int[] data = { 1, 2, 3 };
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.field(AccStatic, "points", "[LPoint;")
+	b.field(AccStatic, "data", "[I")
+	code := newAsm().op(OpCodeIconst0+2).ref(OpCodeANewArray, b.classRef("Point"))
+	for i := byte(0); i < 2; i++ {
+		code.op(OpCodeDup).op(OpCodeIconst0+OpCode(i)).ref(OpCodeNew, b.classRef("Point")).op(OpCodeDup).
+			op(OpCodeIconst0+OpCode(2*i+1)).op(OpCodeIconst0+OpCode(2*i+2)).ref(OpCodeInvokeSpecial, b.methodRef("Point", "<init>", "(II)V")).
+			op(OpCodeAastore)
+	}
+	code.ref(OpCodePutStatic, b.fieldRef("Main", "points", "[LPoint;")).
+		op(OpCodeIconst0+3).op(OpCodeNewArray, 10)
+	for i := byte(0); i < 3; i++ {
+		code.op(OpCodeDup).op(OpCodeIconst0 + OpCode(i)).op(OpCodeIconst0 + OpCode(i+1)).op(OpCodeIastore)
+	}
+	code.ref(OpCodePutStatic, b.fieldRef("Main", "data", "[I")).op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 7, 1, code.bytes()...)
+
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	require.NoError(t, vm.ExecMain())
+	var out bytes.Buffer
+	require.NoError(t, vm.DumpHeap(&out))
+
+	d := readHprof(t, out.Bytes())
+	var points []string
+	for _, values := range d.instances["Point"] {
+		require.Len(t, values, 8)
+		points = append(points, fmt.Sprint(binary.BigEndian.Uint32(values), binary.BigEndian.Uint32(values[4:])))
+	}
+	require.ElementsMatch(t, []string{"1 2", "3 4"}, points)
+	require.Equal(t, []int{2}, d.arrays["[LPoint;"])
+	require.Contains(t, d.ints, []int32{1, 2, 3})
+	for id, name := range d.classes {
+		if name == "Point" {
+			require.Equal(t, []byte{10, 10}, d.fields[id])
+		}
+	}
+	require.Greater(t, d.roots[hprofRootStickyClass], 0)
+}
+
+func TestVirtualMachine_HeapDumpOnOutOfMemoryError(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	// int[] kept = new int[10]; new int[1 << 20];
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 1, 2, newAsm().
+		op(OpCodeBipush, 10).op(OpCodeNewArray, 10).op(OpCodeAstore, 1).
+		op(OpCodeLdc, lo(b.integer(1<<20))).op(OpCodeNewArray, 10).op(OpCodePop).op(OpCodeReturn).bytes()...)
+
+	dir := t.TempDir()
+	vm := NewVM(b.build())
+	vm.MaxHeapSize = 1 << 20
+	vm.HeapDumpOnOutOfMemoryError = true
+	vm.HeapDumpPath = dir
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	err := vm.ExecMain()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "java/lang/OutOfMemoryError: Java heap space")
+
+	path := filepath.Join(dir, fmt.Sprintf("java_pid%d.hprof", os.Getpid()))
+	require.True(t, strings.HasPrefix(stdout.String(), "Dumping heap to "+path+" ...\nHeap dump file created ["), stdout.String())
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	d := readHprof(t, data)
+	// the frame of main is a root of the array it keeps
+	require.Greater(t, d.roots[hprofRootJavaFrame], 0)
+	require.Contains(t, d.ints, make([]int32, 10))
+}
+
+func TestVirtualMachine_DumpHeapOnSignal(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	// int[] kept = new int[10]; System.out.println("running"); while (true) Thread.sleep(1);
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 2, newAsm().
+		op(OpCodeBipush, 10).op(OpCodeNewArray, 10).op(OpCodeAstore, 1).
+		ref(OpCodeGetStatic, b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")).op(OpCodeLdc, lo(b.str("running"))).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")).
+		label("loop").op(OpCodeIconst0+1).op(OpCodeI2l).ref(OpCodeInvokeStatic, b.methodRef("java/lang/Thread", "sleep", "(J)V")).
+		branch(OpCodeGoto, "loop").bytes()...)
+
+	dir := t.TempDir()
+	vm := NewVM(b.build())
+	vm.HeapDumpPath = dir
+	r, w := io.Pipe()
+	vm.Out = w
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan error)
+	go func() { exited <- vm.ExecMainContext(ctx) }()
+
+	signals := make(chan os.Signal)
+	done := make(chan struct{})
+	go vm.dumpHeapOn(signals, done)
+	defer close(done)
+	lines := bufio.NewScanner(r)
+	require.True(t, lines.Scan())
+	require.Equal(t, "running", lines.Text())
+	path := filepath.Join(dir, fmt.Sprintf("java_pid%d.hprof", os.Getpid()))
+	for _, p := range []string{path, path + ".1"} {
+		signals <- os.Interrupt
+		require.True(t, lines.Scan())
+		require.Equal(t, "Dumping heap to "+p+" ...", lines.Text())
+		require.True(t, lines.Scan())
+		require.True(t, strings.HasPrefix(lines.Text(), "Heap dump file created ["), lines.Text())
+		data, err := ioutil.ReadFile(p)
+		require.NoError(t, err)
+		d := readHprof(t, data)
+		require.Greater(t, d.roots[hprofRootJavaFrame], 0)
+		require.Contains(t, d.ints, make([]int32, 10))
+	}
+
+	cancel()
+	var canceled *CanceledError
+	require.ErrorAs(t, <-exited, &canceled)
+}
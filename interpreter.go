@@ -0,0 +1,1217 @@
+package jvmgo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+type (
+	// stackError is raised by the typed operand stack accessors and recovered
+	// into an error by executeCode.
+	stackError struct {
+		msg string
+	}
+)
+
+func (vm *VirtualMachine) executeCode(f *Frame) (ret Value, err error) {
+	t := vm.currentThread(f)
+	caller := t.top
+	t.top = f
+	var pc int
+	defer func() {
+		t.top = caller
+		if r := recover(); r != nil {
+			se, ok := r.(stackError)
+			if !ok {
+				// unverified bytecode can use values of the wrong types
+				ret, err = nil, vm.throwNew(f, "java/lang/InternalError", fmt.Sprintf("%s at pc=%d: %v", f.Method, pc, r))
+				return
+			}
+			ret, err = nil, fmt.Errorf("%s at pc=%d: %s", f.Method, f.PC, se.msg)
+		}
+	}()
+
+	for {
+		if atomic.LoadInt32(&vm.halted) != 0 {
+			return nil, vm.exitError()
+		}
+		if max := vm.MaxInstructions; max > 0 && atomic.AddInt64(&vm.instructions, 1) > max {
+			vm.abort(ErrInstructionLimit)
+			return nil, vm.exitError()
+		}
+		vm.safepoint(t)
+		if f.PC >= len(f.Code.Code) {
+			return nil, fmt.Errorf("%s: fell off the end of code", f.Method)
+		}
+		pc = f.PC
+		ret, done, err := vm.executeInstruction(f)
+		if err == nil {
+			if done {
+				return ret, nil
+			}
+			continue
+		}
+
+		var ex *JavaException
+		if !errors.As(err, &ex) {
+			return nil, fmt.Errorf("%s at pc=%d: %w", f.Method, pc, err)
+		}
+		handler, herr := vm.findExceptionHandler(f, pc, ex.Object)
+		if herr != nil {
+			return nil, fmt.Errorf("%s at pc=%d: find exception handler: %w", f.Method, pc, herr)
+		}
+		if handler < 0 {
+			return nil, ex
+		}
+		*f.OperandStack = (*f.OperandStack)[:0]
+		f.OperandStack.push(ex.Object)
+		f.PC = handler
+	}
+}
+
+// executeInstruction runs the instruction at f.PC. done reports that the method
+// returned, with ret as its result.
+func (vm *VirtualMachine) executeInstruction(f *Frame) (ret Value, done bool, err error) {
+	pc := f.PC
+	op, _ := f.readU1()
+	s := f.OperandStack
+
+	switch OpCode(op) {
+	case OpCodeNop:
+	case OpCodeAconstNull:
+		s.push(nil)
+	case OpCodeBipush:
+		b, err := f.readU1()
+		if err != nil {
+			return nil, false, err
+		}
+		s.push(int32(int8(b)))
+	case OpCodeSipush:
+		v, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		s.push(int32(int16(v)))
+	case OpCodeLconst0, OpCodeLconst1:
+		s.push(int64(OpCode(op) - OpCodeLconst0))
+	case OpCodeFconst0, OpCodeFconst1, OpCodeFconst2:
+		s.push(float32(OpCode(op) - OpCodeFconst0))
+	case OpCodeDconst0, OpCodeDconst1:
+		s.push(float64(OpCode(op) - OpCodeDconst0))
+	case OpCodeLdc, OpCodeLdcW, OpCodeLdc2W:
+		var idx uint16
+		if OpCode(op) == OpCodeLdc {
+			b, err := f.readU1()
+			if err != nil {
+				return nil, false, fmt.Errorf("execute ldc: %w", err)
+			}
+			idx = uint16(b)
+		} else if idx, err = f.readU2(); err != nil {
+			return nil, false, fmt.Errorf("execute ldc: %w", err)
+		}
+		v, err := vm.loadConstant(f.Class, idx)
+		if err != nil {
+			return nil, false, err
+		}
+		if isCategory2(v) != (OpCode(op) == OpCodeLdc2W) {
+			return nil, false, fmt.Errorf("opcode 0x%02x cannot load constant #%d of kind %d", op, idx, f.Class.File.GetCpInfo(idx).Tag)
+		}
+		s.push(v)
+	case OpCodeIload, OpCodeLload, OpCodeFload, OpCodeDload, OpCodeAload:
+		idx, err := f.readU1()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := f.load(int(idx)); err != nil {
+			return nil, false, err
+		}
+	case OpCodeIstore, OpCodeLstore, OpCodeFstore, OpCodeDstore, OpCodeAstore:
+		idx, err := f.readU1()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := f.store(int(idx)); err != nil {
+			return nil, false, err
+		}
+	case OpCodeIaload, OpCodeLaload, OpCodeFaload, OpCodeDaload, OpCodeAaload, OpCodeBaload, OpCodeCaload, OpCodeSaload:
+		idx := s.popInt()
+		arr := s.popRef()
+		if arr == nil {
+			return nil, false, vm.throwNullPointer(f)
+		}
+		v, err := vm.arrayLoad(f, arr, idx)
+		if err != nil {
+			return nil, false, err
+		}
+		s.push(v)
+	case OpCodeIastore, OpCodeLastore, OpCodeFastore, OpCodeDastore, OpCodeAastore, OpCodeBastore, OpCodeCastore, OpCodeSastore:
+		v := s.mustPop()
+		idx := s.popInt()
+		arr := s.popRef()
+		if arr == nil {
+			return nil, false, vm.throwNullPointer(f)
+		}
+		if err := vm.arrayStore(f, arr, idx, v); err != nil {
+			return nil, false, err
+		}
+	case OpCodePop:
+		s.popWords(1)
+	case OpCodePop2:
+		s.popWords(2)
+	case OpCodeDup, OpCodeDupX1, OpCodeDupX2:
+		s.dupX(1, int(OpCode(op)-OpCodeDup))
+	case OpCodeDup2, OpCodeDup2X1, OpCodeDup2X2:
+		s.dupX(2, int(OpCode(op)-OpCodeDup2))
+	case OpCodeSwap:
+		v1, v2 := s.mustPop(), s.mustPop()
+		s.push(v1)
+		s.push(v2)
+	case OpCodeIadd:
+		b, a := s.popInt(), s.popInt()
+		s.push(a + b)
+	case OpCodeLadd:
+		b, a := s.popLong(), s.popLong()
+		s.push(a + b)
+	case OpCodeFadd:
+		b, a := s.popFloat(), s.popFloat()
+		s.push(a + b)
+	case OpCodeDadd:
+		b, a := s.popDouble(), s.popDouble()
+		s.push(a + b)
+	case OpCodeIsub:
+		b, a := s.popInt(), s.popInt()
+		s.push(a - b)
+	case OpCodeLsub:
+		b, a := s.popLong(), s.popLong()
+		s.push(a - b)
+	case OpCodeFsub:
+		b, a := s.popFloat(), s.popFloat()
+		s.push(a - b)
+	case OpCodeDsub:
+		b, a := s.popDouble(), s.popDouble()
+		s.push(a - b)
+	case OpCodeImul:
+		b, a := s.popInt(), s.popInt()
+		s.push(a * b)
+	case OpCodeLmul:
+		b, a := s.popLong(), s.popLong()
+		s.push(a * b)
+	case OpCodeFmul:
+		b, a := s.popFloat(), s.popFloat()
+		s.push(a * b)
+	case OpCodeDmul:
+		b, a := s.popDouble(), s.popDouble()
+		s.push(a * b)
+	case OpCodeIdiv, OpCodeIrem:
+		b, a := s.popInt(), s.popInt()
+		if b == 0 {
+			return nil, false, vm.throwNew(f, "java/lang/ArithmeticException", "/ by zero")
+		}
+		if OpCode(op) == OpCodeIdiv {
+			s.push(a / b)
+		} else {
+			s.push(a % b)
+		}
+	case OpCodeLdiv, OpCodeLrem:
+		b, a := s.popLong(), s.popLong()
+		if b == 0 {
+			return nil, false, vm.throwNew(f, "java/lang/ArithmeticException", "/ by zero")
+		}
+		if OpCode(op) == OpCodeLdiv {
+			s.push(a / b)
+		} else {
+			s.push(a % b)
+		}
+	case OpCodeFdiv:
+		b, a := s.popFloat(), s.popFloat()
+		s.push(a / b)
+	case OpCodeDdiv:
+		b, a := s.popDouble(), s.popDouble()
+		s.push(a / b)
+	case OpCodeFrem:
+		b, a := s.popFloat(), s.popFloat()
+		s.push(float32(math.Mod(float64(a), float64(b))))
+	case OpCodeDrem:
+		b, a := s.popDouble(), s.popDouble()
+		s.push(math.Mod(a, b))
+	case OpCodeIneg:
+		s.push(-s.popInt())
+	case OpCodeLneg:
+		s.push(-s.popLong())
+	case OpCodeFneg:
+		s.push(-s.popFloat())
+	case OpCodeDneg:
+		s.push(-s.popDouble())
+	case OpCodeIshl:
+		b, a := s.popInt(), s.popInt()
+		s.push(a << uint(b&0x1f))
+	case OpCodeLshl:
+		b, a := s.popInt(), s.popLong()
+		s.push(a << uint(b&0x3f))
+	case OpCodeIshr:
+		b, a := s.popInt(), s.popInt()
+		s.push(a >> uint(b&0x1f))
+	case OpCodeLshr:
+		b, a := s.popInt(), s.popLong()
+		s.push(a >> uint(b&0x3f))
+	case OpCodeIushr:
+		b, a := s.popInt(), s.popInt()
+		s.push(int32(uint32(a) >> uint(b&0x1f)))
+	case OpCodeLushr:
+		b, a := s.popInt(), s.popLong()
+		s.push(int64(uint64(a) >> uint(b&0x3f)))
+	case OpCodeIand:
+		b, a := s.popInt(), s.popInt()
+		s.push(a & b)
+	case OpCodeLand:
+		b, a := s.popLong(), s.popLong()
+		s.push(a & b)
+	case OpCodeIor:
+		b, a := s.popInt(), s.popInt()
+		s.push(a | b)
+	case OpCodeLor:
+		b, a := s.popLong(), s.popLong()
+		s.push(a | b)
+	case OpCodeIxor:
+		b, a := s.popInt(), s.popInt()
+		s.push(a ^ b)
+	case OpCodeLxor:
+		b, a := s.popLong(), s.popLong()
+		s.push(a ^ b)
+	case OpCodeIinc:
+		idx, err := f.readU1()
+		if err != nil {
+			return nil, false, err
+		}
+		c, err := f.readU1()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := f.iinc(int(idx), int32(int8(c))); err != nil {
+			return nil, false, err
+		}
+	case OpCodeI2l:
+		s.push(int64(s.popInt()))
+	case OpCodeI2f:
+		s.push(float32(s.popInt()))
+	case OpCodeI2d:
+		s.push(float64(s.popInt()))
+	case OpCodeL2i:
+		s.push(int32(s.popLong()))
+	case OpCodeL2f:
+		s.push(float32(s.popLong()))
+	case OpCodeL2d:
+		s.push(float64(s.popLong()))
+	case OpCodeF2i:
+		s.push(f2i(float64(s.popFloat())))
+	case OpCodeF2l:
+		s.push(f2l(float64(s.popFloat())))
+	case OpCodeF2d:
+		s.push(float64(s.popFloat()))
+	case OpCodeD2i:
+		s.push(f2i(s.popDouble()))
+	case OpCodeD2l:
+		s.push(f2l(s.popDouble()))
+	case OpCodeD2f:
+		s.push(float32(s.popDouble()))
+	case OpCodeI2b:
+		s.push(int32(int8(s.popInt())))
+	case OpCodeI2c:
+		s.push(int32(uint16(s.popInt())))
+	case OpCodeI2s:
+		s.push(int32(int16(s.popInt())))
+	case OpCodeLcmp:
+		b, a := s.popLong(), s.popLong()
+		switch {
+		case a > b:
+			s.push(int32(1))
+		case a < b:
+			s.push(int32(-1))
+		default:
+			s.push(int32(0))
+		}
+	case OpCodeFcmpl, OpCodeFcmpg:
+		b, a := s.popFloat(), s.popFloat()
+		s.push(floatCompare(float64(a), float64(b), OpCode(op) == OpCodeFcmpg))
+	case OpCodeDcmpl, OpCodeDcmpg:
+		b, a := s.popDouble(), s.popDouble()
+		s.push(floatCompare(a, b, OpCode(op) == OpCodeDcmpg))
+	case OpCodeIfeq, OpCodeIfne, OpCodeIflt, OpCodeIfge, OpCodeIfgt, OpCodeIfle:
+		offset, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		if compareInt(OpCode(op)-OpCodeIfeq, s.popInt(), 0) {
+			f.PC = pc + int(int16(offset))
+		}
+	case OpCodeIfIcmpeq, OpCodeIfIcmpne, OpCodeIfIcmplt, OpCodeIfIcmpge, OpCodeIfIcmpgt, OpCodeIfIcmple:
+		offset, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		b, a := s.popInt(), s.popInt()
+		if compareInt(OpCode(op)-OpCodeIfIcmpeq, a, b) {
+			f.PC = pc + int(int16(offset))
+		}
+	case OpCodeIfAcmpeq, OpCodeIfAcmpne:
+		offset, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		b, a := s.mustPop(), s.mustPop()
+		if sameReference(a, b) == (OpCode(op) == OpCodeIfAcmpeq) {
+			f.PC = pc + int(int16(offset))
+		}
+	case OpCodeIfNull, OpCodeIfNonNull:
+		offset, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		if isNull(s.mustPop()) == (OpCode(op) == OpCodeIfNull) {
+			f.PC = pc + int(int16(offset))
+		}
+	case OpCodeGoto:
+		offset, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		f.PC = pc + int(int16(offset))
+	case OpCodeGotoW:
+		offset, err := f.readU4()
+		if err != nil {
+			return nil, false, err
+		}
+		f.PC = pc + int(int32(offset))
+	case OpCodeJsr:
+		offset, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		s.push(returnAddress(f.PC))
+		f.PC = pc + int(int16(offset))
+	case OpCodeJsrW:
+		offset, err := f.readU4()
+		if err != nil {
+			return nil, false, err
+		}
+		s.push(returnAddress(f.PC))
+		f.PC = pc + int(int32(offset))
+	case OpCodeRet:
+		idx, err := f.readU1()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := f.ret(int(idx)); err != nil {
+			return nil, false, err
+		}
+	case OpCodeWide:
+		if err := f.executeWide(); err != nil {
+			return nil, false, err
+		}
+	case OpCodeTableSwitch:
+		f.PC = (pc + 4) &^ 3
+		def, err := f.readU4()
+		if err != nil {
+			return nil, false, err
+		}
+		low, err := f.readU4()
+		if err != nil {
+			return nil, false, err
+		}
+		high, err := f.readU4()
+		if err != nil {
+			return nil, false, err
+		}
+		key := s.popInt()
+		target := pc + int(int32(def))
+		if key >= int32(low) && key <= int32(high) {
+			f.PC += int(key-int32(low)) * 4
+			offset, err := f.readU4()
+			if err != nil {
+				return nil, false, err
+			}
+			target = pc + int(int32(offset))
+		}
+		f.PC = target
+	case OpCodeLookupSwitch:
+		f.PC = (pc + 4) &^ 3
+		def, err := f.readU4()
+		if err != nil {
+			return nil, false, err
+		}
+		n, err := f.readU4()
+		if err != nil {
+			return nil, false, err
+		}
+		key := s.popInt()
+		target := pc + int(int32(def))
+		for i := uint32(0); i < n; i++ {
+			match, err := f.readU4()
+			if err != nil {
+				return nil, false, err
+			}
+			offset, err := f.readU4()
+			if err != nil {
+				return nil, false, err
+			}
+			if int32(match) == key {
+				target = pc + int(int32(offset))
+				break
+			}
+		}
+		f.PC = target
+	case OpCodeIreturn, OpCodeLreturn, OpCodeFreturn, OpCodeDreturn, OpCodeAreturn:
+		return s.mustPop(), true, nil
+	case OpCodeReturn:
+		return nil, true, nil
+	case OpCodeGetStatic, OpCodePutStatic:
+		idx, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := vm.accessStatic(f, idx, OpCode(op) == OpCodePutStatic); err != nil {
+			return nil, false, err
+		}
+	case OpCodeGetField, OpCodePutField:
+		idx, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := vm.accessField(f, idx, OpCode(op) == OpCodePutField); err != nil {
+			return nil, false, err
+		}
+	case OpCodeInvokeVirtual, OpCodeInvokeSpecial, OpCodeInvokeStatic, OpCodeInvokeInterface:
+		idx, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		if OpCode(op) == OpCodeInvokeInterface {
+			// count and a zero byte that are redundant with the descriptor
+			f.PC += 2
+		}
+		ref, err := f.Class.File.GetMemberRef(idx)
+		if err != nil {
+			return nil, false, fmt.Errorf("execute invoke parse symbol: %w", err)
+		}
+		if err := vm.invoke(f, OpCode(op), ref); err != nil {
+			return nil, false, err
+		}
+	case OpCodeInvokeDynamic:
+		idx, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		// two zero bytes
+		f.PC += 2
+		if err := vm.invokeDynamic(f, pc, idx); err != nil {
+			return nil, false, err
+		}
+	case OpCodeNew:
+		idx, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		class, err := vm.resolveClass(f, idx)
+		if err != nil {
+			return nil, false, err
+		}
+		if class.AccessFlags&(AccInterface|AccAbstract) != 0 {
+			return nil, false, vm.throwNew(f, "java/lang/InstantiationError", javaClassName(class.Name))
+		}
+		if err := vm.initializeClass(f, class); err != nil {
+			return nil, false, err
+		}
+		obj := NewObject(class)
+		if err := vm.allocate(f, obj); err != nil {
+			return nil, false, err
+		}
+		s.push(obj)
+	case OpCodeNewArray:
+		atype, err := f.readU1()
+		if err != nil {
+			return nil, false, err
+		}
+		desc, ok := arrayTypeDescriptors[atype]
+		if !ok {
+			return nil, false, fmt.Errorf("invalid array type %d", atype)
+		}
+		arr, err := vm.newArray(f, "["+desc, s.popInt())
+		if err != nil {
+			return nil, false, err
+		}
+		s.push(arr)
+	case OpCodeANewArray:
+		idx, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		component, err := vm.resolveClass(f, idx)
+		if err != nil {
+			return nil, false, err
+		}
+		arr, err := vm.newArray(f, arrayClassName(component.Name), s.popInt())
+		if err != nil {
+			return nil, false, err
+		}
+		s.push(arr)
+	case OpCodeMultiANewArray:
+		idx, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		dims, err := f.readU1()
+		if err != nil {
+			return nil, false, err
+		}
+		class, err := vm.resolveClass(f, idx)
+		if err != nil {
+			return nil, false, err
+		}
+		counts := make([]int32, dims)
+		for i := int(dims) - 1; i >= 0; i-- {
+			counts[i] = s.popInt()
+		}
+		arr, err := vm.newMultiArray(f, class.Name, counts)
+		if err != nil {
+			return nil, false, err
+		}
+		s.push(arr)
+	case OpCodeArrayLength:
+		arr := s.popRef()
+		if arr == nil {
+			return nil, false, vm.throwNullPointer(f)
+		}
+		s.push(int32(arr.ArrayLength()))
+	case OpCodeCheckCast, OpCodeInstanceOf:
+		idx, err := f.readU2()
+		if err != nil {
+			return nil, false, err
+		}
+		class, err := vm.resolveClass(f, idx)
+		if err != nil {
+			return nil, false, err
+		}
+		v := s.mustPop()
+		if OpCode(op) == OpCodeCheckCast {
+			if err := vm.checkCast(f, v, class); err != nil {
+				return nil, false, err
+			}
+			s.push(v)
+			break
+		}
+		if o, ok := v.(*Object); ok && o != nil && o.Class.IsAssignableTo(class) {
+			s.push(int32(1))
+		} else {
+			s.push(int32(0))
+		}
+	case OpCodeAThrow:
+		ex := s.popRef()
+		if ex == nil {
+			return nil, false, vm.throwNullPointer(f)
+		}
+		return nil, false, &JavaException{Object: ex}
+	case OpCodeMonitorEnter, OpCodeMonitorExit:
+		obj, ok := s.mustPop().(*Object)
+		if !ok || obj == nil {
+			return nil, false, vm.throwNullPointer(f)
+		}
+		var err error
+		if OpCode(op) == OpCodeMonitorEnter {
+			err = vm.monitorEnter(f, obj)
+		} else {
+			err = vm.monitorExit(f, obj)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	default:
+		switch {
+		case OpCode(op) >= OpCodeIconstM1 && OpCode(op) <= OpCodeIconst5:
+			s.push(int32(OpCode(op)) - int32(OpCodeIconst0))
+		case OpCode(op) >= OpCodeIload0 && OpCode(op) <= OpCodeAload3:
+			if err := f.load(int(OpCode(op)-OpCodeIload0) % 4); err != nil {
+				return nil, false, err
+			}
+		case OpCode(op) >= OpCodeIstore0 && OpCode(op) <= OpCodeAstore3:
+			if err := f.store(int(OpCode(op)-OpCodeIstore0) % 4); err != nil {
+				return nil, false, err
+			}
+		default:
+			return nil, false, fmt.Errorf("unsupported opcode 0x%02x", op)
+		}
+	}
+
+	return nil, false, nil
+}
+
+func (vm *VirtualMachine) invoke(f *Frame, op OpCode, ref *MemberRef) error {
+	desc, err := parseMethodDescriptor(ref.Descriptor)
+	if err != nil {
+		return err
+	}
+	n := len(desc.Parameters)
+	if op != OpCodeInvokeStatic {
+		n++
+	}
+	args, ok := f.OperandStack.popN(n)
+	if !ok {
+		return fmt.Errorf("pop arguments of %s: operand stack has %d values, want %d", ref, len(*f.OperandStack), n)
+	}
+	t := vm.currentThread(f)
+	t.hold(args)
+	defer t.release()
+
+	class, err := vm.LoadClass(ref.ClassName)
+	if errors.Is(err, ErrClassNotFound) || (err == nil && class.stub) {
+		// the class library is absent; natives may still stand in for it
+		if native, ok := vm.Natives.Lookup(ref.ClassName, ref.Name, ref.Descriptor); ok {
+			ret, err := vm.callNative(f, nil, ref.ClassName+"."+ref.Name, native, args)
+			if err != nil {
+				return err
+			}
+			if desc.Return != "V" {
+				f.OperandStack.push(ret)
+			}
+			return nil
+		}
+		if err == nil && ref.Name == "<init>" && class.LookupMethod(ref.Name, ref.Descriptor) == nil {
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", ref, err)
+	}
+
+	m, err := vm.resolveMethod(f, class, ref)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case OpCodeInvokeStatic:
+		if !m.IsStatic() {
+			return vm.throwNew(f, "java/lang/IncompatibleClassChangeError", "Expected static method "+m.String())
+		}
+		if err := vm.initializeClass(f, m.Class); err != nil {
+			return err
+		}
+	case OpCodeInvokeVirtual, OpCodeInvokeInterface:
+		if m.IsStatic() {
+			return vm.throwNew(f, "java/lang/IncompatibleClassChangeError", "Expecting non-static method "+m.String())
+		}
+		if isNull(args[0]) {
+			return vm.throwNullPointer(f)
+		}
+		receiver, err := vm.classOf(args[0])
+		if err != nil {
+			return err
+		}
+		if m, err = vm.selectMethod(f, receiver, m); err != nil {
+			return err
+		}
+	case OpCodeInvokeSpecial:
+		if m.IsStatic() {
+			return vm.throwNew(f, "java/lang/IncompatibleClassChangeError", "Expecting non-static method "+m.String())
+		}
+		if isNull(args[0]) {
+			return vm.throwNullPointer(f)
+		}
+		if m, err = vm.selectSpecial(f, class, m); err != nil {
+			return err
+		}
+	}
+
+	ret, err := vm.invokeMethod(f, m, args)
+	if err != nil {
+		return err
+	}
+	if desc.Return != "V" {
+		f.OperandStack.push(ret)
+	}
+	return nil
+}
+
+// invokeMethod runs m with args, the receiver first for instance methods.
+func (vm *VirtualMachine) invokeMethod(caller *Frame, m *RuntimeMethod, args []Value) (Value, error) {
+	t := vm.currentThread(caller)
+	t.hold(args)
+	defer t.release()
+	max := vm.MaxStackDepth
+	if max <= 0 {
+		max = DefaultMaxStackDepth
+	}
+	if caller != nil && caller.Depth+1 >= max {
+		if !t.overflowing {
+			t.overflowing = true
+			err := vm.throwNew(caller, "java/lang/StackOverflowError", "")
+			t.overflowing = false
+			return nil, err
+		}
+	}
+	if m.IsSynchronized() {
+		return vm.invokeSynchronized(caller, m, args)
+	}
+	return vm.invokeUnsynchronized(caller, m, args)
+}
+
+func (vm *VirtualMachine) invokeUnsynchronized(caller *Frame, m *RuntimeMethod, args []Value) (Value, error) {
+	if native, ok := vm.intrinsic(m); ok {
+		return vm.callNative(caller, m, m.String(), native, args)
+	}
+	if m.IsNative() {
+		native, ok := m.native, m.native != nil
+		if !ok {
+			native, ok = vm.Natives.Lookup(m.Class.Name, m.Name, m.Descriptor)
+		}
+		if !ok {
+			return nil, vm.throwNew(caller, "java/lang/UnsatisfiedLinkError", m.String())
+		}
+		return vm.callNative(caller, m, m.String(), native, args)
+	}
+	if m.IsAbstract() {
+		return nil, vm.throwNew(caller, "java/lang/AbstractMethodError", m.String())
+	}
+
+	frame, err := newFrame(vm, caller, m, args)
+	if err != nil {
+		return nil, fmt.Errorf("create frame of %s: %w", m, err)
+	}
+	return vm.executeCode(frame)
+}
+
+func (vm *VirtualMachine) callNative(caller *Frame, m *RuntimeMethod, name string, native NativeMethod, args []Value) (ret Value, err error) {
+	// the arguments stay in the locals, where the collector finds them
+	frame := &Frame{VM: vm, Caller: caller, Method: m, Locals: args, OperandStack: &OperandStack{}}
+	if m != nil {
+		frame.Class = m.Class
+	}
+	if caller != nil {
+		frame.Depth = caller.Depth + 1
+		frame.thread = caller.thread
+	}
+	t := vm.currentThread(frame)
+	top := t.top
+	t.top = frame
+	defer func() {
+		t.top = top
+		if r := recover(); r != nil {
+			// unverified bytecode can pass arguments of the wrong types
+			ret, err = nil, vm.throwNew(caller, "java/lang/InternalError", fmt.Sprintf("native %s: %v", name, r))
+		}
+	}()
+	ret, err = native(frame, args)
+	if err != nil {
+		var ex *JavaException
+		if errors.As(err, &ex) {
+			return nil, ex
+		}
+		return nil, fmt.Errorf("native %s: %w", name, err)
+	}
+	return ret, nil
+}
+
+func (vm *VirtualMachine) resolveClass(f *Frame, idx uint16) (*RuntimeClass, error) {
+	name, err := f.Class.File.ClassName(idx)
+	if err != nil {
+		return nil, err
+	}
+	class, err := vm.classOrStub(name)
+	if err != nil {
+		return nil, fmt.Errorf("resolve class %s: %w", name, err)
+	}
+	return class, nil
+}
+
+func (vm *VirtualMachine) resolveField(f *Frame, idx uint16, static bool) (*RuntimeField, error) {
+	ref, err := f.Class.File.GetMemberRef(idx)
+	if err != nil {
+		return nil, err
+	}
+	class, err := vm.LoadClass(ref.ClassName)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", ref, err)
+	}
+	field := class.LookupField(ref.Name, ref.Descriptor)
+	if field == nil {
+		return nil, vm.throwNew(f, "java/lang/NoSuchFieldError", ref.Name)
+	}
+	if field.IsStatic() != static {
+		return nil, vm.throwNew(f, "java/lang/IncompatibleClassChangeError", "Expected static field "+field.String())
+	}
+	return field, nil
+}
+
+func (vm *VirtualMachine) accessStatic(f *Frame, idx uint16, put bool) error {
+	field, err := vm.resolveField(f, idx, true)
+	if err != nil {
+		return err
+	}
+	if err := vm.initializeClass(f, field.Class); err != nil {
+		return err
+	}
+
+	values := field.Class.StaticValues
+	if put {
+		storeSlot(values, field.Slot, narrow(field.Descriptor, f.OperandStack.mustPop()))
+		return nil
+	}
+	f.OperandStack.push(loadSlot(values, field.Slot))
+	return nil
+}
+
+func (vm *VirtualMachine) accessField(f *Frame, idx uint16, put bool) error {
+	field, err := vm.resolveField(f, idx, false)
+	if err != nil {
+		return err
+	}
+	s := f.OperandStack
+	if put {
+		v := s.mustPop()
+		obj := s.popRef()
+		if obj == nil {
+			return vm.throwNullPointer(f)
+		}
+		storeSlot(obj.Fields, field.Slot, narrow(field.Descriptor, v))
+		return nil
+	}
+	obj := s.popRef()
+	if obj == nil {
+		return vm.throwNullPointer(f)
+	}
+	s.push(loadSlot(obj.Fields, field.Slot))
+	return nil
+}
+
+// classOf returns the class of a reference value.
+func (vm *VirtualMachine) classOf(v Value) (*RuntimeClass, error) {
+	switch o := v.(type) {
+	case *Object:
+		return o.Class, nil
+	}
+	return nil, fmt.Errorf("not a reference: %v", v)
+}
+
+func (vm *VirtualMachine) newArray(f *Frame, className string, length int32) (*Object, error) {
+	if length < 0 {
+		return nil, vm.throwNew(f, "java/lang/NegativeArraySizeException", fmt.Sprint(length))
+	}
+	if max := vm.MaxArrayLength; max > 0 && length > max {
+		return nil, vm.throwNew(f, "java/lang/OutOfMemoryError", "Requested array size exceeds VM limit")
+	}
+	class, err := vm.LoadClass(className)
+	if err != nil {
+		return nil, err
+	}
+	arr := NewArray(class, newArrayStorage(className[1:], int(length)))
+	if err := vm.allocate(f, arr); err != nil {
+		return nil, err
+	}
+	return arr, nil
+}
+
+func (vm *VirtualMachine) newMultiArray(f *Frame, className string, counts []int32) (*Object, error) {
+	// every count is checked before anything is allocated
+	for _, n := range counts {
+		if n < 0 {
+			return nil, vm.throwNew(f, "java/lang/NegativeArraySizeException", fmt.Sprint(n))
+		}
+	}
+	return vm.newArrays(f, className, counts)
+}
+
+func (vm *VirtualMachine) newArrays(f *Frame, className string, counts []int32) (*Object, error) {
+	arr, err := vm.newArray(f, className, counts[0])
+	if err != nil || len(counts) == 1 {
+		return arr, err
+	}
+	// only Go holds the array until it is done
+	t := vm.currentThread(f)
+	t.hold([]Value{arr})
+	defer t.release()
+	elems := arr.Array.([]Value)
+	for i := range elems {
+		sub, err := vm.newArrays(f, className[1:], counts[1:])
+		if err != nil {
+			return nil, err
+		}
+		elems[i] = sub
+	}
+	return arr, nil
+}
+
+func (vm *VirtualMachine) arrayLoad(f *Frame, arr *Object, idx int32) (Value, error) {
+	if idx < 0 || int(idx) >= arr.ArrayLength() {
+		return nil, vm.throwNew(f, "java/lang/ArrayIndexOutOfBoundsException",
+			fmt.Sprintf("Index %d out of bounds for length %d", idx, arr.ArrayLength()))
+	}
+	switch a := arr.Array.(type) {
+	case []int8:
+		return int32(a[idx]), nil
+	case []uint16:
+		return int32(a[idx]), nil
+	case []int16:
+		return int32(a[idx]), nil
+	case []int32:
+		return a[idx], nil
+	case []int64:
+		return a[idx], nil
+	case []float32:
+		return a[idx], nil
+	case []float64:
+		return a[idx], nil
+	case []Value:
+		return a[idx], nil
+	}
+	return nil, fmt.Errorf("not an array: %s", arr.ClassName())
+}
+
+func (vm *VirtualMachine) arrayStore(f *Frame, arr *Object, idx int32, v Value) error {
+	if idx < 0 || int(idx) >= arr.ArrayLength() {
+		return vm.throwNew(f, "java/lang/ArrayIndexOutOfBoundsException",
+			fmt.Sprintf("Index %d out of bounds for length %d", idx, arr.ArrayLength()))
+	}
+	var ok bool
+	switch a := arr.Array.(type) {
+	case []int8:
+		var i int32
+		if i, ok = v.(int32); ok {
+			if arr.ClassName() == "[Z" {
+				i &= 1
+			}
+			a[idx] = int8(i)
+		}
+	case []uint16:
+		var i int32
+		if i, ok = v.(int32); ok {
+			a[idx] = uint16(i)
+		}
+	case []int16:
+		var i int32
+		if i, ok = v.(int32); ok {
+			a[idx] = int16(i)
+		}
+	case []int32:
+		a[idx], ok = v.(int32)
+	case []int64:
+		a[idx], ok = v.(int64)
+	case []float32:
+		a[idx], ok = v.(float32)
+	case []float64:
+		a[idx], ok = v.(float64)
+	case []Value:
+		if o, isObj := v.(*Object); isObj && o != nil && arr.Class.component != nil && !o.Class.IsAssignableTo(arr.Class.component) {
+			return vm.throwNew(f, "java/lang/ArrayStoreException", javaClassName(o.ClassName()))
+		}
+		a[idx], ok = v, true
+	}
+	if !ok {
+		return fmt.Errorf("cannot store %T into %s", v, arr.ClassName())
+	}
+	return nil
+}
+
+func (f *Frame) iinc(idx int, c int32) error {
+	if idx >= len(f.Locals) {
+		return fmt.Errorf("local variable index %d out of range", idx)
+	}
+	i, ok := f.Locals[idx].(int32)
+	if !ok {
+		return fmt.Errorf("iinc on non-int local %d: %T", idx, f.Locals[idx])
+	}
+	f.Locals[idx] = i + c
+	return nil
+}
+
+// ret continues at the returnAddress in local idx.
+func (f *Frame) ret(idx int) error {
+	if idx >= len(f.Locals) {
+		return fmt.Errorf("local variable index %d out of range", idx)
+	}
+	addr, ok := f.Locals[idx].(returnAddress)
+	if !ok {
+		return fmt.Errorf("ret on non-returnAddress local %d: %T", idx, f.Locals[idx])
+	}
+	f.PC = int(addr)
+	return nil
+}
+
+// executeWide runs the instruction following a wide prefix with a 16-bit
+// local variable index, and a 16-bit constant for iinc.
+func (f *Frame) executeWide() error {
+	op, err := f.readU1()
+	if err != nil {
+		return fmt.Errorf("execute wide: %w", err)
+	}
+	idx, err := f.readU2()
+	if err != nil {
+		return fmt.Errorf("execute wide: %w", err)
+	}
+	switch OpCode(op) {
+	case OpCodeIload, OpCodeLload, OpCodeFload, OpCodeDload, OpCodeAload:
+		return f.load(int(idx))
+	case OpCodeIstore, OpCodeLstore, OpCodeFstore, OpCodeDstore, OpCodeAstore:
+		return f.store(int(idx))
+	case OpCodeRet:
+		return f.ret(int(idx))
+	case OpCodeIinc:
+		c, err := f.readU2()
+		if err != nil {
+			return fmt.Errorf("execute wide: %w", err)
+		}
+		return f.iinc(int(idx), int32(int16(c)))
+	}
+	return fmt.Errorf("wide cannot modify opcode 0x%02x", op)
+}
+
+func (s *OperandStack) mustPop() Value {
+	v, ok := s.pop()
+	if !ok {
+		panic(stackError{"operand stack underflow"})
+	}
+	return v
+}
+
+func (s *OperandStack) popInt() int32 {
+	v := s.mustPop()
+	i, ok := v.(int32)
+	if !ok {
+		panic(stackError{fmt.Sprintf("expected int on operand stack, got %T", v)})
+	}
+	return i
+}
+
+func (s *OperandStack) popLong() int64 {
+	v := s.mustPop()
+	i, ok := v.(int64)
+	if !ok {
+		panic(stackError{fmt.Sprintf("expected long on operand stack, got %T", v)})
+	}
+	return i
+}
+
+func (s *OperandStack) popFloat() float32 {
+	v := s.mustPop()
+	x, ok := v.(float32)
+	if !ok {
+		panic(stackError{fmt.Sprintf("expected float on operand stack, got %T", v)})
+	}
+	return x
+}
+
+func (s *OperandStack) popDouble() float64 {
+	v := s.mustPop()
+	x, ok := v.(float64)
+	if !ok {
+		panic(stackError{fmt.Sprintf("expected double on operand stack, got %T", v)})
+	}
+	return x
+}
+
+func (s *OperandStack) popRef() *Object {
+	v := s.mustPop()
+	if v == nil {
+		return nil
+	}
+	o, ok := v.(*Object)
+	if !ok {
+		panic(stackError{fmt.Sprintf("expected reference on operand stack, got %T", v)})
+	}
+	return o
+}
+
+func isNull(v Value) bool {
+	if v == nil {
+		return true
+	}
+	o, ok := v.(*Object)
+	return ok && o == nil
+}
+
+func sameReference(a, b Value) bool {
+	if isNull(a) || isNull(b) {
+		return isNull(a) && isNull(b)
+	}
+	return a == b
+}
+
+func compareInt(cond OpCode, a, b int32) bool {
+	switch cond {
+	case 0:
+		return a == b
+	case 1:
+		return a != b
+	case 2:
+		return a < b
+	case 3:
+		return a >= b
+	case 4:
+		return a > b
+	}
+	return a <= b
+}
+
+func floatCompare(a, b float64, nanIsGreater bool) int32 {
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		if nanIsGreater {
+			return 1
+		}
+		return -1
+	case a > b:
+		return 1
+	case a < b:
+		return -1
+	}
+	return 0
+}
+
+func f2i(v float64) int32 {
+	switch {
+	case math.IsNaN(v):
+		return 0
+	case v >= math.MaxInt32:
+		return math.MaxInt32
+	case v <= math.MinInt32:
+		return math.MinInt32
+	}
+	return int32(v)
+}
+
+func f2l(v float64) int64 {
+	switch {
+	case math.IsNaN(v):
+		return 0
+	case v >= math.MaxInt64:
+		return math.MaxInt64
+	case v <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int64(v)
+}
+
+// narrow truncates an int to the width of a boolean, byte, char or short field.
+func narrow(desc string, v Value) Value {
+	i, ok := v.(int32)
+	if !ok {
+		return v
+	}
+	switch desc {
+	case "Z":
+		return i & 1
+	case "B":
+		return int32(int8(i))
+	case "C":
+		return int32(uint16(i))
+	case "S":
+		return int32(int16(i))
+	}
+	return v
+}
+
+func arrayClassName(component string) string {
+	if component[0] == '[' {
+		return "[" + component
+	}
+	return "[L" + component + ";"
+}
@@ -0,0 +1,106 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachine_ExecMain_SubroutinesAndWide(t *testing.T) {
+	wide := func(op OpCode, idx uint16, operands ...byte) []byte {
+		return append([]byte{byte(op), hi(idx), lo(idx)}, operands...)
+	}
+	tests := []struct {
+		name string
+		code *asm
+		want string
+	}{
+		{
+			// try { return n; } finally { n += 10; }
+			name: "jsr",
+			code: newAsm().branch(OpCodeJsr, "sub").op(OpCodeIload0).op(OpCodeIreturn).
+				label("sub").op(OpCodeAstore, 1).op(OpCodeIinc, 0, 10).op(OpCodeRet, 1),
+			want: "15\n",
+		},
+		{
+			name: "jsr_w",
+			code: newAsm().branch(OpCodeJsrW, "sub").op(OpCodeIload0).op(OpCodeIreturn).
+				label("sub").op(OpCodeAstore, 1).op(OpCodeIinc, 0, 10).op(OpCodeRet, 1),
+			want: "15\n",
+		},
+		{
+			name: "wide load, store and iinc",
+			code: newAsm().op(OpCodeIload0).op(OpCodeWide, wide(OpCodeIstore, 300)...).
+				op(OpCodeWide, wide(OpCodeIinc, 300, hi(1000), lo(1000))...).
+				op(OpCodeWide, wide(OpCodeIload, 300)...).op(OpCodeIreturn),
+			want: "1005\n",
+		},
+		{
+			name: "wide ret",
+			code: newAsm().branch(OpCodeJsr, "sub").op(OpCodeIload0).op(OpCodeIreturn).
+				label("sub").op(OpCodeWide, wide(OpCodeAstore, 300)...).op(OpCodeIinc, 0, 1).
+				op(OpCodeWide, wide(OpCodeRet, 300)...),
+			want: "6\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// subroutines are only allowed before version 50
+			b := newClassBuilder("Main", "java/lang/Object")
+			b.class.MajorVersion = []byte{0, 49}
+			b.method(AccStatic, "f", "(I)I", 2, 301, tt.code.bytes()...)
+			b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 1, newAsm().
+				ref(OpCodeGetStatic, b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")).
+				op(OpCodeIconst5).ref(OpCodeInvokeStatic, b.methodRef("Main", "f", "(I)I")).
+				ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(I)V")).
+				op(OpCodeReturn).bytes()...)
+
+			vm := NewVM(b.build())
+			vm.Verify = true
+			var stdout bytes.Buffer
+			vm.Out = &stdout
+			require.NoError(t, vm.ExecMain())
+			require.Equal(t, tt.want, stdout.String())
+		})
+	}
+}
+
+func TestVirtualMachine_ExecMain_Constants(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	big, half, seven := b.long(1<<40), b.double(2.5), b.long(7)
+	wideInt := b.integer(100000)
+	printTop := func(a *asm, desc string) *asm {
+		return a.ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", desc))
+	}
+	a := newAsm()
+	printTop(a.ref(OpCodeGetStatic, out).op(OpCodeLconst1).ref(OpCodeLdc2W, big).op(OpCodeLadd), "(J)V")
+	printTop(a.ref(OpCodeGetStatic, out).op(OpCodeDconst1).ref(OpCodeLdc2W, half).op(OpCodeDadd), "(D)V")
+	printTop(a.ref(OpCodeGetStatic, out).op(OpCodeFconst2), "(F)V")
+	printTop(a.ref(OpCodeGetStatic, out).ref(OpCodeLdcW, wideInt), "(I)V")
+	// dup2 of a long, then of two ints
+	printTop(a.ref(OpCodeGetStatic, out).ref(OpCodeLdc2W, seven).op(OpCodeDup2).op(OpCodeLadd), "(J)V")
+	printTop(a.ref(OpCodeGetStatic, out).op(OpCodeIconst0+1).op(OpCodeIconst0+2).op(OpCodeDup2).
+		op(OpCodeIadd).op(OpCodeIadd).op(OpCodeIadd), "(I)V")
+	// dup_x2 of an int over a long, dup2_x1 of a long over an int
+	printTop(a.ref(OpCodeGetStatic, out).op(OpCodeLconst1).op(OpCodeIconst5).op(OpCodeDupX2).
+		op(OpCodePop).op(OpCodePop2), "(I)V")
+	printTop(a.ref(OpCodeGetStatic, out).op(OpCodeIconst0+3).ref(OpCodeLdc2W, seven).op(OpCodeDup2X1).
+		op(OpCodePop2).op(OpCodePop), "(J)V")
+	// a long in locals 1 and 2 next to an int in local 3
+	a.ref(OpCodeLdc2W, seven).op(OpCodeLstore0+1).op(OpCodeIconst0+4).op(OpCodeIstore, 3)
+	printTop(a.ref(OpCodeGetStatic, out).op(OpCodeLload0+1), "(J)V")
+	printTop(a.ref(OpCodeGetStatic, out).op(OpCodeIload, 3), "(I)V")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 6, 4, a.op(OpCodeReturn).bytes()...)
+
+	// the decoder has to skip the unusable entry after each long and double
+	class, err := DecodeClassStructure(bytes.NewReader(encodeClass(b.build())))
+	require.NoError(t, err)
+	vm := NewVM(class)
+	vm.Verify = true
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "1099511627777\n3.5\n2.0\n100000\n14\n6\n5\n7\n7\n4\n", stdout.String())
+}
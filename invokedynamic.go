@@ -0,0 +1,231 @@
+package jvmgo
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// callSite is a linked invokedynamic instruction.
+	callSite struct {
+		desc   *MethodDescriptor
+		target NativeMethod
+	}
+
+	// dynamicCallSite describes an invokedynamic instruction to the linker of
+	// its bootstrap method.
+	dynamicCallSite struct {
+		Class     *RuntimeClass
+		Name      string
+		Desc      *MethodDescriptor
+		Bootstrap *MethodHandleRef
+		Arguments []uint16
+	}
+
+	// bootstrapLinker does the work of a bootstrap method in Go and returns
+	// the target of the call site.
+	bootstrapLinker func(caller *Frame, site *dynamicCallSite) (NativeMethod, error)
+)
+
+func bootstrapLinkerFor(h *MethodHandleRef) bootstrapLinker {
+	switch h.Ref.ClassName + "." + h.Ref.Name {
+	case "java/lang/invoke/StringConcatFactory.makeConcatWithConstants",
+		"java/lang/invoke/StringConcatFactory.makeConcat":
+		return linkStringConcat
+	case "java/lang/invoke/LambdaMetafactory.metafactory",
+		"java/lang/invoke/LambdaMetafactory.altMetafactory":
+		return linkLambda
+	}
+	return nil
+}
+
+// invokeDynamic executes the invokedynamic instruction at pc, linking it the
+// first time it runs. Every instruction is a call site of its own.
+func (vm *VirtualMachine) invokeDynamic(f *Frame, pc int, idx uint16) error {
+	site, err := vm.callSite(f, pc, idx)
+	if err != nil {
+		return err
+	}
+	args, ok := f.OperandStack.popN(len(site.desc.Parameters))
+	if !ok {
+		return fmt.Errorf("pop arguments of invokedynamic #%d: operand stack has %d values, want %d", idx, len(*f.OperandStack), len(site.desc.Parameters))
+	}
+	ret, err := vm.callNative(f, nil, fmt.Sprintf("invokedynamic #%d", idx), site.target, args)
+	if err != nil {
+		return err
+	}
+	if site.desc.Return != "V" {
+		f.OperandStack.push(ret)
+	}
+	return nil
+}
+
+func (vm *VirtualMachine) callSite(f *Frame, pc int, idx uint16) (*callSite, error) {
+	m := f.Method
+	m.callSitesMu.Lock()
+	site, ok := m.callSites[pc]
+	m.callSitesMu.Unlock()
+	if ok {
+		return site, nil
+	}
+
+	site, err := vm.linkCallSite(f, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	m.callSitesMu.Lock()
+	defer m.callSitesMu.Unlock()
+	if existing, ok := m.callSites[pc]; ok {
+		// another thread linked it first; every thread sees the same target
+		return existing, nil
+	}
+	if m.callSites == nil {
+		m.callSites = map[int]*callSite{}
+	}
+	m.callSites[pc] = site
+	return site, nil
+}
+
+func (vm *VirtualMachine) linkCallSite(f *Frame, idx uint16) (*callSite, error) {
+	file := f.Class.File
+	bsmIndex, name, descriptor, err := file.GetInvokeDynamic(idx)
+	if err != nil {
+		return nil, fmt.Errorf("resolve invokedynamic #%d: %w", idx, err)
+	}
+	desc, err := parseMethodDescriptor(descriptor)
+	if err != nil {
+		return nil, fmt.Errorf("resolve invokedynamic #%d: %w", idx, err)
+	}
+	methods, err := file.BootstrapMethods()
+	if err != nil {
+		return nil, fmt.Errorf("resolve invokedynamic #%d: %w", idx, err)
+	}
+	if int(bsmIndex) >= len(methods) {
+		return nil, vm.throwNew(f, "java/lang/BootstrapMethodError", fmt.Sprintf("bootstrap method index %d out of range", bsmIndex))
+	}
+	bsm := methods[bsmIndex]
+	handle, err := file.GetMethodHandle(bsm.MethodRef)
+	if err != nil {
+		return nil, fmt.Errorf("resolve bootstrap method of invokedynamic #%d: %w", idx, err)
+	}
+	link := bootstrapLinkerFor(handle)
+	if link == nil {
+		return nil, vm.throwNew(f, "java/lang/BootstrapMethodError", "unsupported bootstrap method "+handle.Ref.String())
+	}
+
+	target, err := link(f, &dynamicCallSite{
+		Class:     f.Class,
+		Name:      name,
+		Desc:      desc,
+		Bootstrap: handle,
+		Arguments: bsm.Arguments,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &callSite{desc: desc, target: target}, nil
+}
+
+// linkStringConcat implements StringConcatFactory. In a recipe \1 stands for
+// the next argument and \2 for the next constant.
+func linkStringConcat(caller *Frame, site *dynamicCallSite) (NativeMethod, error) {
+	vm := caller.VM
+	params := site.Desc.Parameters
+	if site.Desc.Return != "Ljava/lang/String;" {
+		return nil, vm.throwNew(caller, "java/lang/BootstrapMethodError", "string concatenation must return String: "+site.Name)
+	}
+
+	recipe := strings.Repeat("\x01", len(params))
+	var constants []string
+	if site.Bootstrap.Ref.Name == "makeConcatWithConstants" {
+		if len(site.Arguments) == 0 {
+			return nil, vm.throwNew(caller, "java/lang/BootstrapMethodError", "makeConcatWithConstants without a recipe")
+		}
+		for i, idx := range site.Arguments {
+			v, err := vm.loadConstant(site.Class, idx)
+			if err != nil {
+				return nil, fmt.Errorf("load argument of makeConcatWithConstants: %w", err)
+			}
+			s, err := vm.javaString(caller, constantDescriptor(v), v)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				recipe = s
+			} else {
+				constants = append(constants, s)
+			}
+		}
+	}
+
+	type part struct {
+		literal string
+		arg     int
+	}
+	var parts []part
+	var literal []uint16
+	flush := func() {
+		if len(literal) > 0 {
+			parts = append(parts, part{literal: stringFromChars(literal), arg: -1})
+			literal = literal[:0]
+		}
+	}
+	nextArg, nextConst := 0, 0
+	// the recipe is Java text, which may hold lone surrogates
+	for _, c := range javaChars(recipe) {
+		switch c {
+		case '\x01':
+			if nextArg >= len(params) {
+				return nil, vm.throwNew(caller, "java/lang/BootstrapMethodError", fmt.Sprintf("mismatched number of concat arguments: recipe wants more than %d, %q", len(params), recipe))
+			}
+			flush()
+			parts = append(parts, part{arg: nextArg})
+			nextArg++
+		case '\x02':
+			if nextConst >= len(constants) {
+				return nil, vm.throwNew(caller, "java/lang/BootstrapMethodError", fmt.Sprintf("mismatched number of concat constants: recipe wants more than %d, %q", len(constants), recipe))
+			}
+			literal = append(literal, javaChars(constants[nextConst])...)
+			nextConst++
+		default:
+			literal = append(literal, c)
+		}
+	}
+	flush()
+	if nextArg != len(params) {
+		return nil, vm.throwNew(caller, "java/lang/BootstrapMethodError", fmt.Sprintf("mismatched number of concat arguments: recipe wants %d, but signature provides %d", nextArg, len(params)))
+	}
+
+	return func(frame *Frame, args []Value) (Value, error) {
+		var b strings.Builder
+		for _, p := range parts {
+			if p.arg < 0 {
+				b.WriteString(p.literal)
+				continue
+			}
+			s, err := frame.VM.javaString(frame, params[p.arg], args[p.arg])
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(s)
+		}
+		return frame.VM.NewString(b.String()), nil
+	}, nil
+}
+
+// constantDescriptor returns the type of a value loaded from the constant
+// pool.
+func constantDescriptor(v Value) string {
+	switch v.(type) {
+	case int32:
+		return "I"
+	case int64:
+		return "J"
+	case float32:
+		return "F"
+	case float64:
+		return "D"
+	}
+	return "Ljava/lang/Object;"
+}
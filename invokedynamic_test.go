@@ -0,0 +1,122 @@
+package jvmgo
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassStructure_BootstrapMethods(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	bsm := b.methodHandle(RefInvokeStatic, b.methodRef("java/lang/invoke/StringConcatFactory", "makeConcatWithConstants",
+		"(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/String;[Ljava/lang/Object;)Ljava/lang/invoke/CallSite;"))
+	recipe := b.str("\x01!")
+	first := b.invokeDynamic(bsm, []uint16{recipe}, "makeConcatWithConstants", "(I)Ljava/lang/String;")
+	second := b.invokeDynamic(bsm, nil, "makeConcat", "()Ljava/lang/String;")
+
+	c, err := DecodeClassStructure(bytes.NewReader(encodeClass(b.build())))
+	require.NoError(t, err)
+	methods, err := c.BootstrapMethods()
+	require.NoError(t, err)
+	require.Equal(t, []*BootstrapMethod{
+		{MethodRef: bsm, Arguments: []uint16{recipe}},
+		{MethodRef: bsm, Arguments: []uint16{}},
+	}, methods)
+
+	index, name, desc, err := c.GetInvokeDynamic(second)
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), index)
+	require.Equal(t, "makeConcat", name)
+	require.Equal(t, "()Ljava/lang/String;", desc)
+
+	handle, err := c.GetMethodHandle(bsm)
+	require.NoError(t, err)
+	require.Equal(t, RefInvokeStatic, handle.Kind)
+	require.Equal(t, "makeConcatWithConstants", handle.Ref.Name)
+
+	_, _, _, err = c.GetInvokeDynamic(first - 1)
+	require.Error(t, err)
+}
+
+func TestVirtualMachine_ExecMain_StringConcat(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printStr := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+	const factory = "java/lang/invoke/StringConcatFactory"
+	withConstants := b.methodHandle(RefInvokeStatic, b.methodRef(factory, "makeConcatWithConstants",
+		"(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/String;[Ljava/lang/Object;)Ljava/lang/invoke/CallSite;"))
+	plain := b.methodHandle(RefInvokeStatic, b.methodRef(factory, "makeConcat",
+		"(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite;"))
+	mixed := b.invokeDynamic(withConstants, []uint16{b.str("i=\x01 z=\x01 s=\x01 c=\x01 \x02\x02"), b.str("\x01"), b.integer(7)},
+		"makeConcatWithConstants", "(IZLjava/lang/String;C)Ljava/lang/String;")
+	objects := b.invokeDynamic(plain, nil, "makeConcat", "(Ljava/lang/Object;Ljava/lang/String;)Ljava/lang/String;")
+	arrayList := b.classRef("java/util/ArrayList")
+	arrayListInit := b.methodRef("java/util/ArrayList", "<init>", "()V")
+	tail := b.str("|")
+
+	code := newAsm().
+		op(OpCodeIconst0).op(OpCodeIstore0+1).
+		label("loop").
+		op(OpCodeIload0+1).op(OpCodeIconst0+2).branch(OpCodeIfIcmpge, "done").
+		ref(OpCodeGetStatic, out).
+		op(OpCodeIload0+1).op(OpCodeIload0+1).op(OpCodeAconstNull).op(OpCodeBipush, 'x').
+		op(OpCodeInvokeDynamic, hi(mixed), lo(mixed), 0, 0).
+		ref(OpCodeInvokeVirtual, printStr).
+		op(OpCodeIinc, 1, 1).
+		branch(OpCodeGoto, "loop").
+		label("done").
+		ref(OpCodeGetStatic, out).
+		ref(OpCodeNew, arrayList).op(OpCodeDup).ref(OpCodeInvokeSpecial, arrayListInit).
+		op(OpCodeLdc, lo(tail)).
+		op(OpCodeInvokeDynamic, hi(objects), lo(objects), 0, 0).
+		ref(OpCodeInvokeVirtual, printStr).
+		op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 6, 2, code.bytes()...)
+
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "i=0 z=false s=null c=x \x017\ni=1 z=true s=null c=x \x017\n[]|\n", stdout.String())
+
+	main, err := vm.LoadClass("Main")
+	require.NoError(t, err)
+	require.Len(t, main.DeclaredMethod("main", "([Ljava/lang/String;)V").callSites, 2)
+}
+
+func TestVirtualMachine_ExecMain_StringConcatMismatch(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	bsm := b.methodHandle(RefInvokeStatic, b.methodRef("java/lang/invoke/StringConcatFactory", "makeConcatWithConstants",
+		"(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/String;[Ljava/lang/Object;)Ljava/lang/invoke/CallSite;"))
+	indy := b.invokeDynamic(bsm, []uint16{b.str("\x01\x01")}, "makeConcatWithConstants", "(I)Ljava/lang/String;")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 1, 1, newAsm().
+		op(OpCodeIconst0).op(OpCodeInvokeDynamic, hi(indy), lo(indy), 0, 0).op(OpCodePop).op(OpCodeReturn).bytes()...)
+
+	err := NewVM(b.build()).ExecMain()
+	var ex *JavaException
+	require.True(t, errors.As(err, &ex))
+	require.Equal(t, "java/lang/BootstrapMethodError", ex.Object.ClassName())
+}
+
+func TestVirtualMachine_LinkStringConcat_LoneSurrogate(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	withConstants := b.methodHandle(RefInvokeStatic, b.methodRef("java/lang/invoke/StringConcatFactory", "makeConcatWithConstants",
+		"(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/String;[Ljava/lang/Object;)Ljava/lang/invoke/CallSite;"))
+	// "\1\uD800\2" with the constant "\uDC00"
+	recipe := stringFromChars([]uint16{1, 0xD800, 2})
+	indy := b.invokeDynamic(withConstants, []uint16{b.str(recipe), b.str(stringFromChars([]uint16{0xDC00}))},
+		"makeConcatWithConstants", "(Ljava/lang/String;)Ljava/lang/String;")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 1, 1, byte(OpCodeReturn))
+
+	vm := NewVM(b.build())
+	class, err := vm.DefineClass(b.build())
+	require.NoError(t, err)
+	frame := &Frame{VM: vm, Class: class, Method: class.DeclaredMethod("main", "([Ljava/lang/String;)V"), OperandStack: &OperandStack{}}
+	site, err := vm.callSite(frame, 0, indy)
+	require.NoError(t, err)
+	s, err := site.target(frame, []Value{vm.NewString("a")})
+	require.NoError(t, err)
+	require.Equal(t, []uint16{'a', 0xD800, 0xDC00}, javaChars(javaStringValue(s)))
+}
@@ -0,0 +1,97 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachine_ExecMain_InvokeSpecial(t *testing.T) {
+	cp := mapClassPath{}
+	class := func(name, super string) *classBuilder {
+		b := newClassBuilder(name, super)
+		superInit := b.methodRef(super, "<init>", "()V")
+		b.method(AccPublic, "<init>", "()V", 1, 1, newAsm().
+			op(OpCodeAload0).ref(OpCodeInvokeSpecial, superInit).op(OpCodeReturn).bytes()...)
+		return b
+	}
+	constant := func(b *classBuilder, flags uint16, name string, v byte) {
+		b.method(flags, name, "()I", 1, 1, byte(OpCodeIconst0+OpCode(v)), byte(OpCodeIreturn))
+	}
+	base := class("Base", "java/lang/Object")
+	constant(base, AccPublic, "who", 1)
+	cp.add(base.build())
+	mid := class("Mid", "Base")
+	constant(mid, AccPublic, "who", 2)
+	cp.add(mid.build())
+
+	// Outer and Outer$Inner are nestmates; Stranger is not
+	outer := class("Outer", "java/lang/Object")
+	constant(outer, AccPrivate|AccStatic, "hidden", 5)
+	outer.attribute("NestMembers", append(u2(1), u2(outer.classRef("Outer$Inner"))...))
+	cp.add(outer.build())
+	inner := class("Outer$Inner", "java/lang/Object")
+	inner.attribute("NestHost", u2(inner.classRef("Outer")))
+	hidden := inner.methodRef("Outer", "hidden", "()I")
+	inner.method(AccStatic, "peek", "()I", 1, 0, newAsm().ref(OpCodeInvokeStatic, hidden).op(OpCodeIreturn).bytes()...)
+	cp.add(inner.build())
+	stranger := class("Stranger", "java/lang/Object")
+	stranger.attribute("NestHost", u2(stranger.classRef("Outer")))
+	hidden = stranger.methodRef("Outer", "hidden", "()I")
+	stranger.method(AccStatic, "peek", "()I", 1, 0, newAsm().ref(OpCodeInvokeStatic, hidden).op(OpCodeIreturn).bytes()...)
+	cp.add(stranger.build())
+
+	// class Main extends Mid { int who() { return 3; } }
+	b := newClassBuilder("Main", "Mid")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printInt := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	midInit := b.methodRef("Mid", "<init>", "()V")
+	b.method(AccPublic, "<init>", "()V", 1, 1, newAsm().
+		op(OpCodeAload0).ref(OpCodeInvokeSpecial, midInit).op(OpCodeReturn).bytes()...)
+	constant(b, AccPublic, "who", 3)
+	constant(b, AccPrivate, "secret", 4)
+	mainClass := b.classRef("Main")
+	mainInit := b.methodRef("Main", "<init>", "()V")
+	baseWho := b.methodRef("Base", "who", "()I")
+	mainWho := b.methodRef("Main", "who", "()I")
+	secret := b.methodRef("Main", "secret", "()I")
+	innerPeek := b.methodRef("Outer$Inner", "peek", "()I")
+	strangerPeek := b.methodRef("Stranger", "peek", "()I")
+	print := func(a *asm) *asm {
+		return a.ref(OpCodeInvokeVirtual, printInt).ref(OpCodeGetStatic, out)
+	}
+	code := newAsm().
+		ref(OpCodeNew, mainClass).op(OpCodeDup).ref(OpCodeInvokeSpecial, mainInit).op(OpCodeAstore0+1).
+		ref(OpCodeGetStatic, out)
+	// super.who() written against Base still runs the override in Mid
+	print(code.op(OpCodeAload0+1).ref(OpCodeInvokeSpecial, baseWho))
+	print(code.op(OpCodeAload0+1).ref(OpCodeInvokeSpecial, mainWho))
+	print(code.op(OpCodeAload0+1).ref(OpCodeInvokeSpecial, secret))
+	print(code.ref(OpCodeInvokeStatic, innerPeek))
+	code.ref(OpCodeInvokeStatic, strangerPeek).op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 3, 2, code.bytes()...)
+
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	err := vm.ExecMain()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "java/lang/IllegalAccessError: class Stranger tried to access private method 'int Outer.hidden()'")
+	require.Equal(t, "2\n3\n4\n5\n", stdout.String())
+}
+
+func TestVirtualMachine_ResolveMethod_ConstructorsAreNotInherited(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.method(AccPublic, "<init>", "()V", 0, 1, byte(OpCodeReturn))
+	vm := NewVM(b.build())
+	main, err := vm.DefineClass(b.build())
+	require.NoError(t, err)
+	frame := &Frame{VM: vm, Class: main, OperandStack: &OperandStack{}}
+
+	sub, err := vm.DefineClass(newClassBuilder("Sub", "Main").build())
+	require.NoError(t, err)
+	_, err = vm.resolveMethod(frame, sub, &MemberRef{ClassName: "Sub", Name: "<init>", Descriptor: "()V"})
+	require.EqualError(t, err, "java/lang/NoSuchMethodError: 'Sub.<init>:()V'")
+}
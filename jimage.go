@@ -0,0 +1,252 @@
+package jvmgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+const (
+	jimageMagic          = 0xCAFEDADA
+	jimageHeaderSize     = 28
+	jimageHashMultiplier = 0x01000193
+
+	jimageAttributeEnd          = 0
+	jimageAttributeModule       = 1
+	jimageAttributeParent       = 2
+	jimageAttributeBase         = 3
+	jimageAttributeExtension    = 4
+	jimageAttributeOffset       = 5
+	jimageAttributeCompressed   = 6
+	jimageAttributeUncompressed = 7
+	jimageAttributeCount        = 8
+)
+
+var (
+	ErrInvalidJImage = errors.New("invalid jimage file")
+)
+
+type (
+	// JImage reads classes from the lib/modules runtime image of JDK 9 and later.
+	JImage struct {
+		data      []byte
+		order     binary.ByteOrder
+		redirect  []byte
+		offsets   []byte
+		locations []byte
+		strings   []byte
+		indexSize int
+		length    int
+		// modulesMu guards modules, which caches packageModule for the
+		// threads that load classes at the same time
+		modulesMu sync.Mutex
+		modules   map[string]string
+	}
+)
+
+func OpenJImage(path string) (*JImage, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := ReadJImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	return img, nil
+}
+
+func ReadJImage(data []byte) (*JImage, error) {
+	if len(data) < jimageHeaderSize {
+		return nil, ErrInvalidJImage
+	}
+	img := &JImage{data: data, modules: map[string]string{}}
+	switch {
+	case binary.LittleEndian.Uint32(data) == jimageMagic:
+		img.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(data) == jimageMagic:
+		img.order = binary.BigEndian
+	default:
+		return nil, ErrInvalidJImage
+	}
+	if major := img.order.Uint32(data[4:]) >> 16; major != 1 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidJImage, major)
+	}
+
+	tableLength := int(img.order.Uint32(data[16:]))
+	locationsSize := int(img.order.Uint32(data[20:]))
+	stringsSize := int(img.order.Uint32(data[24:]))
+	img.length = tableLength
+	img.indexSize = jimageHeaderSize + tableLength*8 + locationsSize + stringsSize
+	if tableLength < 0 || img.indexSize > len(data) {
+		return nil, fmt.Errorf("%w: index exceeds file size", ErrInvalidJImage)
+	}
+
+	p := jimageHeaderSize
+	img.redirect = data[p : p+tableLength*4]
+	p += tableLength * 4
+	img.offsets = data[p : p+tableLength*4]
+	p += tableLength * 4
+	img.locations = data[p : p+locationsSize]
+	p += locationsSize
+	img.strings = data[p : p+stringsSize]
+
+	return img, nil
+}
+
+func (img *JImage) ReadClass(name string) ([]byte, error) {
+	pkg := ""
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		pkg = name[:i]
+	}
+	module, err := img.packageModule(pkg)
+	if err != nil {
+		return nil, err
+	}
+	if module == "" {
+		return nil, fmt.Errorf("%w: %s", ErrClassNotFound, name)
+	}
+	buf, ok, err := img.Resource("/" + module + "/" + name + ".class")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrClassNotFound, name)
+	}
+	return buf, nil
+}
+
+func (img *JImage) Close() error {
+	return nil
+}
+
+// packageModule maps a package such as "java/lang" to its module using the
+// /packages/<package> entries of the image.
+func (img *JImage) packageModule(pkg string) (string, error) {
+	img.modulesMu.Lock()
+	m, ok := img.modules[pkg]
+	img.modulesMu.Unlock()
+	if ok {
+		return m, nil
+	}
+	content, ok, err := img.Resource("/packages/" + strings.ReplaceAll(pkg, "/", "."))
+	if err != nil {
+		return "", err
+	}
+	module := ""
+	if ok {
+		// pairs of (isEmpty, module name offset); the first non-empty one wins
+		for i := 0; i+8 <= len(content); i += 8 {
+			if img.order.Uint32(content[i:]) == 0 {
+				module = img.getString(img.order.Uint32(content[i+4:]))
+				break
+			}
+		}
+	}
+	img.modulesMu.Lock()
+	img.modules[pkg] = module
+	img.modulesMu.Unlock()
+	return module, nil
+}
+
+// Resource returns the content of a resource such as "/java.base/java/lang/Object.class".
+func (img *JImage) Resource(path string) ([]byte, bool, error) {
+	attrs, ok := img.findLocation(path)
+	if !ok {
+		return nil, false, nil
+	}
+	if attrs[jimageAttributeCompressed] != 0 {
+		return nil, false, fmt.Errorf("%w: compressed resource %s is not supported", ErrInvalidJImage, path)
+	}
+	start := uint64(img.indexSize) + attrs[jimageAttributeOffset]
+	end := start + attrs[jimageAttributeUncompressed]
+	if end > uint64(len(img.data)) {
+		return nil, false, fmt.Errorf("%w: resource %s exceeds file size", ErrInvalidJImage, path)
+	}
+	return img.data[start:end], true, nil
+}
+
+func (img *JImage) findLocation(path string) ([jimageAttributeCount]uint64, bool) {
+	var attrs [jimageAttributeCount]uint64
+	if img.length == 0 {
+		return attrs, false
+	}
+	index := int(jimageHash(path, jimageHashMultiplier) % uint32(img.length))
+	value := int32(img.order.Uint32(img.redirect[index*4:]))
+	switch {
+	case value < 0:
+		index = int(-1 - value)
+	case value > 0:
+		index = int(jimageHash(path, uint32(value)) % uint32(img.length))
+	default:
+		return attrs, false
+	}
+	if index < 0 || index >= img.length {
+		return attrs, false
+	}
+
+	offset := img.order.Uint32(img.offsets[index*4:])
+	attrs = img.decodeLocation(offset)
+	if img.locationName(attrs) != path {
+		return attrs, false
+	}
+	return attrs, true
+}
+
+func (img *JImage) decodeLocation(offset uint32) [jimageAttributeCount]uint64 {
+	var attrs [jimageAttributeCount]uint64
+	for p := int(offset); p < len(img.locations); {
+		b := img.locations[p]
+		kind := b >> 3
+		if kind == jimageAttributeEnd || kind >= jimageAttributeCount {
+			break
+		}
+		n := int(b&0x7) + 1
+		var v uint64
+		for i := 1; i <= n && p+i < len(img.locations); i++ {
+			v = v<<8 | uint64(img.locations[p+i])
+		}
+		attrs[kind] = v
+		p += n + 1
+	}
+	return attrs
+}
+
+func (img *JImage) locationName(attrs [jimageAttributeCount]uint64) string {
+	var b strings.Builder
+	if m := img.getString(uint32(attrs[jimageAttributeModule])); m != "" {
+		b.WriteString("/" + m + "/")
+	}
+	if p := img.getString(uint32(attrs[jimageAttributeParent])); p != "" {
+		b.WriteString(p + "/")
+	}
+	b.WriteString(img.getString(uint32(attrs[jimageAttributeBase])))
+	if e := img.getString(uint32(attrs[jimageAttributeExtension])); e != "" {
+		b.WriteString("." + e)
+	}
+	return b.String()
+}
+
+func (img *JImage) getString(offset uint32) string {
+	if int(offset) >= len(img.strings) {
+		return ""
+	}
+	s := img.strings[offset:]
+	if i := bytes.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return string(s)
+}
+
+// jimageHash is ImageStringsReader.hashCode over the UTF-8 bytes of s.
+func jimageHash(s string, seed uint32) uint32 {
+	h := seed
+	for i := 0; i < len(s); i++ {
+		h = (h * jimageHashMultiplier) ^ uint32(s[i])
+	}
+	return h & 0x7FFFFFFF
+}
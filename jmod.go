@@ -0,0 +1,81 @@
+package jvmgo
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	jmodMagic          = []byte{'J', 'M', 0x01, 0x00}
+	ErrInvalidJmodFile = errors.New("invalid jmod file")
+)
+
+type (
+	// Jmod reads classes from a JDK .jmod file, which is a zip archive behind a
+	// four byte header with the classes stored under "classes/".
+	Jmod struct {
+		file    *os.File
+		entries map[string]*zip.File
+	}
+)
+
+func OpenJmod(path string) (*Jmod, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	j, err := readJmod(f, stat.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	j.file = f
+	return j, nil
+}
+
+func readJmod(r io.ReaderAt, size int64) (*Jmod, error) {
+	header := make([]byte, len(jmodMagic))
+	if _, err := r.ReadAt(header, 0); err != nil || !bytes.Equal(header, jmodMagic) {
+		return nil, ErrInvalidJmodFile
+	}
+	zr, err := zip.NewReader(io.NewSectionReader(r, int64(len(jmodMagic)), size-int64(len(jmodMagic))), size-int64(len(jmodMagic)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJmodFile, err)
+	}
+
+	j := &Jmod{entries: map[string]*zip.File{}}
+	for _, f := range zr.File {
+		j.entries[f.Name] = f
+	}
+	return j, nil
+}
+
+func (j *Jmod) ReadClass(name string) ([]byte, error) {
+	f, ok := j.entries["classes/"+name+".class"]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrClassNotFound, name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func (j *Jmod) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,297 @@
+package jvmgo
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// flags of LambdaMetafactory.altMetafactory
+const (
+	lambdaFlagSerializable = 1 << iota
+	lambdaFlagMarkers
+	lambdaFlagBridges
+)
+
+var lambdaClassCount uint32
+
+// linkLambda implements LambdaMetafactory.metafactory and altMetafactory. It
+// spins a class implementing the functional interface whose instances hold
+// the captured arguments in fields and forward the interface method to the
+// implementation method.
+func linkLambda(caller *Frame, site *dynamicCallSite) (NativeMethod, error) {
+	vm := caller.VM
+	file := site.Class.File
+	fail := func(format string, args ...interface{}) error {
+		return vm.throwNew(caller, "java/lang/BootstrapMethodError", fmt.Sprintf(format, args...))
+	}
+	if len(site.Arguments) < 3 {
+		return nil, fail("%s takes at least 3 static arguments, got %d", site.Bootstrap.Ref.Name, len(site.Arguments))
+	}
+	samDescriptor, err := file.GetMethodType(site.Arguments[0])
+	if err != nil {
+		return nil, fmt.Errorf("lambda interface method type: %w", err)
+	}
+	impl, err := file.GetMethodHandle(site.Arguments[1])
+	if err != nil {
+		return nil, fmt.Errorf("lambda implementation: %w", err)
+	}
+	samDesc, err := parseMethodDescriptor(samDescriptor)
+	if err != nil {
+		return nil, err
+	}
+	ret := site.Desc.Return
+	if len(ret) < 3 || ret[0] != 'L' {
+		return nil, fail("lambda factory must return an interface: %s", ret)
+	}
+	iface := ret[1 : len(ret)-1]
+	interfaces := []string{iface}
+	bridges := []string{samDescriptor}
+
+	if site.Bootstrap.Ref.Name == "altMetafactory" && len(site.Arguments) > 3 {
+		args := site.Arguments[3:]
+		next := func() (int32, error) {
+			if len(args) == 0 {
+				return 0, fail("altMetafactory arguments end early")
+			}
+			v, err := vm.loadConstant(site.Class, args[0])
+			args = args[1:]
+			if err != nil {
+				return 0, err
+			}
+			n, ok := v.(int32)
+			if !ok {
+				return 0, fail("altMetafactory expects an int argument, got %v", v)
+			}
+			return n, nil
+		}
+		flags, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if flags&lambdaFlagMarkers != 0 {
+			n, err := next()
+			if err != nil {
+				return nil, err
+			}
+			for ; n > 0 && len(args) > 0; n-- {
+				name, err := file.ClassName(args[0])
+				if err != nil {
+					return nil, fmt.Errorf("lambda marker interface: %w", err)
+				}
+				interfaces = append(interfaces, name)
+				args = args[1:]
+			}
+		}
+		if flags&lambdaFlagBridges != 0 {
+			n, err := next()
+			if err != nil {
+				return nil, err
+			}
+			for ; n > 0 && len(args) > 0; n-- {
+				desc, err := file.GetMethodType(args[0])
+				if err != nil {
+					return nil, fmt.Errorf("lambda bridge type: %w", err)
+				}
+				bridges = append(bridges, desc)
+				args = args[1:]
+			}
+		}
+		if flags&lambdaFlagSerializable != 0 {
+			interfaces = append(interfaces, "java/io/Serializable")
+		}
+	}
+
+	forward, err := vm.lambdaForwarder(caller, impl, site.Desc.Parameters, samDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%s$$Lambda$%d", site.Class.Name, atomic.AddUint32(&lambdaClassCount, 1))
+	b := &builtinClass{name: name, super: "java/lang/Object", flags: AccFinal | AccSuper | AccSynthetic, spun: true}
+	for _, i := range interfaces {
+		c, err := vm.classOrStub(i)
+		if err != nil {
+			return nil, fmt.Errorf("load lambda interface %s: %w", i, err)
+		}
+		if c.stub {
+			c.AccessFlags |= AccInterface | AccAbstract
+		}
+		b.interfaces = append(b.interfaces, i)
+	}
+	for i, p := range site.Desc.Parameters {
+		b.field(AccPrivate|AccFinal, fmt.Sprintf("arg$%d", i+1), p, nil)
+	}
+	if len(site.Desc.Parameters) == 0 {
+		// the field keeps the constant instance reachable
+		b.field(AccPrivate|AccStatic|AccFinal, "INSTANCE", "L"+name+";", nil)
+	}
+	// the captured values follow the receiver, in field order
+	var captured func(obj *Object) []Value
+	for _, desc := range bridges {
+		desc := desc
+		bridgeDesc, err := parseMethodDescriptor(desc)
+		if err != nil {
+			return nil, err
+		}
+		b.virtual(site.Name, desc, func(frame *Frame, args []Value) (Value, error) {
+			in := append(captured(args[0].(*Object)), args[1:]...)
+			return forward(frame, in, bridgeDesc)
+		})
+	}
+	class, err := vm.defineBuiltin(b)
+	if err != nil {
+		return nil, err
+	}
+	class.initState = classInitialized
+	slots := make([]int, len(site.Desc.Parameters))
+	for i := range slots {
+		slots[i] = class.Fields[i].Slot
+	}
+	captured = func(obj *Object) []Value {
+		values := make([]Value, len(slots))
+		for i, slot := range slots {
+			values[i] = loadSlot(obj.Fields, slot)
+		}
+		return values
+	}
+
+	if len(slots) == 0 {
+		// a lambda that captures nothing is a constant
+		instance := NewObject(class)
+		if err := vm.allocate(caller, instance); err != nil {
+			return nil, err
+		}
+		slot := class.DeclaredField("INSTANCE", "").Slot
+		storeSlot(class.StaticValues, slot, instance)
+		return func(frame *Frame, args []Value) (Value, error) {
+			return loadSlot(class.StaticValues, slot), nil
+		}, nil
+	}
+	return func(frame *Frame, args []Value) (Value, error) {
+		obj := NewObject(class)
+		if err := frame.VM.allocate(frame, obj); err != nil {
+			return nil, err
+		}
+		for i, slot := range slots {
+			obj.Fields[slot] = args[i]
+		}
+		return obj, nil
+	}, nil
+}
+
+// lambdaForwarder resolves the implementation method of a lambda and returns
+// a function calling it with the captured values followed by the interface
+// method arguments, adapted as method handles do.
+func (vm *VirtualMachine) lambdaForwarder(caller *Frame, impl *MethodHandleRef, capturedTypes []string, samDesc *MethodDescriptor) (func(frame *Frame, args []Value, desc *MethodDescriptor) (Value, error), error) {
+	class, err := vm.LoadClass(impl.Ref.ClassName)
+	if err != nil {
+		return nil, fmt.Errorf("resolve lambda implementation %s: %w", impl.Ref, err)
+	}
+	m := class.LookupMethod(impl.Ref.Name, impl.Ref.Descriptor)
+	if m == nil {
+		return nil, vm.throwNew(caller, "java/lang/NoSuchMethodError", fmt.Sprintf("'%s'", impl.Ref))
+	}
+
+	params := m.Desc.Parameters
+	implReturn := m.Desc.Return
+	switch impl.Kind {
+	case RefInvokeStatic:
+	case RefInvokeVirtual, RefInvokeInterface, RefInvokeSpecial:
+		params = append([]string{"L" + class.Name + ";"}, params...)
+	case RefNewInvokeSpecial:
+		implReturn = "L" + class.Name + ";"
+	default:
+		return nil, vm.throwNew(caller, "java/lang/BootstrapMethodError", fmt.Sprintf("unsupported method handle kind %d for %s", impl.Kind, impl.Ref))
+	}
+	if len(capturedTypes)+len(samDesc.Parameters) != len(params) {
+		return nil, vm.throwNew(caller, "java/lang/BootstrapMethodError",
+			fmt.Sprintf("Incorrect number of parameters for %s; %d captured parameters, %d functional interface method parameters, %d implementation parameters",
+				impl.Ref, len(capturedTypes), len(samDesc.Parameters), len(params)))
+	}
+
+	return func(frame *Frame, args []Value, desc *MethodDescriptor) (Value, error) {
+		types := append(append([]string{}, capturedTypes...), desc.Parameters...)
+		in := make([]Value, len(args))
+		for i, v := range args {
+			a, err := frame.VM.adaptValue(frame, v, types[i], params[i])
+			if err != nil {
+				return nil, err
+			}
+			in[i] = a
+		}
+
+		var ret Value
+		var err error
+		switch impl.Kind {
+		case RefInvokeStatic:
+			if err := frame.VM.initializeClass(frame, m.Class); err != nil {
+				return nil, err
+			}
+			ret, err = frame.VM.invokeMethod(frame, m, in)
+		case RefInvokeVirtual, RefInvokeInterface:
+			receiver, ok := in[0].(*Object)
+			if !ok || receiver == nil {
+				return nil, frame.VM.throwNullPointer(frame)
+			}
+			var selected *RuntimeMethod
+			if selected, err = frame.VM.selectMethod(frame, receiver.Class, m); err != nil {
+				return nil, err
+			}
+			ret, err = frame.VM.invokeMethod(frame, selected, in)
+		case RefInvokeSpecial:
+			if isNull(in[0]) {
+				return nil, frame.VM.throwNullPointer(frame)
+			}
+			ret, err = frame.VM.invokeMethod(frame, m, in)
+		case RefNewInvokeSpecial:
+			if err := frame.VM.initializeClass(frame, class); err != nil {
+				return nil, err
+			}
+			obj := NewObject(class)
+			if _, err := frame.VM.invokeMethod(frame, m, append([]Value{obj}, in...)); err != nil {
+				return nil, err
+			}
+			ret = obj
+		}
+		if err != nil {
+			return nil, err
+		}
+		if desc.Return == "V" || implReturn == "V" {
+			return nil, nil
+		}
+		return frame.VM.adaptValue(frame, ret, implReturn, desc.Return)
+	}, nil
+}
+
+// adaptValue converts v of type from to type to with the boxing, unboxing and
+// widening that MethodHandle.asType applies.
+func (vm *VirtualMachine) adaptValue(caller *Frame, v Value, from, to string) (Value, error) {
+	fromPrimitive, toPrimitive := isPrimitiveDescriptor(from), isPrimitiveDescriptor(to)
+	switch {
+	case fromPrimitive && toPrimitive:
+		if from == to {
+			return v, nil
+		}
+		return convertPrimitive(v, to), nil
+	case fromPrimitive:
+		return vm.box(caller, from, v)
+	case toPrimitive:
+		if isNull(v) {
+			return nil, vm.throwNullPointer(caller)
+		}
+		p, desc, ok := unbox(v)
+		if !ok {
+			return nil, vm.throwNew(caller, "java/lang/ClassCastException",
+				classCastMessage(v.(*Object).ClassName(), boxClassNames[to]))
+		}
+		if desc == to {
+			return p, nil
+		}
+		return convertPrimitive(p, to), nil
+	}
+	return v, nil
+}
+
+func isPrimitiveDescriptor(desc string) bool {
+	return len(desc) == 1 && desc != "V"
+}
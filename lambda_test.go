@@ -0,0 +1,223 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachine_ExecMain_Lambda(t *testing.T) {
+	const (
+		metafactoryDesc = "(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodHandle;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite;"
+		object          = "Ljava/lang/Object;"
+	)
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	print := b.methodRef("java/io/PrintStream", "print", "(Ljava/lang/String;)V")
+	printObj := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/Object;)V")
+	printStr := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+	metafactory := b.methodHandle(RefInvokeStatic, b.methodRef("java/lang/invoke/LambdaMetafactory", "metafactory", metafactoryDesc))
+	methodType := func(desc string) uint16 {
+		return b.add(ConstantKindMethodType, u2(b.utf8(desc)))
+	}
+	lambda := func(kind uint8, class, name, desc string) uint16 {
+		return b.methodHandle(kind, b.methodRef(class, name, desc))
+	}
+
+	// list.forEach(x -> System.out.print(prefix); System.out.println(x))
+	b.method(AccPrivate|AccStatic|AccSynthetic, "lambda$main$0", "(Ljava/lang/String;"+object+")V", 2, 2, newAsm().
+		ref(OpCodeGetStatic, out).op(OpCodeAload0).ref(OpCodeInvokeVirtual, print).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).ref(OpCodeInvokeVirtual, printObj).
+		op(OpCodeReturn).bytes()...)
+	forEachConsumer := b.invokeDynamic(metafactory, []uint16{
+		methodType("(" + object + ")V"),
+		lambda(RefInvokeStatic, "Main", "lambda$main$0", "(Ljava/lang/String;"+object+")V"),
+		methodType("(" + object + ")V"),
+	}, "accept", "(Ljava/lang/String;)Ljava/util/function/Consumer;")
+
+	// Runnable r = main::hello
+	hello := b.str("hello")
+	b.method(AccPublic, "hello", "()V", 2, 1, newAsm().
+		ref(OpCodeGetStatic, out).op(OpCodeLdc, lo(hello)).ref(OpCodeInvokeVirtual, printStr).
+		op(OpCodeReturn).bytes()...)
+	objectInit := b.methodRef("java/lang/Object", "<init>", "()V")
+	b.method(AccPublic, "<init>", "()V", 1, 1, newAsm().
+		op(OpCodeAload0).ref(OpCodeInvokeSpecial, objectInit).op(OpCodeReturn).bytes()...)
+	boundRunnable := b.invokeDynamic(metafactory, []uint16{
+		methodType("()V"),
+		lambda(RefInvokeVirtual, "Main", "hello", "()V"),
+		methodType("()V"),
+	}, "run", "(LMain;)Ljava/lang/Runnable;")
+
+	// Supplier<Integer> s = () -> 42, boxing the result
+	b.method(AccPrivate|AccStatic|AccSynthetic, "lambda$main$1", "()I", 1, 0, newAsm().
+		op(OpCodeBipush, 42).op(OpCodeIreturn).bytes()...)
+	answer := b.invokeDynamic(metafactory, []uint16{
+		methodType("()" + object),
+		lambda(RefInvokeStatic, "Main", "lambda$main$1", "()I"),
+		methodType("()Ljava/lang/Integer;"),
+	}, "get", "()Ljava/util/function/Supplier;")
+
+	// Function<Integer, Integer> f = Main::twice, unboxing the argument
+	b.method(AccStatic, "twice", "(I)I", 2, 1, newAsm().
+		op(OpCodeIload0).op(OpCodeIconst0+2).op(OpCodeImul).op(OpCodeIreturn).bytes()...)
+	twice := b.invokeDynamic(metafactory, []uint16{
+		methodType("(" + object + ")" + object),
+		lambda(RefInvokeStatic, "Main", "twice", "(I)I"),
+		methodType("(Ljava/lang/Integer;)Ljava/lang/Integer;"),
+	}, "apply", "()Ljava/util/function/Function;")
+
+	// Supplier<List> s = ArrayList::new
+	newList := b.invokeDynamic(metafactory, []uint16{
+		methodType("()" + object),
+		lambda(RefNewInvokeSpecial, "java/util/ArrayList", "<init>", "()V"),
+		methodType("()Ljava/util/ArrayList;"),
+	}, "get", "()Ljava/util/function/Supplier;")
+
+	arrayList := b.classRef("java/util/ArrayList")
+	arrayListInit := b.methodRef("java/util/ArrayList", "<init>", "()V")
+	listAdd := b.interfaceMethodRef("java/util/List", "add", "("+object+")Z")
+	forEach := b.interfaceMethodRef("java/util/List", "forEach", "(Ljava/util/function/Consumer;)V")
+	intValueOf := b.methodRef("java/lang/Integer", "valueOf", "(I)Ljava/lang/Integer;")
+	run := b.interfaceMethodRef("java/lang/Runnable", "run", "()V")
+	get := b.interfaceMethodRef("java/util/function/Supplier", "get", "()"+object)
+	apply := b.interfaceMethodRef("java/util/function/Function", "apply", "("+object+")"+object)
+	mainClass := b.classRef("Main")
+	mainInit := b.methodRef("Main", "<init>", "()V")
+	prefix := b.str("item ")
+	indy := func(a *asm, idx uint16) *asm {
+		return a.op(OpCodeInvokeDynamic, hi(idx), lo(idx), 0, 0)
+	}
+
+	code := newAsm().
+		ref(OpCodeNew, arrayList).op(OpCodeDup).ref(OpCodeInvokeSpecial, arrayListInit).op(OpCodeAstore0+1).
+		op(OpCodeAload0+1).op(OpCodeIconst0+1).ref(OpCodeInvokeStatic, intValueOf).op(OpCodeInvokeInterface, hi(listAdd), lo(listAdd), 2, 0).op(OpCodePop).
+		op(OpCodeAload0+1).op(OpCodeIconst0+2).ref(OpCodeInvokeStatic, intValueOf).op(OpCodeInvokeInterface, hi(listAdd), lo(listAdd), 2, 0).op(OpCodePop).
+		op(OpCodeAload0+1).op(OpCodeLdc, lo(prefix))
+	indy(code, forEachConsumer).op(OpCodeInvokeInterface, hi(forEach), lo(forEach), 2, 0).
+		ref(OpCodeNew, mainClass).op(OpCodeDup).ref(OpCodeInvokeSpecial, mainInit)
+	indy(code, boundRunnable).op(OpCodeInvokeInterface, hi(run), lo(run), 1, 0).
+		ref(OpCodeGetStatic, out)
+	indy(code, answer).op(OpCodeInvokeInterface, hi(get), lo(get), 1, 0).ref(OpCodeInvokeVirtual, printObj).
+		ref(OpCodeGetStatic, out)
+	indy(code, twice).op(OpCodeBipush, 21).ref(OpCodeInvokeStatic, intValueOf).op(OpCodeInvokeInterface, hi(apply), lo(apply), 2, 0).ref(OpCodeInvokeVirtual, printObj).
+		ref(OpCodeGetStatic, out)
+	indy(code, newList).op(OpCodeInvokeInterface, hi(get), lo(get), 1, 0).ref(OpCodeInvokeVirtual, printObj).
+		op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 4, 2, code.bytes()...)
+
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "item 1\nitem 2\nhello\n42\n42\n[]\n", stdout.String())
+}
+
+func TestVirtualMachine_LinkLambda_NonCapturingIsConstant(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	metafactory := b.methodHandle(RefInvokeStatic, b.methodRef("java/lang/invoke/LambdaMetafactory", "metafactory",
+		"(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodHandle;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite;"))
+	runType := b.add(ConstantKindMethodType, u2(b.utf8("()V")))
+	b.method(AccPrivate|AccStatic, "lambda$main$0", "()V", 0, 0, byte(OpCodeReturn))
+	impl := b.methodHandle(RefInvokeStatic, b.methodRef("Main", "lambda$main$0", "()V"))
+	indy := b.invokeDynamic(metafactory, []uint16{runType, impl, runType}, "run", "()Ljava/lang/Runnable;")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 1, 1, byte(OpCodeReturn))
+
+	vm := NewVM(b.build())
+	class, err := vm.DefineClass(b.build())
+	require.NoError(t, err)
+	frame := &Frame{VM: vm, Class: class, Method: class.DeclaredMethod("main", "([Ljava/lang/String;)V"), OperandStack: &OperandStack{}}
+	site, err := vm.callSite(frame, 0, indy)
+	require.NoError(t, err)
+	first, err := site.target(frame, nil)
+	require.NoError(t, err)
+	second, err := site.target(frame, nil)
+	require.NoError(t, err)
+	require.Same(t, first, second)
+
+	r := first.(*Object)
+	require.Regexp(t, `^Main\$\$Lambda\$\d+$`, r.ClassName())
+	runnable, err := vm.LoadClass("java/lang/Runnable")
+	require.NoError(t, err)
+	require.Equal(t, []*RuntimeClass{runnable}, r.Class.Interfaces)
+}
+
+func TestVirtualMachine_LinkLambda_Allocates(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	metafactory := b.methodHandle(RefInvokeStatic, b.methodRef("java/lang/invoke/LambdaMetafactory", "metafactory",
+		"(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodHandle;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite;"))
+	runType := b.add(ConstantKindMethodType, u2(b.utf8("()V")))
+	b.method(AccPrivate|AccStatic, "lambda$main$0", "()V", 0, 0, byte(OpCodeReturn))
+	b.method(AccPrivate|AccStatic, "lambda$main$1", "(Ljava/lang/String;)V", 0, 1, byte(OpCodeReturn))
+	constant := b.invokeDynamic(metafactory, []uint16{runType, b.methodHandle(RefInvokeStatic, b.methodRef("Main", "lambda$main$0", "()V")), runType},
+		"run", "()Ljava/lang/Runnable;")
+	capturing := b.invokeDynamic(metafactory, []uint16{runType, b.methodHandle(RefInvokeStatic, b.methodRef("Main", "lambda$main$1", "(Ljava/lang/String;)V")), runType},
+		"run", "(Ljava/lang/String;)Ljava/lang/Runnable;")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 1, 1, byte(OpCodeReturn))
+
+	vm := NewVM(b.build())
+	class, err := vm.DefineClass(b.build())
+	require.NoError(t, err)
+	frame := &Frame{VM: vm, Class: class, Method: class.DeclaredMethod("main", "([Ljava/lang/String;)V"), OperandStack: &OperandStack{}}
+	site, err := vm.callSite(frame, 0, constant)
+	require.NoError(t, err)
+	c, err := site.target(frame, nil)
+	require.NoError(t, err)
+	site, err = vm.callSite(frame, 1, capturing)
+	require.NoError(t, err)
+	captured := vm.NewString("captured")
+	before := vm.heap.usage()
+	r, err := site.target(frame, []Value{captured})
+	require.NoError(t, err)
+	require.Greater(t, vm.heap.usage(), before)
+
+	// the natives of a lambda class stay with it
+	for _, v := range []Value{c, r} {
+		_, ok := vm.Natives.Lookup(v.(*Object).ClassName(), "run", "()V")
+		require.False(t, ok)
+	}
+	_, err = vm.InvokeVirtual(frame, r.(*Object), "run", "()V")
+	require.NoError(t, err)
+
+	// the constant instance is reachable, so a heap dump has it
+	var buf bytes.Buffer
+	require.NoError(t, vm.DumpHeap(&buf))
+	d := readHprof(t, buf.Bytes())
+	require.Len(t, d.instances[c.(*Object).ClassName()], 1)
+}
+
+func TestVirtualMachine_ExecMain_MethodRefThrows(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	metafactory := b.methodHandle(RefInvokeStatic, b.methodRef("java/lang/invoke/LambdaMetafactory", "metafactory",
+		"(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodHandle;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite;"))
+	runType := b.add(ConstantKindMethodType, u2(b.utf8("()V")))
+	b.method(AccPublic, "<init>", "()V", 1, 1, newAsm().
+		op(OpCodeAload0).ref(OpCodeInvokeSpecial, b.methodRef("java/lang/Object", "<init>", "()V")).op(OpCodeReturn).bytes()...)
+	b.method(AccPublic, "fail", "()V", 2, 1, newAsm().
+		ref(OpCodeNew, b.classRef("java/lang/IllegalStateException")).op(OpCodeDup).
+		ref(OpCodeInvokeSpecial, b.methodRef("java/lang/IllegalStateException", "<init>", "()V")).
+		op(OpCodeAThrow).bytes()...)
+	// Runnable r = new Main()::fail; try { r.run(); } catch (IllegalStateException e) { System.out.println("caught"); }
+	indy := b.invokeDynamic(metafactory, []uint16{runType, b.methodHandle(RefInvokeVirtual, b.methodRef("Main", "fail", "()V")), runType},
+		"run", "(LMain;)Ljava/lang/Runnable;")
+	run := b.interfaceMethodRef("java/lang/Runnable", "run", "()V")
+	code := newAsm().
+		ref(OpCodeNew, b.classRef("Main")).op(OpCodeDup).ref(OpCodeInvokeSpecial, b.methodRef("Main", "<init>", "()V")).
+		op(OpCodeInvokeDynamic, hi(indy), lo(indy), 0, 0).
+		label("try").op(OpCodeInvokeInterface, hi(run), lo(run), 1, 0).label("end").
+		op(OpCodeReturn).
+		label("catch").op(OpCodePop).
+		ref(OpCodeGetStatic, b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")).op(OpCodeLdc, lo(b.str("caught"))).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")).
+		op(OpCodeReturn)
+	b.methodWithHandlers(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 1, code.bytes(), []*Exception{
+		{StartPC: code.pc("try"), EndPC: code.pc("end"), HandlerPC: code.pc("catch"), CatchType: b.classRef("java/lang/IllegalStateException")},
+	})
+
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "caught\n", stdout.String())
+}
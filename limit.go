@@ -0,0 +1,59 @@
+package jvmgo
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInstructionLimit is returned when the threads have run MaxInstructions
+// instructions.
+var ErrInstructionLimit = errors.New("instruction limit exceeded")
+
+// DefaultMaxStackDepth is the frames a thread may have when MaxStackDepth is
+// not set, far fewer than fill the stack of a goroutine.
+const DefaultMaxStackDepth = 10000
+
+type (
+	// CanceledError reports that the context of ExecMainContext or
+	// InvokeContext was done before the program ended.
+	CanceledError struct {
+		Err error
+	}
+)
+
+// ExecMainContext is ExecMain that stops every thread when ctx is done, so a
+// deadline of ctx limits the wall-clock time of the program. It then returns
+// a *CanceledError, and the VM cannot run again.
+func (vm *VirtualMachine) ExecMainContext(ctx context.Context, args ...string) error {
+	defer vm.abortOnDone(ctx)()
+	return vm.ExecMain(args...)
+}
+
+// InvokeContext is Invoke that stops every thread when ctx is done, as
+// ExecMainContext does.
+func (vm *VirtualMachine) InvokeContext(ctx context.Context, className, name, desc string, args ...interface{}) (interface{}, error) {
+	defer vm.abortOnDone(ctx)()
+	return vm.Invoke(className, name, desc, args...)
+}
+
+// abortOnDone aborts the VM when ctx is done until the returned function is
+// called.
+func (vm *VirtualMachine) abortOnDone(ctx context.Context) (stop func()) {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			vm.abort(&CanceledError{Err: ctx.Err()})
+		case <-stopped:
+		}
+	}()
+	return func() { close(stopped) }
+}
+
+func (e *CanceledError) Error() string {
+	return "execution canceled: " + e.Err.Error()
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,164 @@
+package jvmgo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newLoopClass builds a main class that never returns.
+func newLoopClass() *ClassStructure {
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 0, 1, newAsm().
+		label("loop").branch(OpCodeGoto, "loop").bytes()...)
+	return b.build()
+}
+
+func TestVirtualMachine_ExecMainContext(t *testing.T) {
+	vm := NewVM(newLoopClass())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := vm.ExecMainContext(ctx)
+	var canceled *CanceledError
+	require.ErrorAs(t, err, &canceled)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// the program ends before the context is done
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 0, 1, byte(OpCodeReturn))
+	vm = NewVM(b.build())
+	require.NoError(t, vm.ExecMainContext(context.Background()))
+}
+
+func TestVirtualMachine_MaxInstructions(t *testing.T) {
+	vm := NewVM(newLoopClass())
+	vm.MaxInstructions = 1000
+	err := vm.ExecMain()
+	require.True(t, errors.Is(err, ErrInstructionLimit))
+	require.EqualValues(t, 1001, vm.instructions)
+}
+
+func TestVirtualMachine_MaxInstructions_PerCall(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.method(AccPublic|AccStatic, "two", "()I", 1, 0, newAsm().op(OpCodeIconst0+2).op(OpCodeIreturn).bytes()...)
+	vm := NewVM(b.build())
+	vm.MaxInstructions = 3
+	// each call has the whole budget
+	for i := 0; i < 5; i++ {
+		v, err := vm.Invoke("Main", "two", "()I")
+		require.NoError(t, err, i)
+		require.Equal(t, int32(2), v)
+	}
+}
+
+func TestVirtualMachine_MaxStackDepth(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	recurse := b.methodRef("Main", "recurse", "(I)I")
+	b.method(AccPublic|AccStatic, "recurse", "(I)I", 2, 1, newAsm().
+		op(OpCodeIload0).op(OpCodeIconst0+1).op(OpCodeIadd).ref(OpCodeInvokeStatic, recurse).op(OpCodeIreturn).bytes()...)
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	code := newAsm().
+		label("try").op(OpCodeIconst0).ref(OpCodeInvokeStatic, recurse).op(OpCodePop).label("end").
+		op(OpCodeReturn).
+		label("catch").op(OpCodePop).
+		ref(OpCodeGetStatic, out).op(OpCodeLdc, lo(b.str("overflow"))).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")).
+		op(OpCodeReturn)
+	b.methodWithHandlers(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 1, code.bytes(), []*Exception{
+		{StartPC: code.pc("try"), EndPC: code.pc("end"), HandlerPC: code.pc("catch"), CatchType: b.classRef("java/lang/StackOverflowError")},
+	})
+	class := b.build()
+	// the default keeps unbounded recursion off the end of the Go stack
+	for _, depth := range []int{100, 0} {
+		vm := NewVM(class)
+		vm.MaxStackDepth = depth
+		var stdout bytes.Buffer
+		vm.Out = &stdout
+		require.NoError(t, vm.ExecMain(), depth)
+		require.Equal(t, "overflow\n", stdout.String(), depth)
+	}
+}
+
+func TestVirtualMachine_MaxArrayLength(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 1, 1, newAsm().
+		op(OpCodeBipush, 10).op(OpCodeNewArray, ArrayTypeByte).op(OpCodePop).
+		op(OpCodeBipush, 11).op(OpCodeNewArray, ArrayTypeByte).op(OpCodePop).
+		op(OpCodeReturn).bytes()...)
+	vm := NewVM(b.build())
+	vm.MaxArrayLength = 10
+	err := vm.ExecMain()
+	var ex *JavaException
+	require.ErrorAs(t, err, &ex)
+	require.Equal(t, "java/lang/OutOfMemoryError: Requested array size exceeds VM limit", ex.Error())
+}
+
+func TestVirtualMachine_ExecMain_MultiArrayCounts(t *testing.T) {
+	// new int[5][-1] fails on the negative count before the outer array,
+	// which is longer than allowed, is allocated
+	b := newClassBuilder("Main", "java/lang/Object")
+	arrays := b.classRef("[[I")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 1, newAsm().
+		op(OpCodeIconst0+5).op(OpCodeIconstM1).op(OpCodeMultiANewArray, hi(arrays), lo(arrays), 2).op(OpCodePop).
+		op(OpCodeReturn).bytes()...)
+	vm := NewVM(b.build())
+	vm.MaxArrayLength = 3
+	err := vm.ExecMain()
+	var ex *JavaException
+	require.ErrorAs(t, err, &ex)
+	require.Equal(t, "java/lang/NegativeArraySizeException: -1", ex.Error())
+}
+
+func TestVirtualMachine_ExecMain_NativePanic(t *testing.T) {
+	// Math.sqrt(1) with an int argument, which only the verifier rejects
+	b := newClassBuilder("Main", "java/lang/Object")
+	code := newAsm().
+		label("try").op(OpCodeIconst0+1).ref(OpCodeInvokeStatic, b.methodRef("java/lang/Math", "sqrt", "(D)D")).op(OpCodePop2).label("end").
+		op(OpCodeReturn).
+		label("catch").ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Throwable", "getMessage", "()Ljava/lang/String;")).op(OpCodeAstore0).
+		ref(OpCodeGetStatic, b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")).op(OpCodeAload0).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")).
+		op(OpCodeReturn)
+	b.methodWithHandlers(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 1, code.bytes(), []*Exception{
+		{StartPC: code.pc("try"), EndPC: code.pc("end"), HandlerPC: code.pc("catch"), CatchType: b.classRef("java/lang/InternalError")},
+	})
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "native java/lang/Math.sqrt(D)D: interface conversion: jvmgo.Value is int32, not float64\n", stdout.String())
+}
+
+func TestVirtualMachine_ExecMain_BytecodePanic(t *testing.T) {
+	// getfield on an array, which only the verifier rejects
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.field(0, "x", "I")
+	get := newAsm().
+		op(OpCodeIconst0+1).op(OpCodeNewArray, ArrayTypeInt).ref(OpCodeGetField, b.fieldRef("Main", "x", "I")).op(OpCodeIreturn)
+	b.method(AccStatic, "get", "()I", 1, 0, get.bytes()...)
+	code := newAsm().
+		label("try").ref(OpCodeInvokeStatic, b.methodRef("Main", "get", "()I")).op(OpCodePop).label("end").
+		op(OpCodeReturn).
+		label("catch").ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Throwable", "getMessage", "()Ljava/lang/String;")).op(OpCodeAstore0).
+		ref(OpCodeGetStatic, b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")).op(OpCodeAload0).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")).
+		op(OpCodeReturn)
+	b.methodWithHandlers(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 1, code.bytes(), []*Exception{
+		{StartPC: code.pc("try"), EndPC: code.pc("end"), HandlerPC: code.pc("catch"), CatchType: b.classRef("java/lang/InternalError")},
+	})
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "Main.get()I at pc=3: runtime error: index out of range [0] with length 0\n", stdout.String())
+
+	// an uncaught one is an error of the entry point, not a crash of the host
+	_, err := vm.Invoke("Main", "get", "()I")
+	var ex *JavaException
+	require.ErrorAs(t, err, &ex)
+	require.Equal(t, "java/lang/InternalError", ex.Object.ClassName())
+}
@@ -0,0 +1,65 @@
+package jvmgo
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type (
+	// MemberRef is a field or method reference with its symbolic names resolved.
+	MemberRef struct {
+		ClassName  string
+		Name       string
+		Descriptor string
+		// Interface is set for CONSTANT_InterfaceMethodref.
+		Interface bool
+	}
+)
+
+func (c *ClassStructure) GetMemberRef(idx uint16) (*MemberRef, error) {
+	info := c.GetCpInfo(idx)
+	switch info.Tag {
+	case ConstantKindFieldref, ConstantKindMethodref, ConstantKindInterfaceMethodref:
+	default:
+		return nil, fmt.Errorf("constant kind mismatch. kind should be member ref: %d", info.Tag)
+	}
+	if len(info.Info) < 4 {
+		return nil, fmt.Errorf("cp info is invalid as kind member ref")
+	}
+
+	className, err := c.ClassName(binary.BigEndian.Uint16(info.Info[:2]))
+	if err != nil {
+		return nil, fmt.Errorf("get member ref class name: %w", err)
+	}
+	name, desc, err := c.GetNameAndType(binary.BigEndian.Uint16(info.Info[2:]))
+	if err != nil {
+		return nil, fmt.Errorf("get member ref name and type: %w", err)
+	}
+
+	return &MemberRef{
+		ClassName:  className,
+		Name:       name,
+		Descriptor: desc,
+		Interface:  info.Tag == ConstantKindInterfaceMethodref,
+	}, nil
+}
+
+func (c *ClassStructure) GetNameAndType(idx uint16) (string, string, error) {
+	nameAndType, err := c.GetCpInfo(idx).ToNameAndType()
+	if err != nil {
+		return "", "", err
+	}
+	name, err := c.GetCpInfo(nameAndType.NameIndex).GetAsUTF8String()
+	if err != nil {
+		return "", "", fmt.Errorf("get name: %w", err)
+	}
+	desc, err := c.GetCpInfo(nameAndType.DescriptorIndex).GetAsUTF8String()
+	if err != nil {
+		return "", "", fmt.Errorf("get descriptor: %w", err)
+	}
+	return name, desc, nil
+}
+
+func (r *MemberRef) String() string {
+	return r.ClassName + "." + r.Name + ":" + r.Descriptor
+}
@@ -56,3 +56,28 @@ func (m *MethodInfo) readAttributes(r io.Reader) error {
 
 	return nil
 }
+
+func (m *MethodInfo) Name(c *ClassStructure) (string, error) {
+	return c.GetCpInfo(m.NameIndex).GetAsUTF8String()
+}
+
+func (m *MethodInfo) Descriptor(c *ClassStructure) (*MethodDescriptor, error) {
+	desc, err := c.GetCpInfo(m.DescriptorIndex).GetAsUTF8String()
+	if err != nil {
+		return nil, fmt.Errorf("get method descriptor: %w", err)
+	}
+	return parseMethodDescriptor(desc)
+}
+
+func (m *MethodInfo) CodeAttribute(c *ClassStructure) (*CodeAttribute, error) {
+	for _, a := range m.Attributes {
+		name, err := c.GetCpInfo(a.AttributeNameIndex).GetAsUTF8String()
+		if err != nil {
+			return nil, fmt.Errorf("get attribute name: %w", err)
+		}
+		if name == "Code" {
+			return a.toCodeAttribute()
+		}
+	}
+	return nil, fmt.Errorf("code attribute does not exist")
+}
@@ -0,0 +1,225 @@
+package jvmgo
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// monitor is the reentrant lock every object carries for synchronized
+// blocks and methods, together with its wait set.
+type monitor struct {
+	mu    sync.Mutex
+	owner *thread
+	count int
+	// released is closed, and replaced, whenever the monitor becomes free
+	released chan struct{}
+	waiters  []chan struct{}
+}
+
+func (o *Object) monitor() *monitor {
+	if p := atomic.LoadPointer(&o.mon); p != nil {
+		return (*monitor)(p)
+	}
+	m := &monitor{released: make(chan struct{})}
+	if atomic.CompareAndSwapPointer(&o.mon, nil, unsafe.Pointer(m)) {
+		return m
+	}
+	return (*monitor)(atomic.LoadPointer(&o.mon))
+}
+
+// ownedBy reports whether t holds the monitor.
+func (m *monitor) ownedBy(t *thread) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.owner == t
+}
+
+// acquire blocks until t owns the monitor and adds count to its recursion
+// count. Blocked threads wait on a channel rather than on the mutex, so that
+// a halting VM can release them.
+func (vm *VirtualMachine) acquire(m *monitor, t *thread, count int) error {
+	for {
+		m.mu.Lock()
+		if m.owner == nil || m.owner == t {
+			m.owner = t
+			m.count += count
+			m.mu.Unlock()
+			return nil
+		}
+		released := m.released
+		m.mu.Unlock()
+		left := vm.leaveWorld(t)
+		select {
+		case <-released:
+		case <-vm.exitCh:
+			return vm.exitError()
+		}
+		if left {
+			vm.enterWorld(t)
+		}
+	}
+}
+
+// release gives up every entry of t into the monitor and returns how many
+// there were, or 0 when t is not the owner. m.mu must be held.
+func (m *monitor) release(t *thread) int {
+	if m.owner != t {
+		return 0
+	}
+	count := m.count
+	m.owner, m.count = nil, 0
+	close(m.released)
+	m.released = make(chan struct{})
+	return count
+}
+
+// monitorEnter implements monitorenter for the thread running f.
+func (vm *VirtualMachine) monitorEnter(f *Frame, obj *Object) error {
+	return vm.acquire(obj.monitor(), vm.currentThread(f), 1)
+}
+
+// monitorExit implements monitorexit, throwing IllegalMonitorStateException
+// when the thread running f does not own the monitor.
+func (vm *VirtualMachine) monitorExit(f *Frame, obj *Object) error {
+	m, t := obj.monitor(), vm.currentThread(f)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.owner != t {
+		return vm.throwNew(f, "java/lang/IllegalMonitorStateException", "")
+	}
+	m.count--
+	if m.count == 0 {
+		m.release(t)
+	}
+	return nil
+}
+
+// invokeSynchronized runs a synchronized method holding the monitor of its
+// receiver, or of its class when it is static.
+func (vm *VirtualMachine) invokeSynchronized(caller *Frame, m *RuntimeMethod, args []Value) (Value, error) {
+	var lock *Object
+	if m.IsStatic() {
+		mirror, err := vm.ClassMirror(m.Class)
+		if err != nil {
+			return nil, err
+		}
+		lock = mirror
+	} else {
+		lock = args[0].(*Object)
+	}
+	if err := vm.monitorEnter(caller, lock); err != nil {
+		return nil, err
+	}
+	ret, err := vm.invokeUnsynchronized(caller, m, args)
+	if exitErr := vm.monitorExit(caller, lock); err == nil && exitErr != nil {
+		return nil, exitErr
+	}
+	return ret, err
+}
+
+// wait implements Object.wait: the current thread releases the monitor of
+// obj until it is notified, interrupted or millis milliseconds pass, unless
+// millis is zero, and then takes the monitor back.
+func (vm *VirtualMachine) wait(caller *Frame, obj *Object, millis int64) error {
+	if millis < 0 {
+		return vm.throwNew(caller, "java/lang/IllegalArgumentException", "timeout value is negative")
+	}
+	m, t := obj.monitor(), vm.currentThread(caller)
+	m.mu.Lock()
+	if m.owner != t {
+		m.mu.Unlock()
+		return vm.throwNew(caller, "java/lang/IllegalMonitorStateException", "current thread is not owner")
+	}
+	if t.isInterrupted(true) {
+		m.mu.Unlock()
+		return vm.throwNew(caller, "java/lang/InterruptedException", "")
+	}
+	notified := make(chan struct{}, 1)
+	m.waiters = append(m.waiters, notified)
+	count := m.release(t)
+	m.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if millis > 0 {
+		timer := time.NewTimer(time.Duration(millis) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	left := vm.leaveWorld(t)
+	interrupted := false
+wait:
+	for {
+		select {
+		case <-notified:
+			break wait
+		case <-timeout:
+			break wait
+		case <-t.wake:
+			if t.isInterrupted(true) {
+				interrupted = true
+				break wait
+			}
+		case <-vm.exitCh:
+			return vm.exitError()
+		}
+	}
+	if left {
+		vm.enterWorld(t)
+	}
+
+	m.mu.Lock()
+	for i, w := range m.waiters {
+		if w == notified {
+			m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+			break
+		}
+	}
+	m.mu.Unlock()
+	if err := vm.acquire(m, t, count); err != nil {
+		return err
+	}
+	if interrupted {
+		return vm.throwNew(caller, "java/lang/InterruptedException", "")
+	}
+	return nil
+}
+
+// notify wakes one thread waiting on obj, or all of them when all is set.
+func (vm *VirtualMachine) notify(caller *Frame, obj *Object, all bool) error {
+	m := obj.monitor()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.owner != vm.currentThread(caller) {
+		return vm.throwNew(caller, "java/lang/IllegalMonitorStateException", "current thread is not owner")
+	}
+	n := len(m.waiters)
+	if !all && n > 1 {
+		n = 1
+	}
+	for _, w := range m.waiters[:n] {
+		w <- struct{}{}
+	}
+	m.waiters = m.waiters[n:]
+	return nil
+}
+
+func registerMonitorNatives(r *NativeRegistry) {
+	r.Register("java/lang/Object", "wait", "(J)V", func(frame *Frame, args []Value) (Value, error) {
+		return nil, frame.VM.wait(frame, args[0].(*Object), args[1].(int64))
+	})
+	r.Register("java/lang/Object", "notify", "()V", func(frame *Frame, args []Value) (Value, error) {
+		return nil, frame.VM.notify(frame, args[0].(*Object), false)
+	})
+	r.Register("java/lang/Object", "notifyAll", "()V", func(frame *Frame, args []Value) (Value, error) {
+		return nil, frame.VM.notify(frame, args[0].(*Object), true)
+	})
+	r.Register("java/lang/Thread", "holdsLock", "(Ljava/lang/Object;)Z", func(frame *Frame, args []Value) (Value, error) {
+		obj, ok := args[0].(*Object)
+		if !ok || obj == nil {
+			return nil, frame.VM.throwNullPointer(frame)
+		}
+		return javaBool(obj.monitor().ownedBy(frame.VM.currentThread(frame))), nil
+	})
+}
@@ -0,0 +1,147 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// monitorClassPath holds
+//
+//	class Box {
+//	  int value; boolean full;
+//	  synchronized void put(int v) { while (full) wait(); value = v; full = true; notifyAll(); }
+//	  synchronized int take() { while (!full) wait(); full = false; notifyAll(); return value; }
+//	}
+//	class Producer extends Thread { public void run() { for (int i = 1; i <= 100; i++) Main.box.put(i); } }
+//	class Counter extends Thread { public void run() { for (int i = 0; i < 1000; i++) synchronized (Main.lock) { Main.count++; } } }
+func monitorClassPath() mapClassPath {
+	cp := mapClassPath{}
+	class := func(name, super string) *classBuilder {
+		b := newClassBuilder(name, super)
+		superInit := b.methodRef(super, "<init>", "()V")
+		b.method(AccPublic, "<init>", "()V", 1, 1, newAsm().
+			op(OpCodeAload0).ref(OpCodeInvokeSpecial, superInit).op(OpCodeReturn).bytes()...)
+		return b
+	}
+
+	b := class("Box", "java/lang/Object")
+	b.field(0, "value", "I")
+	b.field(0, "full", "Z")
+	value := b.fieldRef("Box", "value", "I")
+	full := b.fieldRef("Box", "full", "Z")
+	wait := b.methodRef("java/lang/Object", "wait", "()V")
+	notifyAll := b.methodRef("java/lang/Object", "notifyAll", "()V")
+	b.method(AccSynchronized, "put", "(I)V", 2, 2, newAsm().
+		label("loop").op(OpCodeAload0).ref(OpCodeGetField, full).branch(OpCodeIfeq, "store").
+		op(OpCodeAload0).ref(OpCodeInvokeVirtual, wait).branch(OpCodeGoto, "loop").
+		label("store").op(OpCodeAload0).op(OpCodeIload0+1).ref(OpCodePutField, value).
+		op(OpCodeAload0).op(OpCodeIconst0+1).ref(OpCodePutField, full).
+		op(OpCodeAload0).ref(OpCodeInvokeVirtual, notifyAll).op(OpCodeReturn).bytes()...)
+	b.method(AccSynchronized, "take", "()I", 2, 1, newAsm().
+		label("loop").op(OpCodeAload0).ref(OpCodeGetField, full).branch(OpCodeIfne, "take").
+		op(OpCodeAload0).ref(OpCodeInvokeVirtual, wait).branch(OpCodeGoto, "loop").
+		label("take").op(OpCodeAload0).op(OpCodeIconst0).ref(OpCodePutField, full).
+		op(OpCodeAload0).ref(OpCodeInvokeVirtual, notifyAll).
+		op(OpCodeAload0).ref(OpCodeGetField, value).op(OpCodeIreturn).bytes()...)
+	cp.add(b.build())
+
+	p := class("Producer", "java/lang/Thread")
+	p.method(AccPublic, "run", "()V", 2, 2, newAsm().
+		op(OpCodeIconst0+1).op(OpCodeIstore0+1).
+		label("loop").op(OpCodeIload0+1).op(OpCodeBipush, 100).branch(OpCodeIfIcmpgt, "end").
+		ref(OpCodeGetStatic, p.fieldRef("Main", "box", "LBox;")).op(OpCodeIload0+1).
+		ref(OpCodeInvokeVirtual, p.methodRef("Box", "put", "(I)V")).
+		op(OpCodeIinc, 1, 1).branch(OpCodeGoto, "loop").
+		label("end").op(OpCodeReturn).bytes()...)
+	cp.add(p.build())
+
+	c := class("Counter", "java/lang/Thread")
+	count := c.fieldRef("Main", "count", "I")
+	c.method(AccPublic, "run", "()V", 2, 3, newAsm().
+		op(OpCodeIconst0).op(OpCodeIstore0+1).
+		label("loop").op(OpCodeIload0+1).op(OpCodeSipush, hi(1000), lo(1000)).branch(OpCodeIfIcmpge, "end").
+		ref(OpCodeGetStatic, c.fieldRef("Main", "lock", "Ljava/lang/Object;")).op(OpCodeDup).op(OpCodeAstore0+2).
+		op(OpCodeMonitorEnter).
+		ref(OpCodeGetStatic, count).op(OpCodeIconst0+1).op(OpCodeIadd).ref(OpCodePutStatic, count).
+		op(OpCodeAload0+2).op(OpCodeMonitorExit).
+		op(OpCodeIinc, 1, 1).branch(OpCodeGoto, "loop").
+		label("end").op(OpCodeReturn).bytes()...)
+	cp.add(c.build())
+	return cp
+}
+
+func TestVirtualMachine_ExecMain_Monitors(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.field(AccStatic, "box", "LBox;")
+	b.field(AccStatic, "lock", "Ljava/lang/Object;")
+	b.field(AccStatic, "count", "I")
+	box := b.fieldRef("Main", "box", "LBox;")
+	lock := b.fieldRef("Main", "lock", "Ljava/lang/Object;")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printInt := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	printStr := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+	printBool := b.methodRef("java/io/PrintStream", "println", "(Z)V")
+	start := b.methodRef("java/lang/Thread", "start", "()V")
+	join := b.methodRef("java/lang/Thread", "join", "()V")
+	holdsLock := b.methodRef("java/lang/Thread", "holdsLock", "(Ljava/lang/Object;)Z")
+	newObject := func(a *asm, name string) *asm {
+		return a.ref(OpCodeNew, b.classRef(name)).op(OpCodeDup).ref(OpCodeInvokeSpecial, b.methodRef(name, "<init>", "()V"))
+	}
+
+	code := newObject(newAsm(), "Box").ref(OpCodePutStatic, box)
+	newObject(code, "java/lang/Object").ref(OpCodePutStatic, lock)
+	// the producer and main hand 1..100 over through the box
+	newObject(code, "Producer").ref(OpCodeInvokeVirtual, start).
+		op(OpCodeIconst0).op(OpCodeIstore0+1).op(OpCodeIconst0).op(OpCodeIstore0+2).
+		label("take").op(OpCodeIload0+2).op(OpCodeBipush, 100).branch(OpCodeIfIcmpge, "taken").
+		op(OpCodeIload0+1).ref(OpCodeGetStatic, box).ref(OpCodeInvokeVirtual, b.methodRef("Box", "take", "()I")).
+		op(OpCodeIadd).op(OpCodeIstore0+1).op(OpCodeIinc, 2, 1).branch(OpCodeGoto, "take").
+		label("taken").ref(OpCodeGetStatic, out).op(OpCodeIload0+1).ref(OpCodeInvokeVirtual, printInt)
+	// no increment of the counters gets lost
+	for i := byte(3); i < 7; i++ {
+		newObject(code, "Counter").op(OpCodeDup).op(OpCodeAstore, i).ref(OpCodeInvokeVirtual, start)
+	}
+	for i := byte(3); i < 7; i++ {
+		code.op(OpCodeAload, i).ref(OpCodeInvokeVirtual, join)
+	}
+	code.ref(OpCodeGetStatic, out).ref(OpCodeGetStatic, b.fieldRef("Main", "count", "I")).ref(OpCodeInvokeVirtual, printInt).
+		// monitors are reentrant
+		ref(OpCodeGetStatic, lock).op(OpCodeMonitorEnter).ref(OpCodeGetStatic, lock).op(OpCodeMonitorEnter).
+		ref(OpCodeGetStatic, lock).op(OpCodeMonitorExit).
+		ref(OpCodeGetStatic, out).ref(OpCodeGetStatic, lock).ref(OpCodeInvokeStatic, holdsLock).ref(OpCodeInvokeVirtual, printBool).
+		// wait(5) returns without a notification and takes the monitor back
+		ref(OpCodeGetStatic, lock).op(OpCodeLdc, lo(b.integer(5))).op(OpCodeI2l).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Object", "wait", "(J)V")).
+		ref(OpCodeGetStatic, lock).op(OpCodeMonitorExit).
+		ref(OpCodeGetStatic, out).ref(OpCodeGetStatic, lock).ref(OpCodeInvokeStatic, holdsLock).ref(OpCodeInvokeVirtual, printBool).
+		label("notify").ref(OpCodeGetStatic, lock).ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Object", "notify", "()V")).label("notifyEnd").
+		op(OpCodeReturn).
+		label("handler").ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Throwable", "getMessage", "()Ljava/lang/String;")).op(OpCodeAstore0+1).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).ref(OpCodeInvokeVirtual, printStr).op(OpCodeReturn)
+	b.methodWithHandlers(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 3, 7, code.bytes(), []*Exception{
+		{StartPC: code.pc("notify"), EndPC: code.pc("notifyEnd"), HandlerPC: code.pc("handler"),
+			CatchType: b.classRef("java/lang/IllegalMonitorStateException")},
+	})
+
+	vm := NewVM(b.build())
+	vm.ClassPath = monitorClassPath()
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "5050\n4000\ntrue\nfalse\ncurrent thread is not owner\n", stdout.String())
+}
+
+func TestVirtualMachine_Wait_Interrupted(t *testing.T) {
+	vm := NewVM(newClassBuilder("Main", "java/lang/Object").build())
+	obj := &Object{}
+	require.EqualError(t, vm.monitorExit(nil, obj), "java/lang/IllegalMonitorStateException: ")
+	require.NoError(t, vm.monitorEnter(nil, obj))
+	vm.main.interrupt()
+	require.EqualError(t, vm.wait(nil, obj, 0), "java/lang/InterruptedException: ")
+	require.False(t, vm.main.isInterrupted(false))
+	require.True(t, obj.monitor().ownedBy(vm.main))
+	require.NoError(t, vm.monitorExit(nil, obj))
+	require.False(t, obj.monitor().ownedBy(vm.main))
+}
@@ -0,0 +1,299 @@
+package jvmgo
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// NativeMethod implements a Java method in Go. frame belongs to the native
+	// method itself and frame.Caller is the invoking frame. args holds the
+	// receiver (for instance methods) followed by the arguments. The returned
+	// value is ignored for void methods.
+	NativeMethod   func(frame *Frame, args []Value) (Value, error)
+	NativeRegistry struct {
+		// generation counts the changes, so methods can cache what they found
+		generation uint64
+		mu         sync.RWMutex
+		methods    map[string]nativeEntry
+	}
+	nativeEntry struct {
+		fn        NativeMethod
+		intrinsic bool
+	}
+	// intrinsicCache is the intrinsic of a method, nil for none, as of a
+	// generation of a registry.
+	intrinsicCache struct {
+		natives    *NativeRegistry
+		generation uint64
+		fn         NativeMethod
+	}
+)
+
+// nanoStart is the origin of System.nanoTime, which the monotonic clock
+// reading of time.Now keeps from jumping with the wall clock.
+var nanoStart = time.Now()
+
+func NewNativeRegistry() *NativeRegistry {
+	r := &NativeRegistry{methods: map[string]nativeEntry{}}
+	registerBuiltinNatives(r)
+	registerJDKNatives(r)
+	registerBuiltinClasses(r)
+	return r
+}
+
+func nativeKey(className, methodName, descriptor string) string {
+	return className + "." + methodName + ":" + descriptor
+}
+
+// Register binds fn to a method declared native, or to a method of a class the
+// class path does not provide.
+func (r *NativeRegistry) Register(className, methodName, descriptor string, fn NativeMethod) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	atomic.AddUint64(&r.generation, 1)
+	r.methods[nativeKey(className, methodName, descriptor)] = nativeEntry{fn: fn}
+}
+
+// RegisterIntrinsic binds fn to a method and takes precedence over its bytecode.
+func (r *NativeRegistry) RegisterIntrinsic(className, methodName, descriptor string, fn NativeMethod) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	atomic.AddUint64(&r.generation, 1)
+	r.methods[nativeKey(className, methodName, descriptor)] = nativeEntry{fn: fn, intrinsic: true}
+}
+
+func (r *NativeRegistry) Unregister(className, methodName, descriptor string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	atomic.AddUint64(&r.generation, 1)
+	delete(r.methods, nativeKey(className, methodName, descriptor))
+}
+
+func (r *NativeRegistry) Lookup(className, methodName, descriptor string) (NativeMethod, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.methods[nativeKey(className, methodName, descriptor)]
+	return e.fn, ok
+}
+
+func (r *NativeRegistry) Intrinsic(className, methodName, descriptor string) (NativeMethod, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.methods[nativeKey(className, methodName, descriptor)]
+	return e.fn, ok && e.intrinsic
+}
+
+// intrinsic returns the intrinsic of m, looking it up again only after the
+// registry changed.
+func (vm *VirtualMachine) intrinsic(m *RuntimeMethod) (NativeMethod, bool) {
+	generation := atomic.LoadUint64(&vm.Natives.generation)
+	if c, _ := m.intrinsic.Load().(*intrinsicCache); c != nil && c.natives == vm.Natives && c.generation == generation {
+		return c.fn, c.fn != nil
+	}
+	fn, ok := vm.Natives.Intrinsic(m.Class.Name, m.Name, m.Descriptor)
+	if !ok {
+		fn = nil
+	}
+	m.intrinsic.Store(&intrinsicCache{natives: vm.Natives, generation: generation, fn: fn})
+	return fn, ok
+}
+
+func registerBuiltinNatives(r *NativeRegistry) {
+	r.Register("java/lang/Object", "hashCode", "()I", func(frame *Frame, args []Value) (Value, error) {
+		obj, ok := args[0].(*Object)
+		if !ok {
+			return nil, fmt.Errorf("hashCode: receiver is not an object: %v", args[0])
+		}
+		return obj.IdentityHashCode(), nil
+	})
+	r.Register("java/lang/System", "identityHashCode", "(Ljava/lang/Object;)I", func(frame *Frame, args []Value) (Value, error) {
+		obj, ok := args[0].(*Object)
+		if !ok {
+			return int32(0), nil
+		}
+		return obj.IdentityHashCode(), nil
+	})
+	r.Register("java/lang/System", "nanoTime", "()J", func(frame *Frame, args []Value) (Value, error) {
+		return int64(time.Since(nanoStart)), nil
+	})
+	r.Register("java/lang/System", "currentTimeMillis", "()J", func(frame *Frame, args []Value) (Value, error) {
+		return time.Now().UnixNano() / int64(time.Millisecond), nil
+	})
+	r.Register("java/lang/System", "arraycopy", "(Ljava/lang/Object;ILjava/lang/Object;II)V", nativeArraycopy)
+	r.Register("java/lang/String", "intern", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+		obj := args[0].(*Object)
+		return frame.VM.intern(javaStringValue(obj), obj), nil
+	})
+
+	for name, fn := range map[string]func(float64) float64{
+		"sqrt":  math.Sqrt,
+		"cbrt":  math.Cbrt,
+		"sin":   math.Sin,
+		"cos":   math.Cos,
+		"tan":   math.Tan,
+		"asin":  math.Asin,
+		"acos":  math.Acos,
+		"atan":  math.Atan,
+		"exp":   math.Exp,
+		"log":   math.Log,
+		"log10": math.Log10,
+		"floor": math.Floor,
+		"ceil":  math.Ceil,
+		"rint":  math.RoundToEven,
+	} {
+		fn := fn
+		r.RegisterIntrinsic("java/lang/Math", name, "(D)D", func(frame *Frame, args []Value) (Value, error) {
+			return fn(args[0].(float64)), nil
+		})
+		r.Register("java/lang/StrictMath", name, "(D)D", func(frame *Frame, args []Value) (Value, error) {
+			return fn(args[0].(float64)), nil
+		})
+	}
+	r.RegisterIntrinsic("java/lang/Math", "pow", "(DD)D", func(frame *Frame, args []Value) (Value, error) {
+		return math.Pow(args[0].(float64), args[1].(float64)), nil
+	})
+	r.RegisterIntrinsic("java/lang/Math", "atan2", "(DD)D", func(frame *Frame, args []Value) (Value, error) {
+		return math.Atan2(args[0].(float64), args[1].(float64)), nil
+	})
+
+	for _, desc := range []string{
+		"()V", "(Ljava/lang/String;)V", "(Ljava/lang/Object;)V",
+		"(I)V", "(J)V", "(F)V", "(D)V", "(Z)V", "(C)V", "([C)V",
+	} {
+		desc := desc
+		r.Register("java/io/PrintStream", "println", desc, func(frame *Frame, args []Value) (Value, error) {
+			s, err := printArgs(frame, desc, args[1:])
+			if err != nil {
+				return nil, err
+			}
+			return nil, printStreamOf(frame, args[0]).println(s...)
+		})
+		if desc == "()V" {
+			continue
+		}
+		r.Register("java/io/PrintStream", "print", desc, func(frame *Frame, args []Value) (Value, error) {
+			s, err := printArgs(frame, desc, args[1:])
+			if err != nil {
+				return nil, err
+			}
+			return nil, printStreamOf(frame, args[0]).print(s...)
+		})
+	}
+}
+
+func nativeArraycopy(frame *Frame, args []Value) (Value, error) {
+	src, _ := args[0].(*Object)
+	dst, _ := args[2].(*Object)
+	srcPos, dstPos, length := int(args[1].(int32)), int(args[3].(int32)), int(args[4].(int32))
+	if src == nil || dst == nil {
+		return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+	}
+	if srcPos < 0 || dstPos < 0 || length < 0 ||
+		srcPos+length > src.ArrayLength() || dstPos+length > dst.ArrayLength() {
+		return nil, throwOrError(frame, "java/lang/ArrayIndexOutOfBoundsException",
+			fmt.Sprintf("arraycopy: last source index %d out of bounds for length %d", srcPos+length, src.ArrayLength()))
+	}
+
+	var n int
+	switch s := src.Array.(type) {
+	case []int8:
+		d, ok := dst.Array.([]int8)
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/ArrayStoreException",
+				fmt.Sprintf("arraycopy: type mismatch: can not copy %s into %s", src.ClassName(), dst.ClassName()))
+		}
+		n = copy(d[dstPos:dstPos+length], s[srcPos:srcPos+length])
+	case []uint16:
+		d, ok := dst.Array.([]uint16)
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/ArrayStoreException",
+				fmt.Sprintf("arraycopy: type mismatch: can not copy %s into %s", src.ClassName(), dst.ClassName()))
+		}
+		n = copy(d[dstPos:dstPos+length], s[srcPos:srcPos+length])
+	case []int16:
+		d, ok := dst.Array.([]int16)
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/ArrayStoreException",
+				fmt.Sprintf("arraycopy: type mismatch: can not copy %s into %s", src.ClassName(), dst.ClassName()))
+		}
+		n = copy(d[dstPos:dstPos+length], s[srcPos:srcPos+length])
+	case []int32:
+		d, ok := dst.Array.([]int32)
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/ArrayStoreException",
+				fmt.Sprintf("arraycopy: type mismatch: can not copy %s into %s", src.ClassName(), dst.ClassName()))
+		}
+		n = copy(d[dstPos:dstPos+length], s[srcPos:srcPos+length])
+	case []int64:
+		d, ok := dst.Array.([]int64)
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/ArrayStoreException",
+				fmt.Sprintf("arraycopy: type mismatch: can not copy %s into %s", src.ClassName(), dst.ClassName()))
+		}
+		n = copy(d[dstPos:dstPos+length], s[srcPos:srcPos+length])
+	case []float32:
+		d, ok := dst.Array.([]float32)
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/ArrayStoreException",
+				fmt.Sprintf("arraycopy: type mismatch: can not copy %s into %s", src.ClassName(), dst.ClassName()))
+		}
+		n = copy(d[dstPos:dstPos+length], s[srcPos:srcPos+length])
+	case []float64:
+		d, ok := dst.Array.([]float64)
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/ArrayStoreException",
+				fmt.Sprintf("arraycopy: type mismatch: can not copy %s into %s", src.ClassName(), dst.ClassName()))
+		}
+		n = copy(d[dstPos:dstPos+length], s[srcPos:srcPos+length])
+	case []Value:
+		d, ok := dst.Array.([]Value)
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/ArrayStoreException",
+				fmt.Sprintf("arraycopy: type mismatch: can not copy %s into %s", src.ClassName(), dst.ClassName()))
+		}
+		if elem := dst.Class.component; elem != nil && !src.Class.IsAssignableTo(dst.Class) {
+			// elements up to the first one of the wrong type are copied
+			for i, v := range s[srcPos : srcPos+length] {
+				if o, ok := v.(*Object); ok && o != nil && !o.Class.IsAssignableTo(elem) {
+					return nil, throwOrError(frame, "java/lang/ArrayStoreException",
+						fmt.Sprintf("arraycopy: element type mismatch: can not cast one of the elements of %s to the type of the destination array, %s",
+							javaTypeName(src.ClassName()), javaClassName(elem.Name)))
+				}
+				d[dstPos+i] = v
+			}
+			return nil, nil
+		}
+		n = copy(d[dstPos:dstPos+length], s[srcPos:srcPos+length])
+	default:
+		return nil, throwOrError(frame, "java/lang/ArrayStoreException",
+			fmt.Sprintf("arraycopy: source type %s is not an array", src.ClassName()))
+	}
+	if n != length {
+		return nil, fmt.Errorf("arraycopy: copied %d of %d elements", n, length)
+	}
+
+	return nil, nil
+}
+
+func printStreamOf(frame *Frame, receiver Value) PrintStream {
+	if obj, ok := receiver.(*Object); ok {
+		if ps, ok := obj.Extra.(PrintStream); ok {
+			return ps
+		}
+	}
+	vm := frame.VM
+	return PrintStream{w: writerFunc(func(p []byte) (int, error) { return vm.write(vm.Out, p) })}
+}
+
+// throwOrError raises a Java exception when the native runs inside a VM and
+// falls back to a plain error when it is called directly.
+func throwOrError(frame *Frame, className, message string) error {
+	if frame == nil || frame.VM == nil {
+		return fmt.Errorf("%s: %s", className, message)
+	}
+	return frame.VM.throwNew(frame, className, message)
+}
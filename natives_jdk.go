@@ -0,0 +1,430 @@
+package jvmgo
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// registerJDKNatives registers the natives that HotSpot provides to java.base
+// and that System.initPhase1 reaches.
+func registerJDKNatives(r *NativeRegistry) {
+	noop := func(frame *Frame, args []Value) (Value, error) { return nil, nil }
+	for _, class := range []string{
+		"java/lang/Object", "java/lang/Class", "java/lang/System", "java/lang/Thread",
+		"java/lang/ClassLoader", "jdk/internal/misc/Unsafe", "jdk/internal/misc/VM",
+		"jdk/internal/misc/ScopedMemoryAccess", "java/lang/invoke/MethodHandleNatives",
+		"java/io/FileDescriptor", "java/io/FileInputStream", "java/io/FileOutputStream",
+		"java/io/UnixFileSystem", "java/io/WinNTFileSystem", "java/io/RandomAccessFile",
+	} {
+		r.Register(class, "registerNatives", "()V", noop)
+		r.Register(class, "initIDs", "()V", noop)
+	}
+
+	registerObjectNatives(r)
+	registerClassNatives(r)
+	registerSystemNatives(r)
+	registerUnsafeNatives(r)
+
+	r.Register("jdk/internal/misc/VM", "initialize", "()V", noop)
+	r.Register("jdk/internal/misc/VM", "initializeFromArchive", "(Ljava/lang/Class;)V", noop)
+	r.Register("jdk/internal/misc/VM", "latestUserDefinedLoader0", "()Ljava/lang/ClassLoader;", noop)
+	r.Register("jdk/internal/misc/CDS", "initializeFromArchive", "(Ljava/lang/Class;)V", noop)
+	r.Register("jdk/internal/misc/CDS", "defineArchivedModules", "(Ljava/lang/ClassLoader;Ljava/lang/ClassLoader;)V", noop)
+	for _, name := range []string{"isDumpingClassList0", "isDumpingArchive0", "isSharingEnabled0"} {
+		r.Register("jdk/internal/misc/CDS", name, "()Z", func(frame *Frame, args []Value) (Value, error) {
+			return int32(0), nil
+		})
+	}
+	r.Register("jdk/internal/misc/CDS", "getRandomSeedForDumping", "()J", func(frame *Frame, args []Value) (Value, error) {
+		return int64(0), nil
+	})
+
+	r.Register("jdk/internal/reflect/Reflection", "getCallerClass", "()Ljava/lang/Class;", func(frame *Frame, args []Value) (Value, error) {
+		// skip getCallerClass itself and the method asking for its caller
+		f := frame.Caller
+		for i := 0; f != nil; f = f.Caller {
+			if f.Method == nil || f.Method.Name == "invoke" && f.Method.Class.Name == "java/lang/reflect/Method" {
+				continue
+			}
+			if i == 1 {
+				return frame.VM.ClassMirror(f.Class)
+			}
+			i++
+		}
+		return nil, nil
+	})
+	r.Register("jdk/internal/reflect/Reflection", "getClassAccessFlags", "(Ljava/lang/Class;)I", func(frame *Frame, args []Value) (Value, error) {
+		c, ok := classFromMirror(args[0])
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+		}
+		return int32(c.AccessFlags), nil
+	})
+
+	// core reflection of the class library reads members and calls them
+	// through these natives, which are not implemented
+	for _, n := range [][3]string{
+		{"java/lang/Class", "getDeclaredMethods0", "(Z)[Ljava/lang/reflect/Method;"},
+		{"java/lang/Class", "getDeclaredFields0", "(Z)[Ljava/lang/reflect/Field;"},
+		{"java/lang/Class", "getDeclaredConstructors0", "(Z)[Ljava/lang/reflect/Constructor;"},
+		{"java/lang/Class", "getRawAnnotations", "()[B"},
+		{"java/lang/Class", "getConstantPool", "()Ljdk/internal/reflect/ConstantPool;"},
+		{"jdk/internal/reflect/NativeMethodAccessorImpl", "invoke0", "(Ljava/lang/reflect/Method;Ljava/lang/Object;[Ljava/lang/Object;)Ljava/lang/Object;"},
+		{"jdk/internal/reflect/NativeConstructorAccessorImpl", "newInstance0", "(Ljava/lang/reflect/Constructor;[Ljava/lang/Object;)Ljava/lang/Object;"},
+	} {
+		r.Register(n[0], n[1], n[2], unsupportedReflection)
+	}
+
+	registerThreadNatives(r)
+	registerMonitorNatives(r)
+
+	r.Register("java/lang/Shutdown", "beforeHalt", "()V", noop)
+	r.Register("java/lang/Shutdown", "halt0", "(I)V", func(frame *Frame, args []Value) (Value, error) {
+		return nil, &ExitError{Code: int(args[0].(int32))}
+	})
+	r.Register("java/lang/Runtime", "availableProcessors", "()I", func(frame *Frame, args []Value) (Value, error) {
+		return int32(runtime.NumCPU()), nil
+	})
+	registerHeapNatives(r)
+	registerReferenceNatives(r)
+
+	r.Register("java/lang/Float", "floatToRawIntBits", "(F)I", func(frame *Frame, args []Value) (Value, error) {
+		return int32(math.Float32bits(args[0].(float32))), nil
+	})
+	r.Register("java/lang/Float", "intBitsToFloat", "(I)F", func(frame *Frame, args []Value) (Value, error) {
+		return math.Float32frombits(uint32(args[0].(int32))), nil
+	})
+	r.Register("java/lang/Double", "doubleToRawLongBits", "(D)J", func(frame *Frame, args []Value) (Value, error) {
+		return int64(math.Float64bits(args[0].(float64))), nil
+	})
+	r.Register("java/lang/Double", "longBitsToDouble", "(J)D", func(frame *Frame, args []Value) (Value, error) {
+		return math.Float64frombits(uint64(args[0].(int64))), nil
+	})
+	r.Register("java/lang/StringUTF16", "isBigEndian", "()Z", func(frame *Frame, args []Value) (Value, error) {
+		return int32(0), nil
+	})
+
+	r.Register("java/lang/Throwable", "fillInStackTrace", "(I)Ljava/lang/Throwable;", func(frame *Frame, args []Value) (Value, error) {
+		return args[0], nil
+	})
+
+	for _, action := range []string{"java/security/PrivilegedAction", "java/security/PrivilegedExceptionAction"} {
+		run := func(frame *Frame, args []Value) (Value, error) {
+			obj, _ := args[0].(*Object)
+			return frame.VM.InvokeVirtual(frame, obj, "run", "()Ljava/lang/Object;")
+		}
+		r.Register("java/security/AccessController", "doPrivileged", "(L"+action+";)Ljava/lang/Object;", run)
+		r.Register("java/security/AccessController", "doPrivileged", "(L"+action+";Ljava/security/AccessControlContext;)Ljava/lang/Object;", run)
+	}
+	r.Register("java/security/AccessController", "getStackAccessControlContext", "()Ljava/security/AccessControlContext;", noop)
+	r.Register("java/security/AccessController", "getInheritedAccessControlContext", "()Ljava/security/AccessControlContext;", noop)
+
+	r.Register("java/io/FileDescriptor", "getHandle", "(I)J", func(frame *Frame, args []Value) (Value, error) {
+		return int64(-1), nil
+	})
+	r.Register("java/io/FileDescriptor", "getAppend", "(I)Z", func(frame *Frame, args []Value) (Value, error) {
+		return int32(0), nil
+	})
+	r.Register("java/io/FileOutputStream", "writeBytes", "([BIIZ)V", func(frame *Frame, args []Value) (Value, error) {
+		stream, _ := args[0].(*Object)
+		buf, _ := args[1].(*Object)
+		if stream == nil || buf == nil {
+			return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+		}
+		off, n := int(args[2].(int32)), int(args[3].(int32))
+		data := buf.Array.([]int8)
+		if off < 0 || n < 0 || off+n > len(data) {
+			return nil, throwOrError(frame, "java/lang/IndexOutOfBoundsException", "")
+		}
+		out := make([]byte, n)
+		for i := range out {
+			out[i] = byte(data[off+i])
+		}
+		fdObj, _ := stream.GetField("fd", "Ljava/io/FileDescriptor;")
+		fd := int32(-1)
+		if o, ok := fdObj.(*Object); ok && o != nil {
+			v, _ := o.GetField("fd", "I")
+			fd, _ = v.(int32)
+		}
+		var err error
+		switch fd {
+		case 1:
+			_, err = frame.VM.write(frame.VM.Out, out)
+		case 2:
+			_, err = frame.VM.write(frame.VM.Err, out)
+		default:
+			return nil, throwOrError(frame, "java/io/IOException", fmt.Sprintf("writing to fd %d is not supported", fd))
+		}
+		if err != nil {
+			return nil, throwOrError(frame, "java/io/IOException", err.Error())
+		}
+		return nil, nil
+	})
+
+	r.Register("jdk/internal/misc/Signal", "findSignal0", "(Ljava/lang/String;)I", func(frame *Frame, args []Value) (Value, error) {
+		return int32(-1), nil
+	})
+	r.Register("jdk/internal/misc/Signal", "handle0", "(IJ)J", func(frame *Frame, args []Value) (Value, error) {
+		return int64(0), nil
+	})
+}
+
+func registerObjectNatives(r *NativeRegistry) {
+	r.Register("java/lang/Object", "getClass", "()Ljava/lang/Class;", func(frame *Frame, args []Value) (Value, error) {
+		c, err := frame.VM.classOf(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return frame.VM.ClassMirror(c)
+	})
+	r.Register("java/lang/Object", "clone", "()Ljava/lang/Object;", func(frame *Frame, args []Value) (Value, error) {
+		obj, ok := args[0].(*Object)
+		if !ok {
+			return args[0], nil
+		}
+		c := obj.shallowCopy()
+		if err := frame.VM.allocate(frame, c); err != nil {
+			return nil, err
+		}
+		return c, nil
+	})
+}
+
+func registerClassNatives(r *NativeRegistry) {
+	r.Register("java/lang/Class", "desiredAssertionStatus0", "(Ljava/lang/Class;)Z", func(frame *Frame, args []Value) (Value, error) {
+		return int32(0), nil
+	})
+	r.Register("java/lang/Class", "getPrimitiveClass", "(Ljava/lang/String;)Ljava/lang/Class;", func(frame *Frame, args []Value) (Value, error) {
+		c, err := frame.VM.primitiveClass(javaStringValue(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		return frame.VM.ClassMirror(c)
+	})
+	classPredicate := func(pred func(c *RuntimeClass) bool) NativeMethod {
+		return func(frame *Frame, args []Value) (Value, error) {
+			c, _ := classFromMirror(args[0])
+			if c != nil && pred(c) {
+				return int32(1), nil
+			}
+			return int32(0), nil
+		}
+	}
+	r.Register("java/lang/Class", "isArray", "()Z", classPredicate((*RuntimeClass).IsArray))
+	r.Register("java/lang/Class", "isInterface", "()Z", classPredicate((*RuntimeClass).IsInterface))
+	r.Register("java/lang/Class", "isPrimitive", "()Z", classPredicate((*RuntimeClass).IsPrimitive))
+	r.Register("java/lang/Class", "getModifiers", "()I", func(frame *Frame, args []Value) (Value, error) {
+		c, _ := classFromMirror(args[0])
+		return int32(c.AccessFlags &^ AccSuper), nil
+	})
+	r.Register("java/lang/Class", "getSuperclass", "()Ljava/lang/Class;", func(frame *Frame, args []Value) (Value, error) {
+		c, _ := classFromMirror(args[0])
+		if c.Super == nil || c.IsInterface() {
+			return nil, nil
+		}
+		return frame.VM.ClassMirror(c.Super)
+	})
+	r.Register("java/lang/Class", "initClassName", "()Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+		c, _ := classFromMirror(args[0])
+		name := frame.VM.NewString(javaClassName(c.Name))
+		args[0].(*Object).SetField("name", "Ljava/lang/String;", name)
+		return name, nil
+	})
+	r.Register("java/lang/Class", "forName0", "(Ljava/lang/String;ZLjava/lang/ClassLoader;Ljava/lang/Class;)Ljava/lang/Class;", func(frame *Frame, args []Value) (Value, error) {
+		name := binaryClassName(javaStringValue(args[0]))
+		c, err := frame.VM.LoadClass(name)
+		if err != nil {
+			return nil, throwOrError(frame, "java/lang/ClassNotFoundException", javaStringValue(args[0]))
+		}
+		if args[1].(int32) != 0 {
+			if err := frame.VM.initializeClass(frame, c); err != nil {
+				return nil, err
+			}
+		}
+		return frame.VM.ClassMirror(c)
+	})
+}
+
+func registerSystemNatives(r *NativeRegistry) {
+	setStream := func(field string) NativeMethod {
+		return func(frame *Frame, args []Value) (Value, error) {
+			system, err := frame.VM.LoadClass("java/lang/System")
+			if err != nil {
+				return nil, err
+			}
+			f := system.DeclaredField(field, "")
+			if f == nil || !f.IsStatic() {
+				return nil, fmt.Errorf("System.%s does not exist", field)
+			}
+			storeSlot(system.StaticValues, f.Slot, args[0])
+			return nil, nil
+		}
+	}
+	r.Register("java/lang/System", "setIn0", "(Ljava/io/InputStream;)V", setStream("in"))
+	r.Register("java/lang/System", "setOut0", "(Ljava/io/PrintStream;)V", setStream("out"))
+	r.Register("java/lang/System", "setErr0", "(Ljava/io/PrintStream;)V", setStream("err"))
+	r.Register("java/lang/System", "mapLibraryName", "(Ljava/lang/String;)Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+		return frame.VM.NewString("lib" + javaStringValue(args[0]) + ".so"), nil
+	})
+	r.Register("java/lang/System", "initProperties", "(Ljava/util/Properties;)Ljava/util/Properties;", func(frame *Frame, args []Value) (Value, error) {
+		props, _ := args[0].(*Object)
+		for _, kv := range systemProperties(frame.VM) {
+			if _, err := frame.VM.InvokeVirtual(frame, props, "setProperty",
+				"(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/Object;",
+				frame.VM.NewString(kv[0]), frame.VM.NewString(kv[1])); err != nil {
+				return nil, err
+			}
+		}
+		return props, nil
+	})
+	// JDK 17 and later read the properties through SystemProps.Raw
+	r.Register("jdk/internal/util/SystemProps$Raw", "vmProperties", "()[Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+		var flat []Value
+		for _, kv := range systemProperties(frame.VM) {
+			flat = append(flat, frame.VM.NewString(kv[0]), frame.VM.NewString(kv[1]))
+		}
+		return frame.VM.newObjectArray("java/lang/String", flat)
+	})
+	r.Register("jdk/internal/util/SystemProps$Raw", "platformProperties", "()[Ljava/lang/String;", func(frame *Frame, args []Value) (Value, error) {
+		raw, err := frame.VM.LoadClass("jdk/internal/util/SystemProps$Raw")
+		if err != nil {
+			return nil, err
+		}
+		// the array is indexed by the _xxx_NDX constants of SystemProps.Raw
+		n := 0
+		for _, f := range raw.Fields {
+			if f.IsStatic() && f.Descriptor == "I" {
+				if v, ok := loadSlot(raw.StaticValues, f.Slot).(int32); ok && int(v) >= n {
+					n = int(v) + 1
+				}
+			}
+		}
+		values := make([]Value, n)
+		for name, value := range map[string]string{
+			"_file_encoding_NDX":    "UTF-8",
+			"_file_separator_NDX":   string(filepath.Separator),
+			"_line_separator_NDX":   "\n",
+			"_path_separator_NDX":   string(filepath.ListSeparator),
+			"_os_name_NDX":          osName(),
+			"_os_arch_NDX":          runtime.GOARCH,
+			"_sun_jnu_encoding_NDX": "UTF-8",
+			"_java_io_tmpdir_NDX":   os.TempDir(),
+			"_user_dir_NDX":         workingDir(),
+			"_user_home_NDX":        homeDir(),
+			"_user_name_NDX":        os.Getenv("USER"),
+		} {
+			f := raw.DeclaredField(name, "I")
+			if f == nil {
+				continue
+			}
+			if idx, ok := loadSlot(raw.StaticValues, f.Slot).(int32); ok && int(idx) < n {
+				values[idx] = frame.VM.NewString(value)
+			}
+		}
+		return frame.VM.newObjectArray("java/lang/String", values)
+	})
+}
+
+func systemProperties(vm *VirtualMachine) [][2]string {
+	return [][2]string{
+		{"java.vm.name", "jvmgo"},
+		{"java.vm.vendor", "jvmgo"},
+		{"java.vm.version", "0.1"},
+		{"java.vm.specification.name", "Java Virtual Machine Specification"},
+		{"java.home", vm.JavaHome},
+		{"java.class.path", ""},
+		{"java.library.path", ""},
+		{"sun.boot.library.path", ""},
+		{"file.encoding", "UTF-8"},
+		{"sun.jnu.encoding", "UTF-8"},
+		{"file.separator", string(filepath.Separator)},
+		{"path.separator", string(filepath.ListSeparator)},
+		{"line.separator", "\n"},
+		{"os.name", osName()},
+		{"os.arch", runtime.GOARCH},
+		{"os.version", ""},
+		{"user.dir", workingDir()},
+		{"user.home", homeDir()},
+		{"user.name", os.Getenv("USER")},
+		{"java.io.tmpdir", os.TempDir()},
+	}
+}
+
+func osName() string {
+	switch runtime.GOOS {
+	case "linux":
+		return "Linux"
+	case "darwin":
+		return "Mac OS X"
+	case "windows":
+		return "Windows"
+	}
+	return runtime.GOOS
+}
+
+func workingDir() string {
+	wd, _ := os.Getwd()
+	return wd
+}
+
+func homeDir() string {
+	home, _ := os.UserHomeDir()
+	return home
+}
+
+func (vm *VirtualMachine) newObjectArray(componentName string, elems []Value) (*Object, error) {
+	class, err := vm.LoadClass(arrayClassName(componentName))
+	if err != nil {
+		return nil, err
+	}
+	arr := NewArray(class, elems)
+	vm.track(arr)
+	return arr, nil
+}
+
+func (o *Object) shallowCopy() *Object {
+	c := &Object{Class: o.Class, Extra: o.Extra, hash: nextIdentityHash()}
+	if o.Fields != nil {
+		c.Fields = append([]Value{}, o.Fields...)
+	}
+	switch a := o.Array.(type) {
+	case []int8:
+		c.Array = append([]int8{}, a...)
+	case []uint16:
+		c.Array = append([]uint16{}, a...)
+	case []int16:
+		c.Array = append([]int16{}, a...)
+	case []int32:
+		c.Array = append([]int32{}, a...)
+	case []int64:
+		c.Array = append([]int64{}, a...)
+	case []float32:
+		c.Array = append([]float32{}, a...)
+	case []float64:
+		c.Array = append([]float64{}, a...)
+	case []Value:
+		c.Array = append([]Value{}, a...)
+	}
+	return c
+}
+
+// binaryClassName converts "java.lang.String" or "[Ljava.lang.String;" to the
+// internal form.
+func binaryClassName(name string) string {
+	b := []byte(name)
+	for i := range b {
+		if b[i] == '.' {
+			b[i] = '/'
+		}
+	}
+	return string(b)
+}
+
+// unsupportedReflection rejects core reflection on the class library of a
+// JDK. Reflection and annotations work with the bundled runtime only.
+func unsupportedReflection(frame *Frame, args []Value) (Value, error) {
+	return nil, throwOrError(frame, "java/lang/UnsupportedOperationException", "core reflection is not supported with the class library of a JDK, only with the bundled runtime")
+}
@@ -0,0 +1,267 @@
+package jvmgo
+
+import (
+	"fmt"
+)
+
+// Field offsets handed out by Unsafe are slot numbers and array offsets are
+// element indexes, which arrayBaseOffset 0 and arrayIndexScale 1 produce.
+// Static field offsets are slot numbers past staticFieldOffset, so that they
+// do not name the instance fields of the Class object staticFieldBase returns.
+const staticFieldOffset int64 = 1 << 32
+
+func registerUnsafeNatives(r *NativeRegistry) {
+	const unsafe = "jdk/internal/misc/Unsafe"
+	constant := func(v Value) NativeMethod {
+		return func(frame *Frame, args []Value) (Value, error) { return v, nil }
+	}
+	r.Register(unsafe, "arrayBaseOffset0", "(Ljava/lang/Class;)I", constant(int32(0)))
+	r.Register(unsafe, "arrayIndexScale0", "(Ljava/lang/Class;)I", constant(int32(1)))
+	r.Register(unsafe, "addressSize0", "()I", constant(int32(8)))
+	r.Register(unsafe, "pageSize", "()I", constant(int32(4096)))
+	r.Register(unsafe, "isBigEndian0", "()Z", constant(int32(0)))
+	r.Register(unsafe, "unalignedAccess0", "()Z", constant(int32(0)))
+	for _, name := range []string{"storeFence", "loadFence", "fullFence"} {
+		r.Register(unsafe, name, "()V", constant(nil))
+	}
+
+	r.Register(unsafe, "objectFieldOffset1", "(Ljava/lang/Class;Ljava/lang/String;)J", func(frame *Frame, args []Value) (Value, error) {
+		c, ok := classFromMirror(args[1])
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+		}
+		name := javaStringValue(args[2])
+		f := c.DeclaredField(name, "")
+		if f == nil || f.IsStatic() {
+			return nil, throwOrError(frame, "java/lang/InternalError", name)
+		}
+		return int64(f.Slot), nil
+	})
+	r.Register(unsafe, "staticFieldOffset0", "(Ljava/lang/reflect/Field;)J", func(frame *Frame, args []Value) (Value, error) {
+		f, err := unsafeStaticField(frame, args[1])
+		if err != nil {
+			return nil, err
+		}
+		return staticFieldOffset + int64(f.Slot), nil
+	})
+	r.Register(unsafe, "staticFieldBase0", "(Ljava/lang/reflect/Field;)Ljava/lang/Object;", func(frame *Frame, args []Value) (Value, error) {
+		f, err := unsafeStaticField(frame, args[1])
+		if err != nil {
+			return nil, err
+		}
+		return frame.VM.ClassMirror(f.Class)
+	})
+	r.Register(unsafe, "ensureClassInitialized0", "(Ljava/lang/Class;)V", func(frame *Frame, args []Value) (Value, error) {
+		c, ok := classFromMirror(args[1])
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+		}
+		return nil, frame.VM.initializeClass(frame, c)
+	})
+	r.Register(unsafe, "shouldBeInitialized0", "(Ljava/lang/Class;)Z", func(frame *Frame, args []Value) (Value, error) {
+		c, ok := classFromMirror(args[1])
+		if ok && c.initState != classInitialized {
+			return int32(1), nil
+		}
+		return int32(0), nil
+	})
+	r.Register(unsafe, "allocateInstance", "(Ljava/lang/Class;)Ljava/lang/Object;", func(frame *Frame, args []Value) (Value, error) {
+		c, ok := classFromMirror(args[1])
+		if !ok {
+			return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+		}
+		if err := frame.VM.initializeClass(frame, c); err != nil {
+			return nil, err
+		}
+		obj := NewObject(c)
+		if err := frame.VM.allocate(frame, obj); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	})
+
+	for _, t := range []struct {
+		name string
+		desc string
+	}{
+		{"Int", "I"}, {"Long", "J"}, {"Reference", "Ljava/lang/Object;"}, {"Boolean", "Z"},
+		{"Byte", "B"}, {"Short", "S"}, {"Char", "C"}, {"Float", "F"}, {"Double", "D"},
+	} {
+		// every access takes the lock of its slot, so a plain one is volatile
+		r.Register(unsafe, "get"+t.name, "(Ljava/lang/Object;J)"+t.desc, unsafeGetVolatile(t.desc))
+		r.Register(unsafe, "get"+t.name+"Volatile", "(Ljava/lang/Object;J)"+t.desc, unsafeGetVolatile(t.desc))
+		r.Register(unsafe, "put"+t.name, "(Ljava/lang/Object;J"+t.desc+")V", unsafePutVolatile(t.desc))
+		r.Register(unsafe, "put"+t.name+"Volatile", "(Ljava/lang/Object;J"+t.desc+")V", unsafePutVolatile(t.desc))
+	}
+	for _, t := range []struct {
+		name string
+		desc string
+	}{
+		{"Int", "I"}, {"Long", "J"}, {"Reference", "Ljava/lang/Object;"},
+	} {
+		t := t
+		r.Register(unsafe, "compareAndSet"+t.name, "(Ljava/lang/Object;J"+t.desc+t.desc+")Z", unsafeCompareAndSet(t.desc))
+		r.Register(unsafe, "compareAndExchange"+t.name, "(Ljava/lang/Object;J"+t.desc+t.desc+")"+t.desc, func(frame *Frame, args []Value) (Value, error) {
+			return unsafeUpdate(frame, args[1], args[2].(int64), t.desc, func(old Value) (Value, bool) {
+				return args[4], sameValue(old, args[3])
+			})
+		})
+	}
+	registerUnsafeAtomics(r, unsafe, []string{"Int", "I", "Long", "J", "Reference", "Ljava/lang/Object;"})
+
+	// sun.misc.Unsafe delegates to jdk.internal.misc.Unsafe in the class
+	// library; the intrinsics spare code written against it the delegation
+	const sunUnsafe = "sun/misc/Unsafe"
+	for _, t := range []struct {
+		name string
+		desc string
+	}{
+		{"Int", "I"}, {"Long", "J"}, {"Object", "Ljava/lang/Object;"},
+	} {
+		r.RegisterIntrinsic(sunUnsafe, "compareAndSwap"+t.name, "(Ljava/lang/Object;J"+t.desc+t.desc+")Z", unsafeCompareAndSet(t.desc))
+		r.RegisterIntrinsic(sunUnsafe, "get"+t.name+"Volatile", "(Ljava/lang/Object;J)"+t.desc, unsafeGetVolatile(t.desc))
+		r.RegisterIntrinsic(sunUnsafe, "put"+t.name+"Volatile", "(Ljava/lang/Object;J"+t.desc+")V", unsafePutVolatile(t.desc))
+	}
+	registerUnsafeAtomics(r, sunUnsafe, []string{"Int", "I", "Long", "J", "Object", "Ljava/lang/Object;"})
+}
+
+// registerUnsafeAtomics registers getAndSet, and getAndAdd for int and long,
+// as intrinsics of class. types holds pairs of a method name suffix and a
+// descriptor.
+func registerUnsafeAtomics(r *NativeRegistry, class string, types []string) {
+	for i := 0; i < len(types); i += 2 {
+		name, desc := types[i], types[i+1]
+		r.RegisterIntrinsic(class, "getAndSet"+name, "(Ljava/lang/Object;J"+desc+")"+desc, func(frame *Frame, args []Value) (Value, error) {
+			return unsafeUpdate(frame, args[1], args[2].(int64), desc, func(old Value) (Value, bool) {
+				return args[3], true
+			})
+		})
+		if desc != "I" && desc != "J" {
+			continue
+		}
+		r.RegisterIntrinsic(class, "getAndAdd"+name, "(Ljava/lang/Object;J"+desc+")"+desc, func(frame *Frame, args []Value) (Value, error) {
+			return unsafeUpdate(frame, args[1], args[2].(int64), desc, func(old Value) (Value, bool) {
+				if desc == "I" {
+					return old.(int32) + args[3].(int32), true
+				}
+				return old.(int64) + args[3].(int64), true
+			})
+		})
+	}
+}
+
+// unsafeStaticField returns the static field a java.lang.reflect.Field
+// reflects.
+func unsafeStaticField(frame *Frame, field Value) (*RuntimeField, error) {
+	if isNull(field) {
+		return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+	}
+	f := reflectedField(field)
+	if f == nil || !f.IsStatic() {
+		return nil, throwOrError(frame, "java/lang/IllegalArgumentException", "not a static field")
+	}
+	return f, nil
+}
+
+func unsafeGetVolatile(desc string) NativeMethod {
+	return func(frame *Frame, args []Value) (Value, error) {
+		return unsafeUpdate(frame, args[1], args[2].(int64), desc, func(old Value) (Value, bool) {
+			return nil, false
+		})
+	}
+}
+
+func unsafePutVolatile(desc string) NativeMethod {
+	return func(frame *Frame, args []Value) (Value, error) {
+		_, err := unsafeUpdate(frame, args[1], args[2].(int64), desc, func(old Value) (Value, bool) {
+			return args[3], true
+		})
+		return nil, err
+	}
+}
+
+func unsafeCompareAndSet(desc string) NativeMethod {
+	return func(frame *Frame, args []Value) (Value, error) {
+		swapped := false
+		_, err := unsafeUpdate(frame, args[1], args[2].(int64), desc, func(old Value) (Value, bool) {
+			swapped = sameValue(old, args[3])
+			return args[4], swapped
+		})
+		return javaBool(swapped), err
+	}
+}
+
+// unsafeUpdate reads the location at offset in target and replaces it with
+// what fn returns when fn says so. It holds the lock of the location
+// throughout, the one getfield and getstatic take too, which makes the update
+// atomic with respect to the other accesses, and returns the value read.
+func unsafeUpdate(frame *Frame, target Value, offset int64, desc string, fn func(old Value) (Value, bool)) (Value, error) {
+	obj, _ := target.(*Object)
+	if obj == nil {
+		return nil, throwOrError(frame, "java/lang/UnsupportedOperationException", "Unsafe access to off-heap memory")
+	}
+	if obj.Array != nil {
+		if offset < 0 || int(offset) >= obj.ArrayLength() {
+			return nil, throwOrError(frame, "java/lang/InternalError", fmt.Sprintf("Unsafe access to %s at index %d", obj.ClassName(), offset))
+		}
+		mu := elementLock(obj, int(offset))
+		mu.Lock()
+		defer mu.Unlock()
+		old, err := elementValue(obj, int(offset))
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := fn(old); ok {
+			unsafePutElement(obj, int(offset), v)
+		}
+		return old, nil
+	}
+
+	values, slot := obj.Fields, offset
+	if c, ok := classFromMirror(obj); ok && offset >= staticFieldOffset {
+		values, slot = c.StaticValues, offset-staticFieldOffset
+	}
+	if slot < 0 || slot >= int64(len(values)) {
+		return nil, throwOrError(frame, "java/lang/InternalError", fmt.Sprintf("Unsafe access to %s at offset %d", obj.ClassName(), offset))
+	}
+	var old Value
+	updateSlot(values, int(slot), func(v Value) Value {
+		if old = v; old == nil {
+			old = zeroValue(desc)
+		}
+		if nv, ok := fn(old); ok {
+			return nv
+		}
+		return v
+	})
+	return old, nil
+}
+
+func unsafePutElement(arr *Object, idx int, v Value) {
+	switch a := arr.Array.(type) {
+	case []int8:
+		a[idx] = int8(v.(int32))
+	case []uint16:
+		a[idx] = uint16(v.(int32))
+	case []int16:
+		a[idx] = int16(v.(int32))
+	case []int32:
+		a[idx] = v.(int32)
+	case []int64:
+		a[idx] = v.(int64)
+	case []float32:
+		a[idx] = v.(float32)
+	case []float64:
+		a[idx] = v.(float64)
+	case []Value:
+		a[idx] = v
+	}
+}
+
+func sameValue(a, b Value) bool {
+	switch a.(type) {
+	case *Object, nil, string:
+		return sameReference(a, b)
+	}
+	return a == b
+}
@@ -0,0 +1,97 @@
+package jvmgo
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// NestHost returns the class named by the NestHost attribute, or "" when the
+// class has none.
+func (c *ClassStructure) NestHost() (string, error) {
+	a, err := c.attribute(c.Attributes, "NestHost")
+	if err != nil || a == nil {
+		return "", err
+	}
+	if len(a.Info) < 2 {
+		return "", fmt.Errorf("NestHost attribute is truncated")
+	}
+	return c.ClassName(binary.BigEndian.Uint16(a.Info))
+}
+
+// NestMembers returns the classes listed by the NestMembers attribute.
+func (c *ClassStructure) NestMembers() ([]string, error) {
+	a, err := c.attribute(c.Attributes, "NestMembers")
+	if err != nil || a == nil {
+		return nil, err
+	}
+	if len(a.Info) < 2 || len(a.Info) != 2+2*int(binary.BigEndian.Uint16(a.Info)) {
+		return nil, fmt.Errorf("NestMembers attribute is truncated")
+	}
+	members := make([]string, binary.BigEndian.Uint16(a.Info))
+	for i := range members {
+		name, err := c.ClassName(binary.BigEndian.Uint16(a.Info[2+2*i:]))
+		if err != nil {
+			return nil, fmt.Errorf("get nest member idx=%d: %w", i, err)
+		}
+		members[i] = name
+	}
+	return members, nil
+}
+
+// attribute returns the attribute called name among attrs, or nil.
+func (c *ClassStructure) attribute(attrs []*AttributeInfo, name string) (*AttributeInfo, error) {
+	for _, a := range attrs {
+		n, err := c.GetCpInfo(a.AttributeNameIndex).GetAsUTF8String()
+		if err != nil {
+			return nil, fmt.Errorf("get attribute name: %w", err)
+		}
+		if n == name {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+// nestHost returns the host of the nest c belongs to. As in JDK 15 and later,
+// a class whose claimed host cannot be loaded, is in another package or does
+// not list it as a member is the host of its own nest.
+func (vm *VirtualMachine) nestHost(c *RuntimeClass) *RuntimeClass {
+	c.nestHostOnce.Do(func() {
+		c.nestHost = c
+		if c.File == nil {
+			return
+		}
+		name, err := c.File.NestHost()
+		if err != nil || name == "" || name == c.Name || packageName(name) != packageName(c.Name) {
+			return
+		}
+		host, err := vm.LoadClass(name)
+		if err != nil || host.File == nil {
+			return
+		}
+		members, err := host.File.NestMembers()
+		if err != nil {
+			return
+		}
+		for _, m := range members {
+			if m == c.Name {
+				c.nestHost = host
+				return
+			}
+		}
+	})
+	return c.nestHost
+}
+
+// checkMethodAccess throws IllegalAccessError when caller may not access the
+// private method m, which it may only within its own nest.
+func (vm *VirtualMachine) checkMethodAccess(caller *Frame, m *RuntimeMethod) error {
+	if caller == nil || caller.Class == nil || !m.IsPrivate() || caller.Class == m.Class {
+		return nil
+	}
+	if vm.nestHost(caller.Class) == vm.nestHost(m.Class) {
+		return nil
+	}
+	return vm.throwNew(caller, "java/lang/IllegalAccessError", fmt.Sprintf("class %s tried to access private method '%s %s.%s'",
+		javaClassName(caller.Class.Name), javaTypeName(m.Desc.Return), javaClassName(m.Class.Name), m.Name+m.javaParameters()))
+}
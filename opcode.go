@@ -0,0 +1,196 @@
+package jvmgo
+
+const (
+	OpCodeNop             OpCode = 0x00
+	OpCodeAconstNull      OpCode = 0x01
+	OpCodeIconstM1        OpCode = 0x02
+	OpCodeIconst0         OpCode = 0x03
+	OpCodeIconst5         OpCode = 0x08
+	OpCodeLconst0         OpCode = 0x09
+	OpCodeLconst1         OpCode = 0x0a
+	OpCodeFconst0         OpCode = 0x0b
+	OpCodeFconst1         OpCode = 0x0c
+	OpCodeFconst2         OpCode = 0x0d
+	OpCodeDconst0         OpCode = 0x0e
+	OpCodeDconst1         OpCode = 0x0f
+	OpCodeBipush          OpCode = 0x10
+	OpCodeSipush          OpCode = 0x11
+	OpCodeLdc             OpCode = 0x12
+	OpCodeLdcW            OpCode = 0x13
+	OpCodeLdc2W           OpCode = 0x14
+	OpCodeIload           OpCode = 0x15
+	OpCodeLload           OpCode = 0x16
+	OpCodeFload           OpCode = 0x17
+	OpCodeDload           OpCode = 0x18
+	OpCodeAload           OpCode = 0x19
+	OpCodeIload0          OpCode = 0x1a
+	OpCodeLload0          OpCode = 0x1e
+	OpCodeFload0          OpCode = 0x22
+	OpCodeDload0          OpCode = 0x26
+	OpCodeAload0          OpCode = 0x2a
+	OpCodeAload3          OpCode = 0x2d
+	OpCodeIaload          OpCode = 0x2e
+	OpCodeLaload          OpCode = 0x2f
+	OpCodeFaload          OpCode = 0x30
+	OpCodeDaload          OpCode = 0x31
+	OpCodeAaload          OpCode = 0x32
+	OpCodeBaload          OpCode = 0x33
+	OpCodeCaload          OpCode = 0x34
+	OpCodeSaload          OpCode = 0x35
+	OpCodeIstore          OpCode = 0x36
+	OpCodeLstore          OpCode = 0x37
+	OpCodeFstore          OpCode = 0x38
+	OpCodeDstore          OpCode = 0x39
+	OpCodeAstore          OpCode = 0x3a
+	OpCodeIstore0         OpCode = 0x3b
+	OpCodeLstore0         OpCode = 0x3f
+	OpCodeFstore0         OpCode = 0x43
+	OpCodeDstore0         OpCode = 0x47
+	OpCodeAstore0         OpCode = 0x4b
+	OpCodeAstore3         OpCode = 0x4e
+	OpCodeIastore         OpCode = 0x4f
+	OpCodeLastore         OpCode = 0x50
+	OpCodeFastore         OpCode = 0x51
+	OpCodeDastore         OpCode = 0x52
+	OpCodeAastore         OpCode = 0x53
+	OpCodeBastore         OpCode = 0x54
+	OpCodeCastore         OpCode = 0x55
+	OpCodeSastore         OpCode = 0x56
+	OpCodePop             OpCode = 0x57
+	OpCodePop2            OpCode = 0x58
+	OpCodeDup             OpCode = 0x59
+	OpCodeDupX1           OpCode = 0x5a
+	OpCodeDupX2           OpCode = 0x5b
+	OpCodeDup2            OpCode = 0x5c
+	OpCodeDup2X1          OpCode = 0x5d
+	OpCodeDup2X2          OpCode = 0x5e
+	OpCodeSwap            OpCode = 0x5f
+	OpCodeIadd            OpCode = 0x60
+	OpCodeLadd            OpCode = 0x61
+	OpCodeFadd            OpCode = 0x62
+	OpCodeDadd            OpCode = 0x63
+	OpCodeIsub            OpCode = 0x64
+	OpCodeLsub            OpCode = 0x65
+	OpCodeFsub            OpCode = 0x66
+	OpCodeDsub            OpCode = 0x67
+	OpCodeImul            OpCode = 0x68
+	OpCodeLmul            OpCode = 0x69
+	OpCodeFmul            OpCode = 0x6a
+	OpCodeDmul            OpCode = 0x6b
+	OpCodeIdiv            OpCode = 0x6c
+	OpCodeLdiv            OpCode = 0x6d
+	OpCodeFdiv            OpCode = 0x6e
+	OpCodeDdiv            OpCode = 0x6f
+	OpCodeIrem            OpCode = 0x70
+	OpCodeLrem            OpCode = 0x71
+	OpCodeFrem            OpCode = 0x72
+	OpCodeDrem            OpCode = 0x73
+	OpCodeIneg            OpCode = 0x74
+	OpCodeLneg            OpCode = 0x75
+	OpCodeFneg            OpCode = 0x76
+	OpCodeDneg            OpCode = 0x77
+	OpCodeIshl            OpCode = 0x78
+	OpCodeLshl            OpCode = 0x79
+	OpCodeIshr            OpCode = 0x7a
+	OpCodeLshr            OpCode = 0x7b
+	OpCodeIushr           OpCode = 0x7c
+	OpCodeLushr           OpCode = 0x7d
+	OpCodeIand            OpCode = 0x7e
+	OpCodeLand            OpCode = 0x7f
+	OpCodeIor             OpCode = 0x80
+	OpCodeLor             OpCode = 0x81
+	OpCodeIxor            OpCode = 0x82
+	OpCodeLxor            OpCode = 0x83
+	OpCodeIinc            OpCode = 0x84
+	OpCodeI2l             OpCode = 0x85
+	OpCodeI2f             OpCode = 0x86
+	OpCodeI2d             OpCode = 0x87
+	OpCodeL2i             OpCode = 0x88
+	OpCodeL2f             OpCode = 0x89
+	OpCodeL2d             OpCode = 0x8a
+	OpCodeF2i             OpCode = 0x8b
+	OpCodeF2l             OpCode = 0x8c
+	OpCodeF2d             OpCode = 0x8d
+	OpCodeD2i             OpCode = 0x8e
+	OpCodeD2l             OpCode = 0x8f
+	OpCodeD2f             OpCode = 0x90
+	OpCodeI2b             OpCode = 0x91
+	OpCodeI2c             OpCode = 0x92
+	OpCodeI2s             OpCode = 0x93
+	OpCodeLcmp            OpCode = 0x94
+	OpCodeFcmpl           OpCode = 0x95
+	OpCodeFcmpg           OpCode = 0x96
+	OpCodeDcmpl           OpCode = 0x97
+	OpCodeDcmpg           OpCode = 0x98
+	OpCodeIfeq            OpCode = 0x99
+	OpCodeIfne            OpCode = 0x9a
+	OpCodeIflt            OpCode = 0x9b
+	OpCodeIfge            OpCode = 0x9c
+	OpCodeIfgt            OpCode = 0x9d
+	OpCodeIfle            OpCode = 0x9e
+	OpCodeIfIcmpeq        OpCode = 0x9f
+	OpCodeIfIcmpne        OpCode = 0xa0
+	OpCodeIfIcmplt        OpCode = 0xa1
+	OpCodeIfIcmpge        OpCode = 0xa2
+	OpCodeIfIcmpgt        OpCode = 0xa3
+	OpCodeIfIcmple        OpCode = 0xa4
+	OpCodeIfAcmpeq        OpCode = 0xa5
+	OpCodeIfAcmpne        OpCode = 0xa6
+	OpCodeGoto            OpCode = 0xa7
+	OpCodeJsr             OpCode = 0xa8
+	OpCodeRet             OpCode = 0xa9
+	OpCodeTableSwitch     OpCode = 0xaa
+	OpCodeLookupSwitch    OpCode = 0xab
+	OpCodeIreturn         OpCode = 0xac
+	OpCodeLreturn         OpCode = 0xad
+	OpCodeFreturn         OpCode = 0xae
+	OpCodeDreturn         OpCode = 0xaf
+	OpCodeAreturn         OpCode = 0xb0
+	OpCodeReturn          OpCode = 0xb1
+	OpCodeGetStatic       OpCode = 0xb2
+	OpCodePutStatic       OpCode = 0xb3
+	OpCodeGetField        OpCode = 0xb4
+	OpCodePutField        OpCode = 0xb5
+	OpCodeInvokeVirtual   OpCode = 0xb6
+	OpCodeInvokeSpecial   OpCode = 0xb7
+	OpCodeInvokeStatic    OpCode = 0xb8
+	OpCodeInvokeInterface OpCode = 0xb9
+	OpCodeInvokeDynamic   OpCode = 0xba
+	OpCodeNew             OpCode = 0xbb
+	OpCodeNewArray        OpCode = 0xbc
+	OpCodeANewArray       OpCode = 0xbd
+	OpCodeArrayLength     OpCode = 0xbe
+	OpCodeAThrow          OpCode = 0xbf
+	OpCodeCheckCast       OpCode = 0xc0
+	OpCodeInstanceOf      OpCode = 0xc1
+	OpCodeMonitorEnter    OpCode = 0xc2
+	OpCodeMonitorExit     OpCode = 0xc3
+	OpCodeWide            OpCode = 0xc4
+	OpCodeMultiANewArray  OpCode = 0xc5
+	OpCodeIfNull          OpCode = 0xc6
+	OpCodeIfNonNull       OpCode = 0xc7
+	OpCodeGotoW           OpCode = 0xc8
+	OpCodeJsrW            OpCode = 0xc9
+)
+
+const (
+	ArrayTypeBoolean = 4
+	ArrayTypeChar    = 5
+	ArrayTypeFloat   = 6
+	ArrayTypeDouble  = 7
+	ArrayTypeByte    = 8
+	ArrayTypeShort   = 9
+	ArrayTypeInt     = 10
+	ArrayTypeLong    = 11
+)
+
+var arrayTypeDescriptors = map[uint8]string{
+	ArrayTypeBoolean: "Z",
+	ArrayTypeChar:    "C",
+	ArrayTypeFloat:   "F",
+	ArrayTypeDouble:  "D",
+	ArrayTypeByte:    "B",
+	ArrayTypeShort:   "S",
+	ArrayTypeInt:     "I",
+	ArrayTypeLong:    "J",
+}
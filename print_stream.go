@@ -1,9 +1,85 @@
 package jvmgo
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
 
-type PrintStream struct{}
+type PrintStream struct {
+	w io.Writer
+}
+
+func (p PrintStream) println(args ...interface{}) error {
+	_, err := fmt.Fprintln(p.w, args...)
+	return err
+}
+
+func (p PrintStream) print(args ...interface{}) error {
+	_, err := fmt.Fprint(p.w, args...)
+	return err
+}
+
+// printArgs converts the argument of the print or println overload with
+// descriptor desc to the text it writes.
+func printArgs(frame *Frame, desc string, args []Value) ([]interface{}, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if desc == "([C)V" {
+		arr, _ := args[0].(*Object)
+		if arr == nil {
+			return nil, throwOrError(frame, "java/lang/NullPointerException", "")
+		}
+		return []interface{}{stringFromChars(arr.Array.([]uint16))}, nil
+	}
+	s, err := frame.VM.javaString(frame, desc[1:len(desc)-2], args[0])
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{s}, nil
+}
+
+// javaFloatString formats like Double.toString / Float.toString.
+func javaFloatString(f float64, bitSize int) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		if math.Signbit(f) {
+			return "-0.0"
+		}
+		return "0.0"
+	}
+
+	abs := math.Abs(f)
+	if abs >= 1e-3 && abs < 1e7 {
+		s := strconv.FormatFloat(f, 'f', -1, bitSize)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	}
 
-func (PrintStream) println(args ...interface{}) {
-	fmt.Println(args...)
+	s := strconv.FormatFloat(f, 'E', -1, bitSize)
+	mantissa, exp := s, ""
+	if i := strings.IndexByte(s, 'E'); i >= 0 {
+		mantissa, exp = s[:i], s[i+1:]
+	}
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	exp = strings.TrimPrefix(exp, "+")
+	if strings.HasPrefix(exp, "-") {
+		exp = "-" + strings.TrimLeft(exp[1:], "0")
+	} else {
+		exp = strings.TrimLeft(exp, "0")
+	}
+	return mantissa + "E" + exp
 }
@@ -0,0 +1,380 @@
+package jvmgo
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// the strength of a java.lang.ref.Reference, as the collector treats it
+const (
+	refNone = iota
+	refSoft
+	refWeak
+	refPhantom
+)
+
+type (
+	// pendingObject is an object the finalizer thread has to finalize or a
+	// reference it has to enqueue or clean.
+	pendingObject struct {
+		obj      *Object
+		finalize bool
+	}
+
+	// refQueue holds the references enqueued on a ReferenceQueue.
+	refQueue struct {
+		mu   sync.Mutex
+		refs []Value
+		// changed is closed when a reference is enqueued
+		changed chan struct{}
+	}
+)
+
+// linkReference finds out how the collector treats instances of c: whether
+// they are references, and of which strength, and whether they have to be
+// finalized.
+func (c *RuntimeClass) linkReference() {
+	switch c.Name {
+	case "java/lang/ref/SoftReference":
+		c.refKind = refSoft
+	case "java/lang/ref/WeakReference":
+		c.refKind = refWeak
+	case "java/lang/ref/PhantomReference":
+		c.refKind = refPhantom
+	default:
+		if c.Super != nil {
+			c.refKind = c.Super.refKind
+		}
+	}
+	c.referentSlot, c.queueSlot = -1, -1
+	if f := c.LookupField("referent", "Ljava/lang/Object;"); f != nil && !f.IsStatic() {
+		c.referentSlot = f.Slot
+	} else {
+		c.refKind = refNone
+	}
+	if f := c.LookupField("queue", "Ljava/lang/ref/ReferenceQueue;"); f != nil && !f.IsStatic() {
+		c.queueSlot = f.Slot
+	}
+
+	// like HotSpot, skip the finalize methods that only return
+	m := c.LookupMethod("finalize", "()V")
+	c.finalizer = m != nil && m.Class.Super != nil && !m.IsAbstract() &&
+		!(m.Code != nil && len(m.Code.Code) == 1 && OpCode(m.Code.Code[0]) == OpCodeReturn)
+}
+
+// discover reports whether obj is a reference whose referent the marker
+// leaves alone, and records it for processReferences.
+func (m *marker) discover(obj *Object) bool {
+	if obj.Class == nil || m.all {
+		return false
+	}
+	switch obj.Class.refKind {
+	case refNone:
+		return false
+	case refSoft:
+		if !m.clearSoft {
+			return false
+		}
+	}
+	m.refs = append(m.refs, obj)
+	return true
+}
+
+// processReferences runs once the strongly reachable objects are marked. It
+// clears the soft and weak references to unmarked objects and removes the
+// entries of weak maps with unmarked keys. Then it marks the finalizable
+// objects found unreachable again, so that they and what they refer to stay
+// until finalize has run, and last clears the phantom references, whose
+// referents are gone for good. It reports whether it queued work for the
+// finalizer thread.
+func (h *heap) processReferences(m *marker) bool {
+	queued := len(h.pending)
+	refs, maps := len(m.refs), len(m.weakMaps)
+	h.clearReferences(m, m.refs, false)
+	m.removeWeakKeys(m.weakMaps)
+
+	live := h.finalizable[:0]
+	for _, obj := range h.finalizable {
+		if obj.gcMark == m.epoch {
+			live = append(live, obj)
+			continue
+		}
+		h.pending = append(h.pending, pendingObject{obj: obj, finalize: true})
+		m.mark(obj)
+	}
+	for i := len(live); i < len(h.finalizable); i++ {
+		h.finalizable[i] = nil
+	}
+	h.finalizable = live
+	m.drain()
+
+	h.clearReferences(m, m.refs[refs:], false)
+	m.removeWeakKeys(m.weakMaps[maps:])
+	h.clearReferences(m, m.refs, true)
+	return len(h.pending) > queued
+}
+
+// clearReferences clears the phantom references among refs, or the others,
+// whose referents are not marked, and queues those that have a queue or a
+// cleaning action for the finalizer thread.
+func (h *heap) clearReferences(m *marker, refs []*Object, phantom bool) {
+	for _, ref := range refs {
+		c := ref.Class
+		if (c.refKind == refPhantom) != phantom {
+			continue
+		}
+		referent, _ := ref.Fields[c.referentSlot].(*Object)
+		if referent == nil || referent.gcMark == m.epoch {
+			continue
+		}
+		ref.Fields[c.referentSlot] = nil
+		if h.cleanables[ref] || c.queueSlot >= 0 && ref.Fields[c.queueSlot] != nil {
+			h.pending = append(h.pending, pendingObject{obj: ref})
+		}
+	}
+}
+
+func (m *marker) removeWeakKeys(maps []*hashMap) {
+	for _, wm := range maps {
+		wm.removeKeys(func(key *Object) bool {
+			return key.gcMark != m.epoch
+		})
+	}
+}
+
+// addCleanable keeps ref alive until clean runs its action.
+func (h *heap) addCleanable(ref *Object) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cleanables == nil {
+		h.cleanables = map[*Object]bool{}
+	}
+	h.cleanables[ref] = true
+}
+
+// removeCleanable reports whether the action of ref is still to run, making
+// sure it runs only once.
+func (h *heap) removeCleanable(ref *Object) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.cleanables[ref] {
+		return false
+	}
+	delete(h.cleanables, ref)
+	return true
+}
+
+func (h *heap) isCleanable(ref *Object) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cleanables[ref]
+}
+
+func (h *heap) nextPending() (pendingObject, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.pending) == 0 {
+		return pendingObject{}, false
+	}
+	p := h.pending[0]
+	h.pending[0] = pendingObject{}
+	h.pending = h.pending[1:]
+	return p, true
+}
+
+// wakeFinalizer signals the finalizer thread, starting it on first use.
+func (vm *VirtualMachine) wakeFinalizer() {
+	h := &vm.heap
+	h.finalizerOnce.Do(func() {
+		h.wakeFinalizer = make(chan struct{}, 1)
+		go vm.runFinalizer()
+	})
+	select {
+	case h.wakeFinalizer <- struct{}{}:
+	default:
+	}
+}
+
+// runFinalizer runs the finalizer thread, a daemon that calls the finalize
+// methods of unreachable objects, runs the actions of Cleaners and enqueues
+// the references the collector cleared. Like on HotSpot, exceptions thrown
+// by finalize or an action are ignored.
+func (vm *VirtualMachine) runFinalizer() {
+	obj, err := vm.newDaemonThread("Finalizer")
+	if err != nil {
+		vm.threadErrOnce.Do(func() { vm.threadErr = err })
+		return
+	}
+	t := newThread(obj, true)
+	vm.threadsMu.Lock()
+	vm.threads[obj] = t
+	vm.threadsMu.Unlock()
+
+	vm.enterWorld(t)
+	defer vm.leaveWorld(t)
+	root := &Frame{VM: vm, thread: t, OperandStack: &OperandStack{}}
+	for {
+		p, ok := vm.heap.nextPending()
+		if !ok {
+			vm.leaveWorld(t)
+			select {
+			case <-vm.heap.wakeFinalizer:
+			case <-vm.exitCh:
+				return
+			}
+			vm.enterWorld(t)
+			continue
+		}
+		switch {
+		case p.finalize:
+			_, err = vm.InvokeVirtual(root, p.obj, "finalize", "()V")
+		case vm.heap.isCleanable(p.obj):
+			_, err = vm.InvokeVirtual(root, p.obj, "clean", "()V")
+		default:
+			_, err = vm.InvokeVirtual(root, p.obj, "enqueue", "()Z")
+		}
+		var ex *JavaException
+		if err != nil && !errors.As(err, &ex) {
+			vm.uncaught(root, t, err)
+		}
+	}
+}
+
+// newDaemonThread creates the java.lang.Thread of a thread the VM runs.
+func (vm *VirtualMachine) newDaemonThread(name string) (*Object, error) {
+	class, err := vm.LoadClass("java/lang/Thread")
+	if err != nil {
+		return nil, err
+	}
+	obj := NewObject(class)
+	obj.SetField("name", "Ljava/lang/String;", vm.NewString(name))
+	obj.SetField("priority", "I", int32(8))
+	obj.SetField("daemon", "Z", int32(1))
+	obj.SetField("threadStatus", "I", int32(threadStatusRunnable))
+	return obj, nil
+}
+
+func refQueueOf(v Value) *refQueue {
+	obj := v.(*Object)
+	q, ok := obj.Extra.(*refQueue)
+	if !ok {
+		q = &refQueue{}
+		obj.Extra = q
+	}
+	return q
+}
+
+func (q *refQueue) push(ref *Object) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.refs = append(q.refs, ref)
+	if q.changed != nil {
+		close(q.changed)
+		q.changed = nil
+	}
+}
+
+// poll removes the first reference of the queue. When the queue is empty, it
+// returns a channel closed on the next push instead.
+func (q *refQueue) poll() (Value, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.refs) == 0 {
+		if q.changed == nil {
+			q.changed = make(chan struct{})
+		}
+		return nil, q.changed
+	}
+	ref := q.refs[0]
+	q.refs[0] = nil
+	q.refs = q.refs[1:]
+	return ref, nil
+}
+
+// removeReference waits for a reference to be enqueued on q, for at most
+// millis milliseconds unless millis is zero.
+func (vm *VirtualMachine) removeReference(caller *Frame, q *refQueue, millis int64) (Value, error) {
+	if millis < 0 {
+		return nil, vm.throwNew(caller, "java/lang/IllegalArgumentException", "Negative timeout value")
+	}
+	var timeout <-chan time.Time
+	if millis > 0 {
+		timer := time.NewTimer(time.Duration(millis) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	t := vm.currentThread(caller)
+	for {
+		if t.isInterrupted(true) {
+			return nil, vm.throwNew(caller, "java/lang/InterruptedException", "")
+		}
+		ref, changed := q.poll()
+		if changed == nil {
+			return ref, nil
+		}
+		// the queue is only taken from while in the world, so a reference
+		// removed is never missed by a collection
+		in := vm.leaveWorld(t)
+		var err error
+		select {
+		case <-changed:
+		case <-t.wake:
+		case <-timeout:
+			changed = nil
+		case <-vm.exitCh:
+			err = vm.exitError()
+		}
+		if in {
+			vm.enterWorld(t)
+		}
+		if err != nil || changed == nil {
+			return nil, err
+		}
+	}
+}
+
+// enqueueReference adds ref to its queue unless it has none or is already
+// enqueued.
+func enqueueReference(ref *Object) bool {
+	c := ref.Class
+	if c.queueSlot < 0 {
+		return false
+	}
+	q := updateSlot(ref.Fields, c.queueSlot, func(Value) Value { return nil })
+	if q == nil {
+		return false
+	}
+	refQueueOf(q).push(ref)
+	return true
+}
+
+func registerReferenceNatives(r *NativeRegistry) {
+	refersTo := func(frame *Frame, args []Value) (Value, error) {
+		ref := args[0].(*Object)
+		return javaBool(sameReference(loadSlot(ref.Fields, ref.Class.referentSlot), args[1])), nil
+	}
+	r.Register("java/lang/ref/Reference", "refersTo0", "(Ljava/lang/Object;)Z", refersTo)
+	r.Register("java/lang/ref/PhantomReference", "refersTo0", "(Ljava/lang/Object;)Z", refersTo)
+	r.Register("java/lang/ref/Reference", "clear0", "()V", func(frame *Frame, args []Value) (Value, error) {
+		ref := args[0].(*Object)
+		storeSlot(ref.Fields, ref.Class.referentSlot, nil)
+		return nil, nil
+	})
+	// the collector enqueues the references itself, so the pending list the
+	// reference handler thread of the class library waits on stays empty
+	r.Register("java/lang/ref/Reference", "getAndClearReferencePendingList", "()Ljava/lang/ref/Reference;", func(frame *Frame, args []Value) (Value, error) {
+		return nil, nil
+	})
+	r.Register("java/lang/ref/Reference", "hasReferencePendingList", "()Z", func(frame *Frame, args []Value) (Value, error) {
+		return javaBool(false), nil
+	})
+	r.Register("java/lang/ref/Reference", "waitForReferencePendingList", "()V", func(frame *Frame, args []Value) (Value, error) {
+		t := frame.VM.currentThread(frame)
+		if frame.VM.leaveWorld(t) {
+			defer frame.VM.enterWorld(t)
+		}
+		<-frame.VM.exitCh
+		return nil, frame.VM.exitError()
+	})
+}
@@ -0,0 +1,154 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachine_ExecMain_References(t *testing.T) {
+	const (
+		object = "Ljava/lang/Object;"
+		queue  = "Ljava/lang/ref/ReferenceQueue;"
+	)
+	cp := mapClassPath{}
+	// class Fin { protected void finalize() { Main.finalized = 1; } }
+	f := newClassBuilder("Fin", "java/lang/Object")
+	f.method(AccPublic, "<init>", "()V", 1, 1, newAsm().
+		op(OpCodeAload0).ref(OpCodeInvokeSpecial, f.methodRef("java/lang/Object", "<init>", "()V")).op(OpCodeReturn).bytes()...)
+	f.method(AccProtected, "finalize", "()V", 1, 1, newAsm().
+		op(OpCodeIconst0+1).ref(OpCodePutStatic, f.fieldRef("Main", "finalized", "I")).op(OpCodeReturn).bytes()...)
+	cp.add(f.build())
+	// class Action implements Runnable { public void run() { Main.cleaned = 1; } }
+	a := newClassBuilder("Action", "java/lang/Object")
+	a.class.Interfaces = append(a.class.Interfaces, a.classRef("java/lang/Runnable"))
+	a.method(AccPublic, "<init>", "()V", 1, 1, newAsm().
+		op(OpCodeAload0).ref(OpCodeInvokeSpecial, a.methodRef("java/lang/Object", "<init>", "()V")).op(OpCodeReturn).bytes()...)
+	a.method(AccPublic, "run", "()V", 1, 1, newAsm().
+		op(OpCodeIconst0+1).ref(OpCodePutStatic, a.fieldRef("Main", "cleaned", "I")).op(OpCodeReturn).bytes()...)
+	cp.add(a.build())
+
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.field(AccStatic|AccVolatile, "finalized", "I")
+	b.field(AccStatic|AccVolatile, "cleaned", "I")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printBool := b.methodRef("java/io/PrintStream", "println", "(Z)V")
+	printInt := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	refersTo := b.methodRef("java/lang/ref/Reference", "refersTo", "("+object+")Z")
+	equals := b.methodRef("java/lang/Object", "equals", "("+object+")Z")
+	remove := b.methodRef("java/lang/ref/ReferenceQueue", "remove", "(J)Ljava/lang/ref/Reference;")
+	newObject := func(code *asm, class string) *asm {
+		return code.ref(OpCodeNew, b.classRef(class)).op(OpCodeDup).ref(OpCodeInvokeSpecial, b.methodRef(class, "<init>", "()V"))
+	}
+	newArray := func(code *asm) *asm {
+		return code.op(OpCodeLdc, lo(b.integer(1000000))).op(OpCodeNewArray, 10)
+	}
+
+	code := newAsm()
+	// ReferenceQueue q = new ReferenceQueue(); WeakReference w = new WeakReference(new Object(), q);
+	newObject(code, "java/lang/ref/ReferenceQueue").op(OpCodeAstore, 1)
+	code.ref(OpCodeNew, b.classRef("java/lang/ref/WeakReference")).op(OpCodeDup)
+	newObject(code, "java/lang/Object").op(OpCodeAload, 1).
+		ref(OpCodeInvokeSpecial, b.methodRef("java/lang/ref/WeakReference", "<init>", "("+object+queue+")V")).op(OpCodeAstore, 2)
+	// SoftReference s = new SoftReference(new Object());
+	code.ref(OpCodeNew, b.classRef("java/lang/ref/SoftReference")).op(OpCodeDup)
+	newObject(code, "java/lang/Object").
+		ref(OpCodeInvokeSpecial, b.methodRef("java/lang/ref/SoftReference", "<init>", "("+object+")V")).op(OpCodeAstore, 3)
+	// ReferenceQueue pq = new ReferenceQueue(); PhantomReference p = new PhantomReference(new Object(), pq);
+	newObject(code, "java/lang/ref/ReferenceQueue").op(OpCodeAstore, 5)
+	code.ref(OpCodeNew, b.classRef("java/lang/ref/PhantomReference")).op(OpCodeDup)
+	newObject(code, "java/lang/Object").op(OpCodeAload, 5).
+		ref(OpCodeInvokeSpecial, b.methodRef("java/lang/ref/PhantomReference", "<init>", "("+object+queue+")V")).op(OpCodeAstore, 4)
+	// WeakHashMap map = new WeakHashMap(); map.put(key, "kept"); map.put(new Object(), "dropped");
+	put := b.methodRef("java/util/WeakHashMap", "put", "("+object+object+")"+object)
+	newObject(code, "java/util/WeakHashMap").op(OpCodeAstore, 6)
+	newObject(code, "java/lang/Object").op(OpCodeAstore, 7)
+	code.op(OpCodeAload, 6).op(OpCodeAload, 7).op(OpCodeLdc, lo(b.str("kept"))).ref(OpCodeInvokeVirtual, put).op(OpCodePop).
+		op(OpCodeAload, 6)
+	newObject(code, "java/lang/Object").op(OpCodeLdc, lo(b.str("dropped"))).ref(OpCodeInvokeVirtual, put).op(OpCodePop)
+	// new Fin(); Cleaner.create().register(new Object(), new Action());
+	newObject(code, "Fin").op(OpCodePop)
+	code.ref(OpCodeInvokeStatic, b.methodRef("java/lang/ref/Cleaner", "create", "()Ljava/lang/ref/Cleaner;"))
+	newObject(code, "java/lang/Object")
+	newObject(code, "Action").
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/ref/Cleaner", "register", "("+object+"Ljava/lang/Runnable;)Ljava/lang/ref/Cleaner$Cleanable;")).
+		op(OpCodePop)
+
+	code.ref(OpCodeInvokeStatic, b.methodRef("java/lang/System", "gc", "()V")).
+		ref(OpCodeGetStatic, out).op(OpCodeAload, 2).op(OpCodeAconstNull).ref(OpCodeInvokeVirtual, refersTo).ref(OpCodeInvokeVirtual, printBool).
+		ref(OpCodeGetStatic, out).op(OpCodeAload, 2).op(OpCodeAload, 1).op(OpCodeSipush, hi(1000), lo(1000)).op(OpCodeI2l).
+		ref(OpCodeInvokeVirtual, remove).ref(OpCodeInvokeVirtual, equals).ref(OpCodeInvokeVirtual, printBool).
+		ref(OpCodeGetStatic, out).op(OpCodeAload, 3).op(OpCodeAconstNull).ref(OpCodeInvokeVirtual, refersTo).ref(OpCodeInvokeVirtual, printBool).
+		ref(OpCodeGetStatic, out).op(OpCodeAload, 4).op(OpCodeAload, 5).op(OpCodeSipush, hi(1000), lo(1000)).op(OpCodeI2l).
+		ref(OpCodeInvokeVirtual, remove).ref(OpCodeInvokeVirtual, equals).ref(OpCodeInvokeVirtual, printBool).
+		ref(OpCodeGetStatic, out).op(OpCodeAload, 6).ref(OpCodeInvokeVirtual, b.methodRef("java/util/WeakHashMap", "size", "()I")).
+		ref(OpCodeInvokeVirtual, printInt).
+		// while (finalized == 0 || cleaned == 0) Thread.sleep(1);
+		label("wait").ref(OpCodeGetStatic, b.fieldRef("Main", "finalized", "I")).branch(OpCodeIfeq, "sleep").
+		ref(OpCodeGetStatic, b.fieldRef("Main", "cleaned", "I")).branch(OpCodeIfne, "done").
+		label("sleep").op(OpCodeIconst0+1).op(OpCodeI2l).ref(OpCodeInvokeStatic, b.methodRef("java/lang/Thread", "sleep", "(J)V")).
+		branch(OpCodeGoto, "wait").
+		label("done").ref(OpCodeGetStatic, out).op(OpCodeLdc, lo(b.str("finalized"))).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V"))
+
+	// a soft reference is cleared before the heap runs out:
+	// s = new SoftReference(new int[1000000]); int[] x = new int[1000000], y = new int[1000000];
+	code.ref(OpCodeNew, b.classRef("java/lang/ref/SoftReference")).op(OpCodeDup)
+	newArray(code).ref(OpCodeInvokeSpecial, b.methodRef("java/lang/ref/SoftReference", "<init>", "("+object+")V")).op(OpCodeAstore, 3)
+	newArray(code).op(OpCodeAstore, 8)
+	newArray(code).op(OpCodeAstore, 9)
+	code.ref(OpCodeGetStatic, out).op(OpCodeAload, 3).op(OpCodeAconstNull).ref(OpCodeInvokeVirtual, refersTo).ref(OpCodeInvokeVirtual, printBool).
+		op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 6, 10, code.bytes()...)
+
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	vm.MaxHeapSize = 10 << 20
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "true\ntrue\nfalse\ntrue\n1\nfinalized\ntrue\n", stdout.String())
+}
+
+func TestVirtualMachine_ExecMain_CollectDuringInvoke(t *testing.T) {
+	const (
+		object = "Ljava/lang/Object;"
+		weak   = "Ljava/lang/ref/WeakReference;"
+	)
+	cp := mapClassPath{}
+	// class Check { static { System.gc(); } static boolean same(Object o, WeakReference w) { return w.get() == o; } }
+	c := newClassBuilder("Check", "java/lang/Object")
+	c.method(AccStatic, "<clinit>", "()V", 0, 0, newAsm().
+		ref(OpCodeInvokeStatic, c.methodRef("java/lang/System", "gc", "()V")).op(OpCodeReturn).bytes()...)
+	same := newAsm().
+		op(OpCodeAload0+1).ref(OpCodeInvokeVirtual, c.methodRef("java/lang/ref/WeakReference", "get", "()"+object)).
+		op(OpCodeAload0).branch(OpCodeIfAcmpne, "differ").
+		op(OpCodeIconst0 + 1).op(OpCodeIreturn).
+		label("differ").op(OpCodeIconst0).op(OpCodeIreturn)
+	c.method(AccStatic, "same", "("+object+weak+")Z", 2, 2, same.bytes()...)
+	cp.add(c.build())
+
+	// the object is only on the operand stack when Check is initialized:
+	// Object o = new Object(); WeakReference w = new WeakReference(o); push o; o = null;
+	// System.out.println(Check.same(<pushed>, w));
+	b := newClassBuilder("Main", "java/lang/Object")
+	code := newAsm().
+		ref(OpCodeNew, b.classRef("java/lang/Object")).op(OpCodeDup).
+		ref(OpCodeInvokeSpecial, b.methodRef("java/lang/Object", "<init>", "()V")).op(OpCodeAstore, 1).
+		ref(OpCodeNew, b.classRef("java/lang/ref/WeakReference")).op(OpCodeDup).op(OpCodeAload, 1).
+		ref(OpCodeInvokeSpecial, b.methodRef("java/lang/ref/WeakReference", "<init>", "("+object+")V")).op(OpCodeAstore, 2).
+		ref(OpCodeGetStatic, b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")).
+		op(OpCodeAload, 1).op(OpCodeAconstNull).op(OpCodeAstore, 1).op(OpCodeAload, 2).
+		ref(OpCodeInvokeStatic, b.methodRef("Check", "same", "("+object+weak+")Z")).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Z)V")).
+		op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 4, 3, code.bytes()...)
+
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "true\n", stdout.String())
+}
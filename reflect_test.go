@@ -0,0 +1,117 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachine_ExecMain_Reflection(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.field(AccPrivate, "count", "I")
+	// Main(int count) { this.count = count; }
+	b.method(AccPublic, "<init>", "(I)V", 2, 2, newAsm().
+		op(OpCodeAload0).ref(OpCodeInvokeSpecial, b.methodRef("java/lang/Object", "<init>", "()V")).
+		op(OpCodeAload0).op(OpCodeIload0+1).ref(OpCodePutField, b.fieldRef("Main", "count", "I")).
+		op(OpCodeReturn).bytes()...)
+	b.method(AccPublic|AccStatic, "add", "(II)I", 2, 2, newAsm().
+		op(OpCodeIload0).op(OpCodeIload0+1).op(OpCodeIadd).op(OpCodeIreturn).bytes()...)
+	b.method(AccPublic|AccStatic, "fail", "()V", 3, 0, newAsm().
+		ref(OpCodeNew, b.classRef("java/lang/IllegalStateException")).op(OpCodeDup).op(OpCodeLdc, lo(b.str("boom"))).
+		ref(OpCodeInvokeSpecial, b.methodRef("java/lang/IllegalStateException", "<init>", "(Ljava/lang/String;)V")).
+		op(OpCodeAThrow).bytes()...)
+
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printObject := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/Object;)V")
+	valueOf := b.methodRef("java/lang/Integer", "valueOf", "(I)Ljava/lang/Integer;")
+	intClass := func(a *asm) *asm {
+		return a.op(OpCodeLdc, lo(b.str("int"))).ref(OpCodeInvokeStatic, b.methodRef("java/lang/Class", "getPrimitiveClass", "(Ljava/lang/String;)Ljava/lang/Class;"))
+	}
+	// newArray leaves an array of n elements of class on the stack, each
+	// pushed by elem
+	newArray := func(a *asm, class string, n int, elem func(a *asm, i int) *asm) *asm {
+		a.op(OpCodeBipush, byte(n)).ref(OpCodeANewArray, b.classRef(class))
+		for i := 0; i < n; i++ {
+			elem(a.op(OpCodeDup).op(OpCodeBipush, byte(i)), i).op(OpCodeAastore)
+		}
+		return a
+	}
+	ints := func(values ...int) func(a *asm, i int) *asm {
+		return func(a *asm, i int) *asm {
+			return a.op(OpCodeBipush, byte(values[i])).ref(OpCodeInvokeStatic, valueOf)
+		}
+	}
+
+	a := newAsm().op(OpCodeLdc, lo(b.classRef("Main"))).op(OpCodeAstore0 + 1)
+	// System.out.println(Main.class.getDeclaredMethods().length);
+	a.ref(OpCodeGetStatic, out).op(OpCodeAload0+1).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Class", "getDeclaredMethods", "()[Ljava/lang/reflect/Method;")).
+		op(OpCodeArrayLength).ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(I)V"))
+	// Object obj = Main.class.getDeclaredConstructor(int.class).newInstance(7);
+	newArray(a.op(OpCodeAload0+1), "java/lang/Class", 1, func(a *asm, i int) *asm { return intClass(a) }).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Class", "getDeclaredConstructor", "([Ljava/lang/Class;)Ljava/lang/reflect/Constructor;"))
+	newArray(a, "java/lang/Object", 1, ints(7)).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/reflect/Constructor", "newInstance", "([Ljava/lang/Object;)Ljava/lang/Object;")).
+		op(OpCodeAstore0 + 2)
+	// Method add = Main.class.getDeclaredMethod("add", int.class, int.class);
+	// System.out.println(add); System.out.println(add.invoke(null, 2, 3));
+	newArray(a.op(OpCodeAload0+1).op(OpCodeLdc, lo(b.str("add"))), "java/lang/Class", 2, func(a *asm, i int) *asm { return intClass(a) }).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Class", "getDeclaredMethod", "(Ljava/lang/String;[Ljava/lang/Class;)Ljava/lang/reflect/Method;")).
+		op(OpCodeAstore0 + 3)
+	a.ref(OpCodeGetStatic, out).op(OpCodeAload0+3).ref(OpCodeInvokeVirtual, printObject)
+	newArray(a.ref(OpCodeGetStatic, out).op(OpCodeAload0+3).op(OpCodeAconstNull), "java/lang/Object", 2, ints(2, 3)).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/reflect/Method", "invoke", "(Ljava/lang/Object;[Ljava/lang/Object;)Ljava/lang/Object;")).
+		ref(OpCodeInvokeVirtual, printObject)
+	// Field count = Main.class.getDeclaredField("count"); count.setAccessible(true);
+	// System.out.println(count.get(obj)); count.set(obj, 9); System.out.println(count.get(obj));
+	fieldGet := b.methodRef("java/lang/reflect/Field", "get", "(Ljava/lang/Object;)Ljava/lang/Object;")
+	a.op(OpCodeAload0+1).op(OpCodeLdc, lo(b.str("count"))).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Class", "getDeclaredField", "(Ljava/lang/String;)Ljava/lang/reflect/Field;")).
+		op(OpCodeAstore0+3).op(OpCodeAload0+3).op(OpCodeIconst0+1).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/reflect/AccessibleObject", "setAccessible", "(Z)V")).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+3).op(OpCodeAload0+2).ref(OpCodeInvokeVirtual, fieldGet).ref(OpCodeInvokeVirtual, printObject).
+		op(OpCodeAload0+3).op(OpCodeAload0+2).op(OpCodeBipush, 9).ref(OpCodeInvokeStatic, valueOf).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/reflect/Field", "set", "(Ljava/lang/Object;Ljava/lang/Object;)V")).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+3).op(OpCodeAload0+2).ref(OpCodeInvokeVirtual, fieldGet).ref(OpCodeInvokeVirtual, printObject)
+	// Main.class.getDeclaredMethod("fail").invoke(null);
+	newArray(a.op(OpCodeAload0+1).op(OpCodeLdc, lo(b.str("fail"))), "java/lang/Class", 0, nil).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Class", "getDeclaredMethod", "(Ljava/lang/String;[Ljava/lang/Class;)Ljava/lang/reflect/Method;")).
+		op(OpCodeAconstNull).op(OpCodeAconstNull).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/reflect/Method", "invoke", "(Ljava/lang/Object;[Ljava/lang/Object;)Ljava/lang/Object;")).
+		op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 8, 4, a.bytes()...)
+
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	err := vm.ExecMain()
+	require.Equal(t, "3\npublic static int Main.add(int,int)\n5\n7\n9\n", stdout.String())
+	require.Error(t, err)
+	var ex *JavaException
+	require.ErrorAs(t, err, &ex)
+	require.Equal(t, "java/lang/reflect/InvocationTargetException", ex.Object.ClassName())
+	cause, _ := ex.Object.GetField("cause", "Ljava/lang/Throwable;")
+	require.Equal(t, "java/lang/IllegalStateException", cause.(*Object).ClassName())
+}
+
+func TestVirtualMachine_ExecMain_ReflectionAccess(t *testing.T) {
+	other := newClassBuilder("Other", "java/lang/Object")
+	other.field(AccPrivate|AccStatic, "secret", "I")
+
+	b := newClassBuilder("Main", "java/lang/Object")
+	// Other.class.getDeclaredField("secret").get(null);
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 1, newAsm().
+		op(OpCodeLdc, lo(b.classRef("Other"))).op(OpCodeLdc, lo(b.str("secret"))).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Class", "getDeclaredField", "(Ljava/lang/String;)Ljava/lang/reflect/Field;")).
+		op(OpCodeAconstNull).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/lang/reflect/Field", "get", "(Ljava/lang/Object;)Ljava/lang/Object;")).
+		op(OpCodePop).op(OpCodeReturn).bytes()...)
+
+	vm := NewVM(b.build())
+	_, err := vm.DefineClass(other.build())
+	require.NoError(t, err)
+	err = vm.ExecMain()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `java/lang/IllegalAccessException: class Main cannot access a member of class Other with modifiers "private static"`)
+}
@@ -0,0 +1,339 @@
+package jvmgo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	classLoaded = iota
+	classInitializing
+	classInitialized
+	classInitFailed
+)
+
+type (
+	RuntimeClass struct {
+		Name        string
+		File        *ClassStructure
+		AccessFlags uint16
+		Super       *RuntimeClass
+		Interfaces  []*RuntimeClass
+		Fields      []*RuntimeField
+		Methods     []*RuntimeMethod
+
+		StaticValues   []Value
+		InstanceFields int
+		fieldDefaults  []Value
+		methodIndex    map[string]*RuntimeMethod
+
+		initMu     sync.Mutex
+		initCond   *sync.Cond
+		initState  int
+		initThread *thread
+		mirror     *Object
+		stub       bool
+		// boot is set for the classes of the class library of a JDK
+		boot bool
+
+		superInterfaces []*RuntimeClass
+		vtable          []*RuntimeMethod
+		itables         map[*RuntimeClass][]*RuntimeMethod
+		itableSize      int
+
+		// component is the element class of an array of references
+		component *RuntimeClass
+
+		nestHostOnce sync.Once
+		nestHost     *RuntimeClass
+
+		// refKind, referentSlot and queueSlot tell the collector how to
+		// trace instances of a subclass of java.lang.ref.Reference
+		refKind      int
+		referentSlot int
+		queueSlot    int
+		// finalizer is set when the class overrides Object.finalize
+		finalizer bool
+
+		verifyOnce sync.Once
+		verifyErr  error
+
+		// annotationProxy is the class of the instances of an annotation
+		// interface
+		annotationOnce  sync.Once
+		annotationProxy *RuntimeClass
+		annotationErr   error
+	}
+
+	RuntimeField struct {
+		Class       *RuntimeClass
+		Info        *FieldInfo
+		Name        string
+		Descriptor  string
+		AccessFlags uint16
+		Slot        int
+	}
+
+	RuntimeMethod struct {
+		Class       *RuntimeClass
+		Info        *MethodInfo
+		Name        string
+		Descriptor  string
+		AccessFlags uint16
+		Desc        *MethodDescriptor
+		Code        *CodeAttribute
+
+		vtableIndex int
+		itableIndex int
+		callSitesMu sync.Mutex
+		callSites   map[int]*callSite
+		// intrinsic holds an *intrinsicCache
+		intrinsic atomic.Value
+		// native implements a method of a spun class
+		native NativeMethod
+	}
+)
+
+func newRuntimeClass(file *ClassStructure) (*RuntimeClass, error) {
+	name, err := file.Name()
+	if err != nil {
+		return nil, fmt.Errorf("get class name: %w", err)
+	}
+	c := &RuntimeClass{
+		Name:        name,
+		File:        file,
+		AccessFlags: file.AccessFlags,
+		methodIndex: map[string]*RuntimeMethod{},
+	}
+
+	for _, info := range file.Fields {
+		fieldName, err := file.GetCpInfo(info.NameIndex).GetAsUTF8String()
+		if err != nil {
+			return nil, fmt.Errorf("get field name: %w", err)
+		}
+		desc, err := file.GetCpInfo(info.DescriptorIndex).GetAsUTF8String()
+		if err != nil {
+			return nil, fmt.Errorf("get field descriptor: %w", err)
+		}
+		c.Fields = append(c.Fields, &RuntimeField{
+			Class:       c,
+			Info:        info,
+			Name:        fieldName,
+			Descriptor:  desc,
+			AccessFlags: info.AccessFlags,
+		})
+	}
+
+	for _, info := range file.Methods {
+		methodName, err := info.Name(file)
+		if err != nil {
+			return nil, fmt.Errorf("get method name: %w", err)
+		}
+		desc, err := file.GetCpInfo(info.DescriptorIndex).GetAsUTF8String()
+		if err != nil {
+			return nil, fmt.Errorf("get method descriptor: %w", err)
+		}
+		parsed, err := parseMethodDescriptor(desc)
+		if err != nil {
+			return nil, fmt.Errorf("method %s: %w", methodName, err)
+		}
+		m := &RuntimeMethod{
+			Class:       c,
+			Info:        info,
+			Name:        methodName,
+			Descriptor:  desc,
+			AccessFlags: info.AccessFlags,
+			Desc:        parsed,
+		}
+		if info.AccessFlags&(AccNative|AccAbstract) == 0 {
+			if m.Code, err = info.CodeAttribute(file); err != nil {
+				return nil, fmt.Errorf("method %s%s: %w", methodName, desc, err)
+			}
+		}
+		c.Methods = append(c.Methods, m)
+		c.methodIndex[methodName+desc] = m
+	}
+
+	return c, nil
+}
+
+// newSyntheticClass creates a class that has no class file behind it, such as
+// array classes or stand-ins for library classes that could not be loaded.
+func newSyntheticClass(name string, super *RuntimeClass) *RuntimeClass {
+	c := &RuntimeClass{
+		Name:        name,
+		AccessFlags: AccPublic | AccFinal,
+		Super:       super,
+		methodIndex: map[string]*RuntimeMethod{},
+		initState:   classInitialized,
+	}
+	c.layoutFields()
+	c.linkMethods()
+	return c
+}
+
+func (c *RuntimeClass) layoutFields() {
+	if c.Super != nil {
+		c.InstanceFields = c.Super.InstanceFields
+		c.fieldDefaults = append([]Value{}, c.Super.fieldDefaults...)
+	}
+	statics := 0
+	for _, f := range c.Fields {
+		if f.IsStatic() {
+			f.Slot = statics
+			statics++
+			continue
+		}
+		f.Slot = c.InstanceFields
+		c.InstanceFields++
+		c.fieldDefaults = append(c.fieldDefaults, zeroValue(f.Descriptor))
+	}
+
+	c.StaticValues = make([]Value, statics)
+	for _, f := range c.Fields {
+		if f.IsStatic() {
+			c.StaticValues[f.Slot] = zeroValue(f.Descriptor)
+		}
+	}
+}
+
+// initConstantValues assigns ConstantValue attributes of static fields, which
+// happens at preparation before <clinit> runs.
+func (c *RuntimeClass) initConstantValues(vm *VirtualMachine) error {
+	for _, f := range c.Fields {
+		if !f.IsStatic() {
+			continue
+		}
+		for _, a := range f.Info.Attributes {
+			name, err := c.File.GetCpInfo(a.AttributeNameIndex).GetAsUTF8String()
+			if err != nil {
+				return fmt.Errorf("get attribute name: %w", err)
+			}
+			if name != "ConstantValue" || len(a.Info) < 2 {
+				continue
+			}
+			v, err := vm.loadConstant(c, binary.BigEndian.Uint16(a.Info))
+			if err != nil {
+				return fmt.Errorf("constant value of %s: %w", f.Name, err)
+			}
+			c.StaticValues[f.Slot] = v
+		}
+	}
+	return nil
+}
+
+func (c *RuntimeClass) IsInterface() bool {
+	return c.AccessFlags&AccInterface != 0
+}
+
+func (c *RuntimeClass) IsArray() bool {
+	return len(c.Name) > 0 && c.Name[0] == '['
+}
+
+// IsSubclassOf reports whether c is other or extends it, ignoring interfaces.
+func (c *RuntimeClass) IsSubclassOf(other *RuntimeClass) bool {
+	for k := c; k != nil; k = k.Super {
+		if k == other {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *RuntimeClass) DeclaredMethod(name, descriptor string) *RuntimeMethod {
+	return c.methodIndex[name+descriptor]
+}
+
+func (c *RuntimeClass) DeclaredField(name, descriptor string) *RuntimeField {
+	for _, f := range c.Fields {
+		if f.Name == name && (descriptor == "" || f.Descriptor == descriptor) {
+			return f
+		}
+	}
+	return nil
+}
+
+// LookupField resolves a field per JVMS §5.4.3.2: the class itself, then its
+// superinterfaces, then its superclass.
+func (c *RuntimeClass) LookupField(name, descriptor string) *RuntimeField {
+	for k := c; k != nil; k = k.Super {
+		if f := k.DeclaredField(name, descriptor); f != nil {
+			return f
+		}
+		for _, i := range k.Interfaces {
+			if f := i.LookupField(name, descriptor); f != nil {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// LookupMethod resolves a method per JVMS §5.4.3.3: the class and its
+// superclasses, then the maximally-specific superinterface methods,
+// preferring one that is not abstract.
+func (c *RuntimeClass) LookupMethod(name, descriptor string) *RuntimeMethod {
+	for k := c; k != nil; k = k.Super {
+		if m := k.DeclaredMethod(name, descriptor); m != nil {
+			return m
+		}
+	}
+	methods := c.maximallySpecific(name, descriptor)
+	for _, m := range methods {
+		if !m.IsAbstract() {
+			return m
+		}
+	}
+	if len(methods) > 0 {
+		return methods[0]
+	}
+	return nil
+}
+
+func (c *RuntimeClass) String() string {
+	return c.Name
+}
+
+func (f *RuntimeField) IsStatic() bool {
+	return f.AccessFlags&AccStatic != 0
+}
+
+func (f *RuntimeField) String() string {
+	return f.Class.Name + "." + f.Name + ":" + f.Descriptor
+}
+
+func (m *RuntimeMethod) IsStatic() bool {
+	return m.AccessFlags&AccStatic != 0
+}
+
+func (m *RuntimeMethod) IsNative() bool {
+	return m.AccessFlags&AccNative != 0
+}
+
+func (m *RuntimeMethod) IsSynchronized() bool {
+	return m.AccessFlags&AccSynchronized != 0
+}
+
+func (m *RuntimeMethod) IsAbstract() bool {
+	return m.AccessFlags&AccAbstract != 0
+}
+
+func (m *RuntimeMethod) String() string {
+	return m.Class.Name + "." + m.Name + m.Descriptor
+}
+
+func zeroValue(desc string) Value {
+	switch desc[0] {
+	case 'B', 'C', 'I', 'S', 'Z':
+		return int32(0)
+	case 'J':
+		return int64(0)
+	case 'F':
+		return float32(0)
+	case 'D':
+		return float64(0)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package jvmgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// verification_type_info tags of a StackMapTable, JVMS §4.7.4
+const (
+	ItemTop               = 0
+	ItemInteger           = 1
+	ItemFloat             = 2
+	ItemDouble            = 3
+	ItemLong              = 4
+	ItemNull              = 5
+	ItemUninitializedThis = 6
+	ItemObject            = 7
+	ItemUninitialized     = 8
+)
+
+type (
+	// StackMapFrame is an entry of a StackMapTable attribute as stored in
+	// the class file: a same frame has neither locals nor stack, a chop
+	// frame only the number of locals chopped in FrameType.
+	StackMapFrame struct {
+		FrameType   uint8
+		OffsetDelta uint16
+		Locals      []VerificationTypeInfo
+		Stack       []VerificationTypeInfo
+	}
+
+	VerificationTypeInfo struct {
+		Tag uint8
+		// CpoolIndex names the class of an ItemObject.
+		CpoolIndex uint16
+		// Offset is the new instruction of an ItemUninitialized.
+		Offset uint16
+	}
+)
+
+// StackMapTable decodes the StackMapTable attribute of the code, or returns
+// nil if it has none.
+func (c *CodeAttribute) StackMapTable(file *ClassStructure) ([]*StackMapFrame, error) {
+	for _, a := range c.Attributes {
+		name, err := file.GetCpInfo(a.AttributeNameIndex).GetAsUTF8String()
+		if err != nil {
+			return nil, fmt.Errorf("get attribute name: %w", err)
+		}
+		if name == "StackMapTable" {
+			return readStackMapTable(a.Info)
+		}
+	}
+	return nil, nil
+}
+
+func readStackMapTable(info []byte) ([]*StackMapFrame, error) {
+	r := bytes.NewReader(info)
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, fmt.Errorf("read number of entries: %w", err)
+	}
+	frames := make([]*StackMapFrame, n)
+	for i := range frames {
+		f, err := readStackMapFrame(r)
+		if err != nil {
+			return nil, fmt.Errorf("read stack map frame idx=%d: %w", i, err)
+		}
+		frames[i] = f
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes after the last stack map frame", r.Len())
+	}
+	return frames, nil
+}
+
+func readStackMapFrame(r *bytes.Reader) (*StackMapFrame, error) {
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("read frame type: %w", err)
+	}
+	f := &StackMapFrame{FrameType: t}
+	switch {
+	case t < 64:
+		f.OffsetDelta = uint16(t)
+		return f, nil
+	case t < 128:
+		f.OffsetDelta = uint16(t - 64)
+		f.Stack, err = readVerificationTypes(r, 1)
+		return f, err
+	case t < 247:
+		return nil, fmt.Errorf("reserved frame type %d", t)
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &f.OffsetDelta); err != nil {
+		return nil, fmt.Errorf("read offset delta: %w", err)
+	}
+	switch {
+	case t == 247:
+		f.Stack, err = readVerificationTypes(r, 1)
+	case t >= 252 && t <= 254:
+		f.Locals, err = readVerificationTypes(r, int(t)-251)
+	case t == 255:
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, fmt.Errorf("read number of locals: %w", err)
+		}
+		if f.Locals, err = readVerificationTypes(r, int(n)); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, fmt.Errorf("read number of stack items: %w", err)
+		}
+		f.Stack, err = readVerificationTypes(r, int(n))
+	}
+	return f, err
+}
+
+func readVerificationTypes(r *bytes.Reader, n int) ([]VerificationTypeInfo, error) {
+	types := make([]VerificationTypeInfo, n)
+	for i := range types {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read verification type tag: %w", err)
+		}
+		types[i].Tag = tag
+		switch tag {
+		case ItemObject, ItemUninitialized:
+			buf := make([]byte, 2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return nil, fmt.Errorf("read verification type: %w", err)
+			}
+			if tag == ItemObject {
+				types[i].CpoolIndex = binary.BigEndian.Uint16(buf)
+			} else {
+				types[i].Offset = binary.BigEndian.Uint16(buf)
+			}
+		default:
+			if tag > ItemUninitialized {
+				return nil, fmt.Errorf("invalid verification type tag %d", tag)
+			}
+		}
+	}
+	return types, nil
+}
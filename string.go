@@ -0,0 +1,231 @@
+package jvmgo
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// NewString returns a java.lang.String holding s.
+func (vm *VirtualMachine) NewString(s string) *Object {
+	return vm.newStringOfChars(javaChars(s))
+}
+
+func (vm *VirtualMachine) newStringOfChars(chars []uint16) *Object {
+	class, err := vm.LoadClass("java/lang/String")
+	if err != nil {
+		// every program needs String; there is nothing to run without it
+		panic(fmt.Sprintf("load java/lang/String: %v", err))
+	}
+	obj := NewObject(class)
+	vm.setStringValue(obj, chars)
+	vm.track(obj)
+	return obj
+}
+
+// setStringValue stores chars in the value field of a String. The class
+// library keeps compact strings in a byte[] with a coder, the bundled runtime
+// a char[].
+func (vm *VirtualMachine) setStringValue(obj *Object, chars []uint16) {
+	if f := obj.Class.LookupField("value", "[C"); f != nil {
+		obj.Fields[f.Slot] = vm.newPrimitiveArray("[C", chars)
+		return
+	}
+	latin1 := true
+	for _, c := range chars {
+		if c > 0xff {
+			latin1 = false
+			break
+		}
+	}
+	var b []int8
+	if latin1 {
+		b = make([]int8, len(chars))
+		for i, c := range chars {
+			b[i] = int8(c)
+		}
+		obj.SetField("coder", "B", int32(0))
+	} else {
+		// StringUTF16.isBigEndian reports little-endian
+		b = make([]int8, 2*len(chars))
+		for i, c := range chars {
+			b[2*i], b[2*i+1] = int8(c), int8(c>>8)
+		}
+		obj.SetField("coder", "B", int32(1))
+	}
+	obj.SetField("value", "[B", vm.newPrimitiveArray("[B", b))
+}
+
+func (vm *VirtualMachine) newPrimitiveArray(name string, array interface{}) *Object {
+	class, err := vm.arrayClass(name)
+	if err != nil {
+		panic(fmt.Sprintf("load %s: %v", name, err))
+	}
+	arr := NewArray(class, array)
+	vm.track(arr)
+	return arr
+}
+
+// stringChars returns the UTF-16 code units of a String object.
+func stringChars(o *Object) ([]uint16, bool) {
+	if o == nil || o.Class == nil || o.Class.Name != "java/lang/String" {
+		return nil, false
+	}
+	if v, ok := o.GetField("value", "[C"); ok {
+		arr, _ := v.(*Object)
+		if arr == nil {
+			return nil, true
+		}
+		return arr.Array.([]uint16), true
+	}
+	v, _ := o.GetField("value", "[B")
+	arr, _ := v.(*Object)
+	if arr == nil {
+		return nil, true
+	}
+	b := arr.Array.([]int8)
+	if coder, _ := o.GetField("coder", "B"); coder == int32(0) {
+		chars := make([]uint16, len(b))
+		for i, c := range b {
+			chars[i] = uint16(uint8(c))
+		}
+		return chars, true
+	}
+	chars := make([]uint16, len(b)/2)
+	for i := range chars {
+		chars[i] = uint16(uint8(b[2*i])) | uint16(uint8(b[2*i+1]))<<8
+	}
+	return chars, true
+}
+
+func isString(v Value) bool {
+	o, ok := v.(*Object)
+	return ok && o != nil && o.Class != nil && o.Class.Name == "java/lang/String"
+}
+
+// javaStringValue returns the contents of a String object, or "null".
+func javaStringValue(v Value) string {
+	if isNull(v) {
+		return "null"
+	}
+	if chars, ok := stringChars(v.(*Object)); ok {
+		return stringFromChars(chars)
+	}
+	return fmt.Sprint(v)
+}
+
+// intern returns the canonical String with the contents of obj, registering
+// obj itself when there is none yet. A nil obj stands for a new String of s.
+func (vm *VirtualMachine) intern(s string, obj *Object) *Object {
+	vm.internMu.Lock()
+	existing, ok := vm.interned[s]
+	vm.internMu.Unlock()
+	if ok {
+		return existing
+	}
+	if obj == nil {
+		// allocated outside the lock since it may load classes
+		obj = vm.NewString(s)
+	}
+
+	vm.internMu.Lock()
+	defer vm.internMu.Unlock()
+	if existing, ok := vm.interned[s]; ok {
+		return existing
+	}
+	vm.interned[s] = obj
+	return obj
+}
+
+// javaChars returns the UTF-16 code units of s, which is what Java strings
+// index and count. It reads back the lone surrogates stringFromChars writes.
+func javaChars(s string) []uint16 {
+	chars := make([]uint16, 0, len(s))
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && n == 1 && i+2 < len(s) && s[i] == 0xED && s[i+1]&0xE0 == 0xA0 && s[i+2]&0xC0 == 0x80 {
+			chars = append(chars, 0xD000|uint16(s[i+1]&0x3F)<<6|uint16(s[i+2]&0x3F))
+			i += 3
+			continue
+		}
+		if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+			chars = append(chars, uint16(r1), uint16(r2))
+		} else {
+			chars = append(chars, uint16(r))
+		}
+		i += n
+	}
+	return chars
+}
+
+// stringFromChars returns the string of UTF-16 code units. Go strings have no
+// place for a lone surrogate, so it is written in the three bytes UTF-8 would
+// take for its code point, to keep the chars of a String through Go strings.
+func stringFromChars(chars []uint16) string {
+	b := make([]byte, 0, len(chars))
+	var buf [utf8.UTFMax]byte
+	for i := 0; i < len(chars); i++ {
+		r := rune(chars[i])
+		if utf16.IsSurrogate(r) {
+			if i+1 < len(chars) {
+				if pair := utf16.DecodeRune(r, rune(chars[i+1])); pair != utf8.RuneError {
+					n := utf8.EncodeRune(buf[:], pair)
+					b = append(b, buf[:n]...)
+					i++
+					continue
+				}
+			}
+			b = append(b, 0xE0|byte(r>>12), 0x80|byte(r>>6)&0x3F, 0x80|byte(r)&0x3F)
+			continue
+		}
+		n := utf8.EncodeRune(buf[:], r)
+		b = append(b, buf[:n]...)
+	}
+	return string(b)
+}
+
+// javaStringHash is String.hashCode.
+func javaStringHash(chars []uint16) int32 {
+	var h int32
+	for _, c := range chars {
+		h = 31*h + int32(c)
+	}
+	return h
+}
+
+// javaString converts v, a value of type desc, to the string that
+// String.valueOf produces, calling toString on objects.
+func (vm *VirtualMachine) javaString(caller *Frame, desc string, v Value) (string, error) {
+	switch desc {
+	case "Z":
+		if v.(int32) != 0 {
+			return "true", nil
+		}
+		return "false", nil
+	case "C":
+		return stringFromChars([]uint16{uint16(v.(int32))}), nil
+	case "B", "S", "I":
+		return strconv.Itoa(int(v.(int32))), nil
+	case "J":
+		return strconv.FormatInt(v.(int64), 10), nil
+	case "F":
+		return javaFloatString(float64(v.(float32)), 32), nil
+	case "D":
+		return javaFloatString(v.(float64), 64), nil
+	}
+	switch o := v.(type) {
+	case nil:
+		return "null", nil
+	case *Object:
+		if chars, ok := stringChars(o); ok {
+			return stringFromChars(chars), nil
+		}
+		s, err := vm.InvokeVirtual(caller, o, "toString", "()Ljava/lang/String;")
+		if err != nil {
+			return "", err
+		}
+		return javaStringValue(s), nil
+	}
+	return fmt.Sprint(v), nil
+}
@@ -0,0 +1,141 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachine_ExecMain_Strings(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printStr := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+	printInt := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	printChar := b.methodRef("java/io/PrintStream", "println", "(C)V")
+	printBool := b.methodRef("java/io/PrintStream", "println", "(Z)V")
+	stringClass := b.classRef("java/lang/String")
+	stringInit := b.methodRef("java/lang/String", "<init>", "(Ljava/lang/String;)V")
+	intern := b.methodRef("java/lang/String", "intern", "()Ljava/lang/String;")
+	length := b.methodRef("java/lang/String", "length", "()I")
+	charAt := b.methodRef("java/lang/String", "charAt", "(I)C")
+	equals := b.methodRef("java/lang/String", "equals", "(Ljava/lang/Object;)Z")
+	hashCode := b.methodRef("java/lang/String", "hashCode", "()I")
+	concat := b.methodRef("java/lang/String", "concat", "(Ljava/lang/String;)Ljava/lang/String;")
+	substring := b.methodRef("java/lang/String", "substring", "(II)Ljava/lang/String;")
+	hello, world := b.str("hello"), b.str(", wörld")
+
+	same := func(a *asm, label string) *asm {
+		return a.branch(OpCodeIfAcmpne, label+"Differ").op(OpCodeIconst0+1).branch(OpCodeGoto, label+"Print").
+			label(label+"Differ").op(OpCodeIconst0).
+			label(label+"Print").ref(OpCodeInvokeVirtual, printBool)
+	}
+	code := newAsm().
+		// new String("hello") is a distinct object
+		ref(OpCodeNew, stringClass).op(OpCodeDup).op(OpCodeLdc, lo(hello)).ref(OpCodeInvokeSpecial, stringInit).op(OpCodeAstore0+1).
+		// identical literals are the same object
+		ref(OpCodeGetStatic, out).op(OpCodeLdc, lo(hello)).op(OpCodeLdc, lo(hello))
+	same(code, "literal")
+	code.ref(OpCodeGetStatic, out).op(OpCodeAload0+1).op(OpCodeLdc, lo(hello))
+	same(code, "copy")
+	code.ref(OpCodeGetStatic, out).op(OpCodeAload0+1).ref(OpCodeInvokeVirtual, intern).op(OpCodeLdc, lo(hello))
+	same(code, "interned")
+	code.
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).op(OpCodeLdc, lo(hello)).ref(OpCodeInvokeVirtual, equals).ref(OpCodeInvokeVirtual, printBool).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).ref(OpCodeInvokeVirtual, hashCode).ref(OpCodeInvokeVirtual, printInt).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).op(OpCodeIconst0+1).ref(OpCodeInvokeVirtual, charAt).ref(OpCodeInvokeVirtual, printChar).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).op(OpCodeLdc, lo(world)).ref(OpCodeInvokeVirtual, concat).op(OpCodeAstore0+2).
+		op(OpCodeAload0+2).ref(OpCodeInvokeVirtual, printStr).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+2).ref(OpCodeInvokeVirtual, length).ref(OpCodeInvokeVirtual, printInt).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+2).op(OpCodeIconst0+2).op(OpCodeIconst0+4).ref(OpCodeInvokeVirtual, substring).ref(OpCodeInvokeVirtual, printStr).
+		// out of range
+		op(OpCodeAload0+2).op(OpCodeBipush, 20).ref(OpCodeInvokeVirtual, charAt).
+		op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 4, 3, code.bytes()...)
+
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	err := vm.ExecMain()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "java/lang/StringIndexOutOfBoundsException: index 20, length 12")
+	require.Equal(t, "true\nfalse\ntrue\ntrue\n99162322\ne\nhello, wörld\n12\nll\n", stdout.String())
+}
+
+func TestVirtualMachine_NewString_CompactLayout(t *testing.T) {
+	cp := mapClassPath{}
+	str := newClassBuilder("java/lang/String", "java/lang/Object")
+	str.field(AccPrivate|AccFinal, "value", "[B")
+	str.field(AccPrivate|AccFinal, "coder", "B")
+	str.field(AccPrivate, "hash", "I")
+	cp.add(str.build())
+	cp.add(newClassBuilder("java/lang/Object", "").build())
+
+	vm := NewVM(newClassBuilder("Main", "java/lang/Object").build())
+	vm.ClassPath = cp
+
+	latin1 := vm.NewString("café")
+	coder, _ := latin1.GetField("coder", "B")
+	require.Equal(t, int32(0), coder)
+	value, _ := latin1.GetField("value", "[B")
+	require.Equal(t, []int8{'c', 'a', 'f', -0x17}, value.(*Object).Array)
+	require.Equal(t, "café", javaStringValue(latin1))
+
+	utf16 := vm.NewString("a→😀")
+	coder, _ = utf16.GetField("coder", "B")
+	require.Equal(t, int32(1), coder)
+	require.Equal(t, "a→😀", javaStringValue(utf16))
+
+	require.Same(t, vm.intern("x", nil), vm.intern("x", vm.NewString("x")))
+}
+
+func TestCpInfo_GetAsUTF8String_ModifiedUTF8(t *testing.T) {
+	tests := []struct {
+		name  string
+		bytes []byte
+		chars []uint16
+	}{
+		{name: "empty", bytes: []byte{}, chars: []uint16{}},
+		{name: "nul", bytes: []byte{0xC0, 0x80}, chars: []uint16{0}},
+		{name: "two and three bytes", bytes: []byte{'w', 0xC3, 0xB6, 0xE2, 0x86, 0x92}, chars: []uint16{'w', 0xF6, 0x2192}},
+		{name: "supplementary", bytes: []byte{0xED, 0xA0, 0xBD, 0xED, 0xB8, 0x80}, chars: []uint16{0xD83D, 0xDE00}},
+		{name: "lone surrogate", bytes: []byte{'a', 0xED, 0xA0, 0x80, 'b'}, chars: []uint16{'a', 0xD800, 'b'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &CpInfo{Tag: ConstantKindUTF8, Info: append(u2(uint16(len(tt.bytes))), tt.bytes...)}
+			s, err := info.GetAsUTF8String()
+			require.NoError(t, err)
+			require.Equal(t, tt.chars, javaChars(s))
+			require.Equal(t, string(tt.bytes), string(modifiedUTF8(s)))
+		})
+	}
+
+	for _, b := range [][]byte{{0}, {0xF0, 0x9F, 0x98, 0x80}, {0xC3}} {
+		_, err := (&CpInfo{Tag: ConstantKindUTF8, Info: append(u2(uint16(len(b))), b...)}).GetAsUTF8String()
+		require.Error(t, err)
+	}
+}
+
+func TestVirtualMachine_ExecMain_StringConstants(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printInt := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	length := b.methodRef("java/lang/String", "length", "()I")
+	charAt := b.methodRef("java/lang/String", "charAt", "(I)C")
+	code := newAsm()
+	for _, s := range []string{"", "\x00", "😀", stringFromChars([]uint16{0xD800})} {
+		idx := b.str(s)
+		code.ref(OpCodeGetStatic, out).ref(OpCodeLdcW, idx).ref(OpCodeInvokeVirtual, length).ref(OpCodeInvokeVirtual, printInt)
+		if s != "" {
+			code.ref(OpCodeGetStatic, out).ref(OpCodeLdcW, idx).op(OpCodeIconst0).ref(OpCodeInvokeVirtual, charAt).ref(OpCodeInvokeVirtual, printInt)
+		}
+	}
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 3, 1, code.op(OpCodeReturn).bytes()...)
+
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "0\n1\n0\n2\n55357\n1\n55296\n", stdout.String())
+}
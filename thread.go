@@ -0,0 +1,345 @@
+package jvmgo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// values of Thread.threadStatus, as JVMTI defines them
+const (
+	threadStatusNew        = 0
+	threadStatusTerminated = 0x0002
+	threadStatusRunnable   = 0x0005
+)
+
+// thread is the VM side of a java.lang.Thread. Every Java thread runs on a
+// goroutine of its own, with its own chain of frames.
+type thread struct {
+	object      *Object
+	daemon      bool
+	done        chan struct{}
+	interrupted int32
+	// wake is signalled when the thread is interrupted while it may block
+	wake chan struct{}
+	// top is the innermost frame, where the collector starts on the frames
+	// of the thread
+	top *Frame
+	// pending holds the arguments of the calls in progress until they
+	// return, since before the callee has a frame only Go code holds them
+	pending [][]Value
+	// inWorld is set while the thread holds the world lock of the heap
+	inWorld bool
+	// overflowing is set while the thread creates a StackOverflowError,
+	// which may go past MaxStackDepth
+	overflowing bool
+}
+
+func newThread(object *Object, daemon bool) *thread {
+	return &thread{object: object, daemon: daemon, done: make(chan struct{}), wake: make(chan struct{}, 1)}
+}
+
+// hold makes args a root of the collector until release is called.
+func (t *thread) hold(args []Value) {
+	t.pending = append(t.pending, args)
+}
+
+func (t *thread) release() {
+	t.pending[len(t.pending)-1] = nil
+	t.pending = t.pending[:len(t.pending)-1]
+}
+
+func (t *thread) interrupt() {
+	atomic.StoreInt32(&t.interrupted, 1)
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// isInterrupted reports the interrupt status and clears it when clear is set.
+func (t *thread) isInterrupted(clear bool) bool {
+	if clear {
+		return atomic.SwapInt32(&t.interrupted, 0) != 0
+	}
+	return atomic.LoadInt32(&t.interrupted) != 0
+}
+
+func (t *thread) alive() bool {
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// currentThread returns the thread running f. Frames entered from Go without
+// a caller run on the main thread.
+func (vm *VirtualMachine) currentThread(f *Frame) *thread {
+	if f != nil && f.thread != nil {
+		return f.thread
+	}
+	return vm.main
+}
+
+// currentThreadObject returns the java.lang.Thread of the thread running f,
+// creating the main thread of the bundled runtime on first use.
+func (vm *VirtualMachine) currentThreadObject(f *Frame) (*Object, error) {
+	t := vm.currentThread(f)
+	if t != vm.main {
+		return t.object, nil
+	}
+	vm.threadsMu.Lock()
+	defer vm.threadsMu.Unlock()
+	if vm.mainThread == nil && !vm.systemInitialized {
+		class, err := vm.LoadClass("java/lang/Thread")
+		if err != nil {
+			return nil, err
+		}
+		if class.File != nil || class.stub {
+			// the class library creates its main thread in InitSystem
+			return nil, nil
+		}
+		obj := NewObject(class)
+		obj.SetField("name", "Ljava/lang/String;", vm.NewString("main"))
+		obj.SetField("priority", "I", int32(5))
+		obj.SetField("threadStatus", "I", int32(threadStatusRunnable))
+		vm.mainThread = obj
+	}
+	return vm.mainThread, nil
+}
+
+// threadOf returns the running thread of a java.lang.Thread, or nil when it
+// has not started or has terminated.
+func (vm *VirtualMachine) threadOf(obj *Object) *thread {
+	vm.threadsMu.Lock()
+	defer vm.threadsMu.Unlock()
+	if obj == vm.mainThread {
+		return vm.main
+	}
+	return vm.threads[obj]
+}
+
+// startThread runs the run method of obj on a new goroutine.
+func (vm *VirtualMachine) startThread(caller *Frame, obj *Object) error {
+	vm.threadsMu.Lock()
+	status, _ := obj.GetField("threadStatus", "I")
+	if obj == vm.mainThread || vm.threads[obj] != nil || status != nil && status != int32(threadStatusNew) {
+		vm.threadsMu.Unlock()
+		return vm.throwNew(caller, "java/lang/IllegalThreadStateException", "")
+	}
+	daemon, _ := obj.GetField("daemon", "Z")
+	t := newThread(obj, daemon == int32(1))
+	obj.SetField("threadStatus", "I", int32(threadStatusRunnable))
+	vm.threads[obj] = t
+	if !t.daemon {
+		vm.nonDaemon.Add(1)
+	}
+	vm.threadsMu.Unlock()
+
+	go vm.runThread(t)
+	return nil
+}
+
+func (vm *VirtualMachine) runThread(t *thread) {
+	vm.enterWorld(t)
+	defer vm.leaveWorld(t)
+	defer vm.exitThread(t)
+	root := &Frame{VM: vm, thread: t, OperandStack: &OperandStack{}}
+	if _, err := vm.InvokeVirtual(root, t.object, "run", "()V"); err != nil {
+		vm.uncaught(root, t, err)
+	}
+}
+
+func (vm *VirtualMachine) exitThread(t *thread) {
+	vm.threadsMu.Lock()
+	t.object.SetField("threadStatus", "I", int32(threadStatusTerminated))
+	delete(vm.threads, t.object)
+	vm.threadsMu.Unlock()
+	// wake the threads waiting on the Thread object for it to terminate
+	f := &Frame{VM: vm, thread: t}
+	if vm.monitorEnter(f, t.object) == nil {
+		_ = vm.notify(f, t.object, true)
+		_ = vm.monitorExit(f, t.object)
+	}
+	close(t.done)
+	if !t.daemon {
+		vm.nonDaemon.Done()
+	}
+}
+
+// uncaught handles an error that ended a thread other than main: an exit
+// halts the VM, a Java exception goes to the uncaught exception handler and
+// anything else is kept to be reported by ExecMain.
+func (vm *VirtualMachine) uncaught(root *Frame, t *thread, err error) {
+	var exit *ExitError
+	if errors.As(err, &exit) {
+		vm.halt(exit.Code)
+		return
+	}
+	var ex *JavaException
+	if !errors.As(err, &ex) {
+		vm.threadErrOnce.Do(func() { vm.threadErr = err })
+		return
+	}
+	if m := t.object.Class.LookupMethod("dispatchUncaughtException", "(Ljava/lang/Throwable;)V"); m != nil && !m.IsNative() {
+		if _, err := vm.invokeMethod(root, m, []Value{t.object, ex.Object}); err == nil {
+			return
+		}
+	}
+	name, _ := t.object.GetField("name", "Ljava/lang/String;")
+	msg, serr := vm.throwableString(root, ex.Object)
+	if serr != nil {
+		msg = ex.Error()
+	}
+	vm.write(vm.Err, []byte(fmt.Sprintf("Exception in thread \"%s\" %s\n", javaStringValue(name), msg)))
+}
+
+// halt stops every thread and makes ExecMain return with the exit code.
+func (vm *VirtualMachine) halt(code int) {
+	vm.abort(&ExitError{Code: code})
+}
+
+// abort stops every thread and makes them return err, unless the VM has
+// stopped already.
+func (vm *VirtualMachine) abort(err error) {
+	vm.exitOnce.Do(func() {
+		vm.exitErr = err
+		atomic.StoreInt32(&vm.halted, 1)
+		close(vm.exitCh)
+	})
+}
+
+func (vm *VirtualMachine) exitError() error {
+	return vm.exitErr
+}
+
+// waitThreads waits until the non-daemon threads end or the VM halts.
+func (vm *VirtualMachine) waitThreads() error {
+	done := make(chan struct{})
+	go func() {
+		vm.nonDaemon.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-vm.exitCh:
+		return vm.exitError()
+	}
+	return vm.threadErr
+}
+
+// sleep blocks the current thread for millis milliseconds unless it is
+// interrupted.
+func (vm *VirtualMachine) sleep(caller *Frame, millis int64) error {
+	if millis < 0 {
+		return vm.throwNew(caller, "java/lang/IllegalArgumentException", "timeout value is negative")
+	}
+	t := vm.currentThread(caller)
+	if vm.leaveWorld(t) {
+		defer vm.enterWorld(t)
+	}
+	timer := time.NewTimer(time.Duration(millis) * time.Millisecond)
+	defer timer.Stop()
+	for {
+		if t.isInterrupted(true) {
+			return vm.throwNew(caller, "java/lang/InterruptedException", "sleep interrupted")
+		}
+		select {
+		case <-timer.C:
+			return nil
+		case <-t.wake:
+		case <-vm.exitCh:
+			return vm.exitError()
+		}
+	}
+}
+
+// join waits for obj to terminate, for at most millis milliseconds unless
+// millis is zero.
+func (vm *VirtualMachine) join(caller *Frame, obj *Object, millis int64) error {
+	if millis < 0 {
+		return vm.throwNew(caller, "java/lang/IllegalArgumentException", "timeout value is negative")
+	}
+	target := vm.threadOf(obj)
+	if target == nil {
+		return nil
+	}
+	var timeout <-chan time.Time
+	if millis > 0 {
+		timer := time.NewTimer(time.Duration(millis) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	t := vm.currentThread(caller)
+	if vm.leaveWorld(t) {
+		defer vm.enterWorld(t)
+	}
+	for {
+		if t.isInterrupted(true) {
+			return vm.throwNew(caller, "java/lang/InterruptedException", "")
+		}
+		select {
+		case <-target.done:
+			return nil
+		case <-timeout:
+			return nil
+		case <-t.wake:
+		case <-vm.exitCh:
+			return vm.exitError()
+		}
+	}
+}
+
+// write serializes the output of the Java threads.
+func (vm *VirtualMachine) write(w io.Writer, p []byte) (int, error) {
+	vm.ioMu.Lock()
+	defer vm.ioMu.Unlock()
+	return w.Write(p)
+}
+
+func registerThreadNatives(r *NativeRegistry) {
+	r.Register("java/lang/Thread", "currentThread", "()Ljava/lang/Thread;", func(frame *Frame, args []Value) (Value, error) {
+		obj, err := frame.VM.currentThreadObject(frame)
+		if obj == nil {
+			return nil, err
+		}
+		return obj, err
+	})
+	r.Register("java/lang/Thread", "start0", "()V", func(frame *Frame, args []Value) (Value, error) {
+		return nil, frame.VM.startThread(frame, args[0].(*Object))
+	})
+	r.Register("java/lang/Thread", "isAlive", "()Z", func(frame *Frame, args []Value) (Value, error) {
+		t := frame.VM.threadOf(args[0].(*Object))
+		return javaBool(t != nil && t.alive()), nil
+	})
+	r.Register("java/lang/Thread", "sleep", "(J)V", func(frame *Frame, args []Value) (Value, error) {
+		return nil, frame.VM.sleep(frame, args[0].(int64))
+	})
+	r.Register("java/lang/Thread", "yield", "()V", func(frame *Frame, args []Value) (Value, error) {
+		runtime.Gosched()
+		return nil, nil
+	})
+	r.Register("java/lang/Thread", "interrupt0", "()V", func(frame *Frame, args []Value) (Value, error) {
+		if t := frame.VM.threadOf(args[0].(*Object)); t != nil {
+			t.interrupt()
+		}
+		return nil, nil
+	})
+	r.Register("java/lang/Thread", "isInterrupted", "(Z)Z", func(frame *Frame, args []Value) (Value, error) {
+		t := frame.VM.threadOf(args[0].(*Object))
+		return javaBool(t != nil && t.isInterrupted(args[1] == int32(1))), nil
+	})
+	r.Register("java/lang/Thread", "setPriority0", "(I)V", func(frame *Frame, args []Value) (Value, error) {
+		return nil, nil
+	})
+	// Thread.join waits on the monitor of the thread in the class library;
+	// waiting for the goroutine does the same without holding it
+	r.RegisterIntrinsic("java/lang/Thread", "join", "(J)V", func(frame *Frame, args []Value) (Value, error) {
+		return nil, frame.VM.join(frame, args[0].(*Object), args[1].(int64))
+	})
+}
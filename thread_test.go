@@ -0,0 +1,134 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachine_ExecMain_Threads(t *testing.T) {
+	cp := mapClassPath{}
+	// threadClass builds a subclass of Thread whose constructor takes an int
+	// kept in the field i
+	threadClass := func(name string, maxStack, maxLocals uint16, run func(b *classBuilder) (*asm, []*Exception)) {
+		b := newClassBuilder(name, "java/lang/Thread")
+		b.field(0, "i", "I")
+		i := b.fieldRef(name, "i", "I")
+		threadInit := b.methodRef("java/lang/Thread", "<init>", "()V")
+		b.method(AccPublic, "<init>", "(I)V", 2, 2, newAsm().
+			op(OpCodeAload0).ref(OpCodeInvokeSpecial, threadInit).
+			op(OpCodeAload0).op(OpCodeIload0+1).ref(OpCodePutField, i).op(OpCodeReturn).bytes()...)
+		code, handlers := run(b)
+		b.methodWithHandlers(AccPublic, "run", "()V", maxStack, maxLocals, code.bytes(), handlers)
+		cp.add(b.build())
+	}
+	sleep := func(b *classBuilder, a *asm, millis int32) *asm {
+		return a.op(OpCodeLdc, lo(b.integer(millis))).op(OpCodeI2l).
+			ref(OpCodeInvokeStatic, b.methodRef("java/lang/Thread", "sleep", "(J)V"))
+	}
+
+	// results[i] = i * 10 after a nap
+	threadClass("Square", 3, 1, func(b *classBuilder) (*asm, []*Exception) {
+		i := b.fieldRef("Square", "i", "I")
+		code := sleep(b, newAsm(), 5).
+			ref(OpCodeGetStatic, b.fieldRef("Main", "results", "[I")).
+			op(OpCodeAload0).ref(OpCodeGetField, i).
+			op(OpCodeAload0).ref(OpCodeGetField, i).op(OpCodeBipush, 10).op(OpCodeImul).
+			op(OpCodeIastore).op(OpCodeReturn)
+		return code, nil
+	})
+	// try { sleep(forever) } catch (InterruptedException e) { Main.caught = e.getMessage(); }
+	threadClass("Sleeper", 2, 2, func(b *classBuilder) (*asm, []*Exception) {
+		code := sleep(b, newAsm().label("start"), 1000000).label("end").op(OpCodeReturn).
+			label("handler").ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Throwable", "getMessage", "()Ljava/lang/String;")).
+			ref(OpCodePutStatic, b.fieldRef("Main", "caught", "Ljava/lang/String;")).op(OpCodeReturn)
+		return code, []*Exception{{StartPC: code.pc("start"), EndPC: code.pc("end"), HandlerPC: code.pc("handler"),
+			CatchType: b.classRef("java/lang/InterruptedException")}}
+	})
+	threadClass("Forever", 2, 1, func(b *classBuilder) (*asm, []*Exception) {
+		return sleep(b, newAsm(), 1000000).op(OpCodeReturn), nil
+	})
+	threadClass("Boom", 3, 1, func(b *classBuilder) (*asm, []*Exception) {
+		ex := b.classRef("java/lang/IllegalStateException")
+		return newAsm().ref(OpCodeNew, ex).op(OpCodeDup).op(OpCodeLdc, lo(b.str("boom"))).
+			ref(OpCodeInvokeSpecial, b.methodRef("java/lang/IllegalStateException", "<init>", "(Ljava/lang/String;)V")).
+			op(OpCodeAThrow), nil
+	})
+	threadClass("Late", 2, 1, func(b *classBuilder) (*asm, []*Exception) {
+		return sleep(b, newAsm(), 20).
+			ref(OpCodeGetStatic, b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")).
+			op(OpCodeLdc, lo(b.str("late"))).
+			ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")).
+			op(OpCodeReturn), nil
+	})
+
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.field(AccStatic, "results", "[I")
+	b.field(AccStatic, "caught", "Ljava/lang/String;")
+	results := b.fieldRef("Main", "results", "[I")
+	caught := b.fieldRef("Main", "caught", "Ljava/lang/String;")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printInt := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	printStr := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+	start := b.methodRef("java/lang/Thread", "start", "()V")
+	join := b.methodRef("java/lang/Thread", "join", "()V")
+	interrupt := b.methodRef("java/lang/Thread", "interrupt", "()V")
+	setDaemon := b.methodRef("java/lang/Thread", "setDaemon", "(Z)V")
+	getName := b.methodRef("java/lang/Thread", "getName", "()Ljava/lang/String;")
+	currentThread := b.methodRef("java/lang/Thread", "currentThread", "()Ljava/lang/Thread;")
+	newThread := func(a *asm, name string, local byte) *asm {
+		return a.ref(OpCodeNew, b.classRef(name)).op(OpCodeDup).op(OpCodeBipush, local).
+			ref(OpCodeInvokeSpecial, b.methodRef(name, "<init>", "(I)V")).op(OpCodeAstore, local)
+	}
+
+	code := newAsm().op(OpCodeIconst0+3).op(OpCodeNewArray, 10).ref(OpCodePutStatic, results)
+	newThread(code, "Square", 1).op(OpCodeAload, 1).ref(OpCodeInvokeVirtual, start)
+	newThread(code, "Square", 2).op(OpCodeAload, 2).ref(OpCodeInvokeVirtual, start).
+		op(OpCodeAload, 1).ref(OpCodeInvokeVirtual, join).
+		op(OpCodeAload, 2).ref(OpCodeInvokeVirtual, join).
+		ref(OpCodeGetStatic, out).
+		ref(OpCodeGetStatic, results).op(OpCodeIconst0+1).op(OpCodeIaload).
+		ref(OpCodeGetStatic, results).op(OpCodeIconst0+2).op(OpCodeIaload).
+		op(OpCodeIadd).ref(OpCodeInvokeVirtual, printInt).
+		// a thread starts only once
+		label("restart").op(OpCodeAload, 1).ref(OpCodeInvokeVirtual, start).label("restartEnd").
+		op(OpCodeReturn).
+		label("restartHandler").op(OpCodePop).
+		ref(OpCodeGetStatic, out).op(OpCodeLdc, lo(b.str("restart rejected"))).ref(OpCodeInvokeVirtual, printStr)
+	newThread(code, "Sleeper", 3).op(OpCodeAload, 3).ref(OpCodeInvokeVirtual, start).
+		op(OpCodeAload, 3).ref(OpCodeInvokeVirtual, interrupt).
+		op(OpCodeAload, 3).ref(OpCodeInvokeVirtual, join).
+		ref(OpCodeGetStatic, out).ref(OpCodeGetStatic, caught).ref(OpCodeInvokeVirtual, printStr).
+		ref(OpCodeGetStatic, out).ref(OpCodeInvokeStatic, currentThread).ref(OpCodeInvokeVirtual, getName).ref(OpCodeInvokeVirtual, printStr)
+	// the daemon thread does not keep the VM alive
+	newThread(code, "Forever", 4).op(OpCodeAload, 4).op(OpCodeIconst0+1).ref(OpCodeInvokeVirtual, setDaemon).
+		op(OpCodeAload, 4).ref(OpCodeInvokeVirtual, start)
+	newThread(code, "Boom", 5).op(OpCodeAload, 5).ref(OpCodeInvokeVirtual, start).
+		op(OpCodeAload, 5).ref(OpCodeInvokeVirtual, join)
+	// main returns first, but the VM waits for Late
+	newThread(code, "Late", 6).op(OpCodeAload, 6).ref(OpCodeInvokeVirtual, start).
+		ref(OpCodeGetStatic, out).op(OpCodeLdc, lo(b.str("main done"))).ref(OpCodeInvokeVirtual, printStr).
+		op(OpCodeReturn)
+	b.methodWithHandlers(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 4, 7, code.bytes(), []*Exception{
+		{StartPC: code.pc("restart"), EndPC: code.pc("restartEnd"), HandlerPC: code.pc("restartHandler"),
+			CatchType: b.classRef("java/lang/IllegalThreadStateException")},
+	})
+
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	var stdout, stderr bytes.Buffer
+	vm.Out, vm.Err = &stdout, &stderr
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "30\nrestart rejected\nsleep interrupted\nmain\nmain done\nlate\n", stdout.String())
+	require.Equal(t, "Exception in thread \"Thread-4\" java.lang.IllegalStateException: boom\n", stderr.String())
+}
+
+func TestVirtualMachine_Sleep_Interrupted(t *testing.T) {
+	vm := NewVM(newClassBuilder("Main", "java/lang/Object").build())
+	vm.main.interrupt()
+	require.EqualError(t, vm.sleep(nil, 1000000), "java/lang/InterruptedException: sleep interrupted")
+	require.False(t, vm.main.isInterrupted(false))
+	require.EqualError(t, vm.sleep(nil, -1), "java/lang/IllegalArgumentException: timeout value is negative")
+	require.NoError(t, vm.sleep(nil, 1))
+}
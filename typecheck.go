@@ -0,0 +1,84 @@
+package jvmgo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IsAssignableTo reports whether a reference to an instance of c may be
+// treated as t, following the rules of checkcast and instanceof in JVMS §6.5.
+func (c *RuntimeClass) IsAssignableTo(t *RuntimeClass) bool {
+	if c == t {
+		return true
+	}
+	switch {
+	case c.IsArray():
+		switch {
+		case t.IsArray():
+			if c.component == nil || t.component == nil {
+				// arrays of primitives are only assignable to themselves
+				return c.Name == t.Name
+			}
+			return c.component.IsAssignableTo(t.component)
+		case t.Name == "java/lang/Cloneable" || t.Name == "java/io/Serializable":
+			return true
+		}
+		return t.Name == "java/lang/Object"
+	case c.IsInterface():
+		if t.IsInterface() {
+			return c.implements(t)
+		}
+		return t.Name == "java/lang/Object"
+	}
+	if t.IsInterface() {
+		return c.implements(t)
+	}
+	return c.IsSubclassOf(t)
+}
+
+// classCastMessage describes a failed cast the way HotSpot does, e.g.
+// "class java.lang.Integer cannot be cast to class java.lang.String
+// (java.lang.Integer and java.lang.String are in module java.base of loader
+// 'bootstrap')".
+func classCastMessage(from, to string) string {
+	from, to = javaClassName(from), javaClassName(to)
+	fromLoader, toLoader := loaderDescription(from), loaderDescription(to)
+	if fromLoader == toLoader {
+		return fmt.Sprintf("class %s cannot be cast to class %s (%s and %s are in %s)", from, to, from, to, fromLoader)
+	}
+	return fmt.Sprintf("class %s cannot be cast to class %s (%s is in %s; %s is in %s)", from, to, from, fromLoader, to, toLoader)
+}
+
+// loaderDescription tells where a class comes from: the class library lives
+// in java.base and everything else in the unnamed module of the application
+// class loader.
+func loaderDescription(javaName string) string {
+	elem := strings.TrimLeft(javaName, "[")
+	if len(elem) < len(javaName) {
+		if elem[0] != 'L' {
+			return "module java.base of loader 'bootstrap'"
+		}
+		elem = elem[1:]
+	}
+	for _, p := range []string{"java.", "javax.", "jdk.", "sun."} {
+		if strings.HasPrefix(elem, p) {
+			return "module java.base of loader 'bootstrap'"
+		}
+	}
+	return "unnamed module of loader 'app'"
+}
+
+// checkCast throws ClassCastException unless v is null or assignable to t.
+func (vm *VirtualMachine) checkCast(f *Frame, v Value, t *RuntimeClass) error {
+	if isNull(v) {
+		return nil
+	}
+	c, err := vm.classOf(v)
+	if err != nil {
+		return err
+	}
+	if c.IsAssignableTo(t) {
+		return nil
+	}
+	return vm.throwNew(f, "java/lang/ClassCastException", classCastMessage(c.Name, t.Name))
+}
@@ -0,0 +1,112 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeClass_IsAssignableTo(t *testing.T) {
+	vm := NewVM(newClassBuilder("Main", "java/lang/Object").build())
+	load := func(name string) *RuntimeClass {
+		c, err := vm.LoadClass(name)
+		require.NoError(t, err)
+		return c
+	}
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"java/lang/String", "java/lang/Object", true},
+		{"java/lang/String", "java/lang/CharSequence", true},
+		{"java/lang/Integer", "java/lang/Number", true},
+		{"java/lang/Integer", "java/lang/String", false},
+		{"java/util/ArrayList", "java/lang/Iterable", true},
+		{"java/util/List", "java/util/Collection", true},
+		{"java/util/List", "java/lang/Object", true},
+		{"java/util/Collection", "java/util/List", false},
+		{"java/lang/Object", "java/lang/String", false},
+		{"[Ljava/lang/String;", "[Ljava/lang/Object;", true},
+		{"[Ljava/lang/String;", "[Ljava/lang/CharSequence;", true},
+		{"[Ljava/lang/Object;", "[Ljava/lang/String;", false},
+		{"[[Ljava/lang/String;", "[[Ljava/lang/Object;", true},
+		{"[[Ljava/lang/String;", "[Ljava/lang/Object;", true},
+		{"[[I", "[Ljava/lang/Cloneable;", true},
+		{"[I", "[I", true},
+		{"[I", "[J", false},
+		{"[I", "[Ljava/lang/Object;", false},
+		{"[I", "java/lang/Object", true},
+		{"[I", "java/lang/Cloneable", true},
+		{"[Ljava/lang/String;", "java/io/Serializable", true},
+		{"[Ljava/lang/String;", "java/lang/String", false},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, load(tt.from).IsAssignableTo(load(tt.to)), "%s to %s", tt.from, tt.to)
+	}
+}
+
+func TestVirtualMachine_ExecMain_TypeChecks(t *testing.T) {
+	cp := mapClassPath{}
+	cp.add(newClassBuilder("Shape", "java/lang/Object").build())
+
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printBool := b.methodRef("java/io/PrintStream", "println", "(Z)V")
+	printStr := b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+	getMessage := b.methodRef("java/lang/Throwable", "getMessage", "()Ljava/lang/String;")
+	str := b.classRef("java/lang/String")
+	object := b.classRef("java/lang/Object")
+	comparable := b.classRef("java/lang/Comparable")
+	objects := b.classRef("[Ljava/lang/Object;")
+	shape := b.classRef("Shape")
+	cce := b.classRef("java/lang/ClassCastException")
+	ase := b.classRef("java/lang/ArrayStoreException")
+	valueOf := b.methodRef("java/lang/Integer", "valueOf", "(I)Ljava/lang/Integer;")
+	hello := b.str("hello")
+
+	code := newAsm().
+		// Object[] a = new String[1]
+		op(OpCodeIconst0+1).ref(OpCodeANewArray, str).op(OpCodeAstore0+1).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).ref(OpCodeInstanceOf, objects).ref(OpCodeInvokeVirtual, printBool).
+		ref(OpCodeGetStatic, out).op(OpCodeLdc, lo(hello)).ref(OpCodeInstanceOf, comparable).ref(OpCodeInvokeVirtual, printBool).
+		ref(OpCodeGetStatic, out).op(OpCodeAconstNull).ref(OpCodeInstanceOf, object).ref(OpCodeInvokeVirtual, printBool).
+		op(OpCodeAconstNull).ref(OpCodeCheckCast, str).op(OpCodePop).
+		label("castStart").
+		op(OpCodeBipush, 7).ref(OpCodeInvokeStatic, valueOf).ref(OpCodeCheckCast, str).op(OpCodePop).
+		label("castEnd").
+		op(OpCodeReturn).
+		label("castHandler").
+		ref(OpCodeInvokeVirtual, getMessage).op(OpCodeAstore0+2).ref(OpCodeGetStatic, out).op(OpCodeAload0+2).ref(OpCodeInvokeVirtual, printStr).
+		label("shapeStart").
+		op(OpCodeAload0+1).ref(OpCodeCheckCast, shape).op(OpCodePop).
+		label("shapeEnd").
+		op(OpCodeReturn).
+		label("shapeHandler").
+		ref(OpCodeInvokeVirtual, getMessage).op(OpCodeAstore0+2).ref(OpCodeGetStatic, out).op(OpCodeAload0+2).ref(OpCodeInvokeVirtual, printStr).
+		label("storeStart").
+		op(OpCodeAload0+1).op(OpCodeIconst0).op(OpCodeIconst0+1).ref(OpCodeInvokeStatic, valueOf).op(OpCodeAastore).
+		label("storeEnd").
+		op(OpCodeReturn).
+		label("storeHandler").
+		ref(OpCodeInvokeVirtual, getMessage).op(OpCodeAstore0+2).ref(OpCodeGetStatic, out).op(OpCodeAload0+2).ref(OpCodeInvokeVirtual, printStr).
+		op(OpCodeReturn)
+	handler := func(start, end, handler string, catchType uint16) *Exception {
+		return &Exception{StartPC: code.pc(start), EndPC: code.pc(end), HandlerPC: code.pc(handler), CatchType: catchType}
+	}
+	b.methodWithHandlers(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 4, 3, code.bytes(), []*Exception{
+		handler("castStart", "castEnd", "castHandler", cce),
+		handler("shapeStart", "shapeEnd", "shapeHandler", cce),
+		handler("storeStart", "storeEnd", "storeHandler", ase),
+	})
+
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "true\ntrue\nfalse\n"+
+		"class java.lang.Integer cannot be cast to class java.lang.String (java.lang.Integer and java.lang.String are in module java.base of loader 'bootstrap')\n"+
+		"class [Ljava.lang.String; cannot be cast to class Shape ([Ljava.lang.String; is in module java.base of loader 'bootstrap'; Shape is in unnamed module of loader 'app')\n"+
+		"java.lang.Integer\n", stdout.String())
+}
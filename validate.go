@@ -0,0 +1,612 @@
+package jvmgo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+type (
+	// Problem is a violation of the class file format found by Validate.
+	Problem struct {
+		// Location tells where the problem is, like "constant pool #3" or
+		// "method main([Ljava/lang/String;)V".
+		Location string
+		Message  string
+	}
+
+	validator struct {
+		class    *ClassStructure
+		problems []Problem
+	}
+)
+
+var constantKindNames = map[ConstantKind]string{
+	ConstantKindUTF8:               "CONSTANT_Utf8",
+	ConstantKindInteger:            "CONSTANT_Integer",
+	ConstantKindFloat:              "CONSTANT_Float",
+	ConstantKindLong:               "CONSTANT_Long",
+	ConstantKindDouble:             "CONSTANT_Double",
+	ConstantKindClass:              "CONSTANT_Class",
+	ConstantKindString:             "CONSTANT_String",
+	ConstantKindFieldref:           "CONSTANT_Fieldref",
+	ConstantKindMethodref:          "CONSTANT_Methodref",
+	ConstantKindInterfaceMethodref: "CONSTANT_InterfaceMethodref",
+	ConstantKindNameAndType:        "CONSTANT_NameAndType",
+	ConstantKindMethodHandle:       "CONSTANT_MethodHandle",
+	ConstantKindMethodType:         "CONSTANT_MethodType",
+	ConstantKindDynamic:            "CONSTANT_Dynamic",
+	ConstantKindInvokeDynamic:      "CONSTANT_InvokeDynamic",
+	ConstantKindModule:             "CONSTANT_Module",
+	ConstantKindPackage:            "CONSTANT_Package",
+}
+
+// constantInfoLengths are the sizes of the constants with a fixed size.
+var constantInfoLengths = map[ConstantKind]int{
+	ConstantKindInteger:            4,
+	ConstantKindFloat:              4,
+	ConstantKindLong:               8,
+	ConstantKindDouble:             8,
+	ConstantKindClass:              2,
+	ConstantKindString:             2,
+	ConstantKindFieldref:           4,
+	ConstantKindMethodref:          4,
+	ConstantKindInterfaceMethodref: 4,
+	ConstantKindNameAndType:        4,
+	ConstantKindMethodHandle:       3,
+	ConstantKindMethodType:         2,
+	ConstantKindDynamic:            4,
+	ConstantKindInvokeDynamic:      4,
+	ConstantKindModule:             2,
+	ConstantKindPackage:            2,
+}
+
+func (p Problem) String() string {
+	return p.Location + ": " + p.Message
+}
+
+// Validate runs the format checks of JVMS §4.8 on a decoded class file: the
+// constant pool entries refer to entries of the right kinds, names and
+// descriptors are well formed, access flags are consistent and members are
+// unique. It returns every problem found, or nil for a well formed class.
+func Validate(c *ClassStructure) []Problem {
+	v := &validator{class: c}
+	if !c.supportedVersion() {
+		v.report("class", "unsupported class file version %d.%d", c.Major(), c.Minor())
+	}
+	v.checkConstantPool()
+	v.checkClass()
+	v.checkFields()
+	v.checkMethods()
+	v.checkAttributes("class", c.Attributes)
+	return v.problems
+}
+
+func (v *validator) report(loc, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Location: loc, Message: fmt.Sprintf(format, args...)})
+}
+
+// entry returns the constant at idx if it is of one of kinds.
+func (v *validator) entry(loc string, idx uint16, kinds ...ConstantKind) *CpInfo {
+	cp := v.class.ConstantPool
+	if idx == 0 || int(idx) > len(cp) || cp[idx-1] == nil || cp[idx-1].Tag == 0 {
+		v.report(loc, "invalid constant pool index %d", idx)
+		return nil
+	}
+	info := cp[idx-1]
+	names := make([]string, len(kinds))
+	for i, k := range kinds {
+		if info.Tag == k {
+			if n, ok := constantInfoLengths[k]; ok && len(info.Info) != n {
+				return nil
+			}
+			return info
+		}
+		names[i] = constantKindNames[k]
+	}
+	v.report(loc, "constant pool #%d is a %s, not a %s", idx, constantKindName(info.Tag), strings.Join(names, " or "))
+	return nil
+}
+
+func constantKindName(k ConstantKind) string {
+	if name, ok := constantKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("constant of tag %d", k)
+}
+
+// utf8 returns the string of the CONSTANT_Utf8 at idx.
+func (v *validator) utf8(loc string, idx uint16) (string, bool) {
+	if v.entry(loc, idx, ConstantKindUTF8) == nil {
+		return "", false
+	}
+	return v.lookupUTF8(idx)
+}
+
+// lookup returns the constant at idx if it is a well formed one of kind,
+// without reporting a problem otherwise.
+func (v *validator) lookup(idx uint16, kind ConstantKind) *CpInfo {
+	cp := v.class.ConstantPool
+	if idx == 0 || int(idx) > len(cp) || cp[idx-1] == nil || cp[idx-1].Tag != kind {
+		return nil
+	}
+	if n, ok := constantInfoLengths[kind]; ok && len(cp[idx-1].Info) != n {
+		return nil
+	}
+	return cp[idx-1]
+}
+
+func (v *validator) lookupUTF8(idx uint16) (string, bool) {
+	info := v.lookup(idx, ConstantKindUTF8)
+	if info == nil || len(info.Info) < 2 {
+		return "", false
+	}
+	return string(info.Info[2:]), true
+}
+
+func (v *validator) lookupClassName(idx uint16) (string, bool) {
+	info := v.lookup(idx, ConstantKindClass)
+	if info == nil {
+		return "", false
+	}
+	return v.lookupUTF8(binary.BigEndian.Uint16(info.Info))
+}
+
+func (v *validator) lookupNameAndType(idx uint16) (string, string, bool) {
+	info := v.lookup(idx, ConstantKindNameAndType)
+	if info == nil {
+		return "", "", false
+	}
+	name, ok := v.lookupUTF8(binary.BigEndian.Uint16(info.Info))
+	desc, ok2 := v.lookupUTF8(binary.BigEndian.Uint16(info.Info[2:]))
+	return name, desc, ok && ok2
+}
+
+func (v *validator) checkConstantPool() {
+	cp := v.class.ConstantPool
+	if int(v.class.ConstantPoolCount) != len(cp)+1 {
+		v.report("constant pool", "constant_pool_count is %d for %d entries", v.class.ConstantPoolCount, len(cp))
+	}
+	u2 := func(info *CpInfo, at int) uint16 { return binary.BigEndian.Uint16(info.Info[at:]) }
+	for i := 0; i < len(cp); i++ {
+		idx := uint16(i + 1)
+		loc := fmt.Sprintf("constant pool #%d", idx)
+		info := cp[i]
+		if info == nil || info.Tag == 0 {
+			v.report(loc, "unusable entry does not follow a CONSTANT_Long or CONSTANT_Double")
+			continue
+		}
+		if _, ok := constantKindNames[info.Tag]; !ok {
+			v.report(loc, "invalid constant pool tag %d", info.Tag)
+			continue
+		}
+		if n, ok := constantInfoLengths[info.Tag]; ok && len(info.Info) != n {
+			v.report(loc, "malformed %s", constantKindNames[info.Tag])
+			continue
+		}
+		switch info.Tag {
+		case ConstantKindUTF8:
+			if len(info.Info) < 2 || int(u2(info, 0)) != len(info.Info)-2 {
+				v.report(loc, "malformed CONSTANT_Utf8")
+			} else if !isModifiedUTF8(info.Info[2:]) {
+				v.report(loc, "invalid modified UTF-8")
+			}
+		case ConstantKindLong, ConstantKindDouble:
+			// the entry after a long or double is unusable
+			if i+1 < len(cp) && (cp[i+1] == nil || cp[i+1].Tag == 0) {
+				i++
+			} else {
+				v.report(loc, "%s is not followed by an unusable entry", constantKindNames[info.Tag])
+			}
+		case ConstantKindClass:
+			if name, ok := v.utf8(loc, u2(info, 0)); ok && !isClassName(name) && !(strings.HasPrefix(name, "[") && isFieldDescriptor(name)) {
+				v.report(loc, "invalid class name %q", name)
+			}
+		case ConstantKindString, ConstantKindModule, ConstantKindPackage:
+			v.utf8(loc, u2(info, 0))
+			if info.Tag != ConstantKindString && v.class.AccessFlags&AccModule == 0 {
+				v.report(loc, "%s outside a module-info class", constantKindNames[info.Tag])
+			}
+		case ConstantKindFieldref, ConstantKindMethodref, ConstantKindInterfaceMethodref:
+			v.entry(loc, u2(info, 0), ConstantKindClass)
+			v.checkMemberType(loc, u2(info, 2), info.Tag)
+		case ConstantKindNameAndType:
+			v.utf8(loc, u2(info, 0))
+			v.utf8(loc, u2(info, 2))
+		case ConstantKindMethodType:
+			if desc, ok := v.utf8(loc, u2(info, 0)); ok && !isMethodDescriptor(desc) {
+				v.report(loc, "invalid method descriptor %q", desc)
+			}
+		case ConstantKindMethodHandle:
+			v.checkMethodHandle(loc, info.Info[0], u2(info, 1))
+		case ConstantKindDynamic, ConstantKindInvokeDynamic:
+			v.checkDynamic(loc, u2(info, 0))
+			v.checkMemberType(loc, u2(info, 2), info.Tag)
+		}
+	}
+}
+
+// checkMemberType checks the name and type of a member reference or a
+// dynamically computed constant or call site.
+func (v *validator) checkMemberType(loc string, idx uint16, tag ConstantKind) {
+	if v.entry(loc, idx, ConstantKindNameAndType) == nil {
+		return
+	}
+	name, desc, ok := v.lookupNameAndType(idx)
+	if !ok {
+		// reported with the CONSTANT_NameAndType
+		return
+	}
+	switch tag {
+	case ConstantKindFieldref, ConstantKindDynamic:
+		if !isUnqualifiedName(name, false) {
+			v.report(loc, "invalid field name %q", name)
+		}
+		if !isFieldDescriptor(desc) {
+			v.report(loc, "invalid field descriptor %q", desc)
+		}
+		return
+	case ConstantKindMethodref:
+		if name == "<init>" {
+			if !strings.HasSuffix(desc, ")V") {
+				v.report(loc, "<init> must return void")
+			}
+			break
+		}
+		fallthrough
+	default:
+		if !isUnqualifiedName(name, true) {
+			v.report(loc, "invalid method name %q", name)
+		}
+	}
+	if !isMethodDescriptor(desc) {
+		v.report(loc, "invalid method descriptor %q", desc)
+	}
+}
+
+func (v *validator) checkMethodHandle(loc string, kind uint8, idx uint16) {
+	var kinds []ConstantKind
+	switch kind {
+	case RefGetField, RefGetStatic, RefPutField, RefPutStatic:
+		kinds = []ConstantKind{ConstantKindFieldref}
+	case RefInvokeVirtual, RefNewInvokeSpecial:
+		kinds = []ConstantKind{ConstantKindMethodref}
+	case RefInvokeStatic, RefInvokeSpecial:
+		kinds = []ConstantKind{ConstantKindMethodref}
+		if v.class.Major() >= 52 {
+			kinds = append(kinds, ConstantKindInterfaceMethodref)
+		}
+	case RefInvokeInterface:
+		kinds = []ConstantKind{ConstantKindInterfaceMethodref}
+	default:
+		v.report(loc, "invalid reference kind %d", kind)
+		return
+	}
+	ref := v.entry(loc, idx, kinds...)
+	if ref == nil {
+		return
+	}
+	name, _, ok := v.lookupNameAndType(binary.BigEndian.Uint16(ref.Info[2:]))
+	if ok && ((name == "<init>") != (kind == RefNewInvokeSpecial) || name == "<clinit>") {
+		v.report(loc, "reference kind %d cannot refer to %s", kind, name)
+	}
+}
+
+func (v *validator) checkDynamic(loc string, bootstrap uint16) {
+	methods := 0
+	for _, a := range v.class.Attributes {
+		if name, _ := v.lookupUTF8(a.AttributeNameIndex); name == "BootstrapMethods" && len(a.Info) >= 2 {
+			methods = int(binary.BigEndian.Uint16(a.Info))
+		}
+	}
+	if int(bootstrap) >= methods {
+		v.report(loc, "invalid bootstrap method index %d", bootstrap)
+	}
+}
+
+func (v *validator) checkClass() {
+	c := v.class
+	flags := c.AccessFlags
+	if flags&AccModule != 0 {
+		return
+	}
+	if flags&AccInterface != 0 {
+		if flags&AccAbstract == 0 {
+			v.report("class", "interface is not abstract")
+		}
+		if flags&(AccFinal|AccSuper|AccEnum) != 0 {
+			v.report("class", "interface has illegal access flags %#04x", flags)
+		}
+	} else {
+		if flags&AccAnnotation != 0 {
+			v.report("class", "annotation type is not an interface")
+		}
+		if flags&AccFinal != 0 && flags&AccAbstract != 0 {
+			v.report("class", "class is both final and abstract")
+		}
+	}
+
+	if v.entry("this_class", c.ThisClass, ConstantKindClass) == nil {
+		return
+	}
+	name, _ := v.lookupClassName(c.ThisClass)
+	switch {
+	case c.SuperClass == 0:
+		if name != "java/lang/Object" {
+			v.report("super_class", "%s has no super class", name)
+		}
+	case v.entry("super_class", c.SuperClass, ConstantKindClass) != nil:
+		if super, _ := v.lookupClassName(c.SuperClass); flags&AccInterface != 0 && super != "java/lang/Object" {
+			v.report("super_class", "super class of interface is %s, not java/lang/Object", super)
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, idx := range c.Interfaces {
+		loc := fmt.Sprintf("interfaces[%d]", i)
+		if v.entry(loc, idx, ConstantKindClass) == nil {
+			continue
+		}
+		iface, _ := v.lookupClassName(idx)
+		if seen[iface] {
+			v.report(loc, "duplicate interface %s", iface)
+		}
+		seen[iface] = true
+	}
+}
+
+func (v *validator) checkFields() {
+	c := v.class
+	seen := map[string]bool{}
+	for i, f := range c.Fields {
+		loc := fmt.Sprintf("fields[%d]", i)
+		name, nameOK := v.utf8(loc, f.NameIndex)
+		desc, descOK := v.utf8(loc, f.DescriptorIndex)
+		if nameOK && descOK {
+			loc = "field " + name + ":" + desc
+			if seen[name+":"+desc] {
+				v.report(loc, "duplicate field")
+			}
+			seen[name+":"+desc] = true
+		}
+		if nameOK && !isUnqualifiedName(name, false) {
+			v.report(loc, "invalid field name %q", name)
+		}
+		if descOK && !isFieldDescriptor(desc) {
+			v.report(loc, "invalid field descriptor %q", desc)
+			descOK = false
+		}
+
+		flags := f.AccessFlags
+		switch {
+		case c.AccessFlags&AccInterface != 0:
+			if flags&(AccPublic|AccStatic|AccFinal) != AccPublic|AccStatic|AccFinal || flags&^(AccPublic|AccStatic|AccFinal|AccSynthetic) != 0 {
+				v.report(loc, "interface field has illegal access flags %#04x", flags)
+			}
+		case !hasOneAccess(flags):
+			v.report(loc, "more than one of public, private and protected")
+		case flags&AccFinal != 0 && flags&AccVolatile != 0:
+			v.report(loc, "field is both final and volatile")
+		}
+
+		values := 0
+		for _, a := range f.Attributes {
+			if name, _ := v.lookupUTF8(a.AttributeNameIndex); name != "ConstantValue" || flags&AccStatic == 0 {
+				continue
+			}
+			values++
+			if len(a.Info) != 2 {
+				v.report(loc, "malformed ConstantValue attribute")
+			} else if descOK {
+				v.entry(loc, binary.BigEndian.Uint16(a.Info), constantValueKind(desc))
+			}
+		}
+		if values > 1 {
+			v.report(loc, "more than one ConstantValue attribute")
+		}
+		v.checkAttributes(loc, f.Attributes)
+	}
+}
+
+// constantValueKind returns the kind of constant that initializes a field of
+// type desc.
+func constantValueKind(desc string) ConstantKind {
+	switch desc {
+	case "J":
+		return ConstantKindLong
+	case "F":
+		return ConstantKindFloat
+	case "D":
+		return ConstantKindDouble
+	case "Ljava/lang/String;":
+		return ConstantKindString
+	}
+	return ConstantKindInteger
+}
+
+func (v *validator) checkMethods() {
+	c := v.class
+	iface := c.AccessFlags&AccInterface != 0
+	seen := map[string]bool{}
+	for i, m := range c.Methods {
+		loc := fmt.Sprintf("methods[%d]", i)
+		name, nameOK := v.utf8(loc, m.NameIndex)
+		desc, descOK := v.utf8(loc, m.DescriptorIndex)
+		if nameOK && descOK {
+			loc = "method " + name + desc
+			if seen[name+desc] {
+				v.report(loc, "duplicate method")
+			}
+			seen[name+desc] = true
+		}
+		if nameOK && name != "<init>" && name != "<clinit>" && !isUnqualifiedName(name, true) {
+			v.report(loc, "invalid method name %q", name)
+		}
+		var d *MethodDescriptor
+		if descOK {
+			if !isMethodDescriptor(desc) {
+				v.report(loc, "invalid method descriptor %q", desc)
+			} else {
+				d, _ = parseMethodDescriptor(desc)
+			}
+		}
+
+		flags := m.AccessFlags
+		slots := 0
+		if d != nil {
+			slots = d.ArgSlots()
+			if flags&AccStatic == 0 {
+				slots++
+			}
+			if slots > 255 {
+				v.report(loc, "parameters take %d slots, more than 255", slots)
+			}
+		}
+		switch {
+		case name == "<clinit>":
+		case name == "<init>":
+			if iface {
+				v.report(loc, "interface has a constructor")
+			}
+			if d != nil && d.Return != "V" {
+				v.report(loc, "<init> must return void")
+			}
+			if flags&(AccStatic|AccFinal|AccSynchronized|AccNative|AccAbstract) != 0 {
+				v.report(loc, "constructor has illegal access flags %#04x", flags)
+			}
+		case iface && c.Major() >= 52:
+			if flags&(AccProtected|AccFinal|AccSynchronized|AccNative) != 0 || flags&(AccPublic|AccPrivate) == 0 {
+				v.report(loc, "interface method has illegal access flags %#04x", flags)
+			}
+		case iface:
+			if flags&(AccPublic|AccAbstract) != AccPublic|AccAbstract {
+				v.report(loc, "interface method is not public and abstract")
+			}
+		}
+		if !hasOneAccess(flags) {
+			v.report(loc, "more than one of public, private and protected")
+		}
+		if flags&AccAbstract != 0 && flags&(AccPrivate|AccStatic|AccFinal|AccSynchronized|AccNative|AccStrict) != 0 {
+			v.report(loc, "abstract method has illegal access flags %#04x", flags)
+		}
+
+		codes := 0
+		for _, a := range m.Attributes {
+			if name, _ := v.lookupUTF8(a.AttributeNameIndex); name == "Code" {
+				codes++
+				v.checkCode(loc, a, slots)
+			}
+		}
+		switch {
+		case flags&(AccNative|AccAbstract) != 0 && codes > 0:
+			v.report(loc, "native or abstract method has a Code attribute")
+		case flags&(AccNative|AccAbstract) == 0 && codes != 1:
+			v.report(loc, "method needs exactly one Code attribute, has %d", codes)
+		}
+		v.checkAttributes(loc, m.Attributes)
+	}
+}
+
+func (v *validator) checkCode(loc string, a *AttributeInfo, slots int) {
+	code, err := a.toCodeAttribute()
+	if err != nil {
+		v.report(loc, "malformed Code attribute: %v", err)
+		return
+	}
+	if len(code.Code) == 0 || len(code.Code) >= 1<<16 {
+		v.report(loc, "code length %d is not between 1 and 65535", len(code.Code))
+	}
+	if int(code.MaxLocals) < slots {
+		v.report(loc, "max_locals %d is less than the %d slots of the parameters", code.MaxLocals, slots)
+	}
+	for i, e := range code.ExceptionTable {
+		eloc := fmt.Sprintf("%s exception_table[%d]", loc, i)
+		if e.StartPC >= e.EndPC || int(e.EndPC) > len(code.Code) || int(e.HandlerPC) >= len(code.Code) {
+			v.report(eloc, "invalid range %d-%d or handler %d", e.StartPC, e.EndPC, e.HandlerPC)
+		}
+		if e.CatchType != 0 {
+			v.entry(eloc, e.CatchType, ConstantKindClass)
+		}
+	}
+	v.checkAttributes(loc+" Code", code.Attributes)
+}
+
+// checkAttributes checks that the attributes are named and that their
+// lengths agree with their contents.
+func (v *validator) checkAttributes(loc string, attrs []*AttributeInfo) {
+	for i, a := range attrs {
+		name, ok := v.utf8(fmt.Sprintf("%s attributes[%d]", loc, i), a.AttributeNameIndex)
+		if ok && int(a.AttributeLength) != len(a.Info) {
+			v.report(loc, "attribute %s has length %d but %d bytes", name, a.AttributeLength, len(a.Info))
+		}
+	}
+}
+
+func hasOneAccess(flags uint16) bool {
+	n := 0
+	for _, f := range []uint16{AccPublic, AccPrivate, AccProtected} {
+		if flags&f != 0 {
+			n++
+		}
+	}
+	return n <= 1
+}
+
+// isUnqualifiedName reports whether s may name a field or, if method is
+// set, a method other than <init> and <clinit>, JVMS §4.2.2.
+func isUnqualifiedName(s string, method bool) bool {
+	if s == "" {
+		return false
+	}
+	chars := ".;[/"
+	if method {
+		chars += "<>"
+	}
+	return !strings.ContainsAny(s, chars)
+}
+
+// isClassName reports whether s is a binary class name in internal form.
+func isClassName(s string) bool {
+	for _, id := range strings.Split(s, "/") {
+		if !isUnqualifiedName(id, false) {
+			return false
+		}
+	}
+	return true
+}
+
+func isFieldDescriptor(s string) bool {
+	n, err := fieldDescriptorLength(s)
+	if err != nil || n != len(s) {
+		return false
+	}
+	dims := len(s) - len(strings.TrimLeft(s, "["))
+	if dims > 255 {
+		return false
+	}
+	return s[dims] != 'L' || isClassName(s[dims+1:len(s)-1])
+}
+
+func isMethodDescriptor(s string) bool {
+	d, err := parseMethodDescriptor(s)
+	if err != nil {
+		return false
+	}
+	for _, p := range d.Parameters {
+		if !isFieldDescriptor(p) {
+			return false
+		}
+	}
+	return d.Return == "V" || isFieldDescriptor(d.Return)
+}
+
+// isModifiedUTF8 reports whether b may be the contents of a CONSTANT_Utf8,
+// which never holds a zero byte or a byte in the range 0xf0 to 0xff.
+func isModifiedUTF8(b []byte) bool {
+	for _, c := range b {
+		if c == 0 || c >= 0xf0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package jvmgo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	buf, err := ioutil.ReadFile("HelloWorld.class")
+	require.NoError(t, err)
+	class, err := DecodeClassStructure(bytes.NewBuffer(buf))
+	require.NoError(t, err)
+	require.Empty(t, Validate(class))
+
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.field(AccPublic|AccStatic, "count", "I")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 0, 1, byte(OpCodeReturn))
+	require.Empty(t, Validate(b.build()))
+}
+
+func TestValidate_Problems(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.class.AccessFlags = AccPublic | AccFinal | AccAbstract
+	// a CONSTANT_String pointing at a CONSTANT_Class
+	other := b.classRef("Other")
+	str := b.add(ConstantKindString, u2(other))
+	field := b.add(ConstantKindFieldref, append(u2(other), u2(b.nameAndType("x", "Q"))...))
+	b.field(AccPublic|AccPrivate, "a.b", "I")
+	b.field(AccFinal|AccVolatile, "v", "I")
+	b.method(AccStatic, "f", "()V", 0, 0, byte(OpCodeReturn))
+	b.method(AccStatic, "f", "()V", 0, 0, byte(OpCodeReturn))
+	b.method(AccAbstract|AccStatic, "g", "(I", 0, 0)
+	b.method(AccStatic, "h", "(JJ)V", 0, 2, byte(OpCodeReturn))
+	b.method(AccPublic, "<init>", "()I", 0, 1)
+
+	var problems []string
+	for _, p := range Validate(b.build()) {
+		problems = append(problems, p.String())
+	}
+	require.Equal(t, []string{
+		fmt.Sprintf("constant pool #%d: constant pool #%d is a CONSTANT_Class, not a CONSTANT_Utf8", str, other),
+		fmt.Sprintf("constant pool #%d: invalid field descriptor \"Q\"", field),
+		"class: class is both final and abstract",
+		"field a.b:I: invalid field name \"a.b\"",
+		"field a.b:I: more than one of public, private and protected",
+		"field v:I: field is both final and volatile",
+		"method f()V: duplicate method",
+		"method g(I: invalid method descriptor \"(I\"",
+		"method g(I: abstract method has illegal access flags 0x0408",
+		"method h(JJ)V: max_locals 2 is less than the 4 slots of the parameters",
+		"method <init>()I: <init> must return void",
+		"method <init>()I: method needs exactly one Code attribute, has 0",
+	}, problems)
+}
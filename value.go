@@ -0,0 +1,128 @@
+package jvmgo
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+type (
+	// Value holds a single JVM value: int32 (also boolean, byte, char and short),
+	// int64, float32, float64, *Object, nil as the null reference or a
+	// returnAddress pushed by jsr.
+	Value interface{}
+	// returnAddress is the pc of the instruction following a jsr, which ret
+	// continues at.
+	returnAddress int
+	Object        struct {
+		Class  *RuntimeClass
+		Fields []Value
+		Array  interface{}
+		Extra  interface{}
+		hash   int32
+		// mon points to the monitor of the object, created on first use
+		mon unsafe.Pointer
+		// gcMark is the epoch of the last collection that reached the object
+		gcMark uint32
+	}
+)
+
+var identityHashSeed uint32
+
+func NewObject(class *RuntimeClass) *Object {
+	fields := make([]Value, class.InstanceFields)
+	copy(fields, class.fieldDefaults)
+	return &Object{
+		Class:  class,
+		Fields: fields,
+		hash:   nextIdentityHash(),
+	}
+}
+
+func NewArray(class *RuntimeClass, array interface{}) *Object {
+	return &Object{
+		Class: class,
+		Array: array,
+		hash:  nextIdentityHash(),
+	}
+}
+
+func (o *Object) IdentityHashCode() int32 {
+	return o.hash
+}
+
+func (o *Object) ClassName() string {
+	if o.Class == nil {
+		return ""
+	}
+	return o.Class.Name
+}
+
+func (o *Object) ArrayLength() int {
+	switch a := o.Array.(type) {
+	case []int8:
+		return len(a)
+	case []uint16:
+		return len(a)
+	case []int16:
+		return len(a)
+	case []int32:
+		return len(a)
+	case []int64:
+		return len(a)
+	case []float32:
+		return len(a)
+	case []float64:
+		return len(a)
+	case []Value:
+		return len(a)
+	}
+	return -1
+}
+
+// GetField returns the value of the named instance field declared by the
+// object's class or one of its superclasses.
+func (o *Object) GetField(name, descriptor string) (Value, bool) {
+	f := o.Class.LookupField(name, descriptor)
+	if f == nil || f.IsStatic() {
+		return nil, false
+	}
+	return loadSlot(o.Fields, f.Slot), true
+}
+
+func (o *Object) SetField(name, descriptor string, v Value) bool {
+	f := o.Class.LookupField(name, descriptor)
+	if f == nil || f.IsStatic() {
+		return false
+	}
+	storeSlot(o.Fields, f.Slot, v)
+	return true
+}
+
+func nextIdentityHash() int32 {
+	// xorshift over a counter so consecutive objects get unrelated hashes
+	x := atomic.AddUint32(&identityHashSeed, 0x9e3779b9)
+	x ^= x << 13
+	x ^= x >> 17
+	x ^= x << 5
+	return int32(x & 0x7fffffff)
+}
+
+func newArrayStorage(componentDesc string, length int) interface{} {
+	switch componentDesc {
+	case "B", "Z":
+		return make([]int8, length)
+	case "C":
+		return make([]uint16, length)
+	case "S":
+		return make([]int16, length)
+	case "I":
+		return make([]int32, length)
+	case "J":
+		return make([]int64, length)
+	case "F":
+		return make([]float32, length)
+	case "D":
+		return make([]float64, length)
+	}
+	return make([]Value, length)
+}
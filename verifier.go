@@ -0,0 +1,1256 @@
+package jvmgo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// verification types, JVMS §4.10.1.2
+const (
+	vTop = iota
+	vInt
+	vFloat
+	vLong
+	vDouble
+	vRetAddr
+	vNull
+	vUninitThis
+	vUninit
+	vRef
+)
+
+type (
+	// VerifyError reports a method that fails bytecode verification.
+	VerifyError struct {
+		Class      string
+		Method     string
+		Descriptor string
+		PC         int
+		Reason     string
+	}
+
+	vtype struct {
+		kind int
+		// name is the class of a reference; pc is the new instruction that
+		// created an uninitialized object or the pc a returnAddress returns to
+		name string
+		pc   int
+	}
+
+	vframe struct {
+		locals []vtype
+		stack  []vtype
+		// thisUninit is set in a constructor until it calls super() or this()
+		thisUninit bool
+	}
+
+	// verifier type checks the code of a method against its StackMapTable
+	// per JVMS §4.10.1, or infers the types per JVMS §4.10.2 if infer is set.
+	verifier struct {
+		vm     *VirtualMachine
+		class  *RuntimeClass
+		file   *ClassStructure
+		method *RuntimeMethod
+		code   []byte
+
+		maxStack, maxLocals int
+		starts              []bool
+		frames              map[int]*vframe
+		cur                 *vframe
+		pc                  int
+		err                 *VerifyError
+
+		infer  bool
+		ctx    []subroutineCall
+		states map[inferKey]*inferState
+		queue  []inferKey
+	}
+)
+
+var (
+	vtTop         = vtype{kind: vTop}
+	vtInt         = vtype{kind: vInt}
+	vtFloat       = vtype{kind: vFloat}
+	vtLong        = vtype{kind: vLong}
+	vtDouble      = vtype{kind: vDouble}
+	vtNull        = vtype{kind: vNull}
+	vtUninitThis  = vtype{kind: vUninitThis}
+	vtThrowable   = vtRef("java/lang/Throwable")
+	primitiveKind = [...]vtype{vtInt, vtLong, vtFloat, vtDouble}
+)
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%s.%s%s @%d: %s", e.Class, e.Method, e.Descriptor, e.PC, e.Reason)
+}
+
+func vtRef(name string) vtype {
+	return vtype{kind: vRef, name: name}
+}
+
+// vtDescriptor returns the verification type of a field descriptor.
+func vtDescriptor(desc string) vtype {
+	switch desc[0] {
+	case 'Z', 'B', 'C', 'S', 'I':
+		return vtInt
+	case 'F':
+		return vtFloat
+	case 'J':
+		return vtLong
+	case 'D':
+		return vtDouble
+	case 'L':
+		return vtRef(desc[1 : len(desc)-1])
+	}
+	return vtRef(desc)
+}
+
+func (t vtype) size() int {
+	if t.kind == vLong || t.kind == vDouble {
+		return 2
+	}
+	return 1
+}
+
+func (t vtype) isReference() bool {
+	return t.kind >= vNull
+}
+
+func (t vtype) String() string {
+	switch t.kind {
+	case vTop:
+		return "top"
+	case vInt:
+		return "integer"
+	case vFloat:
+		return "float"
+	case vLong:
+		return "long"
+	case vDouble:
+		return "double"
+	case vRetAddr:
+		return "returnAddress"
+	case vNull:
+		return "null"
+	case vUninitThis:
+		return "uninitializedThis"
+	case vUninit:
+		return fmt.Sprintf("uninitialized(%d)", t.pc)
+	}
+	return "'" + t.name + "'"
+}
+
+func (f *vframe) copy() *vframe {
+	return &vframe{
+		locals:     append([]vtype(nil), f.locals...),
+		stack:      append([]vtype(nil), f.stack...),
+		thisUninit: f.thisUninit,
+	}
+}
+
+// verifyClass verifies the methods of c and its superinterfaces once. The
+// classes read from the class library of a JDK are trusted, like HotSpot
+// trusts the classes of the boot loader.
+func (vm *VirtualMachine) verifyClass(c *RuntimeClass) error {
+	c.verifyOnce.Do(func() {
+		if c.File == nil || c.stub || c.boot {
+			return
+		}
+		for _, i := range c.Interfaces {
+			if c.verifyErr = vm.verifyClass(i); c.verifyErr != nil {
+				return
+			}
+		}
+		for _, m := range c.Methods {
+			if m.Code == nil {
+				continue
+			}
+			if err := verifyMethod(vm, c, m); err != nil {
+				c.verifyErr = err
+				return
+			}
+		}
+	})
+	return c.verifyErr
+}
+
+// verifyMethod type checks class files of version 50 and above, which have
+// a StackMapTable, and infers the types in older ones. Like HotSpot, it falls
+// back to type inference when type checking fails for version 50.
+func verifyMethod(vm *VirtualMachine, c *RuntimeClass, m *RuntimeMethod) error {
+	major := c.File.Major()
+	err := newVerifier(vm, c, m, major < 50).run()
+	if err != nil && major == 50 && newVerifier(vm, c, m, true).run() == nil {
+		return nil
+	}
+	return err
+}
+
+func newVerifier(vm *VirtualMachine, c *RuntimeClass, m *RuntimeMethod, infer bool) *verifier {
+	return &verifier{
+		vm:        vm,
+		class:     c,
+		file:      c.File,
+		method:    m,
+		code:      m.Code.Code,
+		maxStack:  int(m.Code.MaxStack),
+		maxLocals: int(m.Code.MaxLocals),
+		infer:     infer,
+	}
+}
+
+func (v *verifier) fail(format string, args ...interface{}) {
+	if v.err == nil {
+		v.err = &VerifyError{
+			Class:      v.class.Name,
+			Method:     v.method.Name,
+			Descriptor: v.method.Descriptor,
+			PC:         v.pc,
+			Reason:     fmt.Sprintf(format, args...),
+		}
+	}
+}
+
+func (v *verifier) run() error {
+	v.check()
+	if v.err != nil {
+		return v.err
+	}
+	return nil
+}
+
+func (v *verifier) check() {
+	if len(v.code) == 0 {
+		v.fail("Code is empty")
+		return
+	}
+	v.starts = make([]bool, len(v.code)+1)
+	for v.pc = 0; v.pc < len(v.code); {
+		n := instructionLength(v.code, v.pc)
+		if n == 0 {
+			v.fail("Illegal instruction or truncated code")
+			return
+		}
+		v.starts[v.pc] = true
+		v.pc += n
+	}
+	v.pc = 0
+	v.starts[len(v.code)] = true
+
+	initial := v.initialFrame()
+	if v.err != nil {
+		return
+	}
+	if !v.infer {
+		v.decodeFrames(initial)
+	}
+	v.checkHandlers()
+	if v.err != nil {
+		return
+	}
+	if v.infer {
+		v.inferTypes(initial)
+		return
+	}
+
+	v.cur = initial
+	dead := false
+	for v.pc = 0; v.pc < len(v.code); v.pc += instructionLength(v.code, v.pc) {
+		if f, ok := v.frames[v.pc]; ok {
+			if !dead && !v.frameAssignable(v.cur, f) {
+				v.fail("Current frame is not assignable to stack map frame")
+				return
+			}
+			v.cur = f.copy()
+		} else if dead {
+			v.fail("Expecting a stack map frame")
+			return
+		}
+		v.checkExceptionFrames()
+		dead = v.execute()
+		if v.err != nil {
+			return
+		}
+		v.checkExceptionFrames()
+		if v.err != nil {
+			return
+		}
+	}
+	if !dead {
+		v.fail("Falling off the end of the code")
+	}
+}
+
+// initialFrame returns the frame on entry to the method, which holds this
+// and the arguments.
+func (v *verifier) initialFrame() *vframe {
+	f := &vframe{locals: make([]vtype, v.maxLocals)}
+	var locals []vtype
+	if !v.method.IsStatic() {
+		if v.method.Name == "<init>" && v.class.Super != nil {
+			locals = append(locals, vtUninitThis)
+			f.thisUninit = true
+		} else {
+			locals = append(locals, vtRef(v.class.Name))
+		}
+	}
+	for _, p := range v.method.Desc.Parameters {
+		locals = append(locals, vtDescriptor(p))
+	}
+	if !v.setLocals(f, locals) {
+		v.fail("Arguments can't fit into locals")
+	}
+	return f
+}
+
+// setLocals lays out the locals of a stack map frame, where a long or double
+// takes two slots.
+func (v *verifier) setLocals(f *vframe, locals []vtype) bool {
+	for i := range f.locals {
+		f.locals[i] = vtTop
+	}
+	i := 0
+	for _, t := range locals {
+		if i+t.size() > len(f.locals) {
+			return false
+		}
+		f.locals[i] = t
+		i += t.size()
+	}
+	return true
+}
+
+// decodeFrames expands the StackMapTable into the frames at the pcs it
+// lists.
+func (v *verifier) decodeFrames(initial *vframe) {
+	entries, err := v.method.Code.StackMapTable(v.file)
+	if err != nil {
+		v.fail("Invalid StackMapTable: %v", err)
+		return
+	}
+	v.frames = map[int]*vframe{}
+	var locals []vtype
+	for _, t := range initial.locals {
+		if t != vtTop {
+			locals = append(locals, t)
+		}
+	}
+	pc := -1
+	for _, e := range entries {
+		pc += int(e.OffsetDelta) + 1
+		if pc >= len(v.code) || !v.starts[pc] {
+			v.fail("StackMapTable error: bad offset %d", pc)
+			return
+		}
+		var stack []vtype
+		switch t := e.FrameType; {
+		case t >= 248 && t <= 250:
+			k := 251 - int(t)
+			if k > len(locals) {
+				v.fail("StackMapTable error: chop frame at %d removes too many locals", pc)
+				return
+			}
+			locals = locals[:len(locals)-k]
+		case t >= 252 && t <= 254:
+			locals = append(locals[:len(locals):len(locals)], v.frameTypes(e.Locals)...)
+		case t == 255:
+			locals = v.frameTypes(e.Locals)
+			stack = v.frameTypes(e.Stack)
+		default:
+			stack = v.frameTypes(e.Stack)
+		}
+		f := &vframe{locals: make([]vtype, v.maxLocals), stack: stack}
+		if !v.setLocals(f, locals) {
+			v.fail("StackMapTable error: locals of frame at %d exceed max locals", pc)
+			return
+		}
+		for _, t := range append(locals[:len(locals):len(locals)], stack...) {
+			f.thisUninit = f.thisUninit || t == vtUninitThis
+		}
+		if stackWords(stack) > v.maxStack {
+			v.fail("StackMapTable error: stack of frame at %d exceeds max stack", pc)
+			return
+		}
+		v.frames[pc] = f
+	}
+}
+
+func (v *verifier) frameTypes(items []VerificationTypeInfo) []vtype {
+	types := make([]vtype, len(items))
+	for i, item := range items {
+		switch item.Tag {
+		case ItemTop:
+			types[i] = vtTop
+		case ItemInteger:
+			types[i] = vtInt
+		case ItemFloat:
+			types[i] = vtFloat
+		case ItemDouble:
+			types[i] = vtDouble
+		case ItemLong:
+			types[i] = vtLong
+		case ItemNull:
+			types[i] = vtNull
+		case ItemUninitializedThis:
+			types[i] = vtUninitThis
+		case ItemObject:
+			types[i] = vtRef(v.className(item.CpoolIndex))
+		case ItemUninitialized:
+			pc := int(item.Offset)
+			if pc >= len(v.code) || OpCode(v.code[pc]) != OpCodeNew {
+				v.fail("StackMapTable error: uninitialized type does not refer to a new instruction at %d", pc)
+			}
+			types[i] = vtype{kind: vUninit, pc: pc}
+		}
+	}
+	return types
+}
+
+func stackWords(stack []vtype) int {
+	words := 0
+	for _, t := range stack {
+		words += t.size()
+	}
+	return words
+}
+
+// checkHandlers checks that the exception table covers instructions and
+// that its handlers start at a stack map frame.
+func (v *verifier) checkHandlers() {
+	for _, e := range v.method.Code.ExceptionTable {
+		start, end, handler := int(e.StartPC), int(e.EndPC), int(e.HandlerPC)
+		if start >= end || end > len(v.code) || !v.starts[start] || !v.starts[end] {
+			v.fail("Illegal exception table range")
+			return
+		}
+		if handler >= len(v.code) || !v.starts[handler] {
+			v.fail("Illegal exception table handler")
+			return
+		}
+		if _, ok := v.frames[handler]; !ok && !v.infer {
+			v.fail("Expecting a stack map frame at exception handler %d", handler)
+			return
+		}
+		if e.CatchType != 0 && !v.refAssignable(v.className(e.CatchType), vtThrowable.name) {
+			v.fail("Catch type is not a subclass of Throwable in exception handler %d", handler)
+			return
+		}
+	}
+}
+
+// checkExceptionFrames checks that the current locals may flow to the
+// handlers that cover the current instruction, or lets them flow there when
+// inferring types.
+func (v *verifier) checkExceptionFrames() {
+	for _, e := range v.method.Code.ExceptionTable {
+		if v.pc < int(e.StartPC) || v.pc >= int(e.EndPC) {
+			continue
+		}
+		catch := vtThrowable
+		if e.CatchType != 0 {
+			catch = vtRef(v.className(e.CatchType))
+		}
+		f := &vframe{locals: v.cur.locals, stack: []vtype{catch}, thisUninit: v.cur.thisUninit}
+		if v.infer {
+			v.flow(int(e.HandlerPC), v.ctx, f)
+			continue
+		}
+		if !v.frameAssignable(f, v.frames[int(e.HandlerPC)]) {
+			v.fail("Stack map does not match the one at exception handler %d", e.HandlerPC)
+			return
+		}
+	}
+}
+
+func (v *verifier) frameAssignable(from, to *vframe) bool {
+	if len(from.stack) != len(to.stack) || from.thisUninit && !to.thisUninit {
+		return false
+	}
+	for i, t := range from.stack {
+		if !v.assignable(t, to.stack[i]) {
+			return false
+		}
+	}
+	for i, t := range from.locals {
+		if !v.assignable(t, to.locals[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// assignable reports whether a value of type from may be used as a value of
+// type to. As in JVMS §4.10.1.2, interfaces are treated like Object.
+func (v *verifier) assignable(from, to vtype) bool {
+	if from == to || to.kind == vTop {
+		return true
+	}
+	if to.kind != vRef {
+		return false
+	}
+	switch from.kind {
+	case vNull:
+		return true
+	case vRef:
+		return v.refAssignable(from.name, to.name)
+	}
+	return false
+}
+
+func (v *verifier) refAssignable(from, to string) bool {
+	if from == to || to == "java/lang/Object" {
+		return true
+	}
+	if from[0] == '[' {
+		if to[0] != '[' {
+			return to == "java/lang/Cloneable" || to == "java/io/Serializable"
+		}
+		from, to = from[1:], to[1:]
+		if !isReferenceDescriptor(from) || !isReferenceDescriptor(to) {
+			return false
+		}
+		return v.refAssignable(vtDescriptor(from).name, vtDescriptor(to).name)
+	}
+	if to[0] == '[' {
+		return false
+	}
+	t := v.loadClass(to)
+	if t == nil {
+		return false
+	}
+	if t.IsInterface() {
+		return true
+	}
+	c := v.loadClass(from)
+	return c != nil && c.IsSubclassOf(t)
+}
+
+func isReferenceDescriptor(desc string) bool {
+	return desc[0] == 'L' || desc[0] == '['
+}
+
+func (v *verifier) loadClass(name string) *RuntimeClass {
+	c, err := v.vm.classOrStub(name)
+	if err != nil {
+		v.fail("Unable to load class %s: %v", name, err)
+		return nil
+	}
+	return c
+}
+
+// constant returns the constant pool entry at idx.
+func (v *verifier) constant(idx uint16) *CpInfo {
+	if idx == 0 || int(idx) > len(v.file.ConstantPool) || v.file.ConstantPool[idx-1] == nil || v.file.ConstantPool[idx-1].Tag == 0 {
+		v.fail("Illegal constant pool index %d", idx)
+		return nil
+	}
+	return v.file.ConstantPool[idx-1]
+}
+
+func (v *verifier) className(idx uint16) string {
+	if info := v.constant(idx); info == nil || info.Tag != ConstantKindClass {
+		v.fail("Illegal type at constant pool entry %d", idx)
+		return "java/lang/Object"
+	}
+	name, err := v.file.ClassName(idx)
+	if err != nil || name == "" {
+		v.fail("Illegal type at constant pool entry %d", idx)
+		return "java/lang/Object"
+	}
+	return name
+}
+
+func (v *verifier) memberRef(idx uint16, kinds ...ConstantKind) *MemberRef {
+	info := v.constant(idx)
+	if info == nil {
+		return nil
+	}
+	for _, k := range kinds {
+		if info.Tag == k {
+			ref, err := v.file.GetMemberRef(idx)
+			if err != nil {
+				v.fail("Illegal member reference at constant pool entry %d: %v", idx, err)
+				return nil
+			}
+			return ref
+		}
+	}
+	v.fail("Illegal type at constant pool entry %d", idx)
+	return nil
+}
+
+func (v *verifier) push(types ...vtype) {
+	for _, t := range types {
+		if stackWords(v.cur.stack)+t.size() > v.maxStack {
+			v.fail("Operand stack overflow")
+			return
+		}
+		v.cur.stack = append(v.cur.stack, t)
+	}
+}
+
+// pop pops a value assignable to want and returns its actual type.
+func (v *verifier) pop(want vtype) vtype {
+	s := v.cur.stack
+	if len(s) == 0 {
+		v.fail("Operand stack underflow")
+		return want
+	}
+	got := s[len(s)-1]
+	v.cur.stack = s[:len(s)-1]
+	if !v.assignable(got, want) {
+		v.fail("Bad type on operand stack: Type %s (current frame, stack[%d]) is not assignable to %s", got, len(s)-1, want)
+	}
+	return got
+}
+
+// popRef pops a reference, which may be to an uninitialized object if
+// uninit is set.
+func (v *verifier) popRef(uninit bool) vtype {
+	s := v.cur.stack
+	if len(s) == 0 {
+		v.fail("Operand stack underflow")
+		return vtNull
+	}
+	got := s[len(s)-1]
+	v.cur.stack = s[:len(s)-1]
+	if !got.isReference() || !uninit && (got.kind == vUninit || got.kind == vUninitThis) {
+		v.fail("Bad type on operand stack: Type %s (current frame, stack[%d]) is not assignable to reference type", got, len(s)-1)
+	}
+	return got
+}
+
+// popWords pops the values that take the top n words of the stack, as the
+// pop and dup instructions do.
+func (v *verifier) popWords(n int) []vtype {
+	s := v.cur.stack
+	i, words := len(s), 0
+	for words < n {
+		if i == 0 {
+			v.fail("Operand stack underflow")
+			return nil
+		}
+		i--
+		words += s[i].size()
+	}
+	if words > n {
+		v.fail("Bad type on operand stack: Type %s (current frame, stack[%d]) is not a category 1 value", s[i], i)
+		return nil
+	}
+	values := append([]vtype(nil), s[i:]...)
+	v.cur.stack = s[:i]
+	return values
+}
+
+// popArray pops null or an array whose component is one of the primitive
+// descriptors in kinds, or a reference if kinds has L, and returns the
+// component descriptor, which is empty for null.
+func (v *verifier) popArray(kinds string) string {
+	i := len(v.cur.stack) - 1
+	got := v.popRef(false)
+	if v.err != nil || got.kind == vNull {
+		return ""
+	}
+	if len(got.name) > 1 && got.name[0] == '[' {
+		elem := got.name[1:]
+		if isReferenceDescriptor(elem) && strings.Contains(kinds, "L") || len(elem) == 1 && strings.Contains(kinds, elem) {
+			return elem
+		}
+	}
+	want := "reference array"
+	if kinds[0] != 'L' {
+		want = "'[" + kinds[:1] + "'"
+	}
+	v.fail("Bad type on operand stack: Type %s (current frame, stack[%d]) is not assignable to %s", got, i, want)
+	return ""
+}
+
+func (v *verifier) load(t vtype, idx int) {
+	if idx+t.size() > v.maxLocals {
+		v.fail("Illegal local variable number")
+		return
+	}
+	got := v.cur.locals[idx]
+	if t.kind == vRef {
+		if !got.isReference() {
+			v.fail("Bad local variable type: Type %s (current frame, locals[%d]) is not assignable to reference type", got, idx)
+			return
+		}
+		t = got
+	} else if got != t {
+		v.fail("Bad local variable type: Type %s (current frame, locals[%d]) is not assignable to %s", got, idx, t)
+		return
+	}
+	v.push(t)
+}
+
+func (v *verifier) iinc(idx int) {
+	if idx >= v.maxLocals {
+		v.fail("Illegal local variable number")
+	} else if got := v.cur.locals[idx]; got != vtInt {
+		v.fail("Bad local variable type: Type %s (current frame, locals[%d]) is not assignable to integer", got, idx)
+	}
+}
+
+func (v *verifier) store(t vtype, idx int) {
+	if s := v.cur.stack; t.kind == vRef && len(s) > 0 && s[len(s)-1].kind == vRetAddr && v.infer {
+		// astore saves the return address of a subroutine
+		t = s[len(s)-1]
+		v.cur.stack = s[:len(s)-1]
+	} else if t.kind == vRef {
+		t = v.popRef(true)
+	} else {
+		v.pop(t)
+	}
+	v.setLocal(idx, t)
+}
+
+func (v *verifier) setLocal(idx int, t vtype) {
+	if idx+t.size() > v.maxLocals {
+		v.fail("Illegal local variable number")
+		return
+	}
+	locals := v.cur.locals
+	locals[idx] = t
+	if t.size() == 2 {
+		locals[idx+1] = vtTop
+	}
+	if idx > 0 && locals[idx-1].size() == 2 {
+		locals[idx-1] = vtTop
+	}
+}
+
+func (v *verifier) branch(offset int) {
+	target := v.pc + offset
+	if v.infer {
+		v.flow(target, v.ctx, v.cur)
+		return
+	}
+	f, ok := v.frames[target]
+	if !ok {
+		v.fail("Expecting a stack map frame at branch target %d", target)
+		return
+	}
+	if !v.frameAssignable(v.cur, f) {
+		v.fail("Current frame is not assignable to stack map frame at branch target %d", target)
+	}
+}
+
+func (v *verifier) u1(i int) int {
+	return int(v.code[v.pc+i])
+}
+
+func (v *verifier) u2(i int) uint16 {
+	return binary.BigEndian.Uint16(v.code[v.pc+i:])
+}
+
+func (v *verifier) s4(i int) int {
+	return int(int32(binary.BigEndian.Uint32(v.code[v.pc+i:])))
+}
+
+// execute applies the instruction at pc to the current frame. It reports
+// whether the next instruction is not reached from this one.
+func (v *verifier) execute() bool {
+	op := OpCode(v.code[v.pc])
+	switch {
+	case op == OpCodeNop:
+	case op == OpCodeAconstNull:
+		v.push(vtNull)
+	case op >= OpCodeIconstM1 && op <= OpCodeIconst5, op == OpCodeBipush, op == OpCodeSipush:
+		v.push(vtInt)
+	case op == OpCodeLconst0 || op == OpCodeLconst1:
+		v.push(vtLong)
+	case op >= OpCodeFconst0 && op <= OpCodeFconst2:
+		v.push(vtFloat)
+	case op == OpCodeDconst0 || op == OpCodeDconst1:
+		v.push(vtDouble)
+	case op == OpCodeLdc:
+		v.ldc(uint16(v.u1(1)), false)
+	case op == OpCodeLdcW || op == OpCodeLdc2W:
+		v.ldc(v.u2(1), op == OpCodeLdc2W)
+	case op == OpCodeAload:
+		v.load(vtRef(""), v.u1(1))
+	case op >= OpCodeIload && op < OpCodeAload:
+		v.load(primitiveKind[op-OpCodeIload], v.u1(1))
+	case op >= OpCodeAload0 && op <= OpCodeAload3:
+		v.load(vtRef(""), int(op-OpCodeAload0))
+	case op >= OpCodeIload0 && op < OpCodeAload0:
+		v.load(primitiveKind[(op-OpCodeIload0)/4], int(op-OpCodeIload0)%4)
+	case op >= OpCodeIaload && op <= OpCodeSaload:
+		v.pop(vtInt)
+		switch op {
+		case OpCodeAaload:
+			if elem := v.popArray("L"); elem != "" {
+				v.push(vtDescriptor(elem))
+			} else {
+				v.push(vtNull)
+			}
+		case OpCodeBaload:
+			v.popArray("BZ")
+			v.push(vtInt)
+		default:
+			elem := [...]string{"I", "J", "F", "D", "", "", "C", "S"}[op-OpCodeIaload]
+			v.popArray(elem)
+			v.push(vtDescriptor(elem))
+		}
+	case op == OpCodeAstore:
+		v.store(vtRef(""), v.u1(1))
+	case op >= OpCodeIstore && op < OpCodeAstore:
+		v.store(primitiveKind[op-OpCodeIstore], v.u1(1))
+	case op >= OpCodeAstore0 && op <= OpCodeAstore3:
+		v.store(vtRef(""), int(op-OpCodeAstore0))
+	case op >= OpCodeIstore0 && op < OpCodeAstore0:
+		v.store(primitiveKind[(op-OpCodeIstore0)/4], int(op-OpCodeIstore0)%4)
+	case op >= OpCodeIastore && op <= OpCodeSastore:
+		switch op {
+		case OpCodeAastore:
+			v.popRef(false)
+			v.pop(vtInt)
+			v.popArray("L")
+		case OpCodeBastore:
+			v.pop(vtInt)
+			v.pop(vtInt)
+			v.popArray("BZ")
+		default:
+			elem := [...]string{"I", "J", "F", "D", "", "", "C", "S"}[op-OpCodeIastore]
+			v.pop(vtDescriptor(elem))
+			v.pop(vtInt)
+			v.popArray(elem)
+		}
+	case op == OpCodePop:
+		v.popWords(1)
+	case op == OpCodePop2:
+		v.popWords(2)
+	case op == OpCodeDup:
+		a := v.popWords(1)
+		v.push(append(a, a...)...)
+	case op == OpCodeDupX1, op == OpCodeDupX2, op == OpCodeDup2X1, op == OpCodeDup2X2:
+		top, under := 1, 1
+		if op == OpCodeDup2X1 || op == OpCodeDup2X2 {
+			top = 2
+		}
+		if op == OpCodeDupX2 || op == OpCodeDup2X2 {
+			under = 2
+		}
+		a := v.popWords(top)
+		b := v.popWords(under)
+		v.push(a...)
+		v.push(b...)
+		v.push(a...)
+	case op == OpCodeDup2:
+		a := v.popWords(2)
+		v.push(append(a, a...)...)
+	case op == OpCodeSwap:
+		a := v.popWords(1)
+		b := v.popWords(1)
+		v.push(a...)
+		v.push(b...)
+	case op >= OpCodeIadd && op <= OpCodeDrem:
+		t := primitiveKind[(op-OpCodeIadd)%4]
+		v.pop(t)
+		v.pop(t)
+		v.push(t)
+	case op >= OpCodeIneg && op <= OpCodeDneg:
+		t := primitiveKind[op-OpCodeIneg]
+		v.pop(t)
+		v.push(t)
+	case op >= OpCodeIshl && op <= OpCodeLushr:
+		t := primitiveKind[(op-OpCodeIshl)%2]
+		v.pop(vtInt)
+		v.pop(t)
+		v.push(t)
+	case op >= OpCodeIand && op <= OpCodeLxor:
+		t := primitiveKind[(op-OpCodeIand)%2]
+		v.pop(t)
+		v.pop(t)
+		v.push(t)
+	case op == OpCodeIinc:
+		v.iinc(v.u1(1))
+	case op >= OpCodeI2l && op <= OpCodeD2f:
+		from := primitiveKind[(op-OpCodeI2l)/3]
+		to := [...]vtype{vtLong, vtFloat, vtDouble, vtInt, vtFloat, vtDouble, vtInt, vtLong, vtDouble, vtInt, vtLong, vtFloat}[op-OpCodeI2l]
+		v.pop(from)
+		v.push(to)
+	case op >= OpCodeI2b && op <= OpCodeI2s:
+		v.pop(vtInt)
+		v.push(vtInt)
+	case op >= OpCodeLcmp && op <= OpCodeDcmpg:
+		t := [...]vtype{vtLong, vtFloat, vtFloat, vtDouble, vtDouble}[op-OpCodeLcmp]
+		v.pop(t)
+		v.pop(t)
+		v.push(vtInt)
+	case op >= OpCodeIfeq && op <= OpCodeIfle:
+		v.pop(vtInt)
+		v.branch(int(int16(v.u2(1))))
+	case op >= OpCodeIfIcmpeq && op <= OpCodeIfIcmple:
+		v.pop(vtInt)
+		v.pop(vtInt)
+		v.branch(int(int16(v.u2(1))))
+	case op == OpCodeIfAcmpeq || op == OpCodeIfAcmpne:
+		v.popRef(true)
+		v.popRef(true)
+		v.branch(int(int16(v.u2(1))))
+	case op == OpCodeIfNull || op == OpCodeIfNonNull:
+		v.popRef(false)
+		v.branch(int(int16(v.u2(1))))
+	case op == OpCodeGoto:
+		v.branch(int(int16(v.u2(1))))
+		return true
+	case op == OpCodeGotoW:
+		v.branch(v.s4(1))
+		return true
+	case op == OpCodeJsr:
+		v.jsr(int(int16(v.u2(1))))
+		return true
+	case op == OpCodeJsrW:
+		v.jsr(v.s4(1))
+		return true
+	case op == OpCodeRet:
+		v.ret(v.u1(1))
+		return true
+	case op == OpCodeTableSwitch || op == OpCodeLookupSwitch:
+		v.pop(vtInt)
+		base := 4 - v.pc%4
+		v.branch(v.s4(base))
+		if op == OpCodeTableSwitch {
+			n := v.s4(base+8) - v.s4(base+4) + 1
+			for i := 0; i < n && v.err == nil; i++ {
+				v.branch(v.s4(base + 12 + 4*i))
+			}
+		} else {
+			n := v.s4(base + 4)
+			for i := 0; i < n && v.err == nil; i++ {
+				if i > 0 && v.s4(base+8+8*i) <= v.s4(base+8*i) {
+					v.fail("Bad lookupswitch instruction")
+				}
+				v.branch(v.s4(base + 12 + 8*i))
+			}
+		}
+		return true
+	case op >= OpCodeIreturn && op <= OpCodeReturn:
+		v.returnValue(op)
+		return true
+	case op >= OpCodeGetStatic && op <= OpCodePutField:
+		v.fieldAccess(op)
+	case op >= OpCodeInvokeVirtual && op <= OpCodeInvokeDynamic:
+		v.invoke(op)
+	case op == OpCodeNew:
+		if name := v.className(v.u2(1)); name[0] == '[' {
+			v.fail("Illegal use of new on array class %s", name)
+		}
+		v.push(vtype{kind: vUninit, pc: v.pc})
+	case op == OpCodeNewArray:
+		elem, ok := arrayTypeDescriptors[uint8(v.u1(1))]
+		if !ok {
+			v.fail("Illegal newarray type %d", v.u1(1))
+		}
+		v.pop(vtInt)
+		v.push(vtRef("[" + elem))
+	case op == OpCodeANewArray:
+		name := v.className(v.u2(1))
+		v.pop(vtInt)
+		if name[0] == '[' {
+			v.push(vtRef("[" + name))
+		} else {
+			v.push(vtRef("[L" + name + ";"))
+		}
+	case op == OpCodeArrayLength:
+		v.popArray("LBCDFIJSZ")
+		v.push(vtInt)
+	case op == OpCodeAThrow:
+		v.pop(vtThrowable)
+		return true
+	case op == OpCodeCheckCast:
+		name := v.className(v.u2(1))
+		v.popRef(false)
+		v.push(vtRef(name))
+	case op == OpCodeInstanceOf:
+		v.className(v.u2(1))
+		v.popRef(false)
+		v.push(vtInt)
+	case op == OpCodeMonitorEnter || op == OpCodeMonitorExit:
+		v.popRef(false)
+	case op == OpCodeWide:
+		return v.executeWide()
+	case op == OpCodeMultiANewArray:
+		name := v.className(v.u2(1))
+		dims := v.u1(3)
+		if dims == 0 || dims > len(name)-len(strings.TrimLeft(name, "[")) {
+			v.fail("Illegal dimension in multianewarray instruction: %d", dims)
+		}
+		for i := 0; i < dims; i++ {
+			v.pop(vtInt)
+		}
+		v.push(vtRef(name))
+	default:
+		v.fail("Illegal instruction %#x", byte(op))
+	}
+	return false
+}
+
+func (v *verifier) executeWide() bool {
+	op := OpCode(v.code[v.pc+1])
+	idx := int(v.u2(2))
+	switch {
+	case op == OpCodeIinc:
+		v.iinc(idx)
+	case op == OpCodeRet:
+		v.ret(idx)
+		return true
+	case op == OpCodeAload:
+		v.load(vtRef(""), idx)
+	case op >= OpCodeIload && op < OpCodeAload:
+		v.load(primitiveKind[op-OpCodeIload], idx)
+	case op == OpCodeAstore:
+		v.store(vtRef(""), idx)
+	case op >= OpCodeIstore && op < OpCodeAstore:
+		v.store(primitiveKind[op-OpCodeIstore], idx)
+	default:
+		v.fail("Illegal instruction %#x after wide", byte(op))
+	}
+	return false
+}
+
+func (v *verifier) ldc(idx uint16, wide bool) {
+	info := v.constant(idx)
+	if info == nil {
+		return
+	}
+	var t vtype
+	switch info.Tag {
+	case ConstantKindInteger:
+		t = vtInt
+	case ConstantKindFloat:
+		t = vtFloat
+	case ConstantKindLong:
+		t = vtLong
+	case ConstantKindDouble:
+		t = vtDouble
+	case ConstantKindString:
+		t = vtRef("java/lang/String")
+	case ConstantKindClass:
+		t = vtRef("java/lang/Class")
+	case ConstantKindMethodType:
+		t = vtRef("java/lang/invoke/MethodType")
+	case ConstantKindMethodHandle:
+		t = vtRef("java/lang/invoke/MethodHandle")
+	case ConstantKindDynamic:
+		if len(info.Info) < 4 {
+			v.fail("Illegal type at constant pool entry %d", idx)
+			return
+		}
+		_, desc, err := v.file.GetNameAndType(binary.BigEndian.Uint16(info.Info[2:]))
+		if err != nil || desc == "" {
+			v.fail("Illegal type at constant pool entry %d", idx)
+			return
+		}
+		t = vtDescriptor(desc)
+	default:
+		v.fail("Illegal type at constant pool entry %d", idx)
+		return
+	}
+	if (t.size() == 2) != wide {
+		v.fail("Invalid index in ldc: constant pool entry %d has the wrong size", idx)
+		return
+	}
+	v.push(t)
+}
+
+func (v *verifier) returnValue(op OpCode) {
+	ret := v.method.Desc.Return
+	if op == OpCodeReturn {
+		if ret != "V" {
+			v.fail("Method expects a return value")
+		} else if v.cur.thisUninit {
+			v.fail("Constructor must call super() or this() before return")
+		}
+		return
+	}
+	if ret == "V" {
+		v.fail("Method does not expect a return value")
+		return
+	}
+	want := vtDescriptor(ret)
+	if op == OpCodeAreturn && want.kind != vRef || op != OpCodeAreturn && want != primitiveKind[op-OpCodeIreturn] {
+		v.fail("Bad return type: the method returns %s", want)
+		return
+	}
+	v.pop(want)
+}
+
+func (v *verifier) fieldAccess(op OpCode) {
+	ref := v.memberRef(v.u2(1), ConstantKindFieldref)
+	if ref == nil {
+		return
+	}
+	t := vtDescriptor(ref.Descriptor)
+	switch op {
+	case OpCodeGetStatic:
+		v.push(t)
+	case OpCodePutStatic:
+		v.pop(t)
+	case OpCodeGetField:
+		v.pop(vtRef(ref.ClassName))
+		v.push(t)
+	case OpCodePutField:
+		v.pop(t)
+		// a constructor may set the fields of its class before super()
+		if s := v.cur.stack; len(s) > 0 && s[len(s)-1] == vtUninitThis && ref.ClassName == v.class.Name {
+			v.cur.stack = s[:len(s)-1]
+			return
+		}
+		v.pop(vtRef(ref.ClassName))
+	}
+}
+
+func (v *verifier) invoke(op OpCode) {
+	var name, desc, class string
+	if op == OpCodeInvokeDynamic {
+		if v.constant(v.u2(1)) == nil {
+			return
+		}
+		_, n, d, err := v.file.GetInvokeDynamic(v.u2(1))
+		if err != nil || v.code[v.pc+3] != 0 || v.code[v.pc+4] != 0 {
+			v.fail("Illegal invokedynamic instruction")
+			return
+		}
+		name, desc = n, d
+	} else {
+		kinds := []ConstantKind{ConstantKindMethodref, ConstantKindInterfaceMethodref}
+		switch op {
+		case OpCodeInvokeVirtual:
+			kinds = kinds[:1]
+		case OpCodeInvokeInterface:
+			kinds = kinds[1:]
+			if v.code[v.pc+3] == 0 || v.code[v.pc+4] != 0 {
+				v.fail("Illegal invokeinterface instruction")
+				return
+			}
+		}
+		ref := v.memberRef(v.u2(1), kinds...)
+		if ref == nil {
+			return
+		}
+		name, desc, class = ref.Name, ref.Descriptor, ref.ClassName
+	}
+	if name == "<clinit>" || name == "<init>" && op != OpCodeInvokeSpecial {
+		v.fail("Illegal call to internal method %s", name)
+		return
+	}
+	d, err := parseMethodDescriptor(desc)
+	if err != nil {
+		v.fail("Illegal method descriptor %s", desc)
+		return
+	}
+	for i := len(d.Parameters) - 1; i >= 0; i-- {
+		v.pop(vtDescriptor(d.Parameters[i]))
+	}
+	switch {
+	case op == OpCodeInvokeStatic || op == OpCodeInvokeDynamic:
+	case name == "<init>":
+		if d.Return != "V" {
+			v.fail("Illegal method descriptor %s for <init>", desc)
+			return
+		}
+		v.initialize(v.popRef(true), class)
+	case op == OpCodeInvokeInterface:
+		v.popRef(false)
+	case op == OpCodeInvokeSpecial:
+		v.pop(vtRef(v.class.Name))
+	default:
+		v.pop(vtRef(class))
+	}
+	if d.Return != "V" {
+		v.push(vtDescriptor(d.Return))
+	}
+}
+
+// initialize replaces the uninitialized object t with an initialized one of
+// the class whose constructor is called.
+func (v *verifier) initialize(t vtype, class string) {
+	var initialized vtype
+	switch t.kind {
+	case vUninitThis:
+		if class != v.class.Name && (v.class.Super == nil || class != v.class.Super.Name) {
+			v.fail("Bad <init> method call: %s is neither this class nor its super class", class)
+			return
+		}
+		initialized = vtRef(v.class.Name)
+		v.cur.thisUninit = false
+	case vUninit:
+		created := v.className(binary.BigEndian.Uint16(v.code[t.pc+1:]))
+		if created != class {
+			v.fail("Bad <init> method call: %s is initialized by a constructor of %s", created, class)
+			return
+		}
+		initialized = vtRef(class)
+	default:
+		v.fail("Bad operand type when invoking <init>: %s", t)
+		return
+	}
+	for _, s := range [][]vtype{v.cur.locals, v.cur.stack} {
+		for i := range s {
+			if s[i] == t {
+				s[i] = initialized
+			}
+		}
+	}
+}
+
+// instructionLength returns the length of the instruction at pc, or zero if
+// it is not a valid instruction or runs past the end of the code.
+func instructionLength(code []byte, pc int) int {
+	op := OpCode(code[pc])
+	n := 1
+	switch {
+	case op == OpCodeBipush, op == OpCodeLdc, op >= OpCodeIload && op <= OpCodeAload,
+		op >= OpCodeIstore && op <= OpCodeAstore, op == OpCodeRet, op == OpCodeNewArray:
+		n = 2
+	case op == OpCodeSipush, op == OpCodeLdcW, op == OpCodeLdc2W, op == OpCodeIinc,
+		op >= OpCodeIfeq && op <= OpCodeJsr, op >= OpCodeGetStatic && op <= OpCodeInvokeStatic,
+		op == OpCodeNew, op == OpCodeANewArray, op == OpCodeCheckCast, op == OpCodeInstanceOf,
+		op == OpCodeIfNull, op == OpCodeIfNonNull:
+		n = 3
+	case op == OpCodeMultiANewArray:
+		n = 4
+	case op == OpCodeInvokeInterface, op == OpCodeInvokeDynamic, op == OpCodeGotoW, op == OpCodeJsrW:
+		n = 5
+	case op == OpCodeTableSwitch || op == OpCodeLookupSwitch:
+		base := pc + 4 - pc%4
+		if base+8 > len(code) || op == OpCodeTableSwitch && base+12 > len(code) {
+			return 0
+		}
+		s4 := func(at int) int64 { return int64(int32(binary.BigEndian.Uint32(code[at:]))) }
+		if op == OpCodeTableSwitch {
+			low, high := s4(base+4), s4(base+8)
+			if low > high {
+				return 0
+			}
+			n = base - pc + 12 + int(4*(high-low+1))
+		} else {
+			pairs := s4(base + 4)
+			if pairs < 0 {
+				return 0
+			}
+			n = base - pc + 8 + int(8*pairs)
+		}
+	case op == OpCodeWide:
+		if pc+1 >= len(code) {
+			return 0
+		}
+		switch next := OpCode(code[pc+1]); {
+		case next == OpCodeIinc:
+			n = 6
+		case next >= OpCodeIload && next <= OpCodeAload, next >= OpCodeIstore && next <= OpCodeAstore, next == OpCodeRet:
+			n = 4
+		default:
+			return 0
+		}
+	case op > OpCodeJsrW:
+		return 0
+	}
+	if pc+n > len(code) {
+		return 0
+	}
+	return n
+}
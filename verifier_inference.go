@@ -0,0 +1,197 @@
+package jvmgo
+
+import "fmt"
+
+type (
+	// subroutineCall is a jsr the current instruction is reached through.
+	subroutineCall struct {
+		entry, ret int
+	}
+
+	// inferKey identifies the frame inferred at an instruction. An
+	// instruction of a subroutine gets a frame for each chain of jsr it is
+	// reached through, so a subroutine is verified as if inlined at every
+	// call.
+	inferKey struct {
+		pc  int
+		ctx string
+	}
+
+	inferState struct {
+		frame  *vframe
+		ctx    []subroutineCall
+		queued bool
+	}
+)
+
+// inferTypes verifies the method by data flow analysis, JVMS §4.10.2.2:
+// it merges the frames flowing into each instruction until none changes.
+func (v *verifier) inferTypes(initial *vframe) {
+	v.states = map[inferKey]*inferState{}
+	v.flow(0, nil, initial)
+	for len(v.queue) > 0 && v.err == nil {
+		k := v.queue[len(v.queue)-1]
+		v.queue = v.queue[:len(v.queue)-1]
+		s := v.states[k]
+		s.queued = false
+		v.pc, v.ctx, v.cur = k.pc, s.ctx, s.frame.copy()
+
+		v.checkExceptionFrames()
+		dead := v.execute()
+		if v.err != nil {
+			return
+		}
+		v.checkExceptionFrames()
+		if !dead {
+			next := v.pc + instructionLength(v.code, v.pc)
+			if next >= len(v.code) {
+				v.fail("Falling off the end of the code")
+				return
+			}
+			v.flow(next, v.ctx, v.cur)
+		}
+	}
+}
+
+// flow merges f into the frame of the instruction at target and queues the
+// instruction if its frame changed.
+func (v *verifier) flow(target int, ctx []subroutineCall, f *vframe) {
+	if target < 0 || target >= len(v.code) || !v.starts[target] {
+		v.fail("Illegal target of jump or branch %d", target)
+		return
+	}
+	k := inferKey{pc: target, ctx: fmt.Sprint(ctx)}
+	s, ok := v.states[k]
+	if !ok {
+		s = &inferState{frame: f.copy(), ctx: ctx}
+		v.states[k] = s
+	} else if !v.merge(s.frame, f, target) {
+		return
+	}
+	if !s.queued {
+		s.queued = true
+		v.queue = append(v.queue, k)
+	}
+}
+
+// merge merges from into into and reports whether into changed. Locals of
+// different types become unusable, while the stacks have to agree.
+func (v *verifier) merge(into, from *vframe, target int) bool {
+	if len(into.stack) != len(from.stack) {
+		v.fail("Inconsistent stack height %d != %d at %d", len(into.stack), len(from.stack), target)
+		return false
+	}
+	changed := false
+	for i, t := range from.stack {
+		m := v.mergeType(into.stack[i], t)
+		if m == vtTop {
+			v.fail("Mismatched stack types %s and %s (stack[%d]) at %d", into.stack[i], t, i, target)
+			return false
+		}
+		if m != into.stack[i] {
+			into.stack[i] = m
+			changed = true
+		}
+	}
+	for i, t := range from.locals {
+		if m := v.mergeType(into.locals[i], t); m != into.locals[i] {
+			into.locals[i] = m
+			changed = true
+		}
+	}
+	if from.thisUninit && !into.thisUninit {
+		into.thisUninit = true
+		changed = true
+	}
+	return changed
+}
+
+func (v *verifier) mergeType(a, b vtype) vtype {
+	switch {
+	case a == b:
+		return a
+	case a.kind == vNull && b.kind == vRef:
+		return b
+	case a.kind == vRef && b.kind == vNull:
+		return a
+	case a.kind == vRef && b.kind == vRef:
+		return vtRef(v.commonSuperclass(a.name, b.name))
+	}
+	return vtTop
+}
+
+// commonSuperclass returns the closest class both classes are assignable
+// to, taking Object for interfaces.
+func (v *verifier) commonSuperclass(a, b string) string {
+	switch {
+	case v.refAssignable(a, b):
+		return b
+	case v.refAssignable(b, a):
+		return a
+	case a[0] == '[' && b[0] == '[':
+		if !isReferenceDescriptor(a[1:]) || !isReferenceDescriptor(b[1:]) {
+			return "java/lang/Object"
+		}
+		elem := v.commonSuperclass(vtDescriptor(a[1:]).name, vtDescriptor(b[1:]).name)
+		if elem[0] == '[' {
+			return "[" + elem
+		}
+		return "[L" + elem + ";"
+	case a[0] == '[' || b[0] == '[':
+		return "java/lang/Object"
+	}
+	ca, cb := v.loadClass(a), v.loadClass(b)
+	if ca == nil || cb == nil || ca.IsInterface() || cb.IsInterface() {
+		return "java/lang/Object"
+	}
+	for k := ca.Super; k != nil; k = k.Super {
+		if cb.IsSubclassOf(k) {
+			return k.Name
+		}
+	}
+	return "java/lang/Object"
+}
+
+// jsr calls the subroutine at pc+offset, pushing the address to return to.
+func (v *verifier) jsr(offset int) {
+	if !v.infer {
+		v.fail("Illegal instruction jsr: subroutines are not allowed in class files verified by type checking")
+		return
+	}
+	target := v.pc + offset
+	for _, call := range v.ctx {
+		if call.entry == target {
+			v.fail("Recursive call to jsr entry %d", target)
+			return
+		}
+	}
+	ret := v.pc + instructionLength(v.code, v.pc)
+	v.push(vtype{kind: vRetAddr, pc: ret})
+	ctx := append(v.ctx[:len(v.ctx):len(v.ctx)], subroutineCall{entry: target, ret: ret})
+	v.flow(target, ctx, v.cur)
+}
+
+// ret returns from the subroutine whose return address is in local idx,
+// which may be one the current subroutine is nested in.
+func (v *verifier) ret(idx int) {
+	if !v.infer {
+		v.fail("Illegal instruction ret: subroutines are not allowed in class files verified by type checking")
+		return
+	}
+	if idx >= v.maxLocals {
+		v.fail("Illegal local variable number")
+		return
+	}
+	t := v.cur.locals[idx]
+	if t.kind != vRetAddr {
+		v.fail("Bad local variable type: Type %s (current frame, locals[%d]) is not assignable to returnAddress", t, idx)
+		return
+	}
+	for i := len(v.ctx) - 1; i >= 0; i-- {
+		if v.ctx[i].ret == t.pc {
+			v.flow(t.pc, v.ctx[:i], v.cur)
+			return
+		}
+	}
+	v.fail("Illegal return from subroutine to %d", t.pc)
+}
@@ -0,0 +1,226 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachine_ExecMain_Verify(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	// static int sum(int n) { int s = 0; for (int i = 0; i < n; i++) s += i; return s; }
+	sum := newAsm().
+		op(OpCodeIconst0).op(OpCodeIstore0+1).op(OpCodeIconst0).op(OpCodeIstore0+2).
+		label("loop").op(OpCodeIload0+2).op(OpCodeIload0).branch(OpCodeIfIcmpge, "done").
+		op(OpCodeIload0+1).op(OpCodeIload0+2).op(OpCodeIadd).op(OpCodeIstore0+1).
+		op(OpCodeIinc, 2, 1).branch(OpCodeGoto, "loop").
+		label("done").op(OpCodeIload0 + 1).op(OpCodeIreturn)
+	code := sum.bytes()
+	m := b.method(AccStatic, "sum", "(I)I", 2, 3)
+	m.Attributes = []*AttributeInfo{b.codeAttribute(2, 3, code, nil, b.stackMapTable(
+		// append_frame with two ints at loop, then same_frame at done
+		[]byte{253, 0, byte(sum.pc("loop")), ItemInteger, ItemInteger},
+		[]byte{byte(sum.pc("done") - sum.pc("loop") - 1)},
+	))}
+	m.AttributesCount = 1
+
+	// new Object(); System.out.println(sum(5));
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 1, newAsm().
+		ref(OpCodeNew, b.classRef("java/lang/Object")).op(OpCodeDup).
+		ref(OpCodeInvokeSpecial, b.methodRef("java/lang/Object", "<init>", "()V")).op(OpCodePop).
+		ref(OpCodeGetStatic, b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")).
+		op(OpCodeIconst5).ref(OpCodeInvokeStatic, b.methodRef("Main", "sum", "(I)I")).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(I)V")).
+		op(OpCodeReturn).bytes()...)
+
+	vm := NewVM(b.build())
+	vm.Verify = true
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "10\n", stdout.String())
+}
+
+func TestVirtualMachine_ExecMain_VerifyError(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		maxStack  uint16
+		maxLocals uint16
+		code      func(b *classBuilder) []byte
+		want      string
+	}{
+		{
+			name: "bad operand", method: "bad", maxStack: 2, maxLocals: 0,
+			code: func(b *classBuilder) []byte {
+				return newAsm().op(OpCodeLdc, lo(b.str("x"))).op(OpCodeIconst0 + 1).op(OpCodeIadd).op(OpCodePop).op(OpCodeReturn).bytes()
+			},
+			want: "Main.bad()V @3: Bad type on operand stack: Type 'java/lang/String' (current frame, stack[0]) is not assignable to integer",
+		},
+		{
+			name: "stack overflow", method: "bad", maxStack: 1, maxLocals: 0,
+			code: func(b *classBuilder) []byte {
+				return newAsm().op(OpCodeIconst0).op(OpCodeIconst0).op(OpCodePop2).op(OpCodeReturn).bytes()
+			},
+			want: "Main.bad()V @1: Operand stack overflow",
+		},
+		{
+			name: "uninitialized local", method: "bad", maxStack: 1, maxLocals: 2,
+			code: func(b *classBuilder) []byte {
+				return newAsm().op(OpCodeIload0 + 1).op(OpCodePop).op(OpCodeReturn).bytes()
+			},
+			want: "Main.bad()V @0: Bad local variable type: Type top (current frame, locals[1]) is not assignable to integer",
+		},
+		{
+			name: "branch without frame", method: "bad", maxStack: 1, maxLocals: 0,
+			code: func(b *classBuilder) []byte {
+				return newAsm().op(OpCodeIconst0).branch(OpCodeIfeq, "end").label("end").op(OpCodeReturn).bytes()
+			},
+			want: "Main.bad()V @1: Expecting a stack map frame at branch target 4",
+		},
+		{
+			name: "falling off", method: "bad", maxStack: 0, maxLocals: 0,
+			code: func(b *classBuilder) []byte {
+				return []byte{byte(OpCodeNop)}
+			},
+			want: "Main.bad()V @1: Falling off the end of the code",
+		},
+		{
+			name: "subroutine", method: "bad", maxStack: 1, maxLocals: 1,
+			code: func(b *classBuilder) []byte {
+				return newAsm().branch(OpCodeJsr, "sub").op(OpCodeReturn).label("sub").op(OpCodeAstore0).op(OpCodeRet, 0).bytes()
+			},
+			want: "Main.bad()V @0: Illegal instruction jsr: subroutines are not allowed in class files verified by type checking",
+		},
+		{
+			name: "constructor without super", method: "<init>", maxStack: 0, maxLocals: 1,
+			code: func(b *classBuilder) []byte {
+				return []byte{byte(OpCodeReturn)}
+			},
+			want: "Main.<init>()V @0: Constructor must call super() or this() before return",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newClassBuilder("Main", "java/lang/Object")
+			flags := uint16(AccStatic)
+			if tt.method == "<init>" {
+				flags = 0
+			}
+			b.method(flags, tt.method, "()V", tt.maxStack, tt.maxLocals, tt.code(b)...)
+			b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 0, 1, byte(OpCodeReturn))
+
+			vm := NewVM(b.build())
+			vm.Verify = true
+			err := vm.ExecMain()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "java/lang/VerifyError: "+tt.want)
+		})
+	}
+}
+
+func TestVirtualMachine_ExecMain_VerifyTrustsClassLibrary(t *testing.T) {
+	// sun.Bad overflows its operand stack, which only the verifier notices
+	bad := newClassBuilder("sun/Bad", "java/lang/Object")
+	bad.method(AccPublic|AccStatic, "run", "()V", 1, 0, newAsm().
+		op(OpCodeIconst0).op(OpCodeIconst0).op(OpCodePop2).op(OpCodeReturn).bytes()...)
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 0, 1, newAsm().
+		ref(OpCodeInvokeStatic, b.methodRef("sun/Bad", "run", "()V")).op(OpCodeReturn).bytes()...)
+
+	// a class named like the class library is verified on the class path
+	cp := mapClassPath{}
+	cp.add(bad.build())
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	vm.Verify = true
+	err := vm.ExecMain()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "java/lang/VerifyError: sun/Bad.run()V @1: Operand stack overflow")
+
+	// and trusted in the runtime image of a JDK
+	img, err := ReadJImage(buildJImage(map[string]string{"/java.base/sun/Bad.class": string(encodeClass(bad.build()))}))
+	require.NoError(t, err)
+	vm = NewVM(b.build())
+	vm.ClassPath = img
+	vm.Verify = true
+	require.NoError(t, vm.ExecMain())
+}
+
+func TestVirtualMachine_ExecMain_VerifyInference(t *testing.T) {
+	// a Java 5 class file has no StackMapTable
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.class.MajorVersion = []byte{0, 49}
+	// static int sum(int n) { int s = 0; for (int i = 0; i < n; i++) s += i; return s; }
+	b.method(AccStatic, "sum", "(I)I", 2, 3, newAsm().
+		op(OpCodeIconst0).op(OpCodeIstore0+1).op(OpCodeIconst0).op(OpCodeIstore0+2).
+		label("loop").op(OpCodeIload0+2).op(OpCodeIload0).branch(OpCodeIfIcmpge, "done").
+		op(OpCodeIload0+1).op(OpCodeIload0+2).op(OpCodeIadd).op(OpCodeIstore0+1).
+		op(OpCodeIinc, 2, 1).branch(OpCodeGoto, "loop").
+		label("done").op(OpCodeIload0+1).op(OpCodeIreturn).bytes()...)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 1, newAsm().
+		ref(OpCodeGetStatic, b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")).
+		op(OpCodeIconst5).ref(OpCodeInvokeStatic, b.methodRef("Main", "sum", "(I)I")).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(I)V")).
+		op(OpCodeReturn).bytes()...)
+
+	class, err := DecodeClassStructure(bytes.NewReader(encodeClass(b.build())))
+	require.NoError(t, err)
+	vm := NewVM(class)
+	vm.Verify = true
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "10\n", stdout.String())
+}
+
+func TestVirtualMachine_VerifySubroutines(t *testing.T) {
+	tests := []struct {
+		name string
+		code *asm
+		want string
+	}{
+		{
+			// int x = n; try { return x; } finally { n++; }
+			name: "finally",
+			code: newAsm().branch(OpCodeJsr, "sub").op(OpCodeIload0).op(OpCodeIreturn).
+				label("sub").op(OpCodeAstore, 1).op(OpCodeIinc, 0, 1).op(OpCodeRet, 1),
+		},
+		{
+			name: "return address as reference",
+			code: newAsm().branch(OpCodeJsr, "sub").op(OpCodeIload0).op(OpCodeIreturn).
+				label("sub").op(OpCodeAstore, 1).op(OpCodeAload0+1).op(OpCodePop).op(OpCodeRet, 1),
+			want: "Main.f(I)I @7: Bad local variable type: Type returnAddress (current frame, locals[1]) is not assignable to reference type",
+		},
+		{
+			name: "recursive subroutine",
+			code: newAsm().branch(OpCodeJsr, "sub").op(OpCodeIload0).op(OpCodeIreturn).
+				label("sub").op(OpCodeAstore, 1).branch(OpCodeJsr, "sub").op(OpCodeRet, 1),
+			want: "Main.f(I)I @7: Recursive call to jsr entry 5",
+		},
+		{
+			name: "inconsistent stack",
+			code: newAsm().op(OpCodeIload0).branch(OpCodeIfeq, "end").op(OpCodeIconst0).
+				label("end").op(OpCodeIload0).op(OpCodeIreturn),
+			want: "Main.f(I)I @4: Inconsistent stack height 0 != 1 at 5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newClassBuilder("Main", "java/lang/Object")
+			b.class.MajorVersion = []byte{0, 49}
+			b.method(AccStatic, "f", "(I)I", 2, 2, tt.code.bytes()...)
+			vm := NewVM(b.build())
+			c, err := vm.DefineClass(b.build())
+			require.NoError(t, err)
+			err = vm.verifyClass(c)
+			if tt.want == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Equal(t, tt.want, err.Error())
+		})
+	}
+}
@@ -1,179 +1,248 @@
 package jvmgo
 
 import (
-	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"strings"
-)
-
-const (
-	OpCodeGetStatic     OpCode = 0xb2
-	OpCodeLdc           OpCode = 0x12
-	OpCodeInvokeVirtual OpCode = 0xb6
-	OpCodeReturn        OpCode = 0xB1
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
 )
 
 type (
 	VirtualMachine struct {
-		Class        *ClassStructure
-		OperandStack *OperandStack
+		Class     *ClassStructure
+		ClassPath ClassPath
+		JavaHome  string
+		Natives   *NativeRegistry
+		Out       io.Writer
+		Err       io.Writer
+		// MaxHeapSize limits the heap like -Xmx; zero means no limit.
+		MaxHeapSize int64
+		// HeapDumpOnOutOfMemoryError dumps the heap to HeapDumpPath the first
+		// time it runs out, like -XX:+HeapDumpOnOutOfMemoryError. The path
+		// may name a directory and defaults to java_pid<pid>.hprof.
+		// DumpHeapOnSignal writes there too.
+		HeapDumpOnOutOfMemoryError bool
+		HeapDumpPath               string
+		// Verify type checks the bytecode of the application classes before
+		// they are initialized, like -Xverify:remote. A class that fails
+		// verification throws VerifyError.
+		Verify bool
+		// MaxInstructions is the number of instructions the threads may run
+		// in total during each ExecMain or Invoke before the VM stops with
+		// ErrInstructionLimit; zero means no limit.
+		MaxInstructions int64
+		// MaxStackDepth limits the frames of a thread like -Xss; a call that
+		// goes deeper throws StackOverflowError. Zero means
+		// DefaultMaxStackDepth, since the threads run on goroutines, whose
+		// stacks overflow fatally.
+		MaxStackDepth int
+		// MaxArrayLength limits the length of new arrays; a longer array
+		// throws OutOfMemoryError. Zero means no limit.
+		MaxArrayLength int32
+
+		// entryMu makes ExecMain and Invoke take turns on the main thread
+		entryMu           sync.Mutex
+		classesMu         sync.Mutex
+		classes           map[string]*RuntimeClass
+		boxesMu           sync.Mutex
+		boxes             map[boxKey]*Object
+		internMu          sync.Mutex
+		interned          map[string]*Object
+		mainThread        *Object
+		systemInitialized bool
+
+		ioMu          sync.Mutex
+		threadsMu     sync.Mutex
+		threads       map[*Object]*thread
+		main          *thread
+		nonDaemon     sync.WaitGroup
+		threadErrOnce sync.Once
+		threadErr     error
+		exitOnce      sync.Once
+		exitCh        chan struct{}
+		exitErr       error
+		halted        int32
+		instructions  int64
+		threadNumber  int32
+
+		heap heap
+	}
+	OpCode uint8
+
+	// ExitError reports that the program called System.exit with a non-zero
+	// status.
+	ExitError struct {
+		Code int
 	}
-	OperandStack []*CpInfo
-	OpCode       uint8
 )
 
 func NewVM(class *ClassStructure) *VirtualMachine {
-	return &VirtualMachine{
-		Class:        class,
-		OperandStack: &OperandStack{},
+	vm := &VirtualMachine{
+		Class:    class,
+		Natives:  NewNativeRegistry(),
+		Out:      os.Stdout,
+		Err:      os.Stderr,
+		classes:  map[string]*RuntimeClass{},
+		boxes:    map[boxKey]*Object{},
+		interned: map[string]*Object{},
+		threads:  map[*Object]*thread{},
+		main:     newThread(nil, false),
+		exitCh:   make(chan struct{}),
 	}
+
+	return vm
+}
+
+// UseJDK loads the class library from the JDK installed at javaHome. Core
+// reflection and annotations are not supported with it; the natives they
+// need throw UnsupportedOperationException.
+func (vm *VirtualMachine) UseJDK(javaHome string) error {
+	cp, err := OpenJDK(javaHome)
+	if err != nil {
+		return err
+	}
+	vm.ClassPath = cp
+	vm.JavaHome = javaHome
+	return nil
+}
+
+// RegisterNative binds fn to a native method, or to any method of a class that
+// is missing from the class path.
+func (vm *VirtualMachine) RegisterNative(className, methodName, descriptor string, fn NativeMethod) {
+	vm.Natives.Register(className, methodName, descriptor, fn)
+}
+
+// RegisterIntrinsic binds fn to a method so that it replaces the bytecode.
+func (vm *VirtualMachine) RegisterIntrinsic(className, methodName, descriptor string, fn NativeMethod) {
+	vm.Natives.RegisterIntrinsic(className, methodName, descriptor, fn)
 }
 
-func (vm *VirtualMachine) ExecMain() error {
-	for _, methodInfo := range vm.Class.Methods {
-		methodName, err := vm.Class.GetCpInfo(methodInfo.NameIndex).GetAsUTF8String()
+// ExecMain runs public static void main(String[]) of the main class with args
+// and waits for the non-daemon threads, as the java launcher does.
+func (vm *VirtualMachine) ExecMain(args ...string) error {
+	vm.entryMu.Lock()
+	defer vm.entryMu.Unlock()
+	atomic.StoreInt64(&vm.instructions, 0)
+	vm.enterWorld(vm.main)
+	defer vm.leaveWorld(vm.main)
+	class, err := vm.boot()
+	if err != nil {
+		return err
+	}
+	if class == nil {
+		return fmt.Errorf("main class is not set")
+	}
+	m, err := mainMethod(class)
+	if err != nil {
+		return err
+	}
+	if err := vm.initializeClass(nil, class); err != nil {
+		return fmt.Errorf("initialize main class: %w", err)
+	}
+	argArray, err := vm.ToJava("[Ljava/lang/String;", args)
+	if err != nil {
+		return fmt.Errorf("create main arguments: %w", err)
+	}
+	_, err = vm.invokeMethod(nil, m, []Value{argArray})
+	var exit *ExitError
+	if !errors.As(err, &exit) {
+		// like the launcher, wait for the other threads even when main fails
+		vm.leaveWorld(vm.main)
+		werr := vm.waitThreads()
 		if err != nil {
-			return fmt.Errorf("get method name: %w", err)
+			return fmt.Errorf("execute main. %v: %w", m, err)
 		}
-		if methodName == "main" {
-			if err := vm.execute(methodInfo); err != nil {
-				return fmt.Errorf("execute main. %v: %w", methodInfo, err)
-			}
-			fmt.Printf("finished!: %v\n", methodInfo)
+		if werr == nil {
 			return nil
 		}
+		if !errors.As(werr, &exit) {
+			return fmt.Errorf("execute thread: %w", werr)
+		}
 	}
+	vm.halt(exit.Code)
+	if exit.Code == 0 {
+		return nil
+	}
+	return exit
+}
 
-	return fmt.Errorf("main method does not exist")
+// boot brings up the class library when the class path has one and defines
+// the main class, if any. It returns the main class.
+func (vm *VirtualMachine) boot() (*RuntimeClass, error) {
+	if vm.ClassPath != nil && !vm.systemInitialized {
+		if _, err := vm.ClassPath.ReadClass("java/lang/System"); err == nil {
+			if err := vm.InitSystem(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if vm.Class == nil {
+		return nil, nil
+	}
+	class, err := vm.DefineClass(vm.Class)
+	if err != nil {
+		return nil, fmt.Errorf("define main class: %w", err)
+	}
+	return class, nil
 }
 
-func (vm *VirtualMachine) execute(m *MethodInfo) error {
-	for i := range m.Attributes {
-		code, err := m.Attributes[i].toCodeAttribute()
-		if err != nil {
-			return fmt.Errorf("convert to code attribute :%w", err)
+// mainMethod finds public static void main(String[]) in class or its
+// superclasses, like the launcher does.
+func mainMethod(class *RuntimeClass) (*RuntimeMethod, error) {
+	for c := class; c != nil; c = c.Super {
+		m := c.DeclaredMethod("main", "([Ljava/lang/String;)V")
+		if m == nil {
+			continue
 		}
-		if err := vm.executeCode(code); err != nil {
-			return fmt.Errorf("execute code :%w", err)
+		if !m.IsStatic() || m.AccessFlags&AccPublic == 0 {
+			return nil, fmt.Errorf("main method of class %s is not public static, please define it as public static void main(String[] args)", javaClassName(class.Name))
 		}
-		fmt.Printf("executed %v\n", code)
+		return m, nil
 	}
-
-	return nil
+	return nil, fmt.Errorf("main method not found in class %s, please define it as public static void main(String[] args)", javaClassName(class.Name))
 }
 
-func (vm *VirtualMachine) executeCode(c *CodeAttribute) error {
-	r := bytes.NewBuffer(c.Code)
-	for {
-		op := make([]byte, 1)
-		if _, err := io.ReadFull(r, op); err == io.EOF {
-			return nil
-		} else if err != nil {
-			return fmt.Errorf("execute remaining buf len=%d :%w", r.Len(), err)
+func (vm *VirtualMachine) loadConstant(class *RuntimeClass, idx uint16) (Value, error) {
+	info := class.File.GetCpInfo(idx)
+	switch info.Tag {
+	case ConstantKindInteger:
+		return int32(binary.BigEndian.Uint32(info.Info)), nil
+	case ConstantKindFloat:
+		return math.Float32frombits(binary.BigEndian.Uint32(info.Info)), nil
+	case ConstantKindLong:
+		return int64(binary.BigEndian.Uint64(info.Info)), nil
+	case ConstantKindDouble:
+		return math.Float64frombits(binary.BigEndian.Uint64(info.Info)), nil
+	case ConstantKindString:
+		s, err := class.File.GetCpInfo(binary.BigEndian.Uint16(info.Info)).GetAsUTF8String()
+		if err != nil {
+			return nil, err
 		}
-
-		switch OpCode(op[0]) {
-		case OpCodeGetStatic:
-			buf := make([]byte, 2)
-			if _, err := io.ReadFull(r, buf); err != nil {
-				return fmt.Errorf("execute get static symbol: %w", err)
-			}
-			vm.OperandStack.push(vm.Class.GetCpInfo(binary.BigEndian.Uint16(buf)))
-		case OpCodeLdc:
-			buf := make([]byte, 1)
-			if _, err := io.ReadFull(r, buf); err != nil {
-				return fmt.Errorf("execute ldc: %w", err)
-			}
-			vm.OperandStack.push(vm.Class.GetCpInfo(binary.BigEndian.Uint16([]byte{0, buf[0]})))
-		case OpCodeInvokeVirtual:
-			buf := make([]byte, 2)
-			if _, err := io.ReadFull(r, buf); err != nil {
-				return fmt.Errorf("execute invoke virtual: %w", err)
-			}
-			symbol, err := vm.Class.GetCpInfo(binary.BigEndian.Uint16(buf)).ToMethodRef()
-			if err != nil {
-				return fmt.Errorf("execute invoke virtual parse symbol: %w", err)
-			}
-			calleeInfo, err := vm.Class.GetCpInfo(symbol.NameAndTypeIndex).ToNameAndType()
-			if err != nil {
-				return fmt.Errorf("execute invoke virtual name and type: %w", err)
-			}
-			methodName, err := vm.Class.GetCpInfo(calleeInfo.NameIndex).GetAsUTF8String()
-			if err != nil {
-				return fmt.Errorf("execute invoke virtual method name: %w", err)
-			}
-			argumentInfo, err := vm.Class.GetCpInfo(calleeInfo.DescriptorIndex).GetAsUTF8String()
-			if err != nil {
-				return fmt.Errorf("execute invoke virtual arguments: %w", err)
-			}
-
-			arguments := make([]*CpInfo, len(strings.Split(argumentInfo, ";"))-1)
-			var exists bool
-			for i := range arguments {
-				arguments[i], exists = vm.OperandStack.pop()
-				if !exists {
-					return fmt.Errorf("execute invoke virtual pop arguments: arguments not exist. idx: %d", i)
-				}
-			}
-			callableInfo, exists := vm.OperandStack.pop()
-			if !exists {
-				return fmt.Errorf("execute invoke virtual pop callable info: callable not exist")
-			}
-			callable, err := callableInfo.ToFieldRef()
-			if err != nil {
-				return fmt.Errorf("execute invoke virtual parse callable: %w", err)
-			}
-			callableClass, err := vm.Class.GetCpInfo(callable.ClassIndex).ToClass()
-			if err != nil {
-				return fmt.Errorf("execute invoke virtual callable class: %w", err)
-			}
-			callableClassName, err := vm.Class.GetCpInfo(callableClass.NameIndex).GetAsUTF8String()
-			if err != nil {
-				return fmt.Errorf("execute invoke virtual callable class name: %w", err)
-			}
-
-			nameAndType, err := vm.Class.GetCpInfo(callable.NameAndTypeIndex).ToNameAndType()
-			if err != nil {
-				return fmt.Errorf("execute invoke virtual name and type: %w", err)
-			}
-			fieldType, err := vm.Class.GetCpInfo(nameAndType.DescriptorIndex).GetAsUTF8String()
-			if err != nil {
-				return fmt.Errorf("execute invoke virtual field type: %w", err)
-			}
-
-			switch callableClassName {
-			case "java/lang/System":
-				switch fieldType {
-				case "Ljava/io/PrintStream;":
-					f := PrintStream{}
-					switch methodName {
-					case "println":
-						args := make([]interface{}, len(arguments))
-						for i := range arguments {
-							args[i], _ = vm.Class.GetCpInfo(binary.BigEndian.Uint16(arguments[i].Info)).GetAsUTF8String()
-						}
-						f.println(args...)
-					}
-				}
-			}
-		case OpCodeReturn:
-			break
+		return vm.intern(s, nil), nil
+	case ConstantKindClass:
+		name, err := class.File.ClassName(idx)
+		if err != nil {
+			return nil, err
 		}
+		c, err := vm.LoadClass(name)
+		if err != nil {
+			return nil, err
+		}
+		return vm.ClassMirror(c)
 	}
+	return nil, fmt.Errorf("unsupported constant kind: %d", info.Tag)
 }
 
-func (s *OperandStack) push(ope *CpInfo) {
-	*s = append(*s, ope)
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
 }
 
-func (s *OperandStack) pop() (*CpInfo, bool) {
-	if len(*s) == 0 {
-		return nil, false
-	}
-	idx := len(*s) - 1
-	res := (*s)[idx]
-	*s = (*s)[:idx]
-	return res, true
+type writerFunc func(p []byte) (int, error)
+
+func (w writerFunc) Write(p []byte) (int, error) {
+	return w(p)
 }
@@ -72,3 +72,102 @@ func TestVirtualMachine_ExecMain_HelloJVM(t *testing.T) {
 	//		}},
 	//	}
 }
+
+func TestVirtualMachine_RegisterNative(t *testing.T) {
+	b := newClassBuilder("Calc", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	forty := b.integer(40)
+	two := b.integer(2)
+	add := b.methodRef("Calc", "add", "(II)I")
+	println := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	b.method(AccStatic|AccNative, "add", "(II)I", 0, 0)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 3, 1,
+		byte(OpCodeGetStatic), hi(out), lo(out),
+		byte(OpCodeLdc), lo(forty),
+		byte(OpCodeLdc), lo(two),
+		byte(OpCodeInvokeStatic), hi(add), lo(add),
+		byte(OpCodeInvokeVirtual), hi(println), lo(println),
+		byte(OpCodeReturn),
+	)
+
+	vm := NewVM(b.build())
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	var ex *JavaException
+	require.ErrorAs(t, vm.ExecMain(), &ex)
+	require.Equal(t, "java/lang/UnsatisfiedLinkError: Calc.add(II)I", ex.Error())
+
+	vm.RegisterNative("Calc", "add", "(II)I", func(frame *Frame, args []Value) (Value, error) {
+		return args[0].(int32) + args[1].(int32), nil
+	})
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "42\n", stdout.String())
+}
+
+func TestNativeRegistry_Builtins(t *testing.T) {
+	r := NewNativeRegistry()
+
+	sqrt, ok := r.Lookup("java/lang/Math", "sqrt", "(D)D")
+	require.True(t, ok)
+	v, err := sqrt(nil, []Value{float64(16)})
+	require.NoError(t, err)
+	require.Equal(t, float64(4), v)
+
+	arraycopy, ok := r.Lookup("java/lang/System", "arraycopy", "(Ljava/lang/Object;ILjava/lang/Object;II)V")
+	require.True(t, ok)
+	src := NewArray(newSyntheticClass("[I", nil), []int32{1, 2, 3, 4})
+	_, err = arraycopy(nil, []Value{src, int32(0), src, int32(1), int32(3)})
+	require.NoError(t, err)
+	require.Equal(t, []int32{1, 1, 2, 3}, src.Array)
+	_, err = arraycopy(nil, []Value{src, int32(2), src, int32(0), int32(3)})
+	require.Error(t, err)
+
+	hashCode, ok := r.Lookup("java/lang/Object", "hashCode", "()I")
+	require.True(t, ok)
+	obj := NewObject(newSyntheticClass("java/lang/Object", nil))
+	h1, err := hashCode(nil, []Value{obj})
+	require.NoError(t, err)
+	h2, err := hashCode(nil, []Value{obj})
+	require.NoError(t, err)
+	require.Equal(t, h1, h2)
+
+	nanoTime, ok := r.Lookup("java/lang/System", "nanoTime", "()J")
+	require.True(t, ok)
+	t1, err := nanoTime(nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, int64(0), t1)
+
+	_, ok = r.Lookup("java/lang/Math", "sqrt", "(F)F")
+	require.False(t, ok)
+}
+
+func TestVirtualMachine_RegisterIntrinsic_AfterCall(t *testing.T) {
+	b := newClassBuilder("Calc", "java/lang/Object")
+	b.method(AccPublic|AccStatic, "one", "()I", 1, 0, byte(OpCodeIconst0+1), byte(OpCodeIreturn))
+	vm := NewVM(nil)
+	_, err := vm.DefineClass(b.build())
+	require.NoError(t, err)
+
+	call := func() interface{} {
+		v, err := vm.Invoke("Calc", "one", "()I")
+		require.NoError(t, err)
+		return v
+	}
+	require.Equal(t, int32(1), call())
+	vm.RegisterIntrinsic("Calc", "one", "()I", func(frame *Frame, args []Value) (Value, error) {
+		return int32(2), nil
+	})
+	require.Equal(t, int32(2), call())
+	vm.Natives.Unregister("Calc", "one", "()I")
+	require.Equal(t, int32(1), call())
+}
+
+func TestNativeRegistry_NanoTime(t *testing.T) {
+	nanoTime, ok := NewNativeRegistry().Lookup("java/lang/System", "nanoTime", "()J")
+	require.True(t, ok)
+	first, err := nanoTime(nil, nil)
+	require.NoError(t, err)
+	second, err := nanoTime(nil, nil)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, second.(int64), first.(int64))
+}
@@ -0,0 +1,56 @@
+package jvmgo
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// Fields are shared by the threads, which run on goroutines, and a Value is
+// an interface of two words that a racing read could see half written. So
+// every access to a field slot, volatile or not, goes through one of a fixed
+// set of locks picked by the address of the slot. The same slot always takes
+// the same lock, whether getfield, getstatic, reflection or Unsafe reaches
+// it, which orders the accesses as the Java memory model asks of volatile
+// and replaces a value whole, so neither long nor double tears.
+var slotLocks [64]sync.Mutex
+
+func slotLock(values []Value, slot int) *sync.Mutex {
+	// a Value is two words, so slots next to each other take different locks
+	p := uintptr(unsafe.Pointer(&values[slot])) / unsafe.Sizeof(Value(nil))
+	return &slotLocks[p%uintptr(len(slotLocks))]
+}
+
+// elementLock is the lock of an element of a primitive array, which only the
+// atomic operations of Unsafe take.
+func elementLock(arr *Object, idx int) *sync.Mutex {
+	return &slotLocks[(uintptr(unsafe.Pointer(arr))*31+uintptr(idx))%uintptr(len(slotLocks))]
+}
+
+func (f *RuntimeField) IsVolatile() bool {
+	return f.AccessFlags&AccVolatile != 0
+}
+
+func loadSlot(values []Value, slot int) Value {
+	mu := slotLock(values, slot)
+	mu.Lock()
+	defer mu.Unlock()
+	return values[slot]
+}
+
+func storeSlot(values []Value, slot int, v Value) {
+	mu := slotLock(values, slot)
+	mu.Lock()
+	defer mu.Unlock()
+	values[slot] = v
+}
+
+// updateSlot replaces values[slot] with what fn makes of it and returns the
+// old value. fn must not access another slot.
+func updateSlot(values []Value, slot int, fn func(Value) Value) Value {
+	mu := slotLock(values, slot)
+	mu.Lock()
+	defer mu.Unlock()
+	old := values[slot]
+	values[slot] = fn(old)
+	return old
+}
@@ -0,0 +1,191 @@
+package jvmgo
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachine_ExecMain_Atomics(t *testing.T) {
+	const atomicInteger = "java/util/concurrent/atomic/AtomicInteger"
+	// class Adder extends Thread {
+	//   public void run() { for (int i = 0; i < 1000; i++) { Main.counter.incrementAndGet(); Main.last = i; } }
+	// }
+	a := newClassBuilder("Adder", "java/lang/Thread")
+	a.method(AccPublic, "<init>", "()V", 1, 1, newAsm().
+		op(OpCodeAload0).ref(OpCodeInvokeSpecial, a.methodRef("java/lang/Thread", "<init>", "()V")).op(OpCodeReturn).bytes()...)
+	a.method(AccPublic, "run", "()V", 2, 2, newAsm().
+		op(OpCodeIconst0).op(OpCodeIstore0+1).
+		label("loop").op(OpCodeIload0+1).op(OpCodeSipush, hi(1000), lo(1000)).branch(OpCodeIfIcmpge, "end").
+		ref(OpCodeGetStatic, a.fieldRef("Main", "counter", "L"+atomicInteger+";")).
+		ref(OpCodeInvokeVirtual, a.methodRef(atomicInteger, "incrementAndGet", "()I")).op(OpCodePop).
+		op(OpCodeIload0+1).op(OpCodeI2l).ref(OpCodePutStatic, a.fieldRef("Main", "last", "J")).
+		op(OpCodeIinc, 1, 1).branch(OpCodeGoto, "loop").
+		label("end").op(OpCodeReturn).bytes()...)
+	cp := mapClassPath{}
+	cp.add(a.build())
+
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.field(AccStatic, "counter", "L"+atomicInteger+";")
+	b.field(AccStatic|AccVolatile, "last", "J")
+	counter := b.fieldRef("Main", "counter", "L"+atomicInteger+";")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printInt := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	printBool := b.methodRef("java/io/PrintStream", "println", "(Z)V")
+	printLong := b.methodRef("java/io/PrintStream", "println", "(J)V")
+	code := newAsm().ref(OpCodeNew, b.classRef(atomicInteger)).op(OpCodeDup).
+		ref(OpCodeInvokeSpecial, b.methodRef(atomicInteger, "<init>", "()V")).ref(OpCodePutStatic, counter)
+	for i := byte(1); i <= 4; i++ {
+		code.ref(OpCodeNew, b.classRef("Adder")).op(OpCodeDup).ref(OpCodeInvokeSpecial, b.methodRef("Adder", "<init>", "()V")).
+			op(OpCodeDup).op(OpCodeAstore, i).ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Thread", "start", "()V"))
+	}
+	for i := byte(1); i <= 4; i++ {
+		code.op(OpCodeAload, i).ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Thread", "join", "()V"))
+	}
+	compareAndSet := b.methodRef(atomicInteger, "compareAndSet", "(II)Z")
+	expected := b.integer(4000)
+	code.ref(OpCodeGetStatic, out).ref(OpCodeGetStatic, counter).ref(OpCodeInvokeVirtual, b.methodRef(atomicInteger, "get", "()I")).
+		ref(OpCodeInvokeVirtual, printInt).
+		ref(OpCodeGetStatic, out).ref(OpCodeGetStatic, b.fieldRef("Main", "last", "J")).ref(OpCodeInvokeVirtual, printLong).
+		ref(OpCodeGetStatic, out).ref(OpCodeGetStatic, counter).op(OpCodeLdc, lo(expected)).op(OpCodeIconst0).
+		ref(OpCodeInvokeVirtual, compareAndSet).ref(OpCodeInvokeVirtual, printBool).
+		ref(OpCodeGetStatic, out).ref(OpCodeGetStatic, counter).op(OpCodeLdc, lo(expected)).op(OpCodeIconst0).
+		ref(OpCodeInvokeVirtual, compareAndSet).ref(OpCodeInvokeVirtual, printBool).
+		ref(OpCodeGetStatic, out).ref(OpCodeGetStatic, counter).ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Object", "toString", "()Ljava/lang/String;")).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(Ljava/lang/String;)V")).
+		op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 4, 5, code.bytes()...)
+
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "4000\n999\ntrue\nfalse\n0\n", stdout.String())
+}
+
+// TestVirtualMachine_ExecMain_PlainFields races a thread writing plain long
+// and reference fields against main reading them, which the race detector
+// reports unless every slot access takes its lock.
+func TestVirtualMachine_ExecMain_PlainFields(t *testing.T) {
+	const n = 2000
+	// loop runs body n times with i in local 1
+	loop := func(code *asm, body func(*asm) *asm) *asm {
+		code.op(OpCodeIconst0).op(OpCodeIstore, 1).
+			label("loop").op(OpCodeIload, 1).op(OpCodeSipush, hi(n), lo(n)).branch(OpCodeIfIcmpge, "end")
+		return body(code).op(OpCodeIinc, 1, 1).branch(OpCodeGoto, "loop").label("end")
+	}
+	cp := mapClassPath{}
+	// class Writer extends Thread { public void run() { for (...) { Main.x = i; Main.o = new Object(); } } }
+	w := newClassBuilder("Writer", "java/lang/Thread")
+	w.method(AccPublic, "<init>", "()V", 1, 1, newAsm().
+		op(OpCodeAload0).ref(OpCodeInvokeSpecial, w.methodRef("java/lang/Thread", "<init>", "()V")).op(OpCodeReturn).bytes()...)
+	run := loop(newAsm(), func(a *asm) *asm {
+		return a.op(OpCodeIload, 1).op(OpCodeI2l).ref(OpCodePutStatic, w.fieldRef("Main", "x", "J")).
+			ref(OpCodeNew, w.classRef("java/lang/Object")).op(OpCodeDup).
+			ref(OpCodeInvokeSpecial, w.methodRef("java/lang/Object", "<init>", "()V")).
+			ref(OpCodePutStatic, w.fieldRef("Main", "o", "Ljava/lang/Object;"))
+	}).op(OpCodeReturn)
+	w.method(AccPublic, "run", "()V", 2, 2, run.bytes()...)
+	cp.add(w.build())
+
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.field(AccStatic, "x", "J")
+	b.field(AccStatic, "o", "Ljava/lang/Object;")
+	code := newAsm().
+		ref(OpCodeNew, b.classRef("Writer")).op(OpCodeDup).ref(OpCodeInvokeSpecial, b.methodRef("Writer", "<init>", "()V")).
+		op(OpCodeAstore, 2).op(OpCodeAload, 2).ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Thread", "start", "()V"))
+	loop(code, func(a *asm) *asm {
+		return a.ref(OpCodeGetStatic, b.fieldRef("Main", "x", "J")).op(OpCodePop2).
+			ref(OpCodeGetStatic, b.fieldRef("Main", "o", "Ljava/lang/Object;")).op(OpCodePop)
+	}).op(OpCodeAload, 2).ref(OpCodeInvokeVirtual, b.methodRef("java/lang/Thread", "join", "()V")).
+		ref(OpCodeGetStatic, b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")).
+		ref(OpCodeGetStatic, b.fieldRef("Main", "x", "J")).
+		ref(OpCodeInvokeVirtual, b.methodRef("java/io/PrintStream", "println", "(J)V")).
+		op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 3, 3, code.bytes()...)
+
+	vm := NewVM(b.build())
+	vm.ClassPath = cp
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "1999\n", stdout.String())
+}
+
+func TestUnsafe_CompareAndSet(t *testing.T) {
+	r := NewNativeRegistry()
+	cas, ok := r.Lookup("jdk/internal/misc/Unsafe", "compareAndSetLong", "(Ljava/lang/Object;JJJ)Z")
+	require.True(t, ok)
+	getVolatile, ok := r.Lookup("jdk/internal/misc/Unsafe", "getLongVolatile", "(Ljava/lang/Object;J)J")
+	require.True(t, ok)
+	getAndAdd, ok := r.Intrinsic("sun/misc/Unsafe", "getAndAddLong", "(Ljava/lang/Object;JJ)J")
+	require.True(t, ok)
+
+	obj := &Object{Fields: []Value{int64(0)}}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				for {
+					old, err := getVolatile(nil, []Value{nil, obj, int64(0)})
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if swapped, _ := cas(nil, []Value{nil, obj, int64(0), old, old.(int64) + 1}); swapped == int32(1) {
+						break
+					}
+				}
+				if _, err := getAndAdd(nil, []Value{nil, obj, int64(0), int64(1)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int64(8000), obj.Fields[0])
+}
+
+func TestUnsafe_StaticField(t *testing.T) {
+	const unsafe = "jdk/internal/misc/Unsafe"
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.field(AccStatic|AccVolatile, "count", "J")
+	vm := NewVM(b.build())
+	class, err := vm.DefineClass(vm.Class)
+	require.NoError(t, err)
+	frame := &Frame{VM: vm}
+	native := func(name, desc string, args ...Value) (Value, error) {
+		fn, ok := vm.Natives.Lookup(unsafe, name, desc)
+		require.True(t, ok, name)
+		return fn(frame, append([]Value{nil}, args...))
+	}
+
+	field := &Object{Extra: class.DeclaredField("count", "J")}
+	base, err := native("staticFieldBase0", "(Ljava/lang/reflect/Field;)Ljava/lang/Object;", field)
+	require.NoError(t, err)
+	mirror, err := vm.ClassMirror(class)
+	require.NoError(t, err)
+	require.Same(t, mirror, base)
+	offset, err := native("staticFieldOffset0", "(Ljava/lang/reflect/Field;)J", field)
+	require.NoError(t, err)
+
+	swapped, err := native("compareAndSetLong", "(Ljava/lang/Object;JJJ)Z", base, offset, int64(0), int64(7))
+	require.NoError(t, err)
+	require.Equal(t, int32(1), swapped)
+	require.Equal(t, int64(7), loadSlot(class.StaticValues, class.DeclaredField("count", "J").Slot))
+	v, err := native("getLongVolatile", "(Ljava/lang/Object;J)J", base, offset)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), v)
+
+	// off-heap memory is not supported
+	_, err = native("getLong", "(Ljava/lang/Object;J)J", nil, int64(4096))
+	var ex *JavaException
+	require.ErrorAs(t, err, &ex)
+	require.Equal(t, "java/lang/UnsupportedOperationException", ex.Object.ClassName())
+}
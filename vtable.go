@@ -0,0 +1,313 @@
+package jvmgo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// linkMethods prepares method selection for c: the vtable holding the
+// instance methods of a class, overriding entries inherited from its super
+// class, and one itable per superinterface mapping the methods of the
+// interface to their implementations in c. The super class and interfaces of
+// c are linked before c.
+func (c *RuntimeClass) linkMethods() {
+	seen := map[*RuntimeClass]bool{}
+	var all []*RuntimeClass
+	add := func(i *RuntimeClass) {
+		if !seen[i] {
+			seen[i] = true
+			all = append(all, i)
+		}
+	}
+	if c.Super != nil {
+		for _, i := range c.Super.superInterfaces {
+			add(i)
+		}
+	}
+	for _, i := range c.Interfaces {
+		add(i)
+		for _, s := range i.superInterfaces {
+			add(s)
+		}
+	}
+	c.superInterfaces = all
+
+	for _, m := range c.Methods {
+		m.vtableIndex, m.itableIndex = -1, -1
+	}
+	if c.IsInterface() {
+		for _, m := range c.Methods {
+			if m.isVirtual() {
+				m.itableIndex = c.itableSize
+				c.itableSize++
+			}
+		}
+		return
+	}
+
+	var vtable []*RuntimeMethod
+	if c.Super != nil {
+		vtable = append(vtable, c.Super.vtable...)
+	}
+	for _, m := range c.Methods {
+		if !m.isVirtual() {
+			continue
+		}
+		for i, inherited := range vtable {
+			if inherited.Name == m.Name && inherited.Descriptor == m.Descriptor && m.overrides(inherited) {
+				vtable[i] = m
+				if m.vtableIndex < 0 {
+					m.vtableIndex = i
+				}
+			}
+		}
+		if m.vtableIndex < 0 {
+			m.vtableIndex = len(vtable)
+			vtable = append(vtable, m)
+		}
+	}
+	c.vtable = vtable
+	c.linkReference()
+
+	c.itables = make(map[*RuntimeClass][]*RuntimeMethod, len(all))
+	for _, i := range all {
+		itable := make([]*RuntimeMethod, i.itableSize)
+		for _, im := range i.Methods {
+			if im.itableIndex >= 0 {
+				itable[im.itableIndex] = c.selectInterfaceMethod(im)
+			}
+		}
+		c.itables[i] = itable
+	}
+}
+
+// isVirtual reports whether m takes part in dynamic selection.
+func (m *RuntimeMethod) isVirtual() bool {
+	return m.AccessFlags&(AccStatic|AccPrivate) == 0 && m.Name != "<init>" && m.Name != "<clinit>"
+}
+
+// overrides reports whether m can override other per JVMS §5.4.5.
+func (m *RuntimeMethod) overrides(other *RuntimeMethod) bool {
+	if other.AccessFlags&AccPrivate != 0 {
+		return false
+	}
+	if other.AccessFlags&(AccPublic|AccProtected) != 0 {
+		return true
+	}
+	return packageName(m.Class.Name) == packageName(other.Class.Name)
+}
+
+func packageName(className string) string {
+	if i := strings.LastIndexByte(className, '/'); i >= 0 {
+		return className[:i]
+	}
+	return ""
+}
+
+func (m *RuntimeMethod) IsPrivate() bool {
+	return m.AccessFlags&AccPrivate != 0
+}
+
+// selectInterfaceMethod selects the implementation of an interface method
+// in c per JVMS §5.4.6, or returns nil when there is none or the default
+// methods conflict.
+func (c *RuntimeClass) selectInterfaceMethod(im *RuntimeMethod) *RuntimeMethod {
+	for k := c; k != nil; k = k.Super {
+		if m := k.DeclaredMethod(im.Name, im.Descriptor); m != nil && m.isVirtual() {
+			return m
+		}
+	}
+	var selected *RuntimeMethod
+	for _, m := range c.maximallySpecific(im.Name, im.Descriptor) {
+		if m.IsAbstract() {
+			continue
+		}
+		if selected != nil {
+			return nil
+		}
+		selected = m
+	}
+	return selected
+}
+
+// maximallySpecific returns the maximally-specific superinterface methods of c
+// with the given name and descriptor per JVMS §5.4.3.3.
+func (c *RuntimeClass) maximallySpecific(name, descriptor string) []*RuntimeMethod {
+	var candidates []*RuntimeMethod
+	for _, i := range c.superInterfaces {
+		if m := i.DeclaredMethod(name, descriptor); m != nil && m.isVirtual() {
+			candidates = append(candidates, m)
+		}
+	}
+	var result []*RuntimeMethod
+	for _, m := range candidates {
+		specific := true
+		for _, other := range candidates {
+			if other != m && other.Class.implements(m.Class) {
+				specific = false
+				break
+			}
+		}
+		if specific {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// implements reports whether i is a superinterface of c.
+func (c *RuntimeClass) implements(i *RuntimeClass) bool {
+	for _, s := range c.superInterfaces {
+		if s == i {
+			return true
+		}
+	}
+	return false
+}
+
+// selectMethod selects the method that invokevirtual or invokeinterface runs
+// for a receiver of class c and the resolved method resolved, per JVMS §5.4.6.
+func (vm *VirtualMachine) selectMethod(caller *Frame, c *RuntimeClass, resolved *RuntimeMethod) (*RuntimeMethod, error) {
+	if resolved.IsPrivate() {
+		return resolved, nil
+	}
+	if !resolved.Class.IsInterface() {
+		if i := resolved.vtableIndex; i >= 0 && i < len(c.vtable) {
+			// the verifier would guarantee the receiver is a subclass
+			if m := c.vtable[i]; m.Name == resolved.Name && m.Descriptor == resolved.Descriptor {
+				return m, nil
+			}
+		}
+	} else if itable, ok := c.itables[resolved.Class]; ok && resolved.itableIndex >= 0 {
+		if m := itable[resolved.itableIndex]; m != nil {
+			return m, nil
+		}
+	}
+
+	// the receiver does not derive from the resolved class, or the
+	// interface method has no unique implementation
+	for k := c; k != nil; k = k.Super {
+		if m := k.DeclaredMethod(resolved.Name, resolved.Descriptor); m != nil && m.isVirtual() && (resolved.Class.IsInterface() || m.overrides(resolved)) {
+			return m, nil
+		}
+	}
+	var defaults []string
+	var selected *RuntimeMethod
+	for _, m := range c.maximallySpecific(resolved.Name, resolved.Descriptor) {
+		if !m.IsAbstract() {
+			selected = m
+			defaults = append(defaults, javaClassName(m.Class.Name)+"."+m.Name)
+		}
+	}
+	switch len(defaults) {
+	case 0:
+		kind := "class"
+		if resolved.Class.IsInterface() {
+			kind = "interface"
+		}
+		return nil, vm.throwNew(caller, "java/lang/AbstractMethodError", fmt.Sprintf(
+			"Receiver class %s does not define or inherit an implementation of the resolved method '%s' of %s %s.",
+			javaClassName(c.Name), resolved.javaSignature(), kind, javaClassName(resolved.Class.Name)))
+	case 1:
+		return selected, nil
+	}
+	return nil, vm.throwNew(caller, "java/lang/IncompatibleClassChangeError", "Conflicting default methods: "+strings.Join(defaults, " "))
+}
+
+// javaSignature formats m the way HotSpot error messages do, e.g.
+// "abstract void run()".
+func (m *RuntimeMethod) javaSignature() string {
+	s := javaTypeName(m.Desc.Return) + " " + m.Name + m.javaParameters()
+	if m.IsAbstract() {
+		s = "abstract " + s
+	}
+	return s
+}
+
+func (m *RuntimeMethod) javaParameters() string {
+	var params []string
+	for _, p := range m.Desc.Parameters {
+		params = append(params, javaTypeName(p))
+	}
+	return "(" + strings.Join(params, ", ") + ")"
+}
+
+func javaTypeName(desc string) string {
+	switch desc[0] {
+	case 'L':
+		return javaClassName(desc[1 : len(desc)-1])
+	case '[':
+		return javaTypeName(desc[1:]) + "[]"
+	}
+	if name, ok := primitiveNames[desc]; ok {
+		return name
+	}
+	return "void"
+}
+
+// resolveMethod resolves a method reference per JVMS §5.4.3.3, or §5.4.3.4
+// for interface method references.
+func (vm *VirtualMachine) resolveMethod(caller *Frame, class *RuntimeClass, ref *MemberRef) (*RuntimeMethod, error) {
+	if ref.Interface != class.IsInterface() && !class.stub {
+		expected := "class"
+		if ref.Interface {
+			expected = "interface"
+		}
+		kind := "class"
+		if class.IsInterface() {
+			kind = "interface"
+		}
+		return nil, vm.throwNew(caller, "java/lang/IncompatibleClassChangeError",
+			fmt.Sprintf("Found %s %s, but %s was expected", kind, javaClassName(class.Name), expected))
+	}
+	m := class.LookupMethod(ref.Name, ref.Descriptor)
+	if ref.Name == "<init>" {
+		// constructors are not inherited
+		m = class.DeclaredMethod(ref.Name, ref.Descriptor)
+	}
+	if m == nil {
+		return nil, vm.throwNew(caller, "java/lang/NoSuchMethodError", fmt.Sprintf("'%s'", ref))
+	}
+	if err := vm.checkMethodAccess(caller, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// selectSpecial selects the method that invokespecial runs for the method
+// resolved from a reference to class, per JVMS §6.5.invokespecial. A call to
+// a superclass method from a class with ACC_SUPER semantics starts the search
+// at the direct superclass of the caller, so super.m() runs the nearest
+// override.
+func (vm *VirtualMachine) selectSpecial(caller *Frame, class *RuntimeClass, resolved *RuntimeMethod) (*RuntimeMethod, error) {
+	c := class
+	if resolved.Name != "<init>" && !class.IsInterface() && caller != nil && caller.Class != class &&
+		caller.Class.IsSubclassOf(class) && caller.Class.hasSuperSemantics() {
+		c = caller.Class.Super
+	}
+	if c == class {
+		if resolved.IsAbstract() {
+			return nil, vm.throwNew(caller, "java/lang/AbstractMethodError", resolved.String())
+		}
+		return resolved, nil
+	}
+	for k := c; k != nil; k = k.Super {
+		if m := k.DeclaredMethod(resolved.Name, resolved.Descriptor); m != nil && !m.IsStatic() {
+			if m.IsAbstract() {
+				return nil, vm.throwNew(caller, "java/lang/AbstractMethodError", m.String())
+			}
+			return m, nil
+		}
+	}
+	return vm.selectMethod(caller, c, resolved)
+}
+
+// hasSuperSemantics reports whether invokespecial in c treats superclass
+// methods as ACC_SUPER prescribes, which Java SE 8 and later assume for every
+// class file of version 52 or above.
+func (c *RuntimeClass) hasSuperSemantics() bool {
+	if c.AccessFlags&AccSuper != 0 {
+		return true
+	}
+	return c.File != nil && c.File.Major() >= 52
+}
@@ -0,0 +1,138 @@
+package jvmgo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dispatchClassPath holds
+//
+//	interface A { default int f() { return 1; } }
+//	interface B extends A { default int f() { return 2; } }
+//	interface C { default int f() { return 3; } }
+//	interface R { int h(); }
+//	class P implements B {}
+//	class Q extends P {}
+//	class Conflict implements B, C {}
+//	class NoImpl implements R {}
+//	package pkg; public class Base { int g() { return 5; } public int call() { return g(); } }
+//	class Sub extends pkg.Base { int g() { return 4; } }
+func dispatchClassPath() mapClassPath {
+	cp := mapClassPath{}
+	iface := func(name string, supers ...string) *classBuilder {
+		b := newClassBuilder(name, "java/lang/Object")
+		b.class.AccessFlags = AccPublic | AccInterface | AccAbstract
+		for _, s := range supers {
+			b.class.Interfaces = append(b.class.Interfaces, b.classRef(s))
+		}
+		return b
+	}
+	class := func(name, super string, interfaces ...string) *classBuilder {
+		b := newClassBuilder(name, super)
+		for _, i := range interfaces {
+			b.class.Interfaces = append(b.class.Interfaces, b.classRef(i))
+		}
+		superInit := b.methodRef(super, "<init>", "()V")
+		b.method(AccPublic, "<init>", "()V", 1, 1, newAsm().
+			op(OpCodeAload0).ref(OpCodeInvokeSpecial, superInit).op(OpCodeReturn).bytes()...)
+		return b
+	}
+	constant := func(b *classBuilder, flags uint16, name string, v byte) {
+		b.method(flags, name, "()I", 1, 1, byte(OpCodeIconst0+OpCode(v)), byte(OpCodeIreturn))
+	}
+
+	a := iface("A")
+	constant(a, AccPublic, "f", 1)
+	cp.add(a.build())
+	b := iface("B", "A")
+	constant(b, AccPublic, "f", 2)
+	cp.add(b.build())
+	c := iface("C")
+	constant(c, AccPublic, "f", 3)
+	cp.add(c.build())
+	r := iface("R")
+	r.method(AccPublic|AccAbstract, "h", "()I", 0, 0)
+	cp.add(r.build())
+
+	cp.add(class("P", "java/lang/Object", "B").build())
+	cp.add(class("Q", "P").build())
+	cp.add(class("Conflict", "java/lang/Object", "B", "C").build())
+	cp.add(class("NoImpl", "java/lang/Object", "R").build())
+
+	base := class("pkg/Base", "java/lang/Object")
+	constant(base, 0, "g", 5)
+	g := base.methodRef("pkg/Base", "g", "()I")
+	base.method(AccPublic, "call", "()I", 1, 1, newAsm().
+		op(OpCodeAload0).ref(OpCodeInvokeVirtual, g).op(OpCodeIreturn).bytes()...)
+	cp.add(base.build())
+	sub := class("Sub", "pkg/Base")
+	constant(sub, 0, "g", 4)
+	cp.add(sub.build())
+	return cp
+}
+
+func TestVirtualMachine_ExecMain_Dispatch(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	out := b.fieldRef("java/lang/System", "out", "Ljava/io/PrintStream;")
+	printInt := b.methodRef("java/io/PrintStream", "println", "(I)V")
+	q := b.classRef("Q")
+	qInit := b.methodRef("Q", "<init>", "()V")
+	sub := b.classRef("Sub")
+	subInit := b.methodRef("Sub", "<init>", "()V")
+	af := b.interfaceMethodRef("A", "f", "()I")
+	pf := b.methodRef("P", "f", "()I")
+	call := b.methodRef("pkg/Base", "call", "()I")
+	subG := b.methodRef("Sub", "g", "()I")
+
+	code := newAsm().
+		ref(OpCodeNew, q).op(OpCodeDup).ref(OpCodeInvokeSpecial, qInit).op(OpCodeAstore0+1).
+		// the default method of B overrides the one of A
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).op(OpCodeInvokeInterface, hi(af), lo(af), 1, 0).ref(OpCodeInvokeVirtual, printInt).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).ref(OpCodeInvokeVirtual, pf).ref(OpCodeInvokeVirtual, printInt).
+		ref(OpCodeNew, sub).op(OpCodeDup).ref(OpCodeInvokeSpecial, subInit).op(OpCodeAstore0+1).
+		// Sub.g does not override the package-private pkg.Base.g
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).ref(OpCodeInvokeVirtual, call).ref(OpCodeInvokeVirtual, printInt).
+		ref(OpCodeGetStatic, out).op(OpCodeAload0+1).ref(OpCodeInvokeVirtual, subG).ref(OpCodeInvokeVirtual, printInt).
+		op(OpCodeReturn)
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 2, 2, code.bytes()...)
+
+	vm := NewVM(b.build())
+	vm.ClassPath = dispatchClassPath()
+	var stdout bytes.Buffer
+	vm.Out = &stdout
+	require.NoError(t, vm.ExecMain())
+	require.Equal(t, "2\n2\n5\n4\n", stdout.String())
+}
+
+func TestVirtualMachine_SelectMethod_Errors(t *testing.T) {
+	b := newClassBuilder("Main", "java/lang/Object")
+	b.method(AccPublic|AccStatic, "main", "([Ljava/lang/String;)V", 0, 1, byte(OpCodeReturn))
+	vm := NewVM(b.build())
+	vm.ClassPath = dispatchClassPath()
+	main, err := vm.DefineClass(b.build())
+	require.NoError(t, err)
+	frame := &Frame{VM: vm, Class: main, Method: main.DeclaredMethod("main", "([Ljava/lang/String;)V"), OperandStack: &OperandStack{}}
+	load := func(name string) *RuntimeClass {
+		c, err := vm.LoadClass(name)
+		require.NoError(t, err)
+		return c
+	}
+
+	a := load("A")
+	_, err = vm.selectMethod(frame, load("Conflict"), a.DeclaredMethod("f", "()I"))
+	require.EqualError(t, err, "java/lang/IncompatibleClassChangeError: Conflicting default methods: B.f C.f")
+
+	_, err = vm.selectMethod(frame, load("NoImpl"), load("R").DeclaredMethod("h", "()I"))
+	require.EqualError(t, err, "java/lang/AbstractMethodError: Receiver class NoImpl does not define or inherit an implementation of the resolved method 'abstract int h()' of interface R.")
+
+	_, err = vm.resolveMethod(frame, a, &MemberRef{ClassName: "A", Name: "f", Descriptor: "()I"})
+	require.EqualError(t, err, "java/lang/IncompatibleClassChangeError: Found interface A, but class was expected")
+
+	q := load("Q")
+	m, err := vm.resolveMethod(frame, q, &MemberRef{ClassName: "Q", Name: "f", Descriptor: "()I"})
+	require.NoError(t, err)
+	require.Equal(t, "B", m.Class.Name)
+	require.Equal(t, m, q.itables[a][m.itableIndex])
+}